@@ -1,11 +1,30 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 	"github.com/rotationalio/tidal"
 	"gopkg.in/urfave/cli.v1"
 )
@@ -21,31 +40,163 @@ const (
    directory in the current working directory or using a specified directory
    as an argument. The utility falls back to the current working directory.
 
+   The generated code registers the migrations in an init function when the
+   package is imported. Specify --no-init or --func NAME to generate an
+   exported function that must be called to register the migrations instead,
+   so that applications and tests can control when they are registered.
+
    Tidal also has several utility and helper commands:
 
    tidal command [command options] [args ...]`
 
-	newUsageText = `tidal new [-n "name of migration"] [-p PACKAGE] [-m DIR]
+	newUsageText = `tidal new [-n "name of migration"] [-p PACKAGE] [-m DIR] [-t TEMPLATE]
 
    Creates a new migration file in the specified directory, otherwise looks
-   for a "migrations" directory, then defaults to the current working directory.`
+   for a "migrations" directory, then defaults to the current working directory.
+   The file is scaffolded from a Go text/template if specified (or from the
+   TIDAL_TEMPLATE environment variable), which is executed with the fields
+   {{ .Revision }}, {{ .Name }}, {{ .Date }}, {{ .Timestamp }}, and {{ .Package }}
+   and must produce a -- migrate: up directive.`
 
-	migrateUsageText = `tidal migrate [-D] [-m DIR] [-r REVISION] [-d URL]
+	migrateUsageText = `tidal migrate [+N] [-D] [--validate] [--atomic] [--out-of-order] [--continue-on-error] [-v] [-m DIR] [-r REVISION | -n NAME] [-d URL]
 
    A helper utility to test migration SQL before embedding them.
    This command checks the current migration status in the database and
    applies all migrations in the specified directory (or "migrations" or
-   CWD) up to the specified or latest revision.`
+   CWD) up to the specified or latest revision. Specify +N to apply only
+   the next N migrations after the current revision, e.g. tidal migrate +1
+   or tidal migrate -r +1. For backwards compatibility -r -1 still applies
+   all migrations, the same as omitting the revision.
+
+   Interrupting the command (e.g. with Ctrl-C) cancels the migration in
+   progress and rolls back its transaction; interrupt again to exit
+   immediately.`
 
-	rollbackUsageText = `tidal rollback [-D] [-m DIR] [-r REVISION] [-d URL]
+	rollbackUsageText = `tidal rollback [-N] [-D] [--validate] [-f] [-v] [-y] [-m DIR] [-r REVISION | -n NAME] [-d URL]
 
    A helper utility to test migration SQL before embedding them.
    This command checks the current migration status in the database and
    rolls back all migrations in the specified directory (or "migrations" or
-   CWD) down to the specified or all the way back to no-migrations.`
+   CWD) down to the specified or all the way back to no-migrations. Specify
+   -N directly after rollback to roll back only the last N applied
+   migrations, e.g. tidal rollback -1 or tidal rollback -r -1. Note that
+   -r -1 rolls back one migration rather than all of them, omit the revision
+   or specify -r 0 to roll back all migrations.
+
+   The revisions that will be rolled back are listed and must be confirmed
+   before they are executed unless the -y flag is specified; if stdin is not
+   a terminal (e.g. in CI) the -y flag is required. Interrupting the command
+   (e.g. with Ctrl-C) cancels the rollback in progress and rolls back its
+   transaction.`
+
+	syncUsageText = `tidal sync [-m DIR] [-r REVISION] [-d URL]
+
+   Marks the migrations in the specified directory (or "migrations" or CWD)
+   up to the specified or latest revision as applied without executing any
+   SQL, e.g. to reconcile the migrations table after the schema was changed
+   out of band by restoring a backup or by applying the SQL manually.`
+
+	baselineUsageText = `tidal baseline -r REVISION [-m DIR] [-d URL]
+
+   Adopts tidal on an existing database whose schema already reflects the
+   migrations up to the specified revision. The migrations table is created
+   and the migrations up to the revision are marked as applied without
+   executing any SQL; later migrations can then be applied with migrate.`
+
+	forceUsageText = `tidal force -r REVISION [-a] [-m DIR] [-d URL]
+
+   Marks the specified revision as applied (with -a) or as pending in the
+   migrations table without executing any SQL, e.g. to recover from a
+   migration that failed partway through once the database has been fixed.
+   A pending revision is applied again by the next migrate (which requires
+   --out-of-order if later revisions have been applied).`
+
+	squashUsageText = `tidal squash -r FROM:TO [-m DIR]
+
+   Replaces the migration files of the revisions FROM through TO with a
+   single migration whose up SQL concatenates the up SQL of the revisions
+   and whose down SQL concatenates the down SQL in reverse order. Databases
+   that applied all of the original revisions skip the squashed migration
+   and fresh databases apply it in place of the originals. Squash revisions
+   that have been applied to every database, then regenerate the code.`
+
+	manifestUsageText = `tidal manifest [-m DIR] [-o FILE]
+
+   Writes a JSON manifest with the revision, name, and checksum of every
+   migration in the specified directory (or "migrations" or CWD) to stdout
+   or to the specified file. Commit the manifest and check it with
+   tidal verify --manifest FILE to ensure that migrations are append-only.`
+
+	exportUsageText = `tidal export -o DIR [-m DIR | -e DIR] [--overwrite]
+
+   Writes the migrations in the specified directory (or "migrations" or CWD)
+   or the compressed .bin descriptors written by tidal --embed in the
+   directory specified by -e back out as .sql files in the output directory,
+   reconstructing the directives of the original migration files. Use export
+   to audit or recover the SQL of migrations built into an application when
+   the original migration files are no longer available.`
+
+	verifyUsageText = `tidal verify [-m DIR] [--manifest FILE]
+
+   Verifies that the migrations in the specified directory (or "migrations"
+   or CWD) have no missing or duplicate revisions and that their requires
+   directives are valid. If a manifest written by tidal manifest is
+   specified, also verifies that none of the migrations in the manifest
+   have been modified, renamed, or removed and that new migrations were
+   only appended after the latest revision in the manifest.`
+
+	showUsageText = `tidal show -r REVISION | -n NAME [--up | --down] [--raw] [-m DIR]
+
+   Prints the up and down SQL of the specified revision in the migrations
+   directory (or "migrations" or CWD) without connecting to a database, e.g.
+   to review what a migration does before it is applied. Specify --up or
+   --down to print only one direction and --raw to include the migrate,
+   package, and metadata directive comments of the migration file.`
+
+	lintUsageText = `tidal lint [-m DIR]
+
+   Checks the migration files in the specified directory (or "migrations" or
+   CWD) for common mistakes without connecting to a database: missing down
+   sections, missing, repeated, or misspelled migrate directives, duplicate
+   or non-sequential revisions, and dangerous statements such as DROP TABLE
+   without IF EXISTS. Exits with a non-zero status if any issues are found,
+   e.g. for use as a pre-commit hook.`
+
+	testUsageText = `tidal test [--compare-schema] [-v] [-m DIR] [-d URL]
+
+   Checks that the down SQL of each migration in the specified directory
+   (or "migrations" or CWD) reverses its up SQL by applying each migration,
+   immediately rolling it back, and applying it again. Specify
+   --compare-schema to also check that the schema after each rollback is
+   the same as the schema before the migration was applied. The database
+   must be disposable and have no migrations applied; every migration is
+   left applied once the test passes.`
 )
 
+// Migrations in subdirectories of the migrations directory are loaded unless --flat.
+var flatFlag = cli.BoolFlag{
+	Name:  "flat",
+	Usage: "only load migrations from the top level of the migrations directory",
+}
+
+// Directories that are not searched for migrations directories, in addition to hidden
+// directories, since they contain dependencies rather than application code.
+var ignoreDirs = map[string]bool{
+	"vendor":           true,
+	"node_modules":     true,
+	"bower_components": true,
+}
+
 func main() {
+	// Run the program, it should not error
+	app := newApp()
+	if err := app.Run(stepArgs(app, os.Args)); err != nil {
+		panic(err)
+	}
+}
+
+// Creates the tidal command line application and its commands.
+func newApp() *cli.App {
 	app := cli.NewApp()
 	app.Name = "tidal"
 	app.Version = tidal.Version()
@@ -60,15 +211,33 @@ func main() {
 			Name:  "m, migrations",
 			Usage: "specify directory to look for migrations in (otherwise performs search)",
 		},
+		flatFlag,
 		cli.StringFlag{
 			Name:  "o, out",
 			Usage: "location to write generated code (default: migrations parent directory)",
 		},
+		cli.BoolFlag{
+			Name:  "e, embed",
+			Usage: "write descriptors to .bin files embedded with go:embed instead of byte literals",
+		},
+		cli.BoolFlag{
+			Name:  "strip-comments",
+			Usage: "remove sql comments from the descriptors to reduce the size of the generated code",
+		},
+		cli.BoolFlag{
+			Name:  "no-init",
+			Usage: "register the migrations with an exported function instead of an init function",
+		},
+		cli.StringFlag{
+			Name:  "func",
+			Usage: "name of the exported function that registers the migrations (default: RegisterMigrations)",
+		},
 	}
 	app.Action = generate
 	app.Commands = []cli.Command{
 		{
 			Name:      "new",
+			Aliases:   []string{"create"},
 			Usage:     "create a new blank migration file",
 			UsageText: newUsageText,
 			Action:    create,
@@ -85,6 +254,11 @@ func main() {
 					Name:  "m, migrations",
 					Usage: "specify directory to create migration in (otherwise performs search)",
 				},
+				cli.StringFlag{
+					Name:   "t, template",
+					Usage:  "a text/template file to scaffold the migration from (otherwise uses the default)",
+					EnvVar: "TIDAL_TEMPLATE",
+				},
 			},
 		},
 		{
@@ -92,16 +266,48 @@ func main() {
 			Usage:  "display the current migration status of the database",
 			Action: revision,
 			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "m, migrations",
+					Usage: "specify directory to look for migrations in (otherwise performs search)",
+				},
+				flatFlag,
 				cli.StringFlag{
 					Name:   "d, db",
 					Usage:  "the database uri to connect to",
 					EnvVar: "DATABASE_URL",
 				},
+				cli.StringFlag{
+					Name:   "t, table",
+					Usage:  "the name of the migrations table",
+					Value:  "migrations",
+					EnvVar: "TIDAL_TABLE",
+				},
+				cli.StringFlag{
+					Name:   "table-schema",
+					Usage:  "the schema that contains the migrations table (default: the current schema)",
+					EnvVar: "TIDAL_TABLE_SCHEMA",
+				},
 				cli.IntFlag{
 					Name:  "r, revision",
 					Usage: "specify a revision to get the detail status for",
 					Value: -1,
 				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the status of the migrations as json",
+				},
+				cli.BoolFlag{
+					Name:  "pending",
+					Usage: "only list the migrations that have not been applied",
+				},
+				cli.StringFlag{
+					Name:  "since",
+					Usage: "only list migrations applied at or after a date, timestamp, or duration ago (e.g. 2024-01-01 or 72h)",
+				},
+				cli.StringFlag{
+					Name:  "until",
+					Usage: "only list migrations applied at or before a date, timestamp, or duration ago",
+				},
 			},
 		},
 		{
@@ -115,20 +321,75 @@ func main() {
 					Name:  "m, migrations",
 					Usage: "specify directory to look for migrations in (otherwise performs search)",
 				},
+				flatFlag,
 				cli.StringFlag{
 					Name:   "d, db",
 					Usage:  "the database uri to connect to",
 					EnvVar: "DATABASE_URL",
 				},
-				cli.IntFlag{
+				cli.StringFlag{
+					Name:   "t, table",
+					Usage:  "the name of the migrations table",
+					Value:  "migrations",
+					EnvVar: "TIDAL_TABLE",
+				},
+				cli.StringFlag{
+					Name:   "table-schema",
+					Usage:  "the schema that contains the migrations table (default: the current schema)",
+					EnvVar: "TIDAL_TABLE_SCHEMA",
+				},
+				cli.StringFlag{
 					Name:  "r, revision",
-					Usage: "specify a revision to migrate up to (otherwise applies all)",
-					Value: -1,
+					Usage: "specify a revision to migrate up to or +N to apply the next N migrations (otherwise applies all)",
+				},
+				cli.StringFlag{
+					Name:  "n, name",
+					Usage: "specify the name of a migration to migrate up to instead of a revision",
 				},
 				cli.BoolFlag{
 					Name:  "D, debug",
 					Usage: "specify migration actions without actually executing them",
 				},
+				cli.BoolFlag{
+					Name:  "validate",
+					Usage: "execute the migration sql in a transaction that is always rolled back",
+				},
+				cli.BoolFlag{
+					Name:  "atomic",
+					Usage: "apply all migrations in a single transaction so that none are applied on failure",
+				},
+				cli.BoolFlag{
+					Name:  "out-of-order",
+					Usage: "apply pending migrations below the latest applied revision",
+				},
+				cli.BoolFlag{
+					Name:  "continue-on-error",
+					Usage: "attempt every pending migration and report all failures (idempotent migrations only)",
+				},
+				cli.BoolFlag{
+					Name:  "allow-dirty",
+					Usage: "migrate even if a previous migration was interrupted, once the database has been checked",
+				},
+				cli.BoolFlag{
+					Name:  "f, force",
+					Usage: "apply migrations even if applied migrations have been modified",
+				},
+				cli.BoolFlag{
+					Name:  "L, lock",
+					Usage: "lock the database so that only one process can migrate at a time",
+				},
+				cli.BoolFlag{
+					Name:  "v, verbose",
+					Usage: "print the sql of each migration before it is executed",
+				},
+				cli.BoolFlag{
+					Name:  "q, quiet",
+					Usage: "do not print the progress of each migration or the summary, only errors",
+				},
+				cli.BoolFlag{
+					Name:  "no-color",
+					Usage: "do not color the output even if stdout is a terminal",
+				},
 			},
 		},
 		{
@@ -142,114 +403,1692 @@ func main() {
 					Name:  "m, migrations",
 					Usage: "specify directory to look for migrations in (otherwise performs search)",
 				},
+				flatFlag,
 				cli.StringFlag{
 					Name:   "d, db",
 					Usage:  "the database uri to connect to",
 					EnvVar: "DATABASE_URL",
 				},
-				cli.IntFlag{
+				cli.StringFlag{
+					Name:   "t, table",
+					Usage:  "the name of the migrations table",
+					Value:  "migrations",
+					EnvVar: "TIDAL_TABLE",
+				},
+				cli.StringFlag{
+					Name:   "table-schema",
+					Usage:  "the schema that contains the migrations table (default: the current schema)",
+					EnvVar: "TIDAL_TABLE_SCHEMA",
+				},
+				cli.StringFlag{
 					Name:  "r, revision",
-					Usage: "specify a revision to rollback down to (otherwise rollsback all)",
-					Value: -1,
+					Usage: "specify a revision to rollback down to or -N to roll back the last N migrations (otherwise rollsback all)",
+				},
+				cli.StringFlag{
+					Name:  "n, name",
+					Usage: "specify the name of a migration to rollback down to instead of a revision",
 				},
 				cli.BoolFlag{
 					Name:  "D, debug",
 					Usage: "specify rollback actions without actually executing them",
 				},
+				cli.BoolFlag{
+					Name:  "validate",
+					Usage: "execute the rollback sql in a transaction that is always rolled back",
+				},
+				cli.BoolFlag{
+					Name:  "f, force",
+					Usage: "mark irreversible migrations as rolled back without executing any sql",
+				},
+				cli.BoolFlag{
+					Name:  "L, lock",
+					Usage: "lock the database so that it cannot be rolled back while another process migrates it",
+				},
+				cli.BoolFlag{
+					Name:  "y, yes",
+					Usage: "do not prompt for confirmation before rolling back (required if stdin is not a terminal)",
+				},
+				cli.BoolFlag{
+					Name:  "v, verbose",
+					Usage: "print the sql of each migration before it is executed",
+				},
+				cli.BoolFlag{
+					Name:  "q, quiet",
+					Usage: "do not print the progress of each migration or the summary, only errors",
+				},
+				cli.BoolFlag{
+					Name:  "no-color",
+					Usage: "do not color the output even if stdout is a terminal",
+				},
 			},
 		},
+		{
+			Name:      "sync",
+			Usage:     "mark migrations as applied without executing them",
+			UsageText: syncUsageText,
+			Action:    sync,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "m, migrations",
+					Usage: "specify directory to look for migrations in (otherwise performs search)",
+				},
+				flatFlag,
+				cli.StringFlag{
+					Name:   "d, db",
+					Usage:  "the database uri to connect to",
+					EnvVar: "DATABASE_URL",
+				},
+				cli.StringFlag{
+					Name:   "t, table",
+					Usage:  "the name of the migrations table",
+					Value:  "migrations",
+					EnvVar: "TIDAL_TABLE",
+				},
+				cli.StringFlag{
+					Name:   "table-schema",
+					Usage:  "the schema that contains the migrations table (default: the current schema)",
+					EnvVar: "TIDAL_TABLE_SCHEMA",
+				},
+				cli.IntFlag{
+					Name:  "r, revision",
+					Usage: "specify a revision to sync up to (otherwise syncs all)",
+					Value: -1,
+				},
+			},
+		},
+		{
+			Name:      "baseline",
+			Aliases:   []string{"stamp"},
+			Usage:     "adopt tidal on an existing database by marking migrations as applied",
+			UsageText: baselineUsageText,
+			Action:    baseline,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "m, migrations",
+					Usage: "specify directory to look for migrations in (otherwise performs search)",
+				},
+				flatFlag,
+				cli.StringFlag{
+					Name:   "d, db",
+					Usage:  "the database uri to connect to",
+					EnvVar: "DATABASE_URL",
+				},
+				cli.StringFlag{
+					Name:   "t, table",
+					Usage:  "the name of the migrations table",
+					Value:  "migrations",
+					EnvVar: "TIDAL_TABLE",
+				},
+				cli.StringFlag{
+					Name:   "table-schema",
+					Usage:  "the schema that contains the migrations table (default: the current schema)",
+					EnvVar: "TIDAL_TABLE_SCHEMA",
+				},
+				cli.IntFlag{
+					Name:  "r, revision",
+					Usage: "the revision that the existing schema is at (required)",
+				},
+			},
+		},
+		{
+			Name:      "force",
+			Usage:     "mark a revision as applied or pending without executing it",
+			UsageText: forceUsageText,
+			Action:    force,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "m, migrations",
+					Usage: "specify directory to look for migrations in (otherwise performs search)",
+				},
+				flatFlag,
+				cli.StringFlag{
+					Name:   "d, db",
+					Usage:  "the database uri to connect to",
+					EnvVar: "DATABASE_URL",
+				},
+				cli.StringFlag{
+					Name:   "t, table",
+					Usage:  "the name of the migrations table",
+					Value:  "migrations",
+					EnvVar: "TIDAL_TABLE",
+				},
+				cli.StringFlag{
+					Name:   "table-schema",
+					Usage:  "the schema that contains the migrations table (default: the current schema)",
+					EnvVar: "TIDAL_TABLE_SCHEMA",
+				},
+				cli.IntFlag{
+					Name:  "r, revision",
+					Usage: "the revision to mark as applied or pending (required)",
+				},
+				cli.BoolFlag{
+					Name:  "a, applied",
+					Usage: "mark the revision as applied (otherwise marks it as pending)",
+				},
+			},
+		},
+		{
+			Name:      "squash",
+			Usage:     "squash a range of migrations into a single migration",
+			UsageText: squashUsageText,
+			Action:    squash,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "m, migrations",
+					Usage: "specify directory to look for migrations in (otherwise performs search)",
+				},
+				cli.StringFlag{
+					Name:  "r, revisions",
+					Usage: "the range of revisions to squash, e.g. 1:10 (required)",
+				},
+			},
+		},
+		{
+			Name:      "show",
+			Usage:     "print the sql of a migration without executing it",
+			UsageText: showUsageText,
+			Action:    show,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "m, migrations",
+					Usage: "specify directory to look for migrations in (otherwise performs search)",
+				},
+				cli.IntFlag{
+					Name:  "r, revision",
+					Usage: "the revision of the migration to show",
+				},
+				cli.StringFlag{
+					Name:  "n, name",
+					Usage: "the name of the migration to show instead of a revision",
+				},
+				cli.BoolFlag{
+					Name:  "up",
+					Usage: "only print the up sql",
+				},
+				cli.BoolFlag{
+					Name:  "down",
+					Usage: "only print the down sql",
+				},
+				cli.BoolFlag{
+					Name:  "raw",
+					Usage: "include the directive comments of the migration file",
+				},
+				flatFlag,
+			},
+		},
+		{
+			Name:      "manifest",
+			Usage:     "write the checksums of the migrations to a manifest",
+			UsageText: manifestUsageText,
+			Action:    manifest,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "m, migrations",
+					Usage: "specify directory to look for migrations in (otherwise performs search)",
+				},
+				cli.StringFlag{
+					Name:  "o, out",
+					Usage: "location to write the manifest (default: stdout)",
+				},
+				flatFlag,
+			},
+		},
+		{
+			Name:      "export",
+			Usage:     "write embedded migration descriptors back to .sql files",
+			UsageText: exportUsageText,
+			Action:    export,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "o, out",
+					Usage: "the directory to write the migration files to (required)",
+				},
+				cli.StringFlag{
+					Name:  "m, migrations",
+					Usage: "specify directory to look for migrations in (otherwise performs search)",
+				},
+				cli.StringFlag{
+					Name:  "e, embedded",
+					Usage: "specify directory of .bin descriptors to export instead of migrations",
+				},
+				cli.BoolFlag{
+					Name:  "overwrite",
+					Usage: "replace migration files that already exist in the output directory",
+				},
+				flatFlag,
+			},
+		},
+		{
+			Name:      "verify",
+			Usage:     "verify the migrations, optionally against a manifest",
+			UsageText: verifyUsageText,
+			Action:    verify,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "m, migrations",
+					Usage: "specify directory to look for migrations in (otherwise performs search)",
+				},
+				cli.StringFlag{
+					Name:  "manifest",
+					Usage: "the manifest to verify the checksums of the migrations against",
+				},
+				flatFlag,
+			},
+		},
+		{
+			Name:      "lint",
+			Usage:     "check migration files for common mistakes",
+			UsageText: lintUsageText,
+			Action:    lint,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "m, migrations",
+					Usage: "specify directory to look for migrations in (otherwise performs search)",
+				},
+			},
+		},
+		{
+			Name:      "test",
+			Usage:     "check that each migration can be applied, rolled back, and applied again",
+			UsageText: testUsageText,
+			Action:    roundtrip,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "m, migrations",
+					Usage: "specify directory to look for migrations in (otherwise performs search)",
+				},
+				flatFlag,
+				cli.StringFlag{
+					Name:   "d, db",
+					Usage:  "the uri of a disposable database to test the migrations against",
+					EnvVar: "DATABASE_URL",
+				},
+				cli.StringFlag{
+					Name:   "t, table",
+					Usage:  "the name of the migrations table",
+					Value:  "migrations",
+					EnvVar: "TIDAL_TABLE",
+				},
+				cli.StringFlag{
+					Name:   "table-schema",
+					Usage:  "the schema that contains the migrations table (default: the current schema)",
+					EnvVar: "TIDAL_TABLE_SCHEMA",
+				},
+				cli.BoolFlag{
+					Name:  "compare-schema",
+					Usage: "check that the schema is the same after each migration is rolled back",
+				},
+				cli.BoolFlag{
+					Name:  "v, verbose",
+					Usage: "print the sql of each migration before it is executed",
+				},
+				cli.BoolFlag{
+					Name:  "q, quiet",
+					Usage: "do not print the progress of each migration, only errors",
+				},
+				cli.BoolFlag{
+					Name:  "no-color",
+					Usage: "do not color the output even if stdout is a terminal",
+				},
+			},
+		},
+		{
+			Name:   "version",
+			Usage:  "print the version of tidal",
+			Action: version,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "v, verbose",
+					Usage: "print the build metadata of the binary",
+				},
+			},
+		},
+	}
+	return app
+}
+
+func generate(c *cli.Context) (err error) {
+	var mdir string
+	if mdir, err = findMigrations(c); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	outpath := determineFileOutputPath(c)
+	packageName := c.String("package")
+	warnPadding(mdir, !c.Bool("flat"))
+
+	opts := tidal.GenerateOptions{
+		Embed:         c.Bool("embed"),
+		Recursive:     !c.Bool("flat"),
+		StripComments: c.Bool("strip-comments"),
+		Func:          c.String("func"),
+	}
+
+	if c.Bool("no-init") && opts.Func == "" {
+		opts.Func = "RegisterMigrations"
+	}
+
+	if err = tidal.Generate(mdir, outpath, packageName, opts); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	return nil
+}
+
+func create(c *cli.Context) (err error) {
+	var mdir string
+	if mdir, err = findMigrations(c); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	var opts tidal.CreateOptions
+	if path := c.String("template"); path != "" {
+		var data []byte
+		if data, err = ioutil.ReadFile(path); err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		opts.Template = string(data)
+	}
+
+	var path string
+	if path, err = tidal.Create(mdir, c.String("name"), c.String("package"), opts); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	fmt.Printf("created migration %s\n", path)
+	return nil
+}
+
+func revision(c *cli.Context) (err error) {
+	var mdir string
+	if mdir, err = findMigrations(c); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	if _, err = loadMigrations(c, mdir); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	var conn *sql.DB
+	if conn, err = connect(c); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	defer conn.Close()
+
+	var window tidal.StatusOptions
+	if window.Since, err = parseTime(c.String("since")); err != nil {
+		return cli.NewExitError(fmt.Errorf("could not parse --since: %s", err), 1)
+	}
+	if window.Until, err = parseTime(c.String("until")); err != nil {
+		return cli.NewExitError(fmt.Errorf("could not parse --until: %s", err), 1)
+	}
+
+	if c.Bool("pending") {
+		if !window.Since.IsZero() || !window.Until.IsZero() {
+			return cli.NewExitError("pending migrations have not been applied, --since and --until cannot be used with --pending", 1)
+		}
+		return pending(c, conn)
+	}
+
+	var current int
+	if current, err = tidal.CurrentRevision(conn); err != nil {
+		if errors.Is(err, tidal.ErrUninitialized) && !c.Bool("json") {
+			fmt.Println("database is uninitialized: the migrations table does not exist, run tidal migrate")
+			return nil
+		}
+		return cli.NewExitError(err, 1)
+	}
+
+	// Status returns an error along with the migrations if the database has unknown revisions
+	var status []tidal.Migration
+	if status, err = tidal.Status(conn, window); err != nil {
+		if status == nil {
+			return cli.NewExitError(err, 1)
+		}
+		fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+	}
+
+	// Display the detailed status of a single revision
+	if target := c.Int("revision"); target >= 0 {
+		for _, m := range status {
+			if m.Revision == target {
+				if c.Bool("json") {
+					return printJSON(m)
+				}
+
+				if err = printDetail(m); err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				return nil
+			}
+		}
+		return cli.NewExitError(fmt.Errorf("revision %d not found in %q", target, mdir), 1)
+	}
+
+	if c.Bool("json") {
+		return printJSON(status)
+	}
+
+	var stragglers []int
+	if stragglers, err = tidal.OutOfOrder(conn); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	fmt.Printf("database is at revision %d\n", current)
+	if !window.Since.IsZero() || !window.Until.IsZero() {
+		var bounds []string
+		if !window.Since.IsZero() {
+			bounds = append(bounds, "since "+timestamp(window.Since))
+		}
+		if !window.Until.IsZero() {
+			bounds = append(bounds, "until "+timestamp(window.Until))
+		}
+		fmt.Printf("%d migration(s) applied %s\n", len(status), strings.Join(bounds, " and "))
+	}
+	if len(stragglers) > 0 {
+		fmt.Printf("%d revision(s) below the current revision are pending, apply them with migrate --out-of-order\n", len(stragglers))
+	}
+	for _, m := range status {
+		if m.Dirty {
+			fmt.Printf("revision %d %s is dirty: it was interrupted while being applied or rolled back\n", m.Revision, m.Name)
+		}
+	}
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "REVISION\tNAME\tACTIVE\tAPPLIED\tELAPSED\tCREATED")
+	for _, m := range status {
+		// Data-only migrations do not change the revision that the database is at
+		name := m.Name
+		if !m.Versioned() {
+			name += " (data only)"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%t\t%s\t%s\t%s\n", m.Revision, name, m.Active, timestamp(m.Applied), elapsed(m), timestamp(m.Created))
+	}
+	return w.Flush()
+}
+
+func migrate(c *cli.Context) (err error) {
+	var mdir string
+	if mdir, err = findMigrations(c); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	if _, err = loadMigrations(c, mdir); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	var conn *sql.DB
+	if conn, err = connect(c); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	defer conn.Close()
+
+	var target int
+	if target, err = stepTarget(c, conn, 1); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	// The plan is computed without modifying the database
+	var steps []tidal.Step
+	if steps, err = tidal.PlanMigrate(conn, target); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	if c.Bool("debug") {
+		for _, step := range steps {
+			fmt.Printf("-- revision %d %s: %s\n%s\n", step.Revision, step.Direction, step.Name, step.SQL)
+		}
+		return nil
+	}
+
+	if c.Bool("validate") {
+		return validate(conn, steps)
+	}
+
+	report := reporter(c)
+	progress := track(report)
+	tidal.SetLogger(progress)
+	ctx, stop := interruptible()
+	defer stop()
+
+	opts := tidal.MigrateOptions{
+		Force:           c.Bool("force"),
+		Lock:            c.Bool("lock"),
+		Atomic:          c.Bool("atomic"),
+		OutOfOrder:      c.Bool("out-of-order"),
+		ContinueOnError: c.Bool("continue-on-error"),
+		AllowDirty:      c.Bool("allow-dirty"),
+	}
+
+	var result tidal.Result
+	if result, err = tidal.MigrateResultContext(ctx, conn, target, opts); err != nil {
+		if ierr := progress.interrupted(ctx); ierr != nil {
+			if report != nil {
+				report.Summary(result)
+			}
+			return cli.NewExitError(ierr, 130)
+		}
+
+		if errors.Is(err, tidal.ErrOutOfOrder) {
+			return cli.NewExitError(fmt.Errorf("%s (use --out-of-order to apply them)", err), 1)
+		}
+
+		if errors.Is(err, tidal.ErrDirty) {
+			return cli.NewExitError(fmt.Errorf("%s (use --allow-dirty to migrate once it has been checked)", err), 1)
+		}
+
+		// Report every failed revision on its own line when continuing on error
+		var errs tidal.MultiError
+		if errors.As(err, &errs) && opts.ContinueOnError {
+			for _, err := range errs {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			return cli.NewExitError(fmt.Sprintf("%d migration(s) failed, the database is partially migrated", len(errs)), 1)
+		}
+		return cli.NewExitError(err, 1)
+	}
+
+	if report != nil {
+		report.Summary(result)
+	}
+	return nil
+}
+
+func rollback(c *cli.Context) (err error) {
+	var mdir string
+	if mdir, err = findMigrations(c); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	var migrations []tidal.Migration
+	if migrations, err = loadMigrations(c, mdir); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	var conn *sql.DB
+	if conn, err = connect(c); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	defer conn.Close()
+
+	var active map[int]bool
+	if active, err = activeRevisions(conn); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	// Rolling back all the way means rolling back to revision 0, the migrations table
+	var target int
+	if target, err = stepTarget(c, conn, -1); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	if target < 0 {
+		target = 0
+	}
+
+	pending := make([]tidal.Migration, 0)
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Revision <= target {
+			break
+		}
+
+		if active[m.Revision] {
+			pending = append(pending, m)
+		}
+	}
+
+	if c.Bool("debug") {
+		for _, m := range pending {
+			var query string
+			if m.Irreversible() {
+				fmt.Printf("-- revision %d down: %s is irreversible\n", m.Revision, m.Name)
+				continue
+			}
+
+			if query, err = m.DownSQL(); err != nil {
+				return cli.NewExitError(err, 1)
+			}
+			fmt.Printf("-- revision %d down: %s\n%s\n", m.Revision, m.Name, query)
+		}
+		return nil
+	}
+
+	if c.Bool("validate") {
+		var steps []tidal.Step
+		if steps, err = tidal.Plan(conn, target); err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		return validate(conn, steps)
+	}
+
+	if len(pending) > 0 && !c.Bool("yes") {
+		var ok bool
+		if ok, err = confirmRollback(pending); err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		if !ok {
+			return cli.NewExitError("rollback aborted", 1)
+		}
+	}
+
+	report := reporter(c)
+	progress := track(report)
+	tidal.SetLogger(progress)
+	ctx, stop := interruptible()
+	defer stop()
+
+	var result tidal.Result
+	if result, err = tidal.RollbackResultContext(ctx, conn, target, tidal.RollbackOptions{Force: c.Bool("force"), Lock: c.Bool("lock")}); err != nil {
+		if ierr := progress.interrupted(ctx); ierr != nil {
+			if report != nil {
+				report.Summary(result)
+			}
+			return cli.NewExitError(ierr, 130)
+		}
+
+		if errors.Is(err, tidal.ErrIrreversible) {
+			return cli.NewExitError(fmt.Errorf("%s (use --force to mark it as rolled back)", err), 1)
+		}
+		return cli.NewExitError(err, 1)
+	}
+
+	if report != nil {
+		report.Summary(result)
+	}
+	return nil
+}
+
+// Returns the reporter that prints the progress of each migration to stdout as it is
+// applied or rolled back, or nil if the --quiet flag is specified. Colors are disabled
+// by the --no-color flag or if stdout is not a terminal, e.g. when piped.
+func reporter(c *cli.Context) *tidal.Reporter {
+	if c.Bool("quiet") {
+		return nil
+	}
+
+	report := tidal.NewReporter(os.Stdout, tidal.ReporterOptions{
+		NoColor: c.Bool("no-color"),
+		Verbose: c.Bool("verbose"),
+	})
+	return report
+}
+
+// Returns a context that is cancelled when the process receives SIGINT or SIGTERM, e.g.
+// if the user hits Ctrl-C, so that the transaction of the migration in progress is
+// rolled back rather than abandoned on a half-open connection. The signals are only
+// handled once, a second interrupt exits immediately.
+func interruptible() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// progress is a tidal.Logger that records the migration in progress so that it can be
+// reported if the command is interrupted, forwarding each event to the reporter unless
+// the output is quiet.
+type progress struct {
+	report    *tidal.Reporter
+	running   bool
+	revision  int
+	name      string
+	direction string
+}
+
+// helper utility to track the migration in progress, forwarding events to the reporter
+func track(report *tidal.Reporter) *progress {
+	return &progress{report: report}
+}
+
+func (p *progress) Start(revision int, name, direction, sql string) {
+	p.running, p.revision, p.name, p.direction = true, revision, name, direction
+	if p.report != nil {
+		p.report.Start(revision, name, direction, sql)
+	}
+}
+
+// A migration that fails is still in progress for the purpose of reporting interrupts.
+func (p *progress) Finish(revision int, name, direction string, elapsed time.Duration, err error) {
+	if err == nil {
+		p.running = false
+	}
+
+	if p.report != nil {
+		p.report.Finish(revision, name, direction, elapsed, err)
+	}
+}
+
+// Returns the error to exit with if the context was cancelled by an interrupt, which
+// describes the revision that was in progress, or nil if the command was not interrupted.
+func (p *progress) interrupted(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+
+	if !p.running {
+		return errors.New("interrupted, no migration was in progress")
+	}
+
+	// Repeatable migrations are reported with the negative revision they are recorded
+	// with but are registered without a revision, so they are found by name instead
+	label := fmt.Sprintf("revision %d %s: %s", p.revision, p.direction, p.name)
+	migrations := tidal.Migrations()
+	if p.revision < 0 {
+		label = "repeatable migration " + p.name
+		migrations = tidal.Repeatables()
+	}
+
+	for _, m := range migrations {
+		if (p.revision < 0 && m.Name == p.name || p.revision >= 0 && m.Revision == p.revision) && !m.Transactional() {
+			return fmt.Errorf("interrupted %s, it is not transactional and may be partially applied, check the database and use tidal force to mark its state", label)
+		}
+	}
+
+	if dialect := tidal.CurrentDialect(); !dialect.TransactionalDDL() {
+		return fmt.Errorf("interrupted %s, its transaction was rolled back but %s commits schema changes implicitly so it may be partially applied, check the database and use tidal force to mark its state", label, dialect.Name())
+	}
+	return fmt.Errorf("interrupted %s, its transaction was rolled back", label)
+}
+
+func baseline(c *cli.Context) (err error) {
+	target := c.Int("revision")
+	if target < 1 {
+		return cli.NewExitError("specify the revision of the existing schema with -r", 1)
+	}
+
+	var mdir string
+	if mdir, err = findMigrations(c); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	var migrations []tidal.Migration
+	if migrations, err = loadMigrations(c, mdir); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	var conn *sql.DB
+	if conn, err = connect(c); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	defer conn.Close()
+
+	if err = tidal.Baseline(conn, target); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	for _, m := range migrations {
+		if m.Revision > target {
+			break
+		}
+		fmt.Printf("baselined revision %d: %s\n", m.Revision, m.Name)
+	}
+	fmt.Printf("database is now at revision %d\n", target)
+	return nil
+}
+
+func force(c *cli.Context) (err error) {
+	target := c.Int("revision")
+	if target < 1 {
+		return cli.NewExitError("specify the revision to force with -r", 1)
+	}
+
+	var mdir string
+	if mdir, err = findMigrations(c); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	if _, err = loadMigrations(c, mdir); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	var conn *sql.DB
+	if conn, err = connect(c); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	defer conn.Close()
+
+	if err = tidal.Force(conn, target, c.Bool("applied")); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	if c.Bool("applied") {
+		fmt.Printf("marked revision %d as applied\n", target)
+	} else {
+		fmt.Printf("marked revision %d as pending\n", target)
+	}
+	return nil
+}
+
+// Squashes the range of revisions in the migrations directory into a single migration.
+func squash(c *cli.Context) (err error) {
+	var from, to int
+	if from, to, err = parseRange(c.String("revisions")); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	var mdir string
+	if mdir, err = findMigrations(c); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	var m tidal.Migration
+	if m, err = tidal.Squash(mdir, from, to); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	fmt.Printf("squashed revisions %d through %d into revision %d %s\n", from, to, m.Revision, m.Name)
+	return nil
+}
+
+// Lists the migrations that have not been applied to the database.
+func pending(c *cli.Context, conn *sql.DB) (err error) {
+	var migrations []tidal.Migration
+	if migrations, err = tidal.Pending(conn); err != nil {
+		if migrations == nil {
+			return cli.NewExitError(err, 1)
+		}
+		fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+	}
+
+	if c.Bool("json") {
+		return printJSON(migrations)
+	}
+
+	if len(migrations) == 0 {
+		fmt.Println("no pending migrations, the database is up to date")
+		return nil
+	}
+
+	fmt.Printf("%d migration(s) pending\n\n", len(migrations))
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "REVISION\tNAME\tCREATED")
+	for _, m := range migrations {
+		fmt.Fprintf(w, "%d\t%s\t%s\n", m.Revision, m.Name, timestamp(m.Created))
+	}
+	return w.Flush()
+}
+
+// Prints the up and/or down sql of a migration without connecting to the database.
+func show(c *cli.Context) (err error) {
+	if !c.IsSet("revision") && c.String("name") == "" {
+		return cli.NewExitError("specify the migration to show with -r REVISION or -n NAME", 1)
+	}
+
+	if c.Bool("up") && c.Bool("down") {
+		return cli.NewExitError("specify either --up or --down, not both", 1)
+	}
+
+	var mdir string
+	if mdir, err = findMigrations(c); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	var migrations []tidal.Migration
+	if migrations, err = loadMigrations(c, mdir); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	var target int
+	if target, err = targetRevision(c); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	var m *tidal.Migration
+	for i := range migrations {
+		if migrations[i].Revision == target {
+			m = &migrations[i]
+			break
+		}
+	}
+
+	if m == nil {
+		return cli.NewExitError(fmt.Sprintf("revision %d not found in %q", target, mdir), 1)
+	}
+
+	// Print the entire migration file unless only one direction is requested
+	directions := []string{"up", "down"}
+	switch {
+	case c.Bool("up"):
+		directions = directions[:1]
+	case c.Bool("down"):
+		directions = directions[1:]
+	case c.Bool("raw"):
+		var src string
+		if src, err = m.Source(); err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		fmt.Print(src)
+		return nil
+	}
+
+	for _, direction := range directions {
+		var query string
+		if direction == "up" {
+			query, err = m.UpSQL()
+		} else {
+			query, err = m.DownSQL()
+		}
+
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("could not parse revision %d %s sql: %s", m.Revision, direction, err), 1)
+		}
+
+		switch {
+		case c.Bool("raw"):
+			fmt.Println(strings.Join(append([]string{"-- migrate:", direction}, m.Options(direction)...), " "))
+		case len(directions) > 1:
+			fmt.Printf("-- revision %d %s: %s\n", m.Revision, m.Name, direction)
+		}
+		fmt.Print(query)
+	}
+	return nil
+}
+
+// Writes the manifest of the migrations to stdout or the output file.
+func manifest(c *cli.Context) (err error) {
+	var mdir string
+	if mdir, err = findMigrations(c); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	if _, err = loadMigrations(c, mdir); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	out := c.String("out")
+	if out == "" {
+		if err = tidal.WriteManifest(os.Stdout); err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		return nil
+	}
+
+	var f *os.File
+	if f, err = os.Create(out); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	if err = tidal.WriteManifest(f); err != nil {
+		f.Close()
+		return cli.NewExitError(err, 1)
+	}
+
+	if err = f.Close(); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	fmt.Fprintf(os.Stderr, "wrote manifest of %d migrations to %s\n", len(tidal.Migrations()), out)
+	return nil
+}
+
+// Writes the migrations or embedded descriptors back to .sql files in the output directory.
+func export(c *cli.Context) (err error) {
+	out := c.String("out")
+	if out == "" {
+		return cli.NewExitError("specify the directory to export the migrations to with -o DIR", 1)
+	}
+
+	if c.String("embedded") != "" && c.String("migrations") != "" {
+		return cli.NewExitError("specify either -m or -e, not both", 1)
+	}
+
+	if edir := c.String("embedded"); edir != "" {
+		if err = loadDescriptors(edir); err != nil {
+			return cli.NewExitError(err, 1)
+		}
+	} else {
+		var mdir string
+		if mdir, err = findMigrations(c); err != nil {
+			return cli.NewExitError(err, 1)
+		}
+
+		if _, err = loadMigrations(c, mdir); err != nil {
+			return cli.NewExitError(err, 1)
+		}
+	}
+
+	var paths []string
+	if paths, err = tidal.Export(out, tidal.ExportOptions{Overwrite: c.Bool("overwrite")}); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	for _, path := range paths {
+		fmt.Println(path)
+	}
+	fmt.Fprintf(os.Stderr, "exported %d migrations to %s\n", len(paths), out)
+	return nil
+}
+
+// helper utility to register the compressed .bin descriptors in the specified directory
+// that were written by generating the migrations with --embed.
+func loadDescriptors(dir string) (err error) {
+	var paths []string
+	if paths, err = filepath.Glob(filepath.Join(dir, "*.bin")); err != nil {
+		return err
+	}
+
+	if len(paths) == 0 {
+		return fmt.Errorf("no descriptors found in %q", dir)
+	}
+
+	for _, path := range paths {
+		var data []byte
+		if data, err = ioutil.ReadFile(path); err != nil {
+			return err
+		}
+
+		if err = tidal.RegisterDescriptor(data); err != nil {
+			return fmt.Errorf("could not register descriptor %s: %s", path, err)
+		}
+	}
+	return nil
+}
+
+// Verifies the migrations and, if specified, compares them to the manifest.
+func verify(c *cli.Context) (err error) {
+	var mdir string
+	if mdir, err = findMigrations(c); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	var migrations []tidal.Migration
+	if migrations, err = loadMigrations(c, mdir); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	if err = tidal.Verify(); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	if path := c.String("manifest"); path != "" {
+		var f *os.File
+		if f, err = os.Open(path); err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		defer f.Close()
+
+		if err = tidal.VerifyManifest(f); err != nil {
+			// Report every difference from the manifest on its own line
+			var errs tidal.MultiError
+			if errors.As(err, &errs) {
+				for _, err := range errs {
+					fmt.Fprintln(os.Stderr, err)
+				}
+				return cli.NewExitError(fmt.Sprintf("%d migration(s) do not match the manifest %s", len(errs), path), 1)
+			}
+			return cli.NewExitError(err, 1)
+		}
+
+		fmt.Printf("verified %d migrations against %s\n", len(migrations), path)
+		return nil
+	}
+
+	fmt.Printf("verified %d migrations\n", len(migrations))
+	return nil
+}
+
+// Reports the issues found in the migration files and exits with an error if any.
+func lint(c *cli.Context) (err error) {
+	var mdir string
+	if mdir, err = findMigrations(c); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	var issues []tidal.LintIssue
+	if issues, err = tidal.Lint(mdir); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+
+	if len(issues) > 0 {
+		return cli.NewExitError(fmt.Sprintf("found %d issue(s) in %q", len(issues), mdir), 1)
+	}
+
+	fmt.Printf("no issues found in %q\n", mdir)
+	return nil
+}
+
+// Applies, rolls back, and reapplies each migration in the migrations directory.
+func roundtrip(c *cli.Context) (err error) {
+	var mdir string
+	if mdir, err = findMigrations(c); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	warnPadding(mdir, !c.Bool("flat"))
+
+	var conn *sql.DB
+	if conn, err = connect(c); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	defer conn.Close()
+
+	report := reporter(c)
+	if report != nil {
+		tidal.SetLogger(report)
+	}
+
+	opts := tidal.RoundTripOptions{
+		CompareSchema: c.Bool("compare-schema"),
+		Recursive:     !c.Bool("flat"),
+	}
+
+	if err = tidal.TestRoundTrip(conn, mdir, opts); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	if report != nil {
+		fmt.Printf("every migration in %q was applied, rolled back, and applied again\n", mdir)
+	}
+	return nil
+}
+
+// Parses a FROM:TO range of revisions.
+func parseRange(s string) (from, to int, err error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, 0, errors.New("specify the range of revisions to squash with -r FROM:TO")
+	}
+
+	if from, err = strconv.Atoi(parts[0]); err != nil {
+		return 0, 0, fmt.Errorf("could not parse revision %q: %s", parts[0], err)
+	}
+
+	if to, err = strconv.Atoi(parts[1]); err != nil {
+		return 0, 0, fmt.Errorf("could not parse revision %q: %s", parts[1], err)
+	}
+	return from, to, nil
+}
+
+func version(c *cli.Context) (err error) {
+	if !c.Bool("verbose") {
+		fmt.Println(tidal.Version())
+		return nil
+	}
+
+	build := "dev"
+	if tidal.Release() {
+		build = "release"
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Version:\t%d.%d.%d\n", tidal.VersionMajor, tidal.VersionMinor, tidal.VersionPatch)
+	fmt.Fprintf(w, "Build:\t%s\n", build)
+	fmt.Fprintf(w, "Commit:\t%s\n", unknown(tidal.GitCommit))
+	fmt.Fprintf(w, "Date:\t%s\n", unknown(tidal.BuildDate))
+	fmt.Fprintf(w, "Go:\t%s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return w.Flush()
+}
+
+// Returns unknown if the build metadata was not set at build time.
+func unknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// Executes the sql of the steps without committing it and reports the results.
+func validate(conn *sql.DB, steps []tidal.Step) (err error) {
+	if err = tidal.Validate(conn, steps); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	for _, step := range steps {
+		if !step.Transactional {
+			fmt.Printf("skipped revision %d %s: %s (notransaction)\n", step.Revision, step.Direction, step.Name)
+			continue
+		}
+		fmt.Printf("validated revision %d %s: %s\n", step.Revision, step.Direction, step.Name)
+	}
+	return nil
+}
+
+// Lists the migrations that are about to be rolled back and prompts the user to confirm
+// the rollback on stdin. If stdin is not a terminal the rollback cannot be confirmed
+// interactively, so an error is returned that instructs the user to pass -y instead.
+func confirmRollback(pending []tidal.Migration) (ok bool, err error) {
+	var info os.FileInfo
+	if info, err = os.Stdin.Stat(); err != nil {
+		return false, err
+	}
+
+	if info.Mode()&os.ModeCharDevice == 0 {
+		return false, errors.New("stdin is not a terminal, use --yes to confirm the rollback")
+	}
+
+	fmt.Println("the following revisions will be rolled back:")
+	for _, m := range pending {
+		fmt.Printf("  revision %d: %s\n", m.Revision, m.Name)
+	}
+	fmt.Print("continue? [y/N] ")
+
+	var answer string
+	if answer, err = bufio.NewReader(os.Stdin).ReadString('\n'); err != nil && err != io.EOF {
+		return false, err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+func sync(c *cli.Context) (err error) {
+	var mdir string
+	if mdir, err = findMigrations(c); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	var migrations []tidal.Migration
+	if migrations, err = loadMigrations(c, mdir); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	var conn *sql.DB
+	if conn, err = connect(c); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	defer conn.Close()
+
+	var active map[int]bool
+	if active, err = activeRevisions(conn); err != nil {
+		// The migrations table does not exist yet, it is created by tidal.Sync
+		active = make(map[int]bool)
+	}
+
+	target := c.Int("revision")
+	if err = tidal.Sync(conn, target); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	for _, m := range migrations {
+		if target >= 0 && m.Revision > target {
+			break
+		}
+
+		if !active[m.Revision] {
+			fmt.Printf("synced revision %d: %s\n", m.Revision, m.Name)
+		}
+	}
+	return nil
+}
+
+// Returns the target revision of a relative step argument, e.g. migrate +1 or rollback
+// -1, if one is specified, otherwise the target revision of the flags. The sign is the
+// direction of the command; the step must have exactly one leading + for migrate or -
+// for rollback.
+func stepTarget(c *cli.Context, conn *sql.DB, sign int) (int, error) {
+	if c.NArg() == 0 {
+		return relativeTarget(c, conn, sign)
+	}
+
+	if c.IsSet("revision") || c.IsSet("name") {
+		return 0, errors.New("specify either a number of steps or a revision, not both")
+	}
+
+	arg := c.Args().First()
+	if !steparg.MatchString(arg) || (arg[0] == '+') != (sign > 0) {
+		if sign > 0 {
+			return 0, fmt.Errorf("could not parse %q as a number of migrations to step, specify +N to apply the next N migrations", arg)
+		}
+		return 0, fmt.Errorf("could not parse %q as a number of migrations to step, specify -N to roll back the last N migrations", arg)
+	}
+
+	steps, err := strconv.Atoi(arg[1:])
+	if err != nil || steps < 1 {
+		return 0, fmt.Errorf("could not parse %q as a number of migrations to step", arg)
+	}
+	return tidal.StepRevision(conn, sign*steps)
+}
+
+// Matches a relative step argument, e.g. +1 or -1.
+var steparg = regexp.MustCompile(`^[+-]\d+$`)
+
+// Moves a relative step argument of the migrate or rollback command (or their up and
+// down aliases) after a -- terminator, e.g. tidal rollback -y -1 becomes tidal rollback
+// -y -- -1, so that a negative step is parsed as a positional argument rather than an
+// undefined flag. Arguments that are the value of a flag, e.g. -r -1, are not moved.
+func stepArgs(app *cli.App, args []string) []string {
+	cmd, i := commandArg(app, args)
+	if cmd == nil || (cmd.Name != "migrate" && cmd.Name != "rollback") {
+		return args
+	}
+
+	end := len(args)
+	for j := i + 1; j < len(args); j++ {
+		if args[j] == "--" {
+			end = j
+			break
+		}
+	}
+
+	values := valueFlags(cmd.Flags)
+	for i++; i < end; i++ {
+		switch {
+		case values[args[i]]:
+			i++
+		case steparg.MatchString(args[i]):
+			out := append([]string{}, args[:i]...)
+			out = append(out, args[i+1:end]...)
+			out = append(out, "--", args[i])
+			if end < len(args) {
+				out = append(out, args[end+1:]...)
+			}
+			return out
+		}
+	}
+	return args
+}
+
+// Returns the command specified by the arguments and its index, skipping the global
+// flags and their values, or nil if no command is specified.
+func commandArg(app *cli.App, args []string) (*cli.Command, int) {
+	values := valueFlags(app.Flags)
+	for i := 1; i < len(args); i++ {
+		switch {
+		case values[args[i]]:
+			i++
+		case strings.HasPrefix(args[i], "-"):
+			continue
+		default:
+			return app.Command(args[i]), i
+		}
+	}
+	return nil, 0
+}
+
+// Returns the command line forms of the flags that take a value, e.g. -d and --db.
+func valueFlags(flags []cli.Flag) map[string]bool {
+	values := make(map[string]bool)
+	for _, flag := range flags {
+		if _, ok := flag.(cli.BoolFlag); ok {
+			continue
+		}
+
+		for _, name := range strings.Split(flag.GetName(), ",") {
+			name = strings.TrimSpace(name)
+			values["-"+name] = true
+			values["--"+name] = true
+		}
+	}
+	return values
+}
+
+// Returns the target revision of the -r flag of the migrate (sign 1) or rollback (sign -1)
+// command, which is either an absolute revision or relative to the current revision of
+// the database, e.g. -r +2 applies the next two migrations and -r -1 rolls back the last
+// migration. If the revision is not specified the target is -1 (all migrations); to
+// remain compatible with the previous integer flag, -r -1 also applies all migrations.
+func relativeTarget(c *cli.Context, conn *sql.DB, sign int) (int, error) {
+	rev := strings.TrimSpace(c.String("revision"))
+	if name := c.String("name"); name != "" {
+		if rev != "" {
+			return 0, errors.New("specify either a revision or a name, not both")
+		}
+		return tidal.Lookup(name)
+	}
+
+	switch {
+	case rev == "":
+		return -1, nil
+	case rev == "-1" && sign > 0:
+		return -1, nil
+	case strings.HasPrefix(rev, "+") || strings.HasPrefix(rev, "-"):
+		steps, err := strconv.Atoi(rev[1:])
+		if err != nil || steps < 1 {
+			return 0, fmt.Errorf("could not parse %q as a relative revision", rev)
+		}
+
+		if (rev[0] == '+') != (sign > 0) {
+			if sign > 0 {
+				return 0, fmt.Errorf("cannot migrate to relative revision %s, specify -r +N to apply the next N migrations", rev)
+			}
+			return 0, fmt.Errorf("cannot roll back to relative revision %s, specify -r -N to roll back the last N migrations", rev)
+		}
+		return tidal.StepRevision(conn, sign*steps)
+	default:
+		target, err := strconv.Atoi(rev)
+		if err != nil || target < 0 {
+			return 0, fmt.Errorf("could not parse %q as a revision", rev)
+		}
+		return target, nil
+	}
+}
+
+// helper utility to determine the target revision from the revision or name flags
+func targetRevision(c *cli.Context) (int, error) {
+	name := c.String("name")
+	if name == "" {
+		return c.Int("revision"), nil
+	}
+
+	if c.IsSet("revision") {
+		return 0, errors.New("specify either a revision or a name, not both")
+	}
+	return tidal.Lookup(name)
+}
+
+// helper utility to search for migrations directory
+func findMigrations(c *cli.Context) (path string, err error) {
+	if path = c.String("migrations"); path != "" {
+		return path, nil
+	}
+
+	var cwd string
+	if cwd, err = os.Getwd(); err != nil {
+		return "", err
+	}
+
+	dirs := make([]string, 0)
+	if err = filepath.Walk(cwd, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Unreadable subdirectories cannot contain the migrations directory we can use
+			if path != cwd && errors.Is(err, fs.ErrPermission) {
+				fmt.Fprintf(os.Stderr, "skipping unreadable directory %s\n", path)
+				return filepath.SkipDir
+			}
+			return &tidal.DirectoryError{Path: path, Err: err}
+		}
+
+		if info.IsDir() && path != cwd {
+			basename := strings.ToLower(info.Name())
+			if basename == "migrations" {
+				dirs = append(dirs, path)
+				return nil
+			}
+
+			if strings.HasPrefix(basename, ".") || strings.HasPrefix(basename, "~") || ignoreDirs[basename] {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	}); err != nil {
+		return "", err
+	}
+
+	switch len(dirs) {
+	case 0:
+		fmt.Fprintln(os.Stderr, "no migrations directory found, using the current working directory")
+		return ".", nil
+	case 1:
+		return filepath.Rel(cwd, dirs[0])
+	default:
+		return "", fmt.Errorf("discovered %d migrations directories, please specify which one to use", len(dirs))
+	}
+}
+
+// helper utility to open and register all migrations in the specified directory,
+// returning the migrations sorted by revision.
+func loadMigrations(c *cli.Context, dir string) (migrations []tidal.Migration, err error) {
+	warnPadding(dir, !c.Bool("flat"))
+	if err = tidal.RegisterDir(dir, tidal.RegisterOptions{Recursive: !c.Bool("flat")}); err != nil {
+		return nil, err
+	}
+
+	if migrations = tidal.Migrations(); len(migrations) == 0 {
+		return nil, fmt.Errorf("no migrations found in %q", dir)
+	}
+	return migrations, nil
+}
+
+// helper utility to warn if the revision prefixes of the migration filenames that are
+// loaded from the directory have different widths, e.g. 1_foo.sql and 0002_bar.sql or
+// 2_foo.sql and 10_bar.sql, since sorting the files by name will not match the order of
+// the revisions.
+func warnPadding(dir string, recursive bool) {
+	paths, _ := tidal.MigrationFiles(dir, tidal.RegisterOptions{Recursive: recursive})
+
+	widths := make(map[int]bool)
+	width := 0
+	for _, path := range paths {
+		// Repeatable migrations do not have a revision prefix
+		base := filepath.Base(path)
+		n := len(base) - len(strings.TrimLeft(base, "0123456789"))
+		if n == 0 {
+			continue
+		}
+
+		widths[n] = true
+		if n > width {
+			width = n
+		}
+	}
+
+	if len(widths) > 1 {
+		fmt.Fprintf(os.Stderr, "warning: migrations in %q mix revision widths, pad all revisions to %d digits so they sort by filename\n", dir, width)
+	}
+}
+
+// helper utility to connect to the database specified by the user
+func connect(c *cli.Context) (conn *sql.DB, err error) {
+	uri := c.String("db")
+	if uri == "" {
+		return nil, fmt.Errorf("specify a database uri with -d or $DATABASE_URL")
+	}
+
+	if err = tidal.SetTableName(c.String("table")); err != nil {
+		return nil, err
+	}
+
+	if err = tidal.SetTableSchema(c.String("table-schema")); err != nil {
+		return nil, err
+	}
+
+	// Report which database could not be reached without leaking its password
+	if conn, err = tidal.Connect(uri); err != nil {
+		return nil, fmt.Errorf("%s: %s", tidal.RedactURL(uri), err)
+	}
+	return conn, nil
+}
+
+// helper utility to return the revisions that are currently active in the database
+func activeRevisions(conn *sql.DB) (active map[int]bool, err error) {
+	// Status returns an error along with the migrations if the database has unknown
+	// revisions, which can be ignored when determining which revisions are active.
+	var status []tidal.Migration
+	if status, err = tidal.Status(conn); err != nil && status == nil {
+		return nil, err
+	}
+
+	active = make(map[int]bool)
+	for _, m := range status {
+		if m.Active {
+			active[m.Revision] = true
+		}
+	}
+	return active, nil
+}
+
+// helper utility to print the detailed status of a single migration
+func printDetail(m tidal.Migration) (err error) {
+	var upsql, downsql string
+	if upsql, err = m.UpSQL(); err != nil {
+		return err
+	}
+	if downsql, err = m.DownSQL(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Revision: %d\nName:     %s\n", m.Revision, m.Name)
+	fmt.Printf("Active:   %t\nApplied:  %s\nElapsed:  %s\nCreated:  %s\n", m.Active, timestamp(m.Applied), elapsed(m), timestamp(m.Created))
+	if m.Dirty {
+		fmt.Println("Dirty:    true")
+	}
+
+	if meta := m.Meta(); len(meta) > 0 {
+		keys := make([]string, 0, len(meta))
+		for key := range meta {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		fmt.Println("Meta:")
+		for _, key := range keys {
+			fmt.Printf("  %s: %s\n", key, meta[key])
+		}
+	}
+	fmt.Printf("\n-- migrate: up\n%s\n-- migrate: down\n%s", upsql, downsql)
+	return nil
+}
+
+// helper utility to print the value as indented JSON
+func printJSON(v interface{}) (err error) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(v); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	return nil
+}
+
+// helper utility to format a timestamp for display
+func timestamp(ts time.Time) string {
+	if ts.IsZero() {
+		return "-"
+	}
+	return ts.Local().Format(time.RFC3339)
+}
+
+// Parses the time of the --since and --until flags, which is either an RFC3339
+// timestamp, a date and time or date in local time, or a duration before now, e.g. 72h.
+// An empty string returns the zero time, which does not limit the status window.
+func parseTime(s string) (ts time.Time, err error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if ts, err = time.Parse(time.RFC3339, s); err == nil {
+		return ts, nil
+	}
+
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+		if ts, err = time.ParseInLocation(layout, s, time.Local); err == nil {
+			return ts, nil
+		}
+	}
+
+	var ago time.Duration
+	if ago, err = time.ParseDuration(s); err == nil && ago >= 0 {
+		return time.Now().Add(-ago), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date, timestamp, or duration", s)
+}
+
+// helper utility to format the time it took to apply a migration for display
+func elapsed(m tidal.Migration) string {
+	if !m.Active || m.Elapsed == 0 {
+		return "-"
+	}
+	if m.Elapsed < time.Millisecond {
+		return m.Elapsed.String()
 	}
-
-	// Run the program, it should not error
-	if err := app.Run(os.Args); err != nil {
-		panic(err)
-	}
-}
-
-func generate(c *cli.Context) (err error) {
-	var mdir string
-	if mdir, err = findMigrations(c); err != nil {
-		return cli.NewExitError(err, 1)
-	}
-
-	outpath := determineFileOutputPath(c)
-	packageName := c.String("package")
-
-	if err = tidal.Generate(mdir, outpath, packageName); err != nil {
-		return cli.NewExitError(err, 1)
-	}
-	return nil
-}
-
-func create(c *cli.Context) (err error) {
-	var mdir string
-	if mdir, err = findMigrations(c); err != nil {
-		return cli.NewExitError(err, 1)
-	}
-
-	if err = tidal.Create(mdir, c.String("name"), c.String("package")); err != nil {
-		return cli.NewExitError(err, 1)
-	}
-
-	return nil
-}
-
-func revision(c *cli.Context) (err error) {
-	fmt.Println("rvision")
-	return nil
-}
-
-func migrate(c *cli.Context) (err error) {
-	fmt.Println("migrate")
-	return nil
-}
-
-func rollback(c *cli.Context) (err error) {
-	fmt.Println("migrate")
-	return nil
-}
-
-// helper utility to search for migrations directory
-func findMigrations(c *cli.Context) (path string, err error) {
-	if path = c.String("migrations"); path != "" {
-		return path, nil
-	}
-
-	var cwd string
-	if cwd, err = os.Getwd(); err != nil {
-		return "", err
-	}
-
-	dirs := make([]string, 0)
-	if err = filepath.Walk(cwd, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if info.IsDir() {
-			basename := strings.ToLower(info.Name())
-			if basename == "migrations" {
-				dirs = append(dirs, path)
-				return nil
-			}
-
-			if strings.HasPrefix(basename, ".") || strings.HasPrefix(basename, "~") {
-				return filepath.SkipDir
-			}
-		}
-		return nil
-	}); err != nil {
-		return "", err
-	}
-
-	switch len(dirs) {
-	case 0:
-		return filepath.Rel(cwd, cwd)
-	case 1:
-		return filepath.Rel(cwd, dirs[0])
-	default:
-		return "", fmt.Errorf("discovered %d migrations directories, please specify which one to use", len(dirs))
-	}
+	return m.Elapsed.Round(time.Millisecond).String()
 }
 
 // If outpath is a go file, e.g. ends in .go - simply write it to that file. Otherwise,
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/rotationalio/tidal"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func TestStepArgs(t *testing.T) {
+	app := newApp()
+	tests := []struct {
+		args     string
+		expected string
+	}{
+		{"tidal rollback -1", "tidal rollback -- -1"},
+		{"tidal down -1", "tidal down -- -1"},
+		{"tidal rollback -y -1", "tidal rollback -y -- -1"},
+		{"tidal rollback -2 -y", "tidal rollback -y -- -2"},
+		{"tidal rollback --db x -1", "tidal rollback --db x -- -1"},
+		{"tidal rollback -d sqlite://dev.db -1 -y", "tidal rollback -d sqlite://dev.db -y -- -1"},
+		{"tidal up +2 -L", "tidal up -L -- +2"},
+		{"tidal -m migrations rollback -1", "tidal -m migrations rollback -- -1"},
+		{"tidal rollback -1 -- extra", "tidal rollback -- -1 extra"},
+
+		// Flag values and arguments that are not steps are not moved
+		{"tidal rollback -r -1", "tidal rollback -r -1"},
+		{"tidal rollback --revision -1 -y", "tidal rollback --revision -1 -y"},
+		{"tidal rollback -- -1", "tidal rollback -- -1"},
+		{"tidal rollback 3", "tidal rollback 3"},
+		{"tidal rollback --3", "tidal rollback --3"},
+		{"tidal revision -1", "tidal revision -1"},
+		{"tidal -m rollback -1", "tidal -m rollback -1"},
+		{"tidal", "tidal"},
+	}
+
+	for _, tc := range tests {
+		require.Equal(t, strings.Fields(tc.expected), stepArgs(app, strings.Fields(tc.args)), "unexpected args for %q", tc.args)
+	}
+}
+
+func TestRelativeTarget(t *testing.T) {
+	defer tidal.Reset()
+	defer tidal.SetDialect(nil)
+
+	conn, err := tidal.Connect("sqlite://:memory:")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	for i, name := range []string{"0001_create_users.sql", "0002_create_groups.sql", "0003_create_roles.sql"} {
+		m, err := tidal.OpenString(name, fmt.Sprintf("-- migrate: up\nCREATE TABLE t%d (id integer);\n-- migrate: down\nDROP TABLE t%d;\n", i, i))
+		require.NoError(t, err)
+		require.NoError(t, tidal.Register(m))
+	}
+	require.NoError(t, tidal.Migrate(conn, 2))
+
+	tests := []struct {
+		revision string
+		name     string
+		sign     int
+		target   int
+		err      string
+	}{
+		{"", "", 1, -1, ""},
+		{"", "", -1, -1, ""},
+		{"-1", "", 1, -1, ""},
+		{"3", "", 1, 3, ""},
+		{" 1 ", "", -1, 1, ""},
+		{"+1", "", 1, 3, ""},
+		{"-1", "", -1, 1, ""},
+		{"-2", "", -1, 0, ""},
+		{"", "create_groups", -1, 2, ""},
+		{"+2", "", 1, 0, "cannot migrate 2 revision(s) past revision 2, only 1 pending"},
+		{"-3", "", -1, 0, "cannot roll back 3 revision(s) from revision 2, only 2 applied"},
+		{"-2", "", 1, 0, "cannot migrate to relative revision -2, specify -r +N to apply the next N migrations"},
+		{"+1", "", -1, 0, "cannot roll back to relative revision +1, specify -r -N to roll back the last N migrations"},
+		{"+0", "", 1, 0, `could not parse "+0" as a relative revision`},
+		{"+-1", "", 1, 0, `could not parse "+-1" as a relative revision`},
+		{"abc", "", 1, 0, `could not parse "abc" as a revision`},
+		{"1", "create_groups", -1, 0, "specify either a revision or a name, not both"},
+		{"", "create_teams", 1, 0, `no migration named "create_teams" has been registered`},
+	}
+
+	for _, tc := range tests {
+		set := flag.NewFlagSet("test", flag.ContinueOnError)
+		set.String("revision", tc.revision, "")
+		set.String("name", tc.name, "")
+
+		target, err := relativeTarget(cli.NewContext(nil, set, nil), conn, tc.sign)
+		if tc.err != "" {
+			require.EqualError(t, err, tc.err, "expected -r %q -n %q to fail", tc.revision, tc.name)
+			continue
+		}
+
+		require.NoError(t, err, "expected -r %q -n %q to succeed", tc.revision, tc.name)
+		require.Equal(t, tc.target, target, "unexpected target for -r %q -n %q", tc.revision, tc.name)
+	}
+}
@@ -0,0 +1,75 @@
+package tidal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Matches statements that build an index concurrently and captures the name of the
+// index, e.g. CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS users_email ON users (email)
+// or REINDEX INDEX CONCURRENTLY users_email.
+var concre = regexp.MustCompile(`(?i)^(?:CREATE\s+(?:UNIQUE\s+)?INDEX|REINDEX\s+INDEX)\s+CONCURRENTLY\s+(?:IF\s+NOT\s+EXISTS\s+)?("[^"]+"|[\w.]+)`)
+
+// Selects true if the index whose name is bound to the first placeholder can be used by
+// queries; a concurrent index build that fails or is cancelled leaves an invalid index.
+const indexValidSQL = "SELECT indisvalid FROM pg_index WHERE indexrelid = to_regclass($1)"
+
+// Concurrent returns true if the up migration is marked with the concurrently option,
+// e.g. -- migrate: up concurrently, for zero-downtime schema changes such as
+// CREATE INDEX CONCURRENTLY. Concurrent migrations are executed like notransaction
+// migrations: each statement is executed on its own outside of a transaction and the
+// migrations table is updated separately once all of the statements have completed.
+// The down migration may also be marked concurrently, e.g. for DROP INDEX CONCURRENTLY.
+//
+// PostgreSQL does not return from CREATE INDEX CONCURRENTLY until the index build has
+// completed, but a build that fails or is cancelled leaves an invalid index behind. With
+// the Postgres dialect, every index that is built concurrently by the migration is
+// therefore checked once its statements have been executed, and the migration fails
+// without being marked as applied if any of the indexes is invalid. The invalid index
+// must be dropped before the migration is applied again.
+//
+// MySQL and SQLite do not build indexes concurrently (MySQL performs online DDL without
+// any special syntax), so for these dialects the concurrently option only executes the
+// migration outside of a transaction, exactly like the notransaction option.
+func (m *Migration) Concurrent() bool {
+	return m.option("up", "concurrently")
+}
+
+// Returns the names of the indexes that are built concurrently by the statements.
+func concurrentIndexes(stmts []string) (indexes []string) {
+	for _, stmt := range stmts {
+		if groups := concre.FindStringSubmatch(stripComments(stmt)); groups != nil {
+			indexes = append(indexes, groups[1])
+		}
+	}
+	return indexes
+}
+
+// If the specified direction is marked concurrently, checks that every index that was
+// built concurrently by the statements is valid. Only PostgreSQL builds indexes
+// concurrently, so for any other dialect no indexes are checked.
+func (m *Migration) checkIndexes(ctx context.Context, conn executor, direction string, stmts []string) (err error) {
+	if dialect != Postgres || !m.option(direction, "concurrently") {
+		return nil
+	}
+
+	for _, index := range concurrentIndexes(stmts) {
+		var valid bool
+		if err = conn.QueryRowContext(ctx, indexValidSQL, index).Scan(&valid); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				// The index does not exist, e.g. if the statement was a no-op
+				continue
+			}
+			return fmt.Errorf("could not check index %s of revision %d %s: %s", index, m.Revision, direction, err)
+		}
+
+		if !valid {
+			return fmt.Errorf("revision %d %s left index %s invalid, drop the index before applying the migration again", m.Revision, direction, strings.Trim(index, `"`))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package tidal
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContextPropagation(t *testing.T) {
+	defer Reset()
+	tracer := &traceConnector{}
+	conn := sql.OpenDB(tracer)
+	defer conn.Close()
+
+	// Each connection to :memory: is a new database so limit the pool to one
+	conn.SetMaxOpenConns(1)
+	SetDialect(lockingDialect{sqlite{}, &mockLocker{}})
+	defer SetDialect(SQLite)
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	descriptor, err := NewDescriptor(strings.NewReader("-- migrate: up notransaction\nCREATE INDEX users_id ON users (id);\n-- migrate: down notransaction\nDROP INDEX users_id;\n"), "0002_users_id_index.sql")
+	require.NoError(t, err)
+	require.NoError(t, RegisterDescriptor(descriptor))
+
+	// Every call made by the migrations must receive the traced context
+	ctx := context.WithValue(context.Background(), traceKey{}, "migrate")
+	require.NoError(t, MigrateContext(ctx, conn, -1, MigrateOptions{Lock: true}))
+
+	_, err = StatusContext(ctx, conn)
+	require.NoError(t, err)
+
+	current, err := CurrentRevisionContext(ctx, conn)
+	require.NoError(t, err)
+	require.Equal(t, 2, current)
+
+	// The health check uses the context of the request
+	code, _ := DefaultRegistry.health(ctx, conn)
+	require.Equal(t, http.StatusOK, code)
+
+	require.NoError(t, RollbackContext(ctx, conn, 0))
+	require.NotZero(t, tracer.traced)
+	require.Empty(t, tracer.untraced)
+
+	// The context is dropped if the variants without a context are used
+	_, err = Status(conn)
+	require.NoError(t, err)
+	require.NotEmpty(t, tracer.untraced)
+}
+
+func TestMigrateCancel(t *testing.T) {
+	defer Reset()
+
+	// The connection that is interrupted might be discarded so :memory: cannot be used
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "tidal.db"))
+	require.NoError(t, err)
+	defer conn.Close()
+	SetDialect(SQLite)
+	bootstrapTestDB(t, conn)
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_backfill_users.sql", "CREATE TABLE groups (id integer);\nINSERT INTO groups VALUES (1);", "DROP TABLE groups;")
+
+	// The migration is cancelled when revision 2 starts, e.g. by SIGINT
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := &cancelLogger{revision: 2, cancel: cancel}
+	SetLogger(events)
+	defer SetLogger(nil)
+
+	result, err := MigrateResultContext(ctx, conn, -1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "revision 2")
+	require.Contains(t, err.Error(), context.Canceled.Error())
+	require.Equal(t, []int{1}, result.Applied)
+	require.Equal(t, []string{"start 1 up", "finish 1 up", "start 2 up", "finish 2 up"}, events.calls)
+
+	// The transaction of the interrupted revision was rolled back and it is not dirty
+	var exists bool
+	require.NoError(t, conn.QueryRow("SELECT COUNT(*) > 0 FROM sqlite_master WHERE name='groups'").Scan(&exists))
+	require.False(t, exists)
+
+	migrations, err := Status(conn)
+	require.NoError(t, err)
+	require.True(t, migrations[0].Active)
+	require.False(t, migrations[1].Active)
+	require.False(t, migrations[1].Dirty)
+}
+
+func TestDetach(t *testing.T) {
+	parent, cancel := context.WithTimeout(context.WithValue(context.Background(), traceKey{}, "span"), time.Minute)
+	cancel()
+	require.Error(t, parent.Err())
+
+	// The detached context keeps the values of the parent but is never done
+	ctx := detach(parent)
+	require.NoError(t, ctx.Err())
+	require.Nil(t, ctx.Done())
+	_, ok := ctx.Deadline()
+	require.False(t, ok)
+	require.Equal(t, "span", ctx.Value(traceKey{}))
+}
+
+// Cancels the context when the revision is started.
+type cancelLogger struct {
+	mockLogger
+	revision int
+	cancel   context.CancelFunc
+}
+
+func (l *cancelLogger) Start(revision int, name, direction, sql string) {
+	l.mockLogger.Start(revision, name, direction, sql)
+	if revision == l.revision {
+		l.cancel()
+	}
+}
+
+// The context key used to mark the calls that are traced.
+type traceKey struct{}
+
+// Connects to an in-memory sqlite3 database and records whether each query, statement,
+// and transaction was passed a context that carries the trace, as an instrumented driver
+// would use the context to create a span for each call.
+type traceConnector struct {
+	traced   int
+	untraced []string
+}
+
+func (c *traceConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.Driver().Open(":memory:")
+	if err != nil {
+		return nil, err
+	}
+	return &traceConn{conn.(*sqlite3.SQLiteConn), c}, nil
+}
+
+func (c *traceConnector) Driver() driver.Driver {
+	return &sqlite3.SQLiteDriver{}
+}
+
+func (c *traceConnector) record(ctx context.Context, call string) {
+	if ctx.Value(traceKey{}) == nil {
+		c.untraced = append(c.untraced, call)
+		return
+	}
+	c.traced++
+}
+
+type traceConn struct {
+	*sqlite3.SQLiteConn
+	tracer *traceConnector
+}
+
+func (c *traceConn) Ping(ctx context.Context) error {
+	c.tracer.record(ctx, "ping")
+	return c.SQLiteConn.Ping(ctx)
+}
+
+func (c *traceConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.tracer.record(ctx, "begin")
+	return c.SQLiteConn.BeginTx(ctx, opts)
+}
+
+func (c *traceConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.tracer.record(ctx, query)
+	return c.SQLiteConn.ExecContext(ctx, query, args)
+}
+
+func (c *traceConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.tracer.record(ctx, query)
+	return c.SQLiteConn.QueryContext(ctx, query, args)
+}
+
+func (c *traceConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	c.tracer.record(ctx, query)
+	return c.SQLiteConn.PrepareContext(ctx, query)
+}
@@ -4,25 +4,97 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 )
 
 // regular expressions for parsing migration files
+//
+// Migrate directives are matched case-insensitively with flexible whitespace so that
+// well-intentioned variants of the canonical -- migrate: up form still parse, e.g.
+// --migrate:up, -- MIGRATE : Down, or -- +migrate Up as written for other migration
+// tools. The colon may only be omitted after +migrate so that comments such as
+// -- migrate up the users table are not mistaken for directives.
 var (
 	pkgre = regexp.MustCompile(`(?i)^\s*--\s+package:\s+([\w\d\_]+)\s*$`)
-	migre = regexp.MustCompile(`(?i)^\s*--\s+migrate:\s+(up|down|end)\s*$`)
+	migre = regexp.MustCompile(`(?i)^\s*--\s*(?:\+migrate\s*:?|migrate\s*:)\s*(up|down|end)((?:\s+[\w=,.]+)*)\s*$`)
+	metre = regexp.MustCompile(`(?i)^\s*--\s+tidal:\s+(.*?)\s*$`)
+	kvre  = regexp.MustCompile(`([\w.-]+)=("[^"]*"|\S+)`)
+	reqre = regexp.MustCompile(`(?i)^\s*--\s*(?:\+migrate\s*:?|migrate\s*:)\s*requires\s+(.*?)\s*$`)
 )
 
+// DefaultMaxFileSize is the default maximum size of a migration file in bytes.
+const DefaultMaxFileSize = 4 << 20
+
+// The maximum size of a migration file, set using SetMaxFileSize.
+var maxFileSize int64 = DefaultMaxFileSize
+
+// SetMaxFileSize specifies the maximum size in bytes of the uncompressed SQL of a
+// migration that can be read into a descriptor (DefaultMaxFileSize by default). Since
+// descriptors are compiled into application binaries, this guards against exhausting
+// memory or bloating builds with accidentally large migration files. Use a size of 0
+// or less to disable the limit. This should be set before opening migrations.
+func SetMaxFileSize(size int64) {
+	maxFileSize = size
+}
+
+// Returns a reader that returns an error that matches ErrFileTooLarge if more than the
+// maximum file size is read from src.
+func limitReader(src io.Reader, name string) io.Reader {
+	if maxFileSize <= 0 {
+		return src
+	}
+	return &limitedReader{src: io.LimitReader(src, maxFileSize+1), name: name, remaining: maxFileSize}
+}
+
+type limitedReader struct {
+	src       io.Reader
+	name      string
+	remaining int64
+}
+
+func (r *limitedReader) Read(p []byte) (n int, err error) {
+	n, err = r.src.Read(p)
+	if r.remaining -= int64(n); r.remaining < 0 {
+		return n, fmt.Errorf("%w: %s is larger than the maximum migration size of %d bytes", ErrFileTooLarge, r.name, maxFileSize)
+	}
+	return n, err
+}
+
+// The gzip header operating system byte for an unknown operating system.
+const unknownOS = 0xff
+
+// The prefix of the gzip header comment in which StripComments records the checksum of
+// the original SQL; the header cannot be written by the SQL of a migration file.
+const checksumComment = "checksum="
+
 // NewDescriptor reads the data from the source migration file and gzip compresses it
 // for in-memory storage. The reader should not be compressed before hand. Note that
 // the name is not optional, it is used to identify descriptors via the gzip header
 // information -- autogenerated descriptors use this property to ensure that the
-// migrations can be created from a raw descriptor with no other information.
+// migrations can be created from a raw descriptor with no other information. The name
+// should be the base filename of the migration, e.g. 0001_create_users.sql; see the
+// Descriptor documentation for details about the format of the returned data. An error
+// that matches ErrFileTooLarge is returned if src is larger than the maximum file size.
+//
+// The output is deterministic: the same src and name always produce identical bytes
+// with the same version of Go.
 func NewDescriptor(src io.Reader, name string) (_ Descriptor, err error) {
+	return newDescriptor(src, name, "")
+}
+
+// Compresses the source migration file into a descriptor with the specified gzip header
+// comment, which is omitted if empty.
+func newDescriptor(src io.Reader, name, comment string) (_ Descriptor, err error) {
 	var (
 		buf bytes.Buffer
 		zw  *gzip.Writer
@@ -32,11 +104,16 @@ func NewDescriptor(src io.Reader, name string) (_ Descriptor, err error) {
 		return nil, err
 	}
 
-	// Set the header fields for debugging
+	// Set the name for debugging; the modification time is left zero and the operating
+	// system is fixed so that compressing the same file always produces the same bytes,
+	// e.g. so that regenerating descriptors that are committed to source control does
+	// not produce spurious diffs.
 	zw.Name = name
-	zw.ModTime = time.Now().UTC()
+	zw.Comment = comment
+	zw.ModTime = time.Time{}
+	zw.OS = unknownOS
 
-	if _, err = io.Copy(zw, src); err != nil {
+	if _, err = io.Copy(zw, limitReader(src, name)); err != nil {
 		return nil, err
 	}
 
@@ -53,10 +130,40 @@ func NewDescriptor(src io.Reader, name string) (_ Descriptor, err error) {
 // always stored in a compressed format, decompressed as necessary to run migration
 // commands. This slows down the migration process a bit, but as migrations are rare, is
 // an acceptable trade-off.
+//
+// Descriptors are part of the public API so that applications can build their own
+// migration loaders, e.g. reading SQL files from an embedded filesystem or from object
+// storage, then registering the data with RegisterDescriptor. The format is a single,
+// standard gzip stream (RFC 1952) so any gzip implementation can read or write it:
+//
+//	bytes 0-1    magic number 0x1f 0x8b
+//	byte  2      compression method 0x08 (deflate)
+//	byte  3      flags, FNAME (0x08) must be set, FCOMMENT (0x10) is set by
+//	             StripComments
+//	bytes 4-7    modification time, little endian unix seconds (informational only,
+//	             zero when written by NewDescriptor)
+//	byte  8      extra flags, 0x02 when written with best compression
+//	byte  9      operating system, 0xff (unknown) when written by NewDescriptor
+//	...          the FNAME field: the zero terminated migration filename
+//	...          the optional FCOMMENT field: checksum= and the hex encoded checksum
+//	             of the original SQL of a descriptor whose comments were stripped
+//	...          the deflate compressed contents of the migration SQL file
+//	last 8 bytes CRC-32 and length of the uncompressed SQL file
+//
+// The FNAME header is required; it must be the base filename of the migration (e.g.
+// 0001_create_users.sql) since the revision and name of the migration are parsed from
+// it when the descriptor is registered. The compressed payload is the unmodified SQL
+// file; the up and down migrations are delimited by -- migrate: up, -- migrate: down,
+// and -- migrate: end directive comments (matched case-insensitively with flexible
+// whitespace, as well as in the -- +migrate Up form of other tools), an optional
+// -- package: directive specifies the package of generated code, and optional
+// -- tidal: directives specify metadata.
 type Descriptor []byte
 
 // Info returns header information from the compressed data, generated Descriptors will
-// have the associated filename and modification time returned.
+// have the associated filename returned. The modification time is zero for descriptors
+// created by NewDescriptor, descriptors generated by earlier versions of tidal return
+// the time that they were compressed.
 func (d Descriptor) Info() (name string, modTime time.Time, err error) {
 	var zr *gzip.Reader
 	if zr, err = gzip.NewReader(bytes.NewBuffer(d)); err != nil {
@@ -70,21 +177,190 @@ func (d Descriptor) Info() (name string, modTime time.Time, err error) {
 // Package looks for a package directive, e.g. -- package: foo and returns the name of
 // the specified package, otherwise it returns an empty string.
 func (d Descriptor) Package() (s string, err error) {
+	err = d.scan(func(line string, quoted bool) bool {
+		if quoted {
+			return true
+		}
+
+		if groups := pkgre.FindStringSubmatch(line); groups != nil {
+			s = groups[1]
+			return false
+		}
+		return true
+	})
+	return s, err
+}
+
+// Meta returns the key-value pairs of the metadata directives in the migration, e.g.
+// -- tidal: author=jsmith jira=PROJ-123, which are used to record the provenance of a
+// migration. Values that contain spaces must be double quoted, e.g. team="data eng".
+// Keys are lower cased; if a key is specified more than once the last value is used.
+// Metadata directives are typically specified in the header of the migration file and
+// are never included in the SQL of the migration.
+func (d Descriptor) Meta() (meta map[string]string, err error) {
+	meta = make(map[string]string)
+	err = d.scan(func(line string, quoted bool) bool {
+		if quoted {
+			return true
+		}
+
+		if groups := metre.FindStringSubmatch(line); groups != nil {
+			for _, kv := range kvre.FindAllStringSubmatch(groups[1], -1) {
+				meta[strings.ToLower(kv[1])] = strings.Trim(kv[2], `"`)
+			}
+		}
+		return true
+	})
+	return meta, err
+}
+
+// Requires returns the revisions specified by the requires directives of the migration,
+// e.g. -- migrate: requires 3,5 returns [3 5]. Revisions are separated by commas or
+// spaces and may be specified in more than one directive; the revisions are returned
+// sorted without duplicates. Requires directives are never included in the SQL of the
+// migration.
+func (d Descriptor) Requires() (revisions []int, err error) {
+	var perr error
+	seen := make(map[int]bool)
+	err = d.scan(func(line string, quoted bool) bool {
+		if quoted {
+			return true
+		}
+
+		groups := reqre.FindStringSubmatch(line)
+		if groups == nil {
+			return true
+		}
+
+		for _, field := range strings.FieldsFunc(groups[1], func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+			revision, cerr := strconv.Atoi(field)
+			if cerr != nil || revision < 1 {
+				perr = fmt.Errorf("invalid requires directive %q: %q is not a revision", strings.TrimSpace(line), field)
+				return false
+			}
+
+			if !seen[revision] {
+				seen[revision] = true
+				revisions = append(revisions, revision)
+			}
+		}
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if perr != nil {
+		return nil, perr
+	}
+
+	sort.Ints(revisions)
+	return revisions, nil
+}
+
+// Source returns the unmodified SQL source of the migration file, including the
+// migrate, package, and metadata directive comments.
+func (d Descriptor) Source() (s string, err error) {
 	var zr *gzip.Reader
 	if zr, err = gzip.NewReader(bytes.NewBuffer(d)); err != nil {
 		return "", err
 	}
 	defer zr.Close()
 
-	scanner := bufio.NewScanner(zr)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if pkgre.MatchString(line) {
-			return pkgre.FindStringSubmatch(line)[1], nil
+	var data []byte
+	if data, err = ioutil.ReadAll(zr); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Equal returns true if the decompressed source of both descriptors is identical. The
+// compressed bytes are not compared since the output of gzip may differ between Go
+// versions and the gzip header includes the name and modification time of the file.
+// Descriptors that cannot be decompressed are only equal if their bytes are identical.
+func (d Descriptor) Equal(other Descriptor) bool {
+	src, err := d.Source()
+	if err != nil {
+		return bytes.Equal(d, other)
+	}
+
+	osrc, err := other.Source()
+	if err != nil {
+		return false
+	}
+	return src == osrc
+}
+
+// StripComments returns a copy of the descriptor with the line (--) and block (/* */)
+// comments removed from its SQL to reduce the size of descriptors that are compiled into
+// applications, e.g. for heavily commented migrations. The migrate, package, and
+// metadata directives are preserved, as are comments inside of quoted strings and dollar
+// quoted function bodies. Blank lines are removed as well.
+//
+// Since the checksum of a migration is computed from its SQL including comments, the
+// checksum of the original SQL is recorded in the gzip header comment so that
+// migrations that were applied from the original files are not reported as modified,
+// see Migration.Checksum. The checksum is not stored in the SQL so that a migration file
+// cannot specify its own checksum. Stripping a descriptor that is already stripped keeps
+// the original checksum.
+func (d Descriptor) StripComments() (_ Descriptor, err error) {
+	var name, src string
+	if name, _, err = d.Info(); err != nil {
+		return nil, err
+	}
+
+	if src, err = d.Source(); err != nil {
+		return nil, err
+	}
+
+	var checksum string
+	if checksum, err = d.originalChecksum(); err != nil {
+		return nil, err
+	}
+
+	if checksum == "" {
+		if checksum, err = d.checksum(); err != nil {
+			return nil, err
 		}
 	}
 
-	return "", scanner.Err()
+	stripped := removeComments(src, func(line string) bool {
+		return migre.MatchString(line) || pkgre.MatchString(line) || metre.MatchString(line) || reqre.MatchString(line)
+	})
+	return newDescriptor(strings.NewReader(stripped), name, checksumComment+checksum)
+}
+
+// Returns the checksum of the original SQL recorded in the gzip header comment by
+// StripComments or an empty string if the comments of the descriptor were not stripped.
+func (d Descriptor) originalChecksum() (_ string, err error) {
+	var zr *gzip.Reader
+	if zr, err = gzip.NewReader(bytes.NewBuffer(d)); err != nil {
+		return "", err
+	}
+	defer zr.Close()
+
+	if !strings.HasPrefix(zr.Comment, checksumComment) {
+		return "", nil
+	}
+	return strings.TrimPrefix(zr.Comment, checksumComment), nil
+}
+
+// Returns the SHA-256 checksum of the up and down SQL of the descriptor.
+func (d Descriptor) checksum() (_ string, err error) {
+	var upsql, downsql string
+	if upsql, err = d.Up(); err != nil {
+		return "", err
+	}
+
+	if downsql, err = d.Down(); err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(upsql))
+	hash.Write([]byte(downsql))
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
 // Up reads and returns the up migration command, including all comments and statements
@@ -101,30 +377,75 @@ func (d Descriptor) Down() (sql string, err error) {
 	return d.readBetween("down")
 }
 
+// UpStatements returns the up migration command split into individual SQL statements
+// for databases or drivers that cannot execute multiple statements at once.
+func (d Descriptor) UpStatements() (stmts []string, err error) {
+	var sql string
+	if sql, err = d.Up(); err != nil {
+		return nil, err
+	}
+	return splitStatements(sql), nil
+}
+
+// DownStatements returns the down migration command split into individual SQL
+// statements for databases or drivers that cannot execute multiple statements at once.
+func (d Descriptor) DownStatements() (stmts []string, err error) {
+	var sql string
+	if sql, err = d.Down(); err != nil {
+		return nil, err
+	}
+	return splitStatements(sql), nil
+}
+
+// Empty returns true if the up or down target does not contain any SQL statements, e.g.
+// if the directive is missing from the migration file or it only contains comments. A
+// migration with an empty down target is irreversible.
+func (d Descriptor) Empty(target string) (empty bool, err error) {
+	var sql string
+	if sql, err = d.readBetween(target); err != nil {
+		return false, err
+	}
+	return len(splitStatements(sql)) == 0, nil
+}
+
+// Options returns the options specified after the up or down migration directive, e.g.
+// -- migrate: up notransaction returns [notransaction] for the up target. Options are
+// lower cased and returned in the order they are specified.
+func (d Descriptor) Options(target string) (opts []string, err error) {
+	err = d.scan(func(line string, quoted bool) bool {
+		groups := directive(line, quoted)
+		if groups == nil || strings.ToLower(groups[1]) != target {
+			return true
+		}
+
+		for _, opt := range strings.Fields(groups[2]) {
+			opts = append(opts, strings.ToLower(opt))
+		}
+		return true
+	})
+	return opts, err
+}
+
 // Helper function to read the descriptor between the target directive (e.g. up/down)
 // and the next directive or end. This function does not handle the case where multiple
 // directives of the same name are in consecutive order, with the exception that it does
 // omit the directive comments from the returned string.
 func (d Descriptor) readBetween(target string) (s string, err error) {
-	var zr *gzip.Reader
-	if zr, err = gzip.NewReader(bytes.NewBuffer(d)); err != nil {
-		return "", err
-	}
-	defer zr.Close()
-
 	var (
 		sb      strings.Builder
 		between bool
 	)
 
-	scanner := bufio.NewScanner(zr)
-	for scanner.Scan() {
-		// Read the line and check for a migration directive
-		line := scanner.Text()
-		if migre.MatchString(line) {
-			directive := strings.ToLower(migre.FindStringSubmatch(line)[1])
-			between = directive == target
-			continue // skip the directive line
+	err = d.scan(func(line string, quoted bool) bool {
+		// Check for a migration directive, skipping the directive line
+		if groups := directive(line, quoted); groups != nil {
+			between = strings.ToLower(groups[1]) == target
+			return true
+		}
+
+		// Metadata and requires directives are not part of the SQL
+		if !quoted && (metre.MatchString(line) || reqre.MatchString(line)) {
+			return true
 		}
 
 		if between {
@@ -132,9 +453,42 @@ func (d Descriptor) readBetween(target string) (s string, err error) {
 			sb.WriteString(line)
 			sb.WriteRune('\n')
 		}
+		return true
+	})
+
+	return sb.String(), err
+}
+
+// Helper function to decompress the descriptor and call fn with every line until fn
+// returns false. Quoted is true if the line begins inside of a dollar quoted string,
+// e.g. the body of a PL/pgSQL function, in which case it cannot contain a directive.
+func (d Descriptor) scan(fn func(line string, quoted bool) bool) (err error) {
+	var zr *gzip.Reader
+	if zr, err = gzip.NewReader(bytes.NewBuffer(d)); err != nil {
+		return err
 	}
+	defer zr.Close()
 
-	return sb.String(), scanner.Err()
+	var tag string
+	scanner := bufio.NewScanner(zr)
+	for scanner.Scan() {
+		line := scanner.Text()
+		quoted := tag != ""
+		tag = dollarQuote(line, tag)
+		if !fn(line, quoted) {
+			break
+		}
+	}
+	return scanner.Err()
+}
+
+// Returns the submatches of the migrate directive on the line or nil if the line is not
+// a directive or if it is quoted.
+func directive(line string, quoted bool) []string {
+	if quoted {
+		return nil
+	}
+	return migre.FindStringSubmatch(line)
 }
 
 // Repr returns a string representation of the bytes data for embedding into source code.
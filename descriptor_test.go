@@ -1,10 +1,15 @@
 package tidal_test
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"regexp"
 	"strings"
 	"testing"
+	"testing/fstest"
 
 	. "github.com/rotationalio/tidal"
 	"github.com/stretchr/testify/require"
@@ -33,25 +38,323 @@ func TestDescriptor(t *testing.T) {
 	require.NotContains(t, dnsql, "-- migrate: down")
 }
 
+func TestDescriptorOptions(t *testing.T) {
+	src := "-- migrate: up NoTransaction foo=bar\nSELECT 1;\n-- migrate: down\nSELECT 2;\n"
+	d, err := NewDescriptor(strings.NewReader(src), "0002_options.sql")
+	require.NoError(t, err)
+
+	opts, err := d.Options("up")
+	require.NoError(t, err)
+	require.Equal(t, []string{"notransaction", "foo=bar"}, opts)
+
+	opts, err = d.Options("down")
+	require.NoError(t, err)
+	require.Empty(t, opts)
+
+	upsql, err := d.Up()
+	require.NoError(t, err)
+	require.Equal(t, "SELECT 1;\n", upsql)
+}
+
+func TestDescriptorMeta(t *testing.T) {
+	src := "-- Creates the users table\n-- tidal: author=jsmith jira=PROJ-123\n-- TIDAL: Team=\"data eng\"\n-- migrate: up\n-- tidal: jira=PROJ-456\nCREATE TABLE users (id integer);\n-- migrate: down\nDROP TABLE users;\n"
+	d, err := NewDescriptor(strings.NewReader(src), "0001_create_users.sql")
+	require.NoError(t, err)
+
+	meta, err := d.Meta()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"author": "jsmith", "jira": "PROJ-456", "team": "data eng"}, meta)
+
+	// Metadata directives are not part of the SQL
+	upsql, err := d.Up()
+	require.NoError(t, err)
+	require.Equal(t, "CREATE TABLE users (id integer);\n", upsql)
+
+	require.NoError(t, RegisterDescriptor(d))
+	defer Reset()
+	migrations := Migrations()
+	require.Len(t, migrations, 1)
+	require.Equal(t, meta, migrations[0].Meta())
+
+	d, err = NewDescriptor(strings.NewReader("-- migrate: up\nSELECT 1;\n"), "0002_select.sql")
+	require.NoError(t, err)
+	meta, err = d.Meta()
+	require.NoError(t, err)
+	require.Empty(t, meta)
+}
+
+func TestDescriptorRequires(t *testing.T) {
+	src := "-- migrate: requires 5,3\n-- migrate: up\n-- MIGRATE: requires 3 7\nCREATE VIEW admins AS SELECT * FROM users;\n-- migrate: down\nDROP VIEW admins;\n"
+	d, err := NewDescriptor(strings.NewReader(src), "0002_create_admins.sql")
+	require.NoError(t, err)
+
+	revisions, err := d.Requires()
+	require.NoError(t, err)
+	require.Equal(t, []int{3, 5, 7}, revisions)
+
+	// Requires directives are not part of the SQL
+	upsql, err := d.Up()
+	require.NoError(t, err)
+	require.Equal(t, "CREATE VIEW admins AS SELECT * FROM users;\n", upsql)
+
+	d, err = NewDescriptor(strings.NewReader("-- migrate: up\nSELECT 1;\n"), "0003_select.sql")
+	require.NoError(t, err)
+	revisions, err = d.Requires()
+	require.NoError(t, err)
+	require.Empty(t, revisions)
+
+	d, err = NewDescriptor(strings.NewReader("-- migrate: requires 3,latest\n-- migrate: up\nSELECT 1;\n"), "0004_select.sql")
+	require.NoError(t, err)
+	_, err = d.Requires()
+	require.EqualError(t, err, `invalid requires directive "-- migrate: requires 3,latest": "latest" is not a revision`)
+}
+
+func TestDescriptorEmpty(t *testing.T) {
+	// A missing down directive is not an error, the migration is irreversible
+	d, err := NewDescriptor(strings.NewReader("-- migrate: up\nDELETE FROM users;\n"), "0003_cleanup.sql")
+	require.NoError(t, err)
+
+	empty, err := d.Empty("up")
+	require.NoError(t, err)
+	require.False(t, empty)
+
+	empty, err = d.Empty("down")
+	require.NoError(t, err)
+	require.True(t, empty)
+
+	// A down directive that only contains comments is also empty
+	d, err = NewDescriptor(strings.NewReader("-- migrate: up\nDELETE FROM users;\n-- migrate: down\n-- cannot restore users\n\n"), "0003_cleanup.sql")
+	require.NoError(t, err)
+
+	empty, err = d.Empty("down")
+	require.NoError(t, err)
+	require.True(t, empty)
+
+	dnsql, err := d.Down()
+	require.NoError(t, err)
+	require.Equal(t, "-- cannot restore users\n\n", dnsql)
+}
+
+func TestDescriptorDollarQuotes(t *testing.T) {
+	m, err := Open("testdata/0002_trigger_function.sql")
+	require.NoError(t, err)
+
+	// Directives inside of the function bodies must not end the up migration
+	upsql, err := m.UpSQL()
+	require.NoError(t, err)
+	require.Contains(t, upsql, "    -- migrate: down\n")
+	require.Contains(t, upsql, "-- migrate: end\n")
+	require.Contains(t, upsql, "CREATE TRIGGER users_audit AFTER UPDATE ON users")
+	require.NotContains(t, upsql, "DROP TRIGGER")
+
+	stmts, err := m.UpStatements()
+	require.NoError(t, err)
+	require.Len(t, stmts, 5)
+	require.True(t, strings.HasPrefix(stmts[1], "CREATE OR REPLACE FUNCTION set_modified()"))
+	require.True(t, strings.HasSuffix(stmts[1], "$$ LANGUAGE plpgsql"))
+	require.True(t, strings.HasPrefix(stmts[2], "CREATE OR REPLACE FUNCTION audit_users()"))
+	require.True(t, strings.HasSuffix(stmts[2], "$body$ LANGUAGE plpgsql"))
+
+	dnsql, err := m.DownSQL()
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(strings.TrimSpace(dnsql), "DROP TRIGGER IF EXISTS users_audit ON users;"))
+	require.NotContains(t, dnsql, "set_modified() RETURNS trigger")
+
+	stmts, err = m.DownStatements()
+	require.NoError(t, err)
+	require.Len(t, stmts, 5)
+}
+
+func TestDirectiveVariants(t *testing.T) {
+	variants := []string{
+		"-- migrate: up notransaction\nCREATE TABLE users (id integer);\n-- migrate: down\nDROP TABLE users;\n",
+		"--MIGRATE:UP NoTransaction\nCREATE TABLE users (id integer);\n--MIGRATE:DOWN\nDROP TABLE users;\n",
+		"  --  migrate :  up  notransaction  \nCREATE TABLE users (id integer);\n-- Migrate : Down\nDROP TABLE users;\n",
+		"-- +migrate Up notransaction\nCREATE TABLE users (id integer);\n-- +migrate Down\nDROP TABLE users;\n",
+	}
+
+	for i, src := range variants {
+		d, err := NewDescriptor(strings.NewReader(src), "0001_create_users.sql")
+		require.NoError(t, err, "variant %d", i)
+
+		upsql, err := d.Up()
+		require.NoError(t, err, "variant %d", i)
+		require.Equal(t, "CREATE TABLE users (id integer);\n", upsql, "variant %d", i)
+
+		dnsql, err := d.Down()
+		require.NoError(t, err, "variant %d", i)
+		require.Equal(t, "DROP TABLE users;\n", dnsql, "variant %d", i)
+
+		opts, err := d.Options("up")
+		require.NoError(t, err, "variant %d", i)
+		require.Equal(t, []string{"notransaction"}, opts, "variant %d", i)
+	}
+
+	// Comments that only mention migrating are not directives
+	d, err := NewDescriptor(strings.NewReader("-- migrate: up\n-- migrate up the users table\nCREATE TABLE users (id integer);\n"), "0001_create_users.sql")
+	require.NoError(t, err)
+	upsql, err := d.Up()
+	require.NoError(t, err)
+	require.Equal(t, "-- migrate up the users table\nCREATE TABLE users (id integer);\n", upsql)
+
+	// Requires directives accept the same variants
+	d, err = NewDescriptor(strings.NewReader("--MIGRATE:REQUIRES 2\n-- +migrate requires 3\n-- migrate: up\nSELECT 1;\n"), "0004_select.sql")
+	require.NoError(t, err)
+	requires, err := d.Requires()
+	require.NoError(t, err)
+	require.Equal(t, []int{2, 3}, requires)
+}
+
+func TestDescriptorEqual(t *testing.T) {
+	src := "-- migrate: up\nCREATE TABLE roles (id integer);\n-- migrate: down\nDROP TABLE roles;\n"
+	d, err := NewDescriptor(strings.NewReader(src), "0003_create_roles.sql")
+	require.NoError(t, err)
+
+	// The decompressed source is compared rather than the gzip header
+	o, err := NewDescriptor(strings.NewReader(src), "roles.sql")
+	require.NoError(t, err)
+	require.True(t, d.Equal(o))
+	require.True(t, o.Equal(d))
+
+	o, err = NewDescriptor(strings.NewReader("-- tidal: author=jsmith\n"+src), "0003_create_roles.sql")
+	require.NoError(t, err)
+	require.False(t, d.Equal(o))
+
+	// Stripping a descriptor without comments does not modify its source
+	stripped, err := d.StripComments()
+	require.NoError(t, err)
+	require.True(t, d.Equal(stripped))
+
+	require.True(t, Descriptor(nil).Equal(nil))
+	require.False(t, Descriptor(nil).Equal(d))
+	require.False(t, d.Equal(nil))
+}
+
+func TestStripComments(t *testing.T) {
+	m, err := Open("testdata/0002_trigger_function.sql")
+	require.NoError(t, err)
+
+	checksum, err := m.Checksum()
+	require.NoError(t, err)
+
+	f, err := os.Open("testdata/0002_trigger_function.sql")
+	require.NoError(t, err)
+	defer f.Close()
+
+	original, err := NewDescriptor(f, "0002_trigger_function.sql")
+	require.NoError(t, err)
+
+	d, err := original.StripComments()
+	require.NoError(t, err)
+
+	name, _, err := d.Info()
+	require.NoError(t, err)
+	require.Equal(t, "0002_trigger_function.sql", name)
+
+	// The comments are removed but the function bodies are not modified
+	src, err := d.Source()
+	require.NoError(t, err)
+	originalSrc, err := original.Source()
+	require.NoError(t, err)
+	require.Less(t, len(src), len(originalSrc))
+	require.True(t, strings.HasPrefix(src, "-- migrate: up\n"))
+	require.NotContains(t, src, "-- Creates a trigger function")
+	require.Contains(t, src, "    -- migrate: down\n    -- the directive above is part of the function body")
+	require.Contains(t, src, "\n-- migrate: down\nDROP TRIGGER IF EXISTS users_audit ON users;\n")
+
+	// The statements are the same other than their comments
+	expected, err := original.UpStatements()
+	require.NoError(t, err)
+	actual, err := d.UpStatements()
+	require.NoError(t, err)
+	require.Len(t, actual, len(expected))
+	require.Equal(t, expected[1], actual[1])
+	require.Equal(t, expected[2], actual[2])
+
+	expected, err = original.DownStatements()
+	require.NoError(t, err)
+	actual, err = d.DownStatements()
+	require.NoError(t, err)
+	require.Equal(t, expected, actual)
+
+	// The checksum of the original sql is used so applied migrations are not modified
+	registry := &Registry{}
+	require.NoError(t, registry.RegisterDescriptor(d))
+	actualChecksum, err := registry.Migrations()[0].Checksum()
+	require.NoError(t, err)
+	require.Equal(t, checksum, actualChecksum)
+
+	// Stripping the comments again does not change the recorded checksum
+	again, err := d.StripComments()
+	require.NoError(t, err)
+	require.Equal(t, []byte(d), []byte(again))
+
+	// The checksum cannot be specified by the sql of a migration file
+	forged, err := OpenString(name, "-- tidal: checksum="+checksum+"\n"+src)
+	require.NoError(t, err)
+	forgedChecksum, err := forged.Checksum()
+	require.NoError(t, err)
+	require.NotEqual(t, checksum, forgedChecksum)
+
+	// Strings that end in a backslash are not read past their closing quote
+	original, err = NewDescriptor(strings.NewReader("-- migrate: up\nINSERT INTO t VALUES ('C:\\', '-- keep me'); -- removed\n-- migrate: down\nDELETE FROM t;\n"), "0003_paths.sql")
+	require.NoError(t, err)
+	d, err = original.StripComments()
+	require.NoError(t, err)
+	up, err := d.Up()
+	require.NoError(t, err)
+	require.Equal(t, "INSERT INTO t VALUES ('C:\\', '-- keep me');\n", up)
+}
+
+func TestMaxFileSize(t *testing.T) {
+	defer SetMaxFileSize(DefaultMaxFileSize)
+	SetMaxFileSize(32)
+
+	src := "-- migrate: up\nSELECT 1;\n"
+	_, err := NewDescriptor(strings.NewReader(src+strings.Repeat(" ", 32-len(src))), "0001_limit.sql")
+	require.NoError(t, err, "a migration at the maximum size should be allowed")
+
+	_, err = NewDescriptor(strings.NewReader(src+strings.Repeat(" ", 33-len(src))), "0001_limit.sql")
+	require.True(t, errors.Is(err, ErrFileTooLarge))
+	require.EqualError(t, err, "migration file is too large: 0001_limit.sql is larger than the maximum migration size of 32 bytes")
+
+	fsys := fstest.MapFS{
+		"0001_large.sql":      {Data: []byte(src + strings.Repeat("-- padding\n", 10))},
+		"0002_large.up.sql":   {Data: []byte(strings.Repeat("SELECT 1;\n", 10))},
+		"0002_large.down.sql": {Data: []byte("SELECT 1;\n")},
+	}
+
+	_, err = OpenFS(fsys, "0001_large.sql")
+	require.True(t, errors.Is(err, ErrFileTooLarge))
+
+	_, err = OpenFS(fsys, "0002_large.down.sql")
+	require.True(t, errors.Is(err, ErrFileTooLarge))
+
+	// The limit can be disabled
+	SetMaxFileSize(0)
+	_, err = OpenFS(fsys, "0001_large.sql")
+	require.NoError(t, err)
+}
+
 func TestParseRegexp(t *testing.T) {
 	// Copy these regular expressions from the the package
 	pkgre := regexp.MustCompile(`(?i)^\s*--\s+package:\s+([\w\d\_]+)\s*$`)
-	migre := regexp.MustCompile(`(?i)^\s*--\s+migrate:\s+(up|down|end)\s*$`)
+	migre := regexp.MustCompile(`(?i)^\s*--\s*(?:\+migrate\s*:?|migrate\s*:)\s*(up|down|end)((?:\s+[\w=,.]+)*)\s*$`)
 
 	for _, pk := range []string{"-- package: FOO", "  -- package: foo  ", "-- PACKAGE: FOO"} {
 		require.True(t, pkgre.MatchString(pk))
 	}
 
-	for _, mi := range []string{"-- migrate: up", "  -- MIGRATE: DOWN", "-- migrate: END   "} {
-		require.True(t, migre.MatchString(mi))
+	for _, mi := range []string{"-- migrate: up", "  -- MIGRATE: DOWN", "-- migrate: END   ", "-- migrate: up notransaction", "--MIGRATE:UP", "--  migrate :  down  ", "-- +migrate Up", "-- +migrate: Down notransaction", "\t--migrate:\tend"} {
+		require.True(t, migre.MatchString(mi), "expected %q to match", mi)
 	}
 
 	for _, pk := range []string{" package: foo", "-- package:", " foo "} {
 		require.False(t, pkgre.MatchString(pk))
 	}
 
-	for _, mi := range []string{" migrate: up", "-- migrate:", "down"} {
-		require.False(t, migre.MatchString(mi))
+	for _, mi := range []string{" migrate: up", "-- migrate:", "down", "-- migrate: upnotransaction", "-- migrate up the users table", "-- +migrate StatementBegin", "- migrate: up", "-- migrated: up"} {
+		require.False(t, migre.MatchString(mi), "expected %q not to match", mi)
 	}
 
 }
@@ -60,20 +363,56 @@ func TestRepr(t *testing.T) {
 	f, err := os.Open("testdata/0001_test_migration.sql")
 	require.NoError(t, err)
 
-	// Note that the modtime will be set to the current time every time the test is
-	// run, so using the NewDescriptor function we can't directly compare the repr,
-	// since those bytes will change. However we do know the length of both the string
-	// and the descriptor data, which we test here.
+	// Note that the compressed bytes depend on the version of Go that the descriptor
+	// was generated with, so the repr of a descriptor created by NewDescriptor cannot be
+	// compared to the repr of the generated descriptor directly. However the header and
+	// the layout of the repr are determined by the length of the descriptor data, with
+	// 16 bytes per line (each written as 0xNN, and separated by a space).
 	descriptor, err := NewDescriptor(f, "0001_test_migration.sql")
 	require.NoError(t, err)
-	require.Len(t, descriptor, len(generatedDescriptor))
+	require.True(t, descriptor.Equal(generatedDescriptor))
+
+	header := fmt.Sprintf("[]byte{\n\t// %d bytes of compressed tidal.Descriptor data", len(descriptor))
+	lines := (len(descriptor) + 15) / 16
 
 	repr := descriptor.Repr()
+	require.True(t, strings.HasPrefix(repr, header))
+	require.True(t, strings.HasSuffix(repr, ",\n}"))
+	require.Equal(t, lines+2, strings.Count(repr, "\n"))
+	require.Len(t, repr, len(header)+6*len(descriptor)+lines+2)
+
+	// The repr of the generated descriptor has the same layout
+	repr = Descriptor(generatedDescriptor).Repr()
 	require.Len(t, repr, 2515)
-	require.True(t, strings.HasPrefix(repr, "[]byte{\n\t//"))
+	require.True(t, strings.HasPrefix(repr, "[]byte{\n\t// 405 bytes"))
 	require.Equal(t, 28, strings.Count(repr, "\n"))
 }
 
+func TestDeterministicDescriptor(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/0001_test_migration.sql")
+	require.NoError(t, err)
+
+	// Compressing the same file twice produces identical bytes
+	a, err := NewDescriptor(bytes.NewReader(data), "0001_test_migration.sql")
+	require.NoError(t, err)
+	b, err := NewDescriptor(bytes.NewReader(data), "0001_test_migration.sql")
+	require.NoError(t, err)
+	require.Equal(t, []byte(a), []byte(b))
+
+	// The modification time is zero and the operating system is unknown, so the bytes
+	// do not depend on the clock or the platform
+	_, modtime, err := a.Info()
+	require.NoError(t, err)
+	require.True(t, modtime.IsZero())
+	require.Equal(t, []byte{0, 0, 0, 0}, []byte(a[4:8]))
+	require.Equal(t, byte(0xff), a[9])
+
+	// Different names produce different descriptors
+	c, err := NewDescriptor(bytes.NewReader(data), "0001_test.sql")
+	require.NoError(t, err)
+	require.NotEqual(t, []byte(a), []byte(c))
+}
+
 // Generated representation of the descriptor of testdata/0001_test_migration.sql
 // the representation should be generated and added here when changes are made.
 var generatedDescriptor = []byte{
@@ -0,0 +1,148 @@
+package tidal
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Dialect describes the differences in SQL syntax between database engines that tidal
+// must account for when it manages the migrations table and executes migrations. Note
+// that the dialect never rewrites the SQL of application migrations.
+type Dialect interface {
+	// Name returns the name of the dialect, e.g. postgres.
+	Name() string
+
+	// Placeholder returns the bind parameter for the nth argument of a query (1-indexed).
+	Placeholder(n int) string
+
+	// MultiStatements reports if the database driver can execute multiple statements
+	// in a single Exec call. If not, migrations are split into individual statements
+	// that are executed sequentially in the migration's transaction.
+	MultiStatements() bool
+
+	// Separator returns the separator between the statements of a migration, which is
+	// used to split migrations into statements, by default a semicolon. A separator
+	// that is a keyword, e.g. GO for SQL Server tools, separates batches of statements
+	// only when it is on a line by itself. Migrations are always split if the separator
+	// is not a semicolon, since the database cannot execute the separator itself.
+	Separator() string
+
+	// Locker returns the strategy used to lock the database while migrating or nil if
+	// the dialect does not support locking.
+	Locker() Locker
+
+	// TransactionalDDL reports if schema changes, e.g. CREATE TABLE, can be rolled back
+	// as part of a transaction rather than implicitly committing it.
+	TransactionalDDL() bool
+
+	// TableExistsSQL returns a query that selects a single boolean row that is true if
+	// the table whose name is bound to the first placeholder exists in the schema that
+	// is bound to the second placeholder, or in the current schema if it is empty.
+	TableExistsSQL() string
+
+	// SchemaSQL returns the source of the bootstrap migration that creates and drops
+	// the migrations table, formatted as a migration file with up and down directives.
+	// The {table} token is replaced with the name of the migrations table, qualified by
+	// its schema if one is set, see SetTableSchema.
+	SchemaSQL() string
+}
+
+// Dialects supported by tidal. PostgreSQL is the default dialect.
+var (
+	Postgres Dialect = postgres{}
+	MySQL    Dialect = mysql{}
+	SQLite   Dialect = sqlite{}
+)
+
+// The dialect used to generate queries to the migrations table.
+var dialect = Postgres
+
+// SetDialect specifies the dialect of the database being migrated so that tidal can
+// generate compatible queries when updating the migrations table. By default tidal
+// uses the Postgres dialect. This should be set before calling Migrate or Rollback.
+func SetDialect(d Dialect) {
+	if d == nil {
+		d = Postgres
+	}
+	dialect = d
+}
+
+// CurrentDialect returns the dialect specified by SetDialect (Postgres by default).
+func CurrentDialect() Dialect {
+	return dialect
+}
+
+// Used to find numbered placeholders in tidal's internal queries
+var placere = regexp.MustCompile(`\$(\d+)`)
+
+// Rewrites an internal query for the current configuration: the {table} token is
+// replaced with the migrations table name, qualified by its schema if one is set, and
+// Postgres-style $n placeholders are rewritten into the placeholder style of the
+// current dialect.
+func bind(query string) string {
+	query = strings.Replace(query, "{table}", qualifiedTable(dialect), -1)
+	if dialect == Postgres {
+		return query
+	}
+
+	return placere.ReplaceAllStringFunc(query, func(s string) string {
+		n, _ := strconv.Atoi(s[1:])
+		return dialect.Placeholder(n)
+	})
+}
+
+type postgres struct{}
+
+func (postgres) Name() string             { return "postgres" }
+func (postgres) Placeholder(n int) string { return "$" + strconv.Itoa(n) }
+func (postgres) MultiStatements() bool    { return true }
+func (postgres) Separator() string        { return defaultSeparator }
+func (postgres) Locker() Locker           { return advisoryLocker{} }
+func (postgres) TransactionalDDL() bool   { return true }
+func (postgres) TableExistsSQL() string {
+	return "SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name=$1 AND table_schema=COALESCE(NULLIF($2, ''), current_schema()))"
+}
+func (postgres) SchemaSQL() string { return schema }
+
+type mysql struct{}
+
+func (mysql) Name() string           { return "mysql" }
+func (mysql) Placeholder(int) string { return "?" }
+func (mysql) MultiStatements() bool  { return false }
+func (mysql) Separator() string      { return defaultSeparator }
+func (mysql) Locker() Locker         { return namedLocker{} }
+func (mysql) TransactionalDDL() bool { return false }
+func (mysql) TableExistsSQL() string {
+	return "SELECT COUNT(*) > 0 FROM information_schema.tables WHERE table_name=? AND table_schema=COALESCE(NULLIF(?, ''), DATABASE())"
+}
+func (mysql) SchemaSQL() string { return mysqlSchema }
+
+type sqlite struct{}
+
+func (sqlite) Name() string           { return "sqlite3" }
+func (sqlite) Placeholder(int) string { return "?" }
+func (sqlite) MultiStatements() bool  { return true }
+func (sqlite) Separator() string      { return defaultSeparator }
+func (sqlite) Locker() Locker         { return nil }
+func (sqlite) TransactionalDDL() bool { return true }
+func (sqlite) TableExistsSQL() string {
+	return "SELECT COUNT(*) > 0 FROM pragma_table_info(?, NULLIF(?, ''))"
+}
+func (sqlite) SchemaSQL() string { return sqliteSchema }
+
+// Returns true if the SQL of migrations must be split into statements before it is
+// executed, either because the driver cannot execute multiple statements at once or
+// because the separator of the dialect is not a semicolon.
+func requiresSplit(d Dialect) bool {
+	return !d.MultiStatements() || d.Separator() != defaultSeparator
+}
+
+// Quotes the identifier for the dialect: MySQL quotes identifiers with backticks while
+// PostgreSQL and SQLite use double quotes. The identifier must not contain quotes.
+func quoteIdent(d Dialect, name string) string {
+	if d == MySQL {
+		return "`" + name + "`"
+	}
+	return `"` + name + `"`
+}
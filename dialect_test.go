@@ -0,0 +1,24 @@
+package tidal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBind(t *testing.T) {
+	defer SetDialect(Postgres)
+	query := "UPDATE {table} SET active=$1, applied=$2 WHERE revision=$3"
+
+	SetDialect(nil)
+	require.Equal(t, Postgres, dialect)
+	require.Equal(t, Postgres, CurrentDialect())
+	require.Equal(t, "UPDATE migrations SET active=$1, applied=$2 WHERE revision=$3", bind(query))
+
+	SetDialect(MySQL)
+	require.Equal(t, MySQL, CurrentDialect())
+	require.Equal(t, "UPDATE migrations SET active=?, applied=? WHERE revision=?", bind(query))
+
+	SetDialect(SQLite)
+	require.Equal(t, "UPDATE migrations SET active=?, applied=? WHERE revision=?", bind(query))
+}
@@ -0,0 +1,196 @@
+package tidal
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// Standard errors that can be checked with errors.Is to determine the cause of an error.
+var (
+	ErrNotRegistered     = errors.New("revision was not registered")
+	ErrDuplicateRevision = errors.New("revision already exists")
+	ErrUninitialized     = errors.New("migrations table does not exist")
+	ErrIrreversible      = errors.New("migration cannot be rolled back")
+	ErrFileTooLarge      = errors.New("migration file is too large")
+	ErrOutOfOrder        = errors.New("migrations are out of order")
+	ErrSchemaVersion     = errors.New("unsupported migrations table schema version")
+	ErrDirty             = errors.New("migrations table is dirty")
+	ErrActiveSuccessors  = errors.New("later revisions are still active")
+	ErrSchemaMismatch    = errors.New("schema was not restored by rollback")
+)
+
+// NotRegisteredError is returned when an operation requires a revision that has not
+// been registered. It matches ErrNotRegistered using errors.Is.
+type NotRegisteredError struct {
+	Revision int
+}
+
+func (e *NotRegisteredError) Error() string {
+	return fmt.Sprintf("revision %d was not registered", e.Revision)
+}
+
+// Is allows NotRegisteredError to be compared to ErrNotRegistered with errors.Is.
+func (e *NotRegisteredError) Is(target error) bool {
+	return target == ErrNotRegistered
+}
+
+// DuplicateRevisionError is returned when a migration cannot be registered because a
+// migration with the same revision is already registered. It matches
+// ErrDuplicateRevision using errors.Is.
+type DuplicateRevisionError struct {
+	Revision int
+}
+
+func (e *DuplicateRevisionError) Error() string {
+	return fmt.Sprintf("cannot register migration with revision %d: revision already exists", e.Revision)
+}
+
+// Is allows DuplicateRevisionError to be compared to ErrDuplicateRevision with errors.Is.
+func (e *DuplicateRevisionError) Is(target error) bool {
+	return target == ErrDuplicateRevision
+}
+
+// OutOfOrderError is returned by Migrate when registered migrations are pending at a
+// lower revision than the latest applied revision, e.g. when a migration from another
+// branch is merged after later revisions were applied. It matches ErrOutOfOrder using
+// errors.Is.
+type OutOfOrderError struct {
+	Revisions []int // the pending revisions below the latest applied revision
+	Latest    int   // the latest applied revision
+}
+
+func (e *OutOfOrderError) Error() string {
+	return fmt.Sprintf("migrations are out of order: revision(s) %s are pending but revision %d has already been applied", joinInts(e.Revisions), e.Latest)
+}
+
+// Is allows OutOfOrderError to be compared to ErrOutOfOrder with errors.Is.
+func (e *OutOfOrderError) Is(target error) bool {
+	return target == ErrOutOfOrder
+}
+
+// ActiveSuccessorsError is returned when a single migration is rolled back with
+// Migration.Down while revisions after it are still active. Down migrations assume that
+// the revisions after them have already been rolled back, so rolling back a revision
+// out from under its successors almost always breaks the schema that they depend on.
+// It matches ErrActiveSuccessors using errors.Is.
+type ActiveSuccessorsError struct {
+	Revision   int   // the revision that was to be rolled back
+	Successors []int // the active revisions after the revision, in ascending order
+}
+
+func (e *ActiveSuccessorsError) Error() string {
+	return fmt.Sprintf("cannot roll back revision %d: later revision(s) %s are still active, roll back to revision %d instead", e.Revision, joinInts(e.Successors), e.Revision-1)
+}
+
+// Is allows ActiveSuccessorsError to be compared to ErrActiveSuccessors with errors.Is.
+func (e *ActiveSuccessorsError) Is(target error) bool {
+	return target == ErrActiveSuccessors
+}
+
+// SchemaMismatchError is returned by TestRoundTrip when the schema of the database after
+// a revision was applied and rolled back differs from the schema before it was applied,
+// e.g. because the down SQL does not drop an index that the up SQL created. It matches
+// ErrSchemaMismatch using errors.Is.
+type SchemaMismatchError struct {
+	Revision  int      // the revision whose down SQL does not reverse its up SQL
+	Missing   []string // the schema objects that were dropped by the round trip
+	Remaining []string // the schema objects that were created by the round trip
+}
+
+func (e *SchemaMismatchError) Error() string {
+	diff := make([]string, 0, len(e.Missing)+len(e.Remaining))
+	for _, object := range e.Missing {
+		diff = append(diff, "- "+object)
+	}
+	for _, object := range e.Remaining {
+		diff = append(diff, "+ "+object)
+	}
+	return fmt.Sprintf("rolling back revision %d did not restore the schema:\n%s", e.Revision, strings.Join(diff, "\n"))
+}
+
+// Is allows SchemaMismatchError to be compared to ErrSchemaMismatch with errors.Is.
+func (e *SchemaMismatchError) Is(target error) bool {
+	return target == ErrSchemaMismatch
+}
+
+// ShardError is returned by MigrateAll if the migrations could not be applied to one or
+// more of the databases. Shards are identified by their index in the connections passed
+// to MigrateAll so that the failed shards can be retried.
+type ShardError struct {
+	Succeeded []int         // the shards whose migrations were applied, in ascending order
+	Failed    map[int]error // the error that occurred migrating each failed shard
+}
+
+func (e *ShardError) Error() string {
+	shards := e.FailedShards()
+	msgs := make([]string, 0, len(shards))
+	for _, shard := range shards {
+		msgs = append(msgs, fmt.Sprintf("shard %d: %s", shard, e.Failed[shard]))
+	}
+	return fmt.Sprintf("migration failed on %d of %d shard(s): %s", len(shards), len(shards)+len(e.Succeeded), strings.Join(msgs, "; "))
+}
+
+// FailedShards returns the shards whose migrations failed in ascending order.
+func (e *ShardError) FailedShards() []int {
+	shards := make([]int, 0, len(e.Failed))
+	for shard := range e.Failed {
+		shards = append(shards, shard)
+	}
+	sort.Ints(shards)
+	return shards
+}
+
+// DirectoryError is returned when a migrations directory, or one of its subdirectories,
+// cannot be read while searching it for migration files. Use errors.Is with
+// fs.ErrPermission to check if the directory could not be read due to its permissions.
+type DirectoryError struct {
+	Path string
+	Err  error
+}
+
+func (e *DirectoryError) Error() string {
+	if errors.Is(e.Err, fs.ErrPermission) {
+		return fmt.Sprintf("could not read migrations directory %q: permission denied", e.Path)
+	}
+
+	// Do not repeat the path if the underlying error already describes it
+	err := e.Err
+	var perr *fs.PathError
+	if errors.As(err, &perr) {
+		err = perr.Err
+	}
+	return fmt.Sprintf("could not read migrations directory %q: %s", e.Path, err)
+}
+
+// Unwrap returns the underlying error that occurred when reading the directory.
+func (e *DirectoryError) Unwrap() error {
+	return e.Err
+}
+
+// MultiError collects the errors that occur while processing multiple migrations, e.g.
+// when opening every migration file in a directory, so that all of them are reported.
+type MultiError []error
+
+func (e MultiError) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d errors occurred: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// ErrorOrNil returns nil if there are no errors so that an empty MultiError is not
+// returned as a non-nil error interface.
+func (e MultiError) ErrorOrNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,148 @@
+package tidal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ExportOptions modify the default behavior of Export.
+type ExportOptions struct {
+	// Overwrite replaces migration files that already exist in the directory, otherwise
+	// an error is returned before any files are written.
+	Overwrite bool
+}
+
+// Export writes the registered migrations to .sql files in the specified directory,
+// e.g. to audit or edit the migrations that were compiled into an application with
+// Generate when the original files are no longer available. Export is the inverse of
+// Generate: each file is named by the filename of the migration's descriptor, e.g.
+// 0001_create_users.sql, and reconstructs the directive format of the original file
+// from the package, metadata, and requires directives followed by the up and down SQL
+// delimited by their -- migrate: directives and options.
+//
+// Comments before the first directive of the original file are not reconstructed, but
+// the exported migrations have the same SQL, options, and checksums as the originals
+// (see Migration.Equal) and can be registered or generated again. The paths of the
+// files that were written are returned in revision order, followed by the repeatable
+// migrations in name order.
+func Export(dir string, opts ...ExportOptions) (paths []string, err error) {
+	return DefaultRegistry.Export(dir, opts...)
+}
+
+// Export writes the migrations in the registry to the directory, see Export.
+func (r *Registry) Export(dir string, opts ...ExportOptions) (paths []string, err error) {
+	var opt ExportOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	migrations := append(r.registered(), r.repeatables()...)
+	files := make([]string, 0, len(migrations))
+	sources := make([]string, 0, len(migrations))
+	for _, m := range migrations {
+		what := fmt.Sprintf("revision %d", m.Revision)
+		if m.Repeatable() {
+			what = "repeatable migration " + m.Name
+		}
+
+		var name, src string
+		if name, src, err = m.export(); err != nil {
+			return nil, fmt.Errorf("could not export %s: %s", what, err)
+		}
+
+		path := filepath.Join(dir, filepath.Base(name))
+		if !opt.Overwrite {
+			if _, err = os.Stat(path); err == nil {
+				return nil, fmt.Errorf("could not export %s: %s already exists", what, path)
+			}
+		}
+
+		files = append(files, path)
+		sources = append(sources, src)
+	}
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	for i, path := range files {
+		if err = ioutil.WriteFile(path, []byte(sources[i]), 0644); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// Returns the filename and reconstructed SQL source of the migration.
+func (m *Migration) export() (name, src string, err error) {
+	if name, _, err = m.descriptor.Info(); err != nil {
+		return "", "", err
+	}
+
+	if name == "" {
+		name = fmt.Sprintf("%04d_%s.sql", m.Revision, strings.ReplaceAll(m.Name, " ", "_"))
+		if m.Repeatable() {
+			name = "R_" + strings.ReplaceAll(m.Name, " ", "_") + ".sql"
+		}
+	}
+
+	var sb strings.Builder
+	var pkg string
+	if pkg, err = m.descriptor.Package(); err != nil {
+		return "", "", err
+	}
+
+	if pkg != "" {
+		fmt.Fprintf(&sb, "-- package: %s\n", pkg)
+	}
+
+	var meta map[string]string
+	if meta, err = m.descriptor.Meta(); err != nil {
+		return "", "", err
+	}
+
+	keys := make([]string, 0, len(meta))
+	for key := range meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := meta[key]
+		if value == "" || strings.ContainsAny(value, " \t") {
+			value = `"` + value + `"`
+		}
+		fmt.Fprintf(&sb, "-- tidal: %s=%s\n", key, value)
+	}
+
+	var requires []int
+	if requires, err = m.descriptor.Requires(); err != nil {
+		return "", "", err
+	}
+
+	if len(requires) > 0 {
+		fmt.Fprintf(&sb, "-- migrate: requires %s\n", joinInts(requires))
+	}
+
+	for _, direction := range []string{"up", "down"} {
+		var opts []string
+		if opts, err = m.descriptor.Options(direction); err != nil {
+			return "", "", err
+		}
+
+		var sql string
+		if sql, err = m.descriptor.readBetween(direction); err != nil {
+			return "", "", err
+		}
+
+		sb.WriteString(strings.Join(append([]string{"-- migrate:", direction}, opts...), " "))
+		sb.WriteRune('\n')
+		sb.WriteString(sql)
+	}
+	return name, sb.String(), nil
+}
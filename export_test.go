@@ -0,0 +1,73 @@
+package tidal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExport(t *testing.T) {
+	defer Reset()
+	migrations := []struct {
+		filename string
+		src      string
+	}{
+		{"0001_create_users.sql", "-- Creates the users table\n-- package: foo\n-- tidal: author=jsmith ticket=\"PROJ 123\"\n\n-- migrate: up\nCREATE TABLE users (id integer);\n\n-- migrate: down\nDROP TABLE users;\n"},
+		{"0002_users_email_index.sql", "-- migrate: up notransaction\nCREATE INDEX users_email ON users (email);\n-- migrate: down notransaction\nDROP INDEX users_email;\n"},
+		{"0003_create_roles.sql", "-- migrate: requires 1\n-- migrate: up\nCREATE TABLE roles (id integer);\n"},
+	}
+
+	for _, m := range migrations {
+		d, err := NewDescriptor(strings.NewReader(m.src), m.filename)
+		require.NoError(t, err)
+		require.NoError(t, RegisterDescriptor(d))
+	}
+
+	dir := filepath.Join(t.TempDir(), "exported")
+	paths, err := Export(dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(dir, "0001_create_users.sql"),
+		filepath.Join(dir, "0002_users_email_index.sql"),
+		filepath.Join(dir, "0003_create_roles.sql"),
+	}, paths)
+
+	data, err := ioutil.ReadFile(paths[0])
+	require.NoError(t, err)
+	require.Equal(t, "-- package: foo\n-- tidal: author=jsmith\n-- tidal: ticket=\"PROJ 123\"\n-- migrate: up\nCREATE TABLE users (id integer);\n\n-- migrate: down\nDROP TABLE users;\n", string(data))
+
+	data, err = ioutil.ReadFile(paths[2])
+	require.NoError(t, err)
+	require.Equal(t, "-- migrate: requires 1\n-- migrate: up\nCREATE TABLE roles (id integer);\n-- migrate: down\n", string(data))
+
+	// The exported migrations are equal to the registered migrations
+	registered := Migrations()
+	for i, path := range paths {
+		m, err := Open(path)
+		require.NoError(t, err)
+		require.True(t, m.Equal(registered[i]), "revision %d is not equal", m.Revision)
+
+		expected, err := registered[i].Checksum()
+		require.NoError(t, err)
+		checksum, err := m.Checksum()
+		require.NoError(t, err)
+		require.Equal(t, expected, checksum)
+
+		require.Equal(t, registered[i].Meta(), m.Meta())
+	}
+
+	// Existing files are not replaced unless overwrite is specified
+	require.NoError(t, os.Remove(paths[1]))
+	_, err = Export(dir)
+	require.EqualError(t, err, "could not export revision 1: "+paths[0]+" already exists")
+	_, err = os.Stat(paths[1])
+	require.True(t, os.IsNotExist(err), "no files should be written if any file exists")
+
+	paths, err = Export(dir, ExportOptions{Overwrite: true})
+	require.NoError(t, err)
+	require.Len(t, paths, 3)
+}
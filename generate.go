@@ -4,10 +4,15 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"go/ast"
 	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"text/template"
 )
 
@@ -18,64 +23,220 @@ package {{ .PackageName }}
 
 import "github.com/rotationalio/tidal"
 
+{{ if .Func -}}
+// {{ .Func }} registers the migrations generated from {{ .Source }}, it must be
+// called once before the migrations are applied.
+func {{ .Func }}() error {
+	{{- range .Descriptors }}
+	if err := tidal.RegisterDescriptor({{ .Name }}); err != nil {
+		return err
+	}
+	{{- end }}
+	return nil
+}
+{{- else -}}
 func init() {
-	{{- range $varname, $value := .Descriptors }}
-	tidal.RegisterDescriptor({{ $varname }})
+	{{- range .Descriptors }}
+	if err := tidal.RegisterDescriptor({{ .Name }}); err != nil {
+		panic(err)
+	}
 	{{- end }}
 }
+{{- end }}
 
-{{- range $varname, $value := .Descriptors }}
-var {{ $varname }} = {{ $value }}
+{{- range .Descriptors }}
+var {{ .Name }} = {{ .Repr }}
+
+{{- end }}
+`
+
+const embedded string = `// Code generated by tidal. DO NOT EDIT.
+// source: {{ .Source }}
+
+package {{ .PackageName }}
+
+import (
+	"embed"
+
+	"github.com/rotationalio/tidal"
+)
+
+{{ range .Descriptors }}
+//go:embed {{ .Path }}
+{{- end }}
+var descriptors embed.FS
+
+{{ if .Func -}}
+// {{ .Func }} registers the migrations generated from {{ .Source }}, it must be
+// called once before the migrations are applied.
+func {{ .Func }}() error {
+	for _, path := range []string{
+		{{- range .Descriptors }}
+		"{{ .Path }}",
+		{{- end }}
+	} {
+		data, err := descriptors.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		if err = tidal.RegisterDescriptor(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+{{- else -}}
+func init() {
+	for _, path := range []string{
+		{{- range .Descriptors }}
+		"{{ .Path }}",
+		{{- end }}
+	} {
+		data, err := descriptors.ReadFile(path)
+		if err != nil {
+			panic(err)
+		}
 
+		if err = tidal.RegisterDescriptor(data); err != nil {
+			panic(err)
+		}
+	}
+}
 {{- end }}
 `
 
-var bindataTemplate = template.Must(template.New("").Parse(bindata))
+var (
+	bindataTemplate  = template.Must(template.New("").Parse(bindata))
+	embeddedTemplate = template.Must(template.New("").Parse(embedded))
+)
+
+// The directory, relative to the generated code file, that descriptors are written to
+// when they are embedded into the generated code with a go:embed directive.
+const embedDir = "migrations"
+
+// GenerateOptions modify the default behavior of Generate.
+type GenerateOptions struct {
+	// Embed writes each compressed descriptor to a .bin file in the migrations
+	// directory next to the generated code file and generates code that embeds them
+	// with go:embed directives rather than as byte slice literals, which are slow to
+	// compile for large sets of migrations. The generated code requires Go 1.16.
+	Embed bool
+
+	// Recursive includes the migration files in subdirectories of the migrations
+	// directory, see RegisterOptions.
+	Recursive bool
+
+	// StripComments removes the SQL comments from the generated descriptors, preserving
+	// the directives, to reduce the size of the generated code and of the application
+	// binary, see Descriptor.StripComments. Descriptors that would not be smaller once
+	// their comments are stripped are generated unmodified.
+	StripComments bool
+
+	// Func generates an exported function with the specified name that registers the
+	// migrations and returns any error, rather than an init function that registers
+	// them as a side effect of importing the package and panics on error. The function
+	// must be called once before the migrations are applied, which is easy to forget,
+	// but it allows applications and tests to choose if and when the migrations are
+	// registered, e.g. after calling tidal.Reset in a test.
+	Func string
+}
 
 // generateContext is used to populate data into the code template.
 type generateContext struct {
 	Source      string
 	PackageName string
-	Descriptors map[string]string
+	Func        string
+	Descriptors []descriptorContext
+}
+
+// descriptorContext is the variable name and representation of a single descriptor,
+// or the path of the descriptor file if it is embedded.
+type descriptorContext struct {
+	Name string
+	Repr string
+	Path string
 }
 
 // Generate code and descriptors to embed migrations into an application package. The
 // generate command requires the path to the migrations directory and the location to
 // write the generated code file out to. Optionally, a packageName can be supplied,
-// otherwise any package directives in the migration files will be used or simply the
-// basename of the specified outpath.
-func Generate(migrations, outpath, packageName string) (err error) {
+// otherwise any package directives in the migration files will be used, then the package
+// of the Go files already in the output directory, or simply the basename of the
+// directory of the specified outpath.
+func Generate(migrations, outpath, packageName string, opts ...GenerateOptions) (err error) {
+	var opt GenerateOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	if opt.Func != "" && !(token.IsIdentifier(opt.Func) && token.IsExported(opt.Func)) {
+		return fmt.Errorf("%q is not a valid exported Go function name", opt.Func)
+	}
+
 	// Find all migration files in the migrations directory and parse them.
 	var objs []Migration
-	if objs, err = parseMigrations(migrations); err != nil {
+	if objs, err = parseMigrations(migrations, opt.Recursive); err != nil {
 		return err
 	}
 
 	// Migrations must be sorted, ensure that they are
 	sort.Sort(ByRevision(objs))
 
-	// Find the package name if not specified
-	if packageName == "" {
-		if packageName, err = determinePackage(objs, outpath); err != nil {
-			return err
+	// The stripped descriptor records the checksum of the original, so it is only used
+	// if it is actually smaller, e.g. not for migrations with only a few comments
+	if opt.StripComments {
+		for i := range objs {
+			var stripped Descriptor
+			if stripped, err = objs[i].descriptor.StripComments(); err != nil {
+				return fmt.Errorf("could not strip comments from revision %d: %s", objs[i].Revision, err)
+			}
+
+			if len(stripped) < len(objs[i].descriptor) {
+				objs[i].descriptor = stripped
+			}
 		}
 	}
 
+	// Find the package name if not specified
+	if packageName, err = determinePackage(objs, outpath, packageName); err != nil {
+		return err
+	}
+
 	// Create the code generation context
 	ctx := &generateContext{
 		Source:      migrations,
 		PackageName: packageName,
-		Descriptors: make(map[string]string),
+		Func:        opt.Func,
+		Descriptors: make([]descriptorContext, 0, len(objs)),
 	}
 
-	for _, m := range objs {
-		key := fmt.Sprintf("revision%d", m.Revision)
-		ctx.Descriptors[key] = m.descriptor.Repr()
+	tmpl := bindataTemplate
+	if opt.Embed {
+		tmpl = embeddedTemplate
+		if ctx.Descriptors, err = writeDescriptors(objs, filepath.Join(filepath.Dir(outpath), embedDir)); err != nil {
+			return err
+		}
+	} else {
+		repeatable := 0
+		for _, m := range objs {
+			// Repeatable migrations do not have a revision to name their variable by
+			name := fmt.Sprintf("revision%d", m.Revision)
+			if m.Repeatable() {
+				repeatable++
+				name = fmt.Sprintf("repeatable%d", repeatable)
+			}
+
+			ctx.Descriptors = append(ctx.Descriptors, descriptorContext{
+				Name: name,
+				Repr: m.descriptor.Repr(),
+			})
+		}
 	}
 
 	// Execute the template
 	builder := &bytes.Buffer{}
-	if err = bindataTemplate.Execute(builder, ctx); err != nil {
+	if err = tmpl.Execute(builder, ctx); err != nil {
 		return err
 	}
 
@@ -99,63 +260,163 @@ func Generate(migrations, outpath, packageName string) (err error) {
 	return nil
 }
 
-// Find all *.sql files in the specified directory, open them and return the loaded and
-// parsed migrations (unregistered, this is separate from the migrations list).
-func parseMigrations(dir string) (migrations []Migration, err error) {
+// Writes the descriptors of the migrations to .bin files in the specified directory,
+// removing any stale descriptor files that were previously generated. Returns the paths
+// of the descriptor files relative to the parent of the directory for go:embed.
+func writeDescriptors(migrations []Migration, dir string) (files []descriptorContext, err error) {
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	var stale []string
+	if stale, err = filepath.Glob(filepath.Join(dir, "*.bin")); err != nil {
+		return nil, err
+	}
+
+	for _, path := range stale {
+		if name := strings.TrimSuffix(filepath.Base(path), ".bin") + ".sql"; fnamere.MatchString(name) || repeatre.MatchString(name) {
+			if err = os.Remove(path); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	files = make([]descriptorContext, 0, len(migrations))
+	for _, m := range migrations {
+		var name string
+		if name, _, err = m.descriptor.Info(); err != nil {
+			return nil, err
+		}
+
+		name = strings.TrimSuffix(name, ".sql") + ".bin"
+		if err = ioutil.WriteFile(filepath.Join(dir, name), m.descriptor, 0644); err != nil {
+			return nil, err
+		}
+		files = append(files, descriptorContext{Path: filepath.Base(dir) + "/" + name})
+	}
+	return files, nil
+}
+
+// Find all migration files in the specified directory, open them and return the loaded
+// and parsed migrations (unregistered, this is separate from the migrations list). SQL
+// files that do not match the migration filename pattern are ignored, as are
+// subdirectories unless recursive is true.
+func parseMigrations(dir string, recursive bool) (migrations []Migration, err error) {
 	// Find the migration files to generate descriptors from.
-	var paths []string
-	if paths, err = filepath.Glob(filepath.Join(dir, "*.sql")); err != nil {
-		return nil, fmt.Errorf("could not find *.sql files in %q: %s", dir, err)
+	var filenames []string
+	if filenames, err = migrationFiles(os.DirFS(dir), ".", RegisterOptions{Recursive: recursive}); err != nil {
+		return nil, fmt.Errorf("could not find migration files in %q: %s", dir, err)
 	}
 
-	if len(paths) == 0 {
-		return nil, errors.New("no migrations files found")
+	// Ensure every revision is unique
+	var errs MultiError
+	if filenames, errs = uniqueRevisions(filenames); errs != nil {
+		return nil, errs
 	}
 
 	// Parse the migrations from the files
-	migrations = make([]Migration, 0, len(paths))
-	for _, path := range paths {
+	migrations = make([]Migration, 0, len(filenames))
+	for _, filename := range filenames {
+		path := filepath.Join(dir, filepath.FromSlash(filename))
 		var m Migration
 		if m, err = Open(path); err != nil {
 			return nil, err
 		}
 		migrations = append(migrations, m)
 	}
+
+	if len(migrations) == 0 {
+		return nil, errors.New("no migrations files found")
+	}
 	return migrations, nil
 }
 
-func determinePackage(migrations []Migration, outpath string) (packageName string, err error) {
-	names := make(map[string]bool)
+// Determine the package name of the generated code. In priority order, the package name
+// is the explicitly specified name, the package directive shared by the migrations, the
+// package clause of existing Go files in the output directory, or finally the basename
+// of the output directory. An error is returned if the migrations specify conflicting
+// package directives or if the resolved name is not a valid Go identifier.
+func determinePackage(migrations []Migration, outpath, explicit string) (packageName string, err error) {
+	if explicit != "" {
+		return validPackage(explicit)
+	}
+
+	// Collect the package directives from the migrations
+	names := make(map[string][]int)
 	for _, m := range migrations {
 		var name string
 		if name, err = m.Package(); err != nil {
 			return "", err
 		}
 		if name != "" {
-			names[name] = true
+			names[name] = append(names[name], m.Revision)
 		}
 	}
 
 	if len(names) > 1 {
-		return "", fmt.Errorf("discovered %d unique package names, please specify package name", len(names))
+		conflicts := make([]string, 0, len(names))
+		for name, revisions := range names {
+			conflicts = append(conflicts, fmt.Sprintf("%s (revision %s)", name, joinInts(revisions)))
+		}
+		sort.Strings(conflicts)
+		return "", fmt.Errorf("migrations specify conflicting package names: %s; please specify package name", strings.Join(conflicts, ", "))
 	}
 
-	if len(names) == 1 {
-		for name := range names {
-			return name, nil
-		}
+	for name := range names {
+		return validPackage(name)
 	}
 
-	if outpath != "" {
-		packageName = filepath.Base(filepath.Dir(outpath))
-		if packageName == "." {
-			if wd, err := os.Getwd(); err == nil {
-				return filepath.Base(wd), nil
-			}
-		} else {
-			return packageName, nil
+	if outpath == "" {
+		return "", fmt.Errorf("could not determine package name from %d migrations and %q outpath", len(migrations), outpath)
+	}
+
+	// Use the package of the Go files already in the output directory
+	dir := filepath.Dir(outpath)
+	if packageName, err = sourcePackage(dir, filepath.Base(outpath)); err != nil {
+		return "", err
+	}
+	if packageName != "" {
+		return packageName, nil
+	}
+
+	// Otherwise fall back to the name of the output directory
+	if dir, err = filepath.Abs(dir); err != nil {
+		return "", fmt.Errorf("could not determine package name from %q: %s", outpath, err)
+	}
+	return validPackage(filepath.Base(dir))
+}
+
+// Returns the package name declared by the non-test Go files in dir, excluding the file
+// being generated, or an empty string if there are no Go files in the directory.
+func sourcePackage(dir, exclude string) (packageName string, err error) {
+	var paths []string
+	if paths, err = filepath.Glob(filepath.Join(dir, "*.go")); err != nil {
+		return "", err
+	}
+
+	fset := token.NewFileSet()
+	for _, path := range paths {
+		if filepath.Base(path) == exclude || strings.HasSuffix(path, "_test.go") {
+			continue
 		}
+
+		var f *ast.File
+		if f, err = parser.ParseFile(fset, path, nil, parser.PackageClauseOnly); err != nil {
+			return "", fmt.Errorf("could not parse package clause: %s", err)
+		}
+
+		if packageName != "" && packageName != f.Name.Name {
+			return "", fmt.Errorf("found multiple packages %s and %s in %q", packageName, f.Name.Name, dir)
+		}
+		packageName = f.Name.Name
 	}
+	return packageName, nil
+}
 
-	return "", fmt.Errorf("could not determine package name from %d migrations and %q outpath", len(migrations), outpath)
+// Returns the name if it is a valid package name, otherwise an error.
+func validPackage(name string) (string, error) {
+	if !token.IsIdentifier(name) {
+		return "", fmt.Errorf("%q is not a valid Go package name", name)
+	}
+	return name, nil
 }
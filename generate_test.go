@@ -1,20 +1,317 @@
 package tidal
 
 import (
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 )
 
+var update = flag.Bool("update", false, "update golden files")
+
+// The program compiled with the generated code, it prints the registered migrations.
+const generateMain = `package main
+
+import (
+	"fmt"
+
+	"github.com/rotationalio/tidal"
+	_ "github.com/rotationalio/tidal/%s/migrations"
+)
+
+func main() {
+	for _, m := range tidal.Migrations() {
+		pkg, err := m.Package()
+		if err != nil {
+			panic(err)
+		}
+
+		checksum, err := m.Checksum()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%%04d %%s %%s %%s\n", m.Revision, m.Name, pkg, checksum)
+	}
+}
+`
+
+// The program compiled with code generated with a registration function, it calls the
+// function and prints the registered migrations.
+const generateFuncMain = `package main
+
+import (
+	"fmt"
+
+	"github.com/rotationalio/tidal"
+	"github.com/rotationalio/tidal/%s/migrations"
+)
+
+func main() {
+	fmt.Println(len(tidal.Migrations()))
+	if err := migrations.RegisterMigrations(); err != nil {
+		panic(err)
+	}
+
+	for _, m := range tidal.Migrations() {
+		fmt.Printf("%%04d %%s\n", m.Revision, m.Name)
+	}
+}
+`
+
+func TestGenerate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping compilation of generated code in short mode")
+	}
+
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain is not available")
+	}
+
+	for _, embed := range []bool{false, true} {
+		// The generated code must be compiled inside of the module to import tidal
+		tmpdir, err := ioutil.TempDir(".", "generate")
+		require.NoError(t, err)
+		defer os.RemoveAll(tmpdir)
+
+		outpath := filepath.Join(tmpdir, "migrations", "migrations.go")
+		require.NoError(t, os.Mkdir(filepath.Dir(outpath), 0755))
+		require.NoError(t, Generate("testdata", outpath, "migrations", GenerateOptions{Embed: embed}))
+
+		src, err := ioutil.ReadFile(outpath)
+		require.NoError(t, err)
+		formatted, err := format.Source(src)
+		require.NoError(t, err)
+		require.Equal(t, string(formatted), string(src), "generated code is not gofmt clean")
+
+		if embed {
+			bins, err := filepath.Glob(filepath.Join(tmpdir, "migrations", embedDir, "*.bin"))
+			require.NoError(t, err)
+			require.Len(t, bins, 2)
+		}
+
+		main := []byte(fmt.Sprintf(generateMain, filepath.Base(tmpdir)))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(tmpdir, "main.go"), main, 0644))
+
+		cmd := exec.Command(gobin, "run", "./"+filepath.Base(tmpdir))
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+
+		golden := filepath.Join("testdata", "generate.golden")
+		if *update && !embed {
+			require.NoError(t, ioutil.WriteFile(golden, out, 0644))
+		}
+
+		expected, err := ioutil.ReadFile(golden)
+		require.NoError(t, err)
+		require.Equal(t, string(expected), string(out), "embed mode %t", embed)
+	}
+}
+
+func TestGenerateFunc(t *testing.T) {
+	// The function name must be exported
+	for _, name := range []string{"registerMigrations", "Register Migrations", "1Register"} {
+		err := Generate("testdata", filepath.Join(t.TempDir(), "migrations.go"), "migrations", GenerateOptions{Func: name})
+		require.EqualError(t, err, fmt.Sprintf("%q is not a valid exported Go function name", name))
+	}
+
+	if testing.Short() {
+		t.Skip("skipping compilation of generated code in short mode")
+	}
+
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain is not available")
+	}
+
+	for _, embed := range []bool{false, true} {
+		// The generated code must be compiled inside of the module to import tidal
+		tmpdir, err := ioutil.TempDir(".", "generate")
+		require.NoError(t, err)
+		defer os.RemoveAll(tmpdir)
+
+		outpath := filepath.Join(tmpdir, "migrations", "migrations.go")
+		require.NoError(t, os.Mkdir(filepath.Dir(outpath), 0755))
+		require.NoError(t, Generate("testdata", outpath, "migrations", GenerateOptions{Embed: embed, Func: "RegisterMigrations"}))
+
+		src, err := ioutil.ReadFile(outpath)
+		require.NoError(t, err)
+		formatted, err := format.Source(src)
+		require.NoError(t, err)
+		require.Equal(t, string(formatted), string(src), "generated code is not gofmt clean")
+		require.NotContains(t, string(src), "func init()")
+		require.Contains(t, string(src), "func RegisterMigrations() error {")
+
+		main := []byte(fmt.Sprintf(generateFuncMain, filepath.Base(tmpdir)))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(tmpdir, "main.go"), main, 0644))
+
+		// No migrations are registered until the function is called
+		cmd := exec.Command(gobin, "run", "./"+filepath.Base(tmpdir))
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+		require.Equal(t, "0\n0001 test migration\n0002 trigger function\n", string(out), "embed mode %t", embed)
+	}
+}
+
+func TestGenerateStripComments(t *testing.T) {
+	tmpdir := t.TempDir()
+	mdir := filepath.Join(tmpdir, "migrations")
+	require.NoError(t, os.Mkdir(mdir, 0755))
+
+	header := strings.Repeat("-- This migration creates the users table, which stores the accounts of the application.\n", 20)
+	commented := header + "-- migrate: up\nCREATE TABLE users (id integer); -- the primary key\n-- migrate: down\nDROP TABLE users;\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(mdir, "0001_create_users.sql"), []byte(commented), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(mdir, "0002_create_groups.sql"), []byte("-- migrate: up\nCREATE TABLE groups (id integer);\n-- migrate: down\nDROP TABLE groups;\n"), 0644))
+
+	outpath := filepath.Join(tmpdir, "migrations.go")
+	require.NoError(t, Generate(mdir, outpath, "migrations", GenerateOptions{Embed: true, StripComments: true}))
+
+	data, err := ioutil.ReadFile(filepath.Join(tmpdir, embedDir, "0001_create_users.bin"))
+	require.NoError(t, err)
+	src, err := Descriptor(data).Source()
+	require.NoError(t, err)
+	require.Regexp(t, `^-- migrate: up\nCREATE TABLE users \(id integer\);\n-- migrate: down\nDROP TABLE users;\n$`, src)
+
+	// Migrations without comments are not modified
+	data, err = ioutil.ReadFile(filepath.Join(tmpdir, embedDir, "0002_create_groups.bin"))
+	require.NoError(t, err)
+	src, err = Descriptor(data).Source()
+	require.NoError(t, err)
+	require.Equal(t, "-- migrate: up\nCREATE TABLE groups (id integer);\n-- migrate: down\nDROP TABLE groups;\n", src)
+}
+
+func TestGenerateRepeatable(t *testing.T) {
+	tmpdir := t.TempDir()
+	mdir := filepath.Join(tmpdir, "migrations")
+	require.NoError(t, os.Mkdir(mdir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(mdir, "0001_create_users.sql"), []byte("-- migrate: up\nCREATE TABLE users (id integer);\n-- migrate: down\nDROP TABLE users;\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(mdir, "R_user_ids.sql"), []byte("CREATE VIEW user_ids AS SELECT id FROM users;\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(mdir, "R_user_names.sql"), []byte("CREATE VIEW user_names AS SELECT name FROM users;\n"), 0644))
+
+	// Repeatable migrations are named by their position since they have no revision
+	outpath := filepath.Join(tmpdir, "migrations.go")
+	require.NoError(t, Generate(mdir, outpath, "migrations"))
+	data, err := ioutil.ReadFile(outpath)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "var revision1 = ")
+	require.Contains(t, string(data), "var repeatable1 = ")
+	require.Contains(t, string(data), "var repeatable2 = ")
+
+	// Embedded descriptors are written by filename
+	require.NoError(t, Generate(mdir, outpath, "migrations", GenerateOptions{Embed: true}))
+	data, err = ioutil.ReadFile(filepath.Join(tmpdir, embedDir, "R_user_ids.bin"))
+	require.NoError(t, err)
+
+	registry := &Registry{}
+	require.NoError(t, registry.RegisterDescriptor(data))
+	require.Len(t, registry.Repeatables(), 1)
+}
+
+func TestParseMigrations(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "tidal")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+
+	_, err = parseMigrations(tmpdir, false)
+	require.EqualError(t, err, "no migrations files found")
+
+	for _, name := range []string{"0002_create_users.sql", "2_add_users.sql", "schema.sql"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(tmpdir, name), []byte("-- migrate: up\nSELECT 1;\n"), 0644))
+	}
+
+	_, err = parseMigrations(tmpdir, false)
+	require.EqualError(t, err, "revision 2 is defined by both 0002_create_users.sql and 2_add_users.sql")
+
+	require.NoError(t, os.Remove(filepath.Join(tmpdir, "2_add_users.sql")))
+	migrations, err := parseMigrations(tmpdir, false)
+	require.NoError(t, err)
+	require.Len(t, migrations, 1)
+	require.Equal(t, 2, migrations[0].Revision)
+
+	// Migrations in subdirectories are only found recursively
+	require.NoError(t, os.Mkdir(filepath.Join(tmpdir, "users"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tmpdir, "users", "0001_create_roles.sql"), []byte("-- migrate: up\nSELECT 1;\n"), 0644))
+	migrations, err = parseMigrations(tmpdir, false)
+	require.NoError(t, err)
+	require.Len(t, migrations, 1)
+
+	migrations, err = parseMigrations(tmpdir, true)
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+}
+
 func TestDeterminePackage(t *testing.T) {
-	t.Skip("requires descriptors to be tested")
 	migrations := []Migration{
-		{Revision: 1},
-		{Revision: 2},
+		packageMigration(t, 1, "foo"),
+		packageMigration(t, 2, ""),
+		packageMigration(t, 3, "foo"),
 	}
 
-	pkg, err := determinePackage(migrations, "")
+	// The explicit package name takes priority over the package directives
+	pkg, err := determinePackage(migrations, "", "bar")
+	require.NoError(t, err)
+	require.Equal(t, "bar", pkg)
+
+	_, err = determinePackage(migrations, "", "not-valid")
+	require.EqualError(t, err, `"not-valid" is not a valid Go package name`)
+
+	// The package directive in the migrations takes priority over the outpath
+	pkg, err = determinePackage(migrations, filepath.Join("testdata", "bar", "migrations.go"), "")
 	require.NoError(t, err)
 	require.Equal(t, "foo", pkg)
 
+	// Conflicting package directives are reported
+	conflicts := append(migrations, packageMigration(t, 4, "bar"), packageMigration(t, 5, "baz"))
+	_, err = determinePackage(conflicts, "", "")
+	require.EqualError(t, err, "migrations specify conflicting package names: bar (revision 4), baz (revision 5), foo (revision 1, 3); please specify package name")
+
+	// Without package directives the package of the output directory is used
+	migrations = []Migration{packageMigration(t, 1, "")}
+	_, err = determinePackage(migrations, "", "")
+	require.Error(t, err)
+
+	tmpdir, err := ioutil.TempDir("", "tidal")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+
+	outdir := filepath.Join(tmpdir, "models")
+	require.NoError(t, os.Mkdir(outdir, 0755))
+	outpath := filepath.Join(outdir, "migrations.go")
+
+	pkg, err = determinePackage(migrations, outpath, "")
+	require.NoError(t, err)
+	require.Equal(t, "models", pkg)
+
+	// Existing Go files in the output directory take priority over the directory name,
+	// but test files and the generated file itself are ignored.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(outdir, "models_test.go"), []byte("package models_test\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(outpath, []byte("package stale\n"), 0644))
+	pkg, err = determinePackage(migrations, outpath, "")
+	require.NoError(t, err)
+	require.Equal(t, "models", pkg)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(outdir, "db.go"), []byte("package db\n"), 0644))
+	pkg, err = determinePackage(migrations, outpath, "")
+	require.NoError(t, err)
+	require.Equal(t, "db", pkg)
+}
+
+// Creates an unregistered migration with the specified package directive.
+func packageMigration(t *testing.T, revision int, pkg string) Migration {
+	src := "-- migrate: up\nSELECT 1;\n-- migrate: down\nSELECT 1;\n"
+	if pkg != "" {
+		src = "-- package: " + pkg + "\n" + src
+	}
+
+	filename := fmt.Sprintf("%04d_package_test.sql", revision)
+	descriptor, err := NewDescriptor(strings.NewReader(src), filename)
+	require.NoError(t, err)
+	return Migration{Revision: revision, Name: "package test", descriptor: descriptor}
 }
@@ -1,8 +1,11 @@
 module github.com/rotationalio/tidal
 
-go 1.14
+go 1.16
 
 require (
+	github.com/go-sql-driver/mysql v1.6.0
+	github.com/lib/pq v1.8.0
+	github.com/mattn/go-sqlite3 v1.14.5
 	github.com/stretchr/testify v1.6.1
 	gopkg.in/urfave/cli.v1 v1.20.0
 )
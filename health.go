@@ -0,0 +1,85 @@
+package tidal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// HealthStatus is the JSON response of the StatusHandler.
+type HealthStatus struct {
+	Current int    `json:"current"`         // the current revision of the database
+	Latest  int    `json:"latest"`          // the latest registered versioned revision
+	Pending []int  `json:"pending"`         // the registered versioned revisions that have not been applied
+	Ready   bool   `json:"ready"`           // if the database is at the latest registered revision
+	Error   string `json:"error,omitempty"` // the reason the status could not be determined
+}
+
+// StatusHandler returns an http.Handler that reports if the schema of the database is
+// current, e.g. for use as a Kubernetes readiness probe. The handler responds with 200
+// and a JSON HealthStatus if every registered migration up to the latest registered
+// revision has been applied, and 503 if any migrations are pending or the migrations
+// table does not exist. Data-only migrations (see Migration.Versioned) are not required
+// to be applied for the database to be ready. If the status cannot be read from the
+// database, the handler responds with 500 and the error in the JSON body.
+func StatusHandler(conn *sql.DB) http.Handler {
+	return DefaultRegistry.StatusHandler(conn)
+}
+
+// StatusHandler reports if the database is at the latest revision of the registry.
+func (r *Registry) StatusHandler(conn *sql.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		code, status := r.health(req.Context(), conn)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(status)
+	})
+}
+
+// Returns the health status of the database and the http status code to respond with.
+func (r *Registry) health(ctx context.Context, conn *sql.DB) (code int, status HealthStatus) {
+	status.Pending = make([]int, 0)
+	migrations := make([]Migration, 0, len(r.registered()))
+	for _, m := range r.registered() {
+		if m.Versioned() {
+			migrations = append(migrations, m)
+		}
+	}
+
+	if len(migrations) > 0 {
+		status.Latest = migrations[len(migrations)-1].Revision
+	}
+
+	var err error
+	if status.Current, err = CurrentRevisionContext(ctx, conn); err != nil {
+		status.Error = err.Error()
+		if errors.Is(err, ErrUninitialized) {
+			for _, m := range migrations {
+				status.Pending = append(status.Pending, m.Revision)
+			}
+			return http.StatusServiceUnavailable, status
+		}
+		return http.StatusInternalServerError, status
+	}
+
+	var active map[int]bool
+	if active, err = readActive(ctx, conn); err != nil {
+		status.Error = err.Error()
+		return http.StatusInternalServerError, status
+	}
+
+	for _, m := range migrations {
+		if !active[m.Revision] {
+			status.Pending = append(status.Pending, m.Revision)
+		}
+	}
+
+	if len(status.Pending) > 0 {
+		return http.StatusServiceUnavailable, status
+	}
+
+	status.Ready = true
+	return http.StatusOK, status
+}
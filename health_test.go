@@ -0,0 +1,71 @@
+package tidal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusHandler(t *testing.T) {
+	defer Reset()
+	defer SetDialect(Postgres)
+
+	conn, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+	SetDialect(SQLite)
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+
+	check := func(expected int) (status HealthStatus) {
+		rec := httptest.NewRecorder()
+		StatusHandler(conn).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		require.Equal(t, expected, rec.Code)
+		require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+		return status
+	}
+
+	// The migrations table does not exist
+	status := check(http.StatusServiceUnavailable)
+	require.False(t, status.Ready)
+	require.Equal(t, []int{1, 2}, status.Pending)
+	require.Equal(t, `migrations table does not exist: "migrations" has not been created`, status.Error)
+
+	bootstrapTestDB(t, conn)
+	require.NoError(t, Migrate(conn, 1))
+	status = check(http.StatusServiceUnavailable)
+	require.Equal(t, HealthStatus{Current: 1, Latest: 2, Pending: []int{2}}, status)
+
+	require.NoError(t, Migrate(conn, -1))
+	status = check(http.StatusOK)
+	require.Equal(t, HealthStatus{Current: 2, Latest: 2, Pending: []int{}, Ready: true}, status)
+}
+
+func TestStatusHandlerNoversion(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	descriptor, err := NewDescriptor(strings.NewReader("-- migrate: up noversion\nINSERT INTO users VALUES (1);\n"), "0002_backfill_users.sql")
+	require.NoError(t, err)
+	require.NoError(t, RegisterDescriptor(descriptor))
+	require.NoError(t, Migrate(conn, 1))
+
+	// The pending data-only migration does not prevent the database from being ready
+	rec := httptest.NewRecorder()
+	StatusHandler(conn).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var status HealthStatus
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	require.Equal(t, HealthStatus{Current: 1, Latest: 1, Pending: []int{}, Ready: true}, status)
+}
@@ -0,0 +1,271 @@
+package tidal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	pathpkg "path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Matches comments that look like migrate directives, e.g. to find misspelled directives
+// such as -- migrate: upp or -- +migrate Upp that are not parsed by migre and are
+// silently treated as comments.
+var lintre = regexp.MustCompile(`(?i)^\s*--\s*(?:\+migrate|migrate\s*:)`)
+
+// Matches DROP statements and captures the word following the object type (and the
+// CONCURRENTLY keyword of DROP INDEX), which must be IF for the statement to be guarded
+// by IF EXISTS.
+var dropre = regexp.MustCompile(`(?i)^DROP\s+(TABLE|VIEW|INDEX|SEQUENCE|SCHEMA|TYPE|FUNCTION|TRIGGER)\s+(?:CONCURRENTLY\s+)?(\S+)`)
+
+// LintIssue describes a common mistake found in a migration file by Lint.
+type LintIssue struct {
+	Path     string // the path of the migration file relative to the linted directory
+	Revision int    // the revision of the migration, 0 if the filename cannot be parsed
+	Line     int    // the line of the file the issue was found on, 0 if it applies to the file
+	Message  string // a description of the issue
+}
+
+// String returns the issue formatted as path:line: message, omitting the line if the
+// issue applies to the entire file.
+func (i LintIssue) String() string {
+	if i.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", i.Path, i.Line, i.Message)
+	}
+	return fmt.Sprintf("%s: %s", i.Path, i.Message)
+}
+
+// Lint checks the migration files in the directory and its subdirectories for common
+// mistakes without registering them or connecting to a database, e.g. as a pre-commit
+// check. The issues that are returned are:
+//
+//   - migrations that do not define a down section (or a .down.sql file)
+//   - repeatable migrations that define a down section
+//   - missing, repeated, or unrecognized -- migrate: directives
+//   - duplicate revisions and revisions that are not sequential
+//   - dangerous statements, e.g. DROP TABLE without IF EXISTS
+//   - indexes built concurrently by migrations that are executed in a transaction
+//
+// Issues are returned in revision order; an error is only returned if the directory
+// cannot be read. Migrations that cannot be opened are reported as issues.
+func Lint(dir string) (issues []LintIssue, err error) {
+	fsys := os.DirFS(dir)
+
+	var filenames []string
+	if filenames, err = migrationFiles(fsys, ".", RegisterOptions{Recursive: true}); err != nil {
+		return nil, err
+	}
+
+	// Every half of a split-file migration is checked for directives
+	for _, filename := range filenames {
+		if splitBase(filename) != "" {
+			issues = append(issues, lintSplit(fsys, filename)...)
+		}
+	}
+
+	// Like uniqueRevisions, but reporting every duplicate revision as an issue
+	seen := make(map[int]string, len(filenames))
+	split := make(map[string]bool)
+	unique := make([]string, 0, len(filenames))
+	for _, filename := range filenames {
+		if base := splitBase(filename); base != "" {
+			if split[base] {
+				continue
+			}
+			split[base] = true
+		}
+
+		if _, revision, perr := parseFilename(pathpkg.Base(filename)); perr == nil {
+			if other, ok := seen[revision]; ok {
+				issues = append(issues, LintIssue{Path: filename, Revision: revision, Message: fmt.Sprintf("duplicate revision %d, the revision is also defined by %s", revision, other)})
+				continue
+			}
+			seen[revision] = filename
+		}
+		unique = append(unique, filename)
+	}
+
+	migrations := make([]Migration, 0, len(unique))
+	paths := make(map[int]string, len(unique))
+	for _, filename := range unique {
+		var m Migration
+		if m, err = OpenFS(fsys, filename); err != nil {
+			issues = append(issues, LintIssue{Path: filename, Message: err.Error()})
+			continue
+		}
+
+		issues = append(issues, lintMigration(fsys, m, filename)...)
+
+		// Repeatable migrations do not have a revision in the sequence
+		if !m.Repeatable() {
+			migrations = append(migrations, m)
+			paths[m.Revision] = filename
+		}
+	}
+
+	sort.Sort(ByRevision(migrations))
+	issues = append(issues, lintSequence(migrations, paths)...)
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].Revision != issues[j].Revision {
+			return issues[i].Revision < issues[j].Revision
+		}
+		if issues[i].Path != issues[j].Path {
+			return issues[i].Path < issues[j].Path
+		}
+		return issues[i].Line < issues[j].Line
+	})
+	return issues, nil
+}
+
+// Checks the directives, down section, and statements of a migration.
+func lintMigration(fsys fs.FS, m Migration, path string) (issues []LintIssue) {
+	issue := func(line int, format string, args ...interface{}) {
+		issues = append(issues, LintIssue{Path: path, Revision: m.Revision, Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// The directives of split files are checked by lintSplit
+	if splitBase(path) == "" {
+		seen := make(map[string]int)
+		lineno := 0
+		m.descriptor.scan(func(line string, quoted bool) bool {
+			lineno++
+			if quoted || !lintre.MatchString(line) {
+				return true
+			}
+
+			groups := directive(line, quoted)
+			if groups == nil {
+				if !reqre.MatchString(line) {
+					issue(lineno, "unrecognized directive %q, use -- migrate: up, down, end, or requires", strings.TrimSpace(line))
+				}
+				return true
+			}
+
+			target := strings.ToLower(groups[1])
+			if target == "end" {
+				if len(seen) == 0 {
+					issue(lineno, "end directive does not close an up or down section")
+				}
+				return true
+			}
+
+			if prev, ok := seen[target]; ok {
+				issue(lineno, "repeated %s directive, the %s section was already started on line %d", target, target, prev)
+				return true
+			}
+			seen[target] = lineno
+			return true
+		})
+
+		if _, ok := seen["up"]; !ok {
+			issue(0, "missing -- migrate: up directive, the migration does not apply any sql")
+		}
+
+		if _, ok := seen["down"]; !ok && !m.Repeatable() {
+			issue(0, "missing -- migrate: down directive, the migration cannot be rolled back")
+		}
+
+		if m.Repeatable() && !m.Irreversible() {
+			issue(seen["down"], "repeatable migrations cannot define down sql, they are never rolled back")
+		}
+	} else if down := splitBase(path) + ".down.sql"; !exists(fsys, down) {
+		issue(0, "missing %s, the migration cannot be rolled back", pathpkg.Base(down))
+	}
+
+	for _, direction := range []string{"up", "down"} {
+		sql, err := m.descriptor.readBetween(direction)
+		if err != nil {
+			issue(0, "could not parse %s sql: %s", direction, err)
+			continue
+		}
+
+		stmts := splitStatements(sql)
+		for _, stmt := range stmts {
+			if groups := dropre.FindStringSubmatch(stripComments(stmt)); groups != nil && !strings.EqualFold(groups[2], "IF") {
+				issue(0, "%s statement DROP %s %s does not use IF EXISTS", direction, strings.ToUpper(groups[1]), strings.TrimSuffix(groups[2], ";"))
+			}
+		}
+
+		// PostgreSQL cannot build an index concurrently inside of a transaction block
+		if m.transactional(direction) {
+			for _, index := range concurrentIndexes(stmts) {
+				issue(0, "%s statement builds index %s concurrently in a transaction, use -- migrate: %s concurrently", direction, index, direction)
+			}
+		}
+	}
+	return issues
+}
+
+// Split-file migrations specify the direction in the filename, so any migrate directive
+// in either half of the migration is a mistake.
+func lintSplit(fsys fs.FS, path string) (issues []LintIssue) {
+	data, err := readFile(fsys, path)
+	if err != nil {
+		// The error is reported when the migration is opened
+		return nil
+	}
+
+	_, revision, _ := parseFilename(pathpkg.Base(path))
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineno, tag := 1, ""; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+		quoted := tag != ""
+		tag = dollarQuote(line, tag)
+		if !quoted && migre.MatchString(line) {
+			issues = append(issues, LintIssue{Path: path, Revision: revision, Line: lineno, Message: fmt.Sprintf("split-file migrations should not contain %q, the direction is specified by the filename", strings.TrimSpace(line))})
+		}
+	}
+	return issues
+}
+
+// Checks that the revisions are sequential starting at Revision 1, allowing gaps that
+// are covered by squashed migrations.
+func lintSequence(migrations []Migration, paths map[int]string) (issues []LintIssue) {
+	prev := 0
+	for _, m := range migrations {
+		if m.Revision > prev+1 {
+			if from, ok := m.Squashed(); !ok || from > prev+1 {
+				missing := fmt.Sprintf("revision %d is", prev+1)
+				if m.Revision > prev+2 {
+					missing = fmt.Sprintf("revisions %d through %d are", prev+1, m.Revision-1)
+				}
+				issues = append(issues, LintIssue{Path: paths[m.Revision], Revision: m.Revision, Message: fmt.Sprintf("revisions are not sequential, %s missing", missing)})
+			}
+		}
+		prev = m.Revision
+	}
+	return issues
+}
+
+// Returns the statement without leading comments.
+func stripComments(stmt string) string {
+	for {
+		stmt = strings.TrimSpace(stmt)
+		switch {
+		case strings.HasPrefix(stmt, "--"):
+			if i := strings.IndexByte(stmt, '\n'); i >= 0 {
+				stmt = stmt[i+1:]
+				continue
+			}
+			return ""
+		case strings.HasPrefix(stmt, "/*"):
+			if i := strings.Index(stmt, "*/"); i >= 0 {
+				stmt = stmt[i+2:]
+				continue
+			}
+			return ""
+		}
+		return stmt
+	}
+}
+
+// Returns true if the file exists in the filesystem.
+func exists(fsys fs.FS, path string) bool {
+	_, err := fs.Stat(fsys, path)
+	return err == nil
+}
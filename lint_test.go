@@ -0,0 +1,64 @@
+package tidal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLint(t *testing.T) {
+	dir := t.TempDir()
+	writeMigration := func(filename, src string) {
+		path := filepath.Join(dir, filepath.FromSlash(filename))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(src), 0644))
+	}
+
+	// A clean set of migrations does not have any issues
+	writeMigration("0001_create_users.sql", "-- migrate: up\nCREATE TABLE users (id integer);\n-- migrate: down\nDROP TABLE IF EXISTS users;\n")
+	writeMigration("0002_create_groups.up.sql", "CREATE TABLE groups (id integer);\n")
+	writeMigration("0002_create_groups.down.sql", "-- Drops the groups table\nDROP TABLE IF EXISTS groups;\n")
+	writeMigration("0003_cleanup.sql", "-- migrate: up\nDELETE FROM users;\n-- migrate: down\n-- irreversible\n")
+
+	issues, err := Lint(dir)
+	require.NoError(t, err)
+	require.Empty(t, issues)
+
+	writeMigration("0004_create_roles.sql", "-- migrate: up\nCREATE TABLE roles (id integer);\n-- migrate: down\n-- Drops the table\nDROP TABLE roles;\n")
+	writeMigration("0005_create_perms.sql", "-- migrate: upp\nCREATE TABLE perms (id integer);\n-- migrate: down\nDROP TABLE IF EXISTS perms;\n-- migrate: down\n")
+	writeMigration("0006_create_tokens.up.sql", "-- migrate: up\nCREATE TABLE tokens (id integer);\n")
+	writeMigration("auth/0006_create_keys.sql", "-- migrate: up\nCREATE TABLE keys (id integer);\n-- migrate: end\n")
+	writeMigration("0009_create_sessions.sql", "-- migrate: up\nCREATE TABLE sessions (id integer);\n-- migrate: down\nDROP TABLE IF EXISTS sessions;\n")
+	writeMigration("0010_sessions_index.sql", "-- migrate: up\nCREATE INDEX CONCURRENTLY sessions_id ON sessions (id);\n-- migrate: down\nDROP INDEX IF EXISTS sessions_id;\n")
+	writeMigration("0011_users_index.sql", "-- migrate: up concurrently\nCREATE INDEX CONCURRENTLY users_id ON users (id);\n-- migrate: down concurrently\nDROP INDEX CONCURRENTLY IF EXISTS users_id;\n")
+
+	issues, err = Lint(dir)
+	require.NoError(t, err)
+
+	messages := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		messages = append(messages, issue.String())
+	}
+
+	require.Equal(t, []string{
+		"0004_create_roles.sql: down statement DROP TABLE roles does not use IF EXISTS",
+		"0005_create_perms.sql: missing -- migrate: up directive, the migration does not apply any sql",
+		`0005_create_perms.sql:1: unrecognized directive "-- migrate: upp", use -- migrate: up, down, end, or requires`,
+		"0005_create_perms.sql:5: repeated down directive, the down section was already started on line 3",
+		"0006_create_tokens.up.sql: missing 0006_create_tokens.down.sql, the migration cannot be rolled back",
+		`0006_create_tokens.up.sql:1: split-file migrations should not contain "-- migrate: up", the direction is specified by the filename`,
+		"auth/0006_create_keys.sql: duplicate revision 6, the revision is also defined by 0006_create_tokens.up.sql",
+		"0009_create_sessions.sql: revisions are not sequential, revisions 7 through 8 are missing",
+		"0010_sessions_index.sql: up statement builds index sessions_id concurrently in a transaction, use -- migrate: up concurrently",
+	}, messages)
+
+	require.Equal(t, 5, issues[2].Revision)
+	require.Equal(t, 1, issues[2].Line)
+
+	// The directory must be readable
+	_, err = Lint(filepath.Join(dir, "missing"))
+	require.Error(t, err)
+}
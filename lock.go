@@ -0,0 +1,177 @@
+package tidal
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"time"
+)
+
+// DefaultLockTimeout is the maximum amount of time Migrate and Rollback wait to acquire
+// the database lock when the Lock option is specified without a LockTimeout.
+const DefaultLockTimeout = time.Minute
+
+// MySQL lock names are limited to 64 characters.
+const maxLockName = 64
+
+// Returns the name of the lock used to ensure only one process migrates the database at
+// a time. The name is derived from the migrations table, qualified by its schema, so that
+// applications with separate migrations tables in one database do not block each other;
+// the default table is locked as tidal_migrations. Names that are too long for MySQL are
+// replaced by a hash of the name.
+func lockName() string {
+	name := "tidal_" + table
+	if tableSchema != "" {
+		name = "tidal_" + tableSchema + "." + table
+	}
+
+	if len(name) > maxLockName {
+		h := fnv.New64a()
+		h.Write([]byte(name))
+		name = fmt.Sprintf("tidal_%016x", h.Sum64())
+	}
+	return name
+}
+
+// Locker acquires and releases an exclusive, session-level lock on the database so that
+// only one process can apply migrations at a time. The lock is acquired and released on
+// the same dedicated connection. Lock must block until the lock is acquired or until
+// the context is done, in which case an error should be returned.
+type Locker interface {
+	Lock(ctx context.Context, conn *sql.Conn) error
+	Unlock(ctx context.Context, conn *sql.Conn) error
+}
+
+// Acquires the lock using the current dialect's locker, waiting at most the timeout.
+// Returns a function that releases the lock, which must be called by the caller.
+func acquire(ctx context.Context, conn *sql.Conn, timeout time.Duration) (unlock func() error, err error) {
+	locker := dialect.Locker()
+	if locker == nil {
+		return nil, fmt.Errorf("the %s dialect does not support locking", dialect.Name())
+	}
+
+	if timeout <= 0 {
+		timeout = DefaultLockTimeout
+	}
+
+	lctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err = locker.Lock(lctx, conn); err != nil {
+		return nil, fmt.Errorf("could not acquire migrations lock: %s", err)
+	}
+
+	unlock = func() error {
+		// Detach the context so the lock is released even if ctx has been cancelled
+		if err := locker.Unlock(detach(ctx), conn); err != nil {
+			return fmt.Errorf("could not release migrations lock: %s", err)
+		}
+		return nil
+	}
+	return unlock, nil
+}
+
+// Acquires the lock on a dedicated connection from the pool, waiting at most the timeout,
+// and calls fn with the locked connection. The lock is released when fn returns, and
+// before a panic in fn is re-thrown so that other processes are not blocked from
+// migrating until the connection is closed.
+func locked(ctx context.Context, conn *sql.DB, timeout time.Duration, fn func(*sql.Conn) error) (err error) {
+	var c *sql.Conn
+	if c, err = conn.Conn(ctx); err != nil {
+		return fmt.Errorf("could not acquire connection to lock database: %s", err)
+	}
+	defer c.Close()
+
+	var unlock func() error
+	if unlock, err = acquire(ctx, c, timeout); err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			release(c, unlock)
+			panic(p)
+		}
+
+		if uerr := release(c, unlock); uerr != nil && err == nil {
+			err = uerr
+		}
+	}()
+
+	return fn(c)
+}
+
+// Releases the lock acquired on the connection. If the lock cannot be released the
+// connection is discarded rather than returned to the pool, which closes the database
+// session and with it any session-level lock that is still held.
+func release(conn *sql.Conn, unlock func() error) (err error) {
+	if err = unlock(); err != nil {
+		conn.Raw(func(interface{}) error {
+			return driver.ErrBadConn
+		})
+	}
+	return err
+}
+
+// advisoryLocker uses PostgreSQL session-level advisory locks.
+type advisoryLocker struct{}
+
+// Advisory locks are identified by a 64 bit integer, computed from the lock name
+func (advisoryLocker) key() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(lockName()))
+	return int64(h.Sum64() & math.MaxInt64)
+}
+
+func (l advisoryLocker) Lock(ctx context.Context, conn *sql.Conn) (err error) {
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", l.key())
+	return err
+}
+
+func (l advisoryLocker) Unlock(ctx context.Context, conn *sql.Conn) (err error) {
+	var ok bool
+	if err = conn.QueryRowContext(ctx, "SELECT pg_advisory_unlock($1)", l.key()).Scan(&ok); err != nil {
+		return err
+	}
+
+	if !ok {
+		return errors.New("advisory lock was not held")
+	}
+	return nil
+}
+
+// namedLocker uses MySQL named locks via GET_LOCK and RELEASE_LOCK.
+type namedLocker struct{}
+
+func (namedLocker) Lock(ctx context.Context, conn *sql.Conn) (err error) {
+	// GET_LOCK requires a timeout in seconds, which is computed from the deadline
+	timeout := -1
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = int(math.Ceil(time.Until(deadline).Seconds()))
+	}
+
+	var ok sql.NullInt64
+	if err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName(), timeout).Scan(&ok); err != nil {
+		return err
+	}
+
+	if !ok.Valid || ok.Int64 != 1 {
+		return errors.New("timed out waiting for lock")
+	}
+	return nil
+}
+
+func (namedLocker) Unlock(ctx context.Context, conn *sql.Conn) (err error) {
+	var ok sql.NullInt64
+	if err = conn.QueryRowContext(ctx, "SELECT RELEASE_LOCK(?)", lockName()).Scan(&ok); err != nil {
+		return err
+	}
+
+	if !ok.Valid || ok.Int64 != 1 {
+		return errors.New("named lock was not held")
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package tidal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateLock(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+
+	// SQLite does not support locking
+	require.EqualError(t, Migrate(conn, -1, MigrateOptions{Lock: true}), "the sqlite3 dialect does not support locking")
+
+	locker := &mockLocker{}
+	SetDialect(lockingDialect{sqlite{}, locker})
+	defer SetDialect(SQLite)
+
+	// The migrations must be applied on the locked connection since the pool only has one
+	require.NoError(t, Migrate(conn, -1, MigrateOptions{Lock: true}))
+	require.Equal(t, 1, locker.locks)
+	require.Equal(t, 1, locker.unlocks)
+
+	active, err := readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.True(t, active[1])
+
+	// If the lock is held, Migrate should timeout
+	locker.held = true
+	err = Migrate(conn, -1, MigrateOptions{Lock: true, LockTimeout: 10 * time.Millisecond})
+	require.EqualError(t, err, "could not acquire migrations lock: context deadline exceeded")
+	require.Equal(t, 1, locker.unlocks)
+}
+
+func TestMigrateLockPanic(t *testing.T) {
+	defer Reset()
+	defer SetLogger(nil)
+	conn := openTestDB(t)
+	defer conn.Close()
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+
+	locker := &mockLocker{}
+	SetDialect(lockingDialect{sqlite{}, locker})
+	defer SetDialect(SQLite)
+
+	// A panic during the migration must release the lock before it is re-thrown
+	SetLogger(panicLogger{})
+	require.PanicsWithValue(t, "logger panic", func() {
+		Migrate(conn, -1, MigrateOptions{Lock: true})
+	})
+	require.Equal(t, 1, locker.locks)
+	require.Equal(t, 1, locker.unlocks)
+
+	SetLogger(nil)
+	require.NoError(t, Migrate(conn, -1, MigrateOptions{Lock: true}))
+	require.Equal(t, 2, locker.locks)
+	require.Equal(t, 2, locker.unlocks)
+
+	// If the lock cannot be released the error is returned
+	locker.unlockErr = errors.New("connection reset")
+	err := Migrate(conn, -1, MigrateOptions{Lock: true})
+	require.EqualError(t, err, "could not release migrations lock: connection reset")
+}
+
+func TestRollbackLock(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	require.NoError(t, Migrate(conn, -1))
+
+	locker := &mockLocker{}
+	SetDialect(lockingDialect{sqlite{}, locker})
+	defer SetDialect(SQLite)
+
+	// A rollback cannot start while another process holds the lock
+	locker.held = true
+	err := Rollback(conn, 0, RollbackOptions{Lock: true, LockTimeout: 10 * time.Millisecond})
+	require.EqualError(t, err, "could not acquire migrations lock: context deadline exceeded")
+
+	active, err := readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.True(t, active[1])
+
+	// The migrations must be rolled back on the locked connection since the pool only has one
+	locker.held = false
+	result, err := RollbackResult(conn, 0, RollbackOptions{Lock: true})
+	require.NoError(t, err)
+	require.Equal(t, []int{1}, result.RolledBack)
+	require.Equal(t, 1, locker.locks)
+	require.Equal(t, 1, locker.unlocks)
+}
+
+func TestLockName(t *testing.T) {
+	defer SetTableName("migrations")
+	defer SetTableSchema("")
+	require.Equal(t, "tidal_migrations", lockName())
+
+	require.NoError(t, SetTableName("billing_migrations"))
+	require.Equal(t, "tidal_billing_migrations", lockName())
+
+	require.NoError(t, SetTableSchema("ops"))
+	require.Equal(t, "tidal_ops.billing_migrations", lockName())
+
+	// Names are limited to the length of MySQL lock names
+	require.NoError(t, SetTableName(strings.Repeat("m", 63)))
+	require.Regexp(t, `^tidal_[0-9a-f]{16}$`, lockName())
+
+	// Advisory lock keys are derived from the lock name
+	key := advisoryLocker{}.key()
+	require.NoError(t, SetTableSchema(""))
+	require.NotEqual(t, key, advisoryLocker{}.key())
+}
+
+// Panics when a migration is started.
+type panicLogger struct{}
+
+func (panicLogger) Start(int, string, string, string)                { panic("logger panic") }
+func (panicLogger) Finish(int, string, string, time.Duration, error) {}
+
+type lockingDialect struct {
+	sqlite
+	locker Locker
+}
+
+func (d lockingDialect) Locker() Locker { return d.locker }
+
+type mockLocker struct {
+	held      bool
+	locks     int
+	unlocks   int
+	unlockErr error
+}
+
+func (l *mockLocker) Lock(ctx context.Context, conn *sql.Conn) error {
+	if l.held {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	l.locks++
+	return conn.PingContext(ctx)
+}
+
+func (l *mockLocker) Unlock(ctx context.Context, conn *sql.Conn) error {
+	l.unlocks++
+	if l.unlockErr != nil {
+		return l.unlockErr
+	}
+	return conn.PingContext(ctx)
+}
@@ -0,0 +1,87 @@
+package tidal
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Logger receives progress events as migrations are applied and rolled back so that
+// callers can route them into their application's logging system. Start is called
+// before a migration is executed in the specified direction ("up" or "down") with the
+// SQL that will be executed, and Finish is called afterward with the elapsed time and
+// the error returned by the migration, if any. Repeatable migrations are reported with
+// the negative revision they are recorded with in the migrations table.
+type Logger interface {
+	Start(revision int, name, direction, sql string)
+	Finish(revision int, name, direction string, elapsed time.Duration, err error)
+}
+
+// The logger migration events are sent to, events are discarded if nil.
+var logger Logger
+
+// SetLogger specifies the logger that receives migration progress events, use nil to
+// discard events (the default). This should be set before calling Migrate or Rollback.
+func SetLogger(l Logger) {
+	logger = l
+}
+
+// NewWriterLogger returns a Logger that writes human readable migration progress to w,
+// including the SQL of each migration if verbose is true.
+func NewWriterLogger(w io.Writer, verbose bool) Logger {
+	return &writerLogger{w: w, verbose: verbose}
+}
+
+type writerLogger struct {
+	w       io.Writer
+	verbose bool
+}
+
+func (l *writerLogger) Start(revision int, name, direction, sql string) {
+	fmt.Fprintf(l.w, "%s: %s started\n", label(revision, direction), name)
+	if l.verbose {
+		fmt.Fprintln(l.w, strings.TrimRight(sql, "\n"))
+	}
+}
+
+func (l *writerLogger) Finish(revision int, name, direction string, elapsed time.Duration, err error) {
+	if err != nil {
+		fmt.Fprintf(l.w, "%s: %s failed after %s: %s\n", label(revision, direction), name, elapsed, err)
+		return
+	}
+	fmt.Fprintf(l.w, "%s: %s completed in %s\n", label(revision, direction), name, elapsed)
+}
+
+// Describes the migration in progress messages, e.g. revision 1 up, or repeatable up for
+// repeatable migrations since their negative revision is only used internally.
+func label(revision int, direction string) string {
+	if revision < 0 {
+		return "repeatable " + direction
+	}
+	return fmt.Sprintf("revision %d %s", revision, direction)
+}
+
+// Sends the start event to the logger and returns a function that sends the finish
+// event with the elapsed time when the migration returns, e.g.
+//
+//	defer m.log("up")(&err)
+func (m *Migration) log(direction string) func(*error) {
+	if logger == nil {
+		return func(*error) {}
+	}
+
+	var sql string
+	if direction == "up" {
+		sql, _ = m.UpSQL()
+	} else {
+		sql, _ = m.DownSQL()
+	}
+
+	l := logger
+	l.Start(m.Revision, m.Name, direction, sql)
+	start := time.Now()
+	return func(err *error) {
+		l.Finish(m.Revision, m.Name, direction, time.Since(start), *err)
+	}
+}
@@ -0,0 +1,69 @@
+package tidal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger(t *testing.T) {
+	defer Reset()
+	defer SetLogger(nil)
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	events := &mockLogger{}
+	SetLogger(events)
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_bad_sql.sql", "CREATE TABLEZ foo;", "")
+
+	require.Error(t, Migrate(conn, -1))
+	require.Equal(t, []string{"start 1 up", "finish 1 up", "start 2 up", "finish 2 up"}, events.calls)
+	require.Equal(t, "CREATE TABLE users (id integer);\n", events.sql[0])
+	require.NoError(t, events.errs[0])
+	require.Error(t, events.errs[1])
+
+	events.reset()
+	require.NoError(t, Rollback(conn, 0))
+	require.Equal(t, []string{"start 1 down", "finish 1 down"}, events.calls)
+	require.Equal(t, "DROP TABLE users;\n", events.sql[0])
+}
+
+func TestWriterLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewWriterLogger(buf, true)
+	l.Start(1, "create users", "up", "CREATE TABLE users (id integer);\n")
+	l.Finish(1, "create users", "up", 1500*time.Millisecond, nil)
+	require.Equal(t, "revision 1 up: create users started\nCREATE TABLE users (id integer);\nrevision 1 up: create users completed in 1.5s\n", buf.String())
+
+	buf.Reset()
+	l = NewWriterLogger(buf, false)
+	l.Start(2, "bad sql", "up", "CREATE TABLEZ foo;")
+	l.Finish(2, "bad sql", "up", time.Second, errors.New("syntax error"))
+	require.Equal(t, "revision 2 up: bad sql started\nrevision 2 up: bad sql failed after 1s: syntax error\n", buf.String())
+}
+
+type mockLogger struct {
+	calls []string
+	sql   []string
+	errs  []error
+}
+
+func (l *mockLogger) Start(revision int, name, direction, sql string) {
+	l.calls = append(l.calls, fmt.Sprintf("start %d %s", revision, direction))
+	l.sql = append(l.sql, sql)
+}
+
+func (l *mockLogger) Finish(revision int, name, direction string, elapsed time.Duration, err error) {
+	l.calls = append(l.calls, fmt.Sprintf("finish %d %s", revision, direction))
+	l.errs = append(l.errs, err)
+}
+
+func (l *mockLogger) reset() {
+	l.calls, l.sql, l.errs = nil, nil, nil
+}
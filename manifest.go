@@ -0,0 +1,108 @@
+package tidal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Manifest records the checksum of every registered migration so that the migration
+// files can be verified to be append-only, e.g. by committing the manifest and checking
+// it in CI to detect accidental edits to migrations that may already have been applied.
+type Manifest struct {
+	Migrations []ManifestEntry `json:"migrations"`
+}
+
+// ManifestEntry is the revision, name, and checksum of a migration in a Manifest.
+type ManifestEntry struct {
+	Revision int    `json:"revision"`
+	Name     string `json:"name"`
+	Checksum string `json:"checksum"`
+}
+
+// WriteManifest writes the JSON manifest of the registered migrations to w, see
+// Migration.Checksum for how the checksum of each migration is computed.
+func WriteManifest(w io.Writer) (err error) {
+	return DefaultRegistry.WriteManifest(w)
+}
+
+// WriteManifest writes the JSON manifest of the migrations in the registry to w.
+func (r *Registry) WriteManifest(w io.Writer) (err error) {
+	var manifest Manifest
+	if manifest, err = r.Manifest(); err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(manifest)
+}
+
+// Manifest returns the manifest of the migrations in the registry in revision order.
+func (r *Registry) Manifest() (manifest Manifest, err error) {
+	migrations := r.registered()
+	manifest.Migrations = make([]ManifestEntry, 0, len(migrations))
+	for _, m := range migrations {
+		entry := ManifestEntry{Revision: m.Revision, Name: m.Name}
+		if entry.Checksum, err = m.Checksum(); err != nil {
+			return manifest, fmt.Errorf("could not compute revision %d checksum: %s", m.Revision, err)
+		}
+		manifest.Migrations = append(manifest.Migrations, entry)
+	}
+	return manifest, nil
+}
+
+// VerifyManifest reads a JSON manifest written by WriteManifest from r and verifies
+// that the registered migrations are append-only with respect to the manifest. Every
+// migration in the manifest must still be registered with the same name and checksum,
+// and any migrations that have been added since the manifest was written must have a
+// higher revision than the latest revision in the manifest. All of the differences are
+// returned as a MultiError.
+func VerifyManifest(r io.Reader) (err error) {
+	return DefaultRegistry.VerifyManifest(r)
+}
+
+// VerifyManifest verifies the migrations in the registry against the manifest.
+func (r *Registry) VerifyManifest(src io.Reader) (err error) {
+	var manifest Manifest
+	if err = json.NewDecoder(src).Decode(&manifest); err != nil {
+		return fmt.Errorf("could not parse manifest: %s", err)
+	}
+
+	var current Manifest
+	if current, err = r.Manifest(); err != nil {
+		return err
+	}
+
+	registered := make(map[int]ManifestEntry, len(current.Migrations))
+	for _, entry := range current.Migrations {
+		registered[entry.Revision] = entry
+	}
+
+	var errs MultiError
+	latest := 0
+	expected := make(map[int]bool, len(manifest.Migrations))
+	for _, entry := range manifest.Migrations {
+		expected[entry.Revision] = true
+		if entry.Revision > latest {
+			latest = entry.Revision
+		}
+
+		m, ok := registered[entry.Revision]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("revision %d %s is in the manifest but is not registered", entry.Revision, entry.Name))
+		case m.Name != entry.Name:
+			errs = append(errs, fmt.Errorf("revision %d has been renamed from %q to %q", entry.Revision, entry.Name, m.Name))
+		case m.Checksum != entry.Checksum:
+			errs = append(errs, fmt.Errorf("revision %d has been modified: checksum %s does not match manifest checksum %s", entry.Revision, m.Checksum, entry.Checksum))
+		}
+	}
+
+	for _, m := range current.Migrations {
+		if !expected[m.Revision] && m.Revision < latest {
+			errs = append(errs, fmt.Errorf("revision %d is not in the manifest but is before its latest revision %d, new migrations must be appended", m.Revision, latest))
+		}
+	}
+	return errs.ErrorOrNil()
+}
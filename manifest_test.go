@@ -0,0 +1,56 @@
+package tidal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifest(t *testing.T) {
+	defer Reset()
+	users := registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteManifest(&buf))
+
+	var manifest Manifest
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &manifest))
+	require.Len(t, manifest.Migrations, 2)
+	checksum, err := users.Checksum()
+	require.NoError(t, err)
+	require.Equal(t, ManifestEntry{Revision: 1, Name: "create users", Checksum: checksum}, manifest.Migrations[0])
+
+	// The unmodified migrations match the manifest, new migrations can be appended
+	require.NoError(t, VerifyManifest(bytes.NewReader(buf.Bytes())))
+	registerTestMigration(t, "0003_create_roles.sql", "CREATE TABLE roles (id integer);", "DROP TABLE roles;")
+	require.NoError(t, VerifyManifest(bytes.NewReader(buf.Bytes())))
+
+	// Modified, renamed, removed, and inserted migrations do not match the manifest
+	Reset()
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer, name text);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_teams.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	registerTestMigration(t, "0004_create_perms.sql", "CREATE TABLE perms (id integer);", "DROP TABLE perms;")
+
+	manifest.Migrations = append(manifest.Migrations, ManifestEntry{Revision: 3, Name: "create roles", Checksum: checksum}, ManifestEntry{Revision: 5, Name: "create tokens", Checksum: checksum})
+	data, err := json.Marshal(manifest)
+	require.NoError(t, err)
+
+	err = VerifyManifest(bytes.NewReader(data))
+	var errs MultiError
+	require.True(t, errors.As(err, &errs))
+	require.Len(t, errs, 5)
+	require.True(t, strings.HasPrefix(errs[0].Error(), "revision 1 has been modified: checksum "))
+	require.EqualError(t, errs[1], `revision 2 has been renamed from "create groups" to "create teams"`)
+	require.EqualError(t, errs[2], "revision 3 create roles is in the manifest but is not registered")
+	require.EqualError(t, errs[3], "revision 5 create tokens is in the manifest but is not registered")
+	require.EqualError(t, errs[4], "revision 4 is not in the manifest but is before its latest revision 5, new migrations must be appended")
+
+	err = VerifyManifest(strings.NewReader("not json"))
+	require.Error(t, err)
+	require.True(t, strings.HasPrefix(err.Error(), "could not parse manifest: "))
+}
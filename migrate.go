@@ -0,0 +1,1634 @@
+package tidal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The bootstrap migration, Revision 0, creates the migrations table that is used to
+// track the state of all application migrations. The {table} token is replaced with the
+// configured table name. This SQL must be kept in sync with
+// migrations/0000_migrations_schema.sql.
+const schema = `-- This table is used to track the state of migrations as different revisions are applied
+-- migrate: up
+
+CREATE TABLE IF NOT EXISTS {table} (
+    "revision" integer NOT NULL,
+    "name" varchar(128) NOT NULL,
+    "active" boolean NOT NULL DEFAULT false,
+    "applied" TIMESTAMP WITH TIME ZONE,
+    "created" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "checksum" varchar(64),
+    "elapsed" bigint,
+    "dirty" boolean NOT NULL DEFAULT false,
+    "versioned" boolean NOT NULL DEFAULT true,
+    PRIMARY KEY ("revision")
+) WITHOUT OIDS;
+
+COMMENT ON TABLE {table} IS 'Manages the state of database by enabling migrations and rollbacks';
+COMMENT ON COLUMN {table}."revision" IS 'The revision id parsed from the filename of the migration';
+COMMENT ON COLUMN {table}."name" IS 'The name of the migration parsed from the filename of the migration';
+COMMENT ON COLUMN {table}."active" IS 'If the migration has been applied, set to false on rollbacks or if not applied';
+COMMENT ON COLUMN {table}."applied" IS 'Timestamp when the migration was applied, null if rolledback or not applied';
+COMMENT ON COLUMN {table}."created" IS 'Timestamp when the migration was created';
+COMMENT ON COLUMN {table}."checksum" IS 'SHA-256 checksum of the up and down sql when the migration was applied';
+COMMENT ON COLUMN {table}."elapsed" IS 'Nanoseconds taken to execute the up sql when the migration was applied';
+COMMENT ON COLUMN {table}."dirty" IS 'If the migration was interrupted while being applied or rolled back';
+COMMENT ON COLUMN {table}."versioned" IS 'If the migration changes the schema version, false for data-only migrations';
+
+-- The down migration will take the database all the way back to a blank slate
+-- migrate: down
+
+DROP TABLE IF EXISTS {table} CASCADE;`
+
+// The MySQL version of the bootstrap migration. MySQL does not quote identifiers with
+// double quotes by default and does not support COMMENT ON statements, so identifiers
+// are unquoted, comments are specified inline, and timestamps are stored as datetimes.
+const mysqlSchema = `-- This table is used to track the state of migrations as different revisions are applied
+-- migrate: up
+
+CREATE TABLE IF NOT EXISTS {table} (
+    revision integer NOT NULL COMMENT 'The revision id parsed from the filename of the migration',
+    name varchar(128) NOT NULL COMMENT 'The name of the migration parsed from the filename of the migration',
+    active boolean NOT NULL DEFAULT false COMMENT 'If the migration has been applied, set to false on rollbacks or if not applied',
+    applied datetime(6) NULL COMMENT 'Timestamp when the migration was applied, null if rolledback or not applied',
+    created datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT 'Timestamp when the migration was created',
+    checksum varchar(64) COMMENT 'SHA-256 checksum of the up and down sql when the migration was applied',
+    elapsed bigint COMMENT 'Nanoseconds taken to execute the up sql when the migration was applied',
+    dirty boolean NOT NULL DEFAULT false COMMENT 'If the migration was interrupted while being applied or rolled back',
+    versioned boolean NOT NULL DEFAULT true COMMENT 'If the migration changes the schema version, false for data-only migrations',
+    PRIMARY KEY (revision)
+) COMMENT 'Manages the state of database by enabling migrations and rollbacks';
+
+-- The down migration will take the database all the way back to a blank slate
+-- migrate: down
+
+DROP TABLE IF EXISTS {table};`
+
+// The SQLite version of the bootstrap migration. SQLite does not support COMMENT ON
+// statements, WITHOUT OIDS, or CASCADE when dropping tables; booleans are stored as 0
+// or 1 and timestamps are stored as text, which the driver converts back to time.Time
+// because the column is declared as a TIMESTAMP.
+const sqliteSchema = `-- This table is used to track the state of migrations as different revisions are applied
+-- migrate: up
+
+CREATE TABLE IF NOT EXISTS {table} (
+    "revision" integer NOT NULL,
+    "name" varchar(128) NOT NULL,
+    "active" boolean NOT NULL DEFAULT false,
+    "applied" TIMESTAMP,
+    "created" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "checksum" varchar(64),
+    "elapsed" bigint,
+    "dirty" boolean NOT NULL DEFAULT false,
+    "versioned" boolean NOT NULL DEFAULT true,
+    PRIMARY KEY ("revision")
+);
+
+-- The down migration will take the database all the way back to a blank slate
+-- migrate: down
+
+DROP TABLE IF EXISTS {table};`
+
+// The name of the migrations table, set using SetTableName.
+var table = "migrations"
+
+// Valid table names are unquoted SQL identifiers to prevent SQL injection
+var tablere = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}$`)
+
+// Table names must also be lowercase because they are not quoted, so PostgreSQL folds
+// them to lowercase while the existence check compares the name as it was specified.
+var lowerre = regexp.MustCompile(`^[a-z0-9_]*$`)
+
+// SetTableName specifies the name of the table used to track the state of migrations,
+// which is "migrations" by default. This allows multiple applications to manage their
+// migrations independently in the same schema, e.g. billing_migrations. The name must
+// be a valid, lowercase, unquoted SQL identifier of at most 63 characters. The table
+// name must be set before calling Migrate, Rollback, or Status.
+func SetTableName(name string) error {
+	if !tablere.MatchString(name) || !lowerre.MatchString(name) {
+		return fmt.Errorf("%q is not a valid migrations table name", name)
+	}
+	table = name
+	return nil
+}
+
+// TableName returns the name of the table used to track the state of migrations.
+func TableName() string {
+	return table
+}
+
+// The schema that contains the migrations table, set using SetTableSchema.
+var tableSchema = ""
+
+// SetTableSchema specifies the schema that contains the migrations table, e.g. ops for
+// the ops.migrations table, so that tidal can be run with a limited-privilege role that
+// can only write to a specific schema. By default the schema is empty and the table is
+// created in the current schema of the connection (the search path in PostgreSQL). In
+// MySQL the schema is the database that contains the table and in SQLite it is the name
+// of the main or an attached database. The schema must already exist; tidal does not
+// create it. The name must be a valid, unquoted SQL identifier of at most 63 characters;
+// it is quoted for the current dialect in every query so it is case sensitive. Specify
+// an empty name to use the current schema. The schema must be set before calling
+// Migrate, Rollback, or Status.
+func SetTableSchema(name string) error {
+	if name != "" && !tablere.MatchString(name) {
+		return fmt.Errorf("%q is not a valid migrations table schema", name)
+	}
+	tableSchema = name
+	return nil
+}
+
+// TableSchema returns the schema that contains the migrations table or an empty string
+// if the table is in the current schema of the connection.
+func TableSchema() string {
+	return tableSchema
+}
+
+// Returns the name of the migrations table qualified by its schema, if any, which is
+// quoted for the specified dialect, e.g. "ops".migrations.
+func qualifiedTable(d Dialect) string {
+	if tableSchema == "" {
+		return table
+	}
+	return quoteIdent(d, tableSchema) + "." + table
+}
+
+// The clock used to timestamp migrations, set using SetClock.
+var nowFunc = time.Now
+
+// SetClock specifies the function that returns the current time when the applied and
+// created timestamps of migrations are recorded in the migrations table (time.Now by
+// default or if now is nil), e.g. to assert exact timestamps in tests. Timestamps are
+// always stored in UTC. The time taken to execute migrations is measured with the
+// system clock regardless of the clock that is set.
+func SetClock(now func() time.Time) {
+	if now == nil {
+		now = time.Now
+	}
+	nowFunc = now
+}
+
+// Returns the current time in UTC from the clock to record in the migrations table.
+func timestamp() time.Time {
+	return nowFunc().UTC()
+}
+
+// BootstrapMigration returns Revision 0, the migration that creates the migrations table
+// with the DDL of the specified dialect (the Postgres dialect if nil) and the configured
+// table name. Migrate applies the bootstrap migration automatically before any
+// application migrations if the migrations table does not exist, so it is usually only
+// required to inspect the DDL or to create the migrations table out of band.
+func BootstrapMigration(d Dialect) Migration {
+	if d == nil {
+		d = Postgres
+	}
+
+	m := Migration{Revision: 0, Name: "migrations schema"}
+	src := strings.NewReader(strings.Replace(d.SchemaSQL(), "{table}", qualifiedTable(d), -1))
+
+	var err error
+	if m.descriptor, err = NewDescriptor(src, "0000_migrations_schema.sql"); err != nil {
+		panic(fmt.Errorf("could not parse the %s bootstrap migration: %s", d.Name(), err))
+	}
+	return m
+}
+
+// MigrateOptions modify the default behavior of Migrate.
+type MigrateOptions struct {
+	// Force migrations to be applied even if an applied migration has been modified or
+	// a migration was interrupted (see AllowDirty).
+	Force bool
+
+	// AllowDirty migrates the database even if a previous migration was interrupted and
+	// left its revision dirty, clearing the dirty state of every revision first. The
+	// state of the database should be checked (and repaired) before it is allowed.
+	AllowDirty bool
+
+	// Lock the database before applying migrations so that only one process can
+	// migrate the database at a time; other processes block until the lock is released
+	// or the LockTimeout expires. Locking requires a dialect with a Locker.
+	Lock bool
+
+	// The maximum amount of time to wait to acquire the lock (DefaultLockTimeout if 0).
+	LockTimeout time.Duration
+
+	// TxOptions are used to begin the transaction of each migration, e.g. to specify
+	// the isolation level; if nil the driver's default isolation level is used. The
+	// isolation level only affects the data manipulation statements of a migration:
+	//
+	// PostgreSQL executes DDL transactionally at every isolation level. The default,
+	// READ COMMITTED, is safe for all migrations; REPEATABLE READ and SERIALIZABLE are
+	// also safe but may fail with a serialization error if the tables being migrated
+	// are written to concurrently, in which case the migration should be retried.
+	//
+	// MySQL implicitly commits the transaction before and after most DDL statements so
+	// any isolation level is safe, but it only applies to data migrations and DDL is
+	// never rolled back if the migration fails.
+	//
+	// SQLite transactions are always serializable and the driver ignores the options.
+	//
+	// ReadOnly must not be set since the migrations table is updated in the same
+	// transaction. The status of notransaction migrations is updated in a transaction
+	// that uses the default options.
+	TxOptions *sql.TxOptions
+
+	// Atomic applies all of the migrations up to the target revision in a single
+	// transaction rather than in a transaction per migration, so that if any migration
+	// fails none of them are applied. Atomic requires a dialect with transactional DDL
+	// and cannot be used if any of the migrations are notransaction migrations. It is
+	// not supported for MySQL, which implicitly commits the transaction on DDL so a
+	// failure would leave the group partially applied.
+	Atomic bool
+
+	// OutOfOrder applies pending migrations whose revisions are lower than the latest
+	// applied revision, e.g. a migration that was merged from another branch after later
+	// revisions were applied. By default Migrate returns an OutOfOrderError rather than
+	// applying them since they may depend on a schema that differs from the one they
+	// were written against.
+	OutOfOrder bool
+
+	// ContinueOnError attempts to apply every pending migration even if an earlier
+	// migration fails, returning a MultiError with the error of every revision that
+	// failed rather than stopping at the first failure. Migrations that require a
+	// revision that failed are not attempted and are also reported. This leaves the
+	// database partially migrated, with later revisions applied on top of the failed
+	// ones, so it is only intended for schemas whose migrations are idempotent, e.g. that
+	// use IF NOT EXISTS throughout, and it cannot be combined with Atomic.
+	ContinueOnError bool
+
+	// ConnectRetries is the number of times to retry connecting to the database before
+	// any migrations are applied if it is not reachable, e.g. when migrations are
+	// applied while the database is starting up. Only connection errors are retried,
+	// errors executing the migrations are returned immediately.
+	ConnectRetries int
+
+	// ConnectBackoff is the delay before the first connection retry, which doubles
+	// after every attempt (DefaultConnectBackoff if 0).
+	ConnectBackoff time.Duration
+
+	// BeforeEach is called with each pending migration before it is applied, e.g. to
+	// record a metric or to abort the migration if a feature flag is not set. If it
+	// returns an error the migration is not applied and Migrate returns the error; with
+	// ContinueOnError the migration is reported as failed and the remaining migrations
+	// are still attempted. With Atomic, BeforeEach is called for every pending migration
+	// before the transaction begins and any error aborts all of them.
+	BeforeEach func(Migration) error
+
+	// AfterEach is called with each migration that was attempted and the error that
+	// applying it returned, or nil if it was applied, e.g. to send a notification when a
+	// long migration finishes. It is not called for migrations aborted by BeforeEach.
+	// With Atomic, AfterEach is called for every migration once the transaction has
+	// been committed or rolled back, with the error of the transaction.
+	AfterEach func(Migration, error)
+}
+
+// Calls the BeforeEach hook, if any, returning an error if the migration must not be
+// applied; the error of the hook is wrapped so that it can be matched with errors.Is.
+func (o MigrateOptions) before(m Migration) error {
+	if o.BeforeEach == nil {
+		return nil
+	}
+
+	if err := o.BeforeEach(m); err != nil {
+		return fmt.Errorf("migration to revision %d aborted: %w", m.Revision, err)
+	}
+	return nil
+}
+
+// Calls the AfterEach hook, if any, with the result of applying the migration.
+func (o MigrateOptions) after(m Migration, err error) {
+	if o.AfterEach != nil {
+		o.AfterEach(m, err)
+	}
+}
+
+// Migrate applies all registered migrations that have not yet been applied to the
+// database, in revision order, up to and including the target revision (use -1 to
+// apply all registered migrations). Migrations with requires directives are applied
+// after the revisions they require (see Migration.Requires); an error is returned if a
+// pending migration requires a revision after the target revision. The migrations
+// table is created by applying the bootstrap migration if it does not exist and every
+// registered migration is added to the table so that its state can be tracked. If a
+// migration fails, the error will describe which revision failed; all migrations before
+// it will remain applied. If the ContinueOnError option is specified, the remaining
+// migrations are still attempted. Once every registered revision has been applied, the
+// repeatable migrations whose checksums have changed are applied, see
+// Migration.Repeatable.
+//
+// Before any migrations are applied, the registered migrations are verified to ensure
+// that there are no missing revisions and the checksum of every applied migration is
+// compared to the checksum stored when it was applied; if the migration has been
+// modified since then an error is returned unless the Force option is specified. An
+// OutOfOrderError is returned if a pending migration has a lower revision than the
+// latest applied revision unless the OutOfOrder option is specified.
+//
+// A revision is marked as dirty in the migrations table while it is being applied or
+// rolled back, so a migration that is interrupted, e.g. because the process crashed or
+// because a notransaction migration failed part of the way through, is left dirty. An
+// error that matches ErrDirty is returned if any revision is dirty until the state of
+// the database has been checked and Migrate is called with the AllowDirty or Force
+// option, which clears the dirty state of every revision before migrating.
+//
+// If the Lock option is specified, a database lock is acquired on a dedicated
+// connection before any state is read and all migrations are applied on that
+// connection; the lock is released when Migrate returns.
+func Migrate(conn *sql.DB, target int, opts ...MigrateOptions) (err error) {
+	return DefaultRegistry.Migrate(conn, target, opts...)
+}
+
+// Migrate applies the migrations in the registry, see Migrate.
+func (r *Registry) Migrate(conn *sql.DB, target int, opts ...MigrateOptions) (err error) {
+	return r.MigrateContext(context.Background(), conn, target, opts...)
+}
+
+// MigrateContext applies the registered migrations as described by Migrate. The context
+// is passed to every query, statement, and transaction that is executed on the database,
+// including those that acquire and release the database lock, so that an instrumented
+// driver can attach them to the caller's trace. If the context is cancelled, the
+// migration that is being executed fails and no further migrations are applied.
+func MigrateContext(ctx context.Context, conn *sql.DB, target int, opts ...MigrateOptions) (err error) {
+	return DefaultRegistry.MigrateContext(ctx, conn, target, opts...)
+}
+
+// MigrateContext applies the migrations in the registry, see MigrateContext.
+func (r *Registry) MigrateContext(ctx context.Context, conn *sql.DB, target int, opts ...MigrateOptions) (err error) {
+	_, err = r.MigrateResultContext(ctx, conn, target, opts...)
+	return err
+}
+
+// MigrateResult applies the registered migrations as described by Migrate and returns a
+// Result that summarizes the revisions that were applied and skipped, the revision of
+// the database before and after migrating, and how long it took. The result is returned
+// even if a migration fails so that callers know how far the migration got.
+func MigrateResult(conn *sql.DB, target int, opts ...MigrateOptions) (result Result, err error) {
+	return DefaultRegistry.MigrateResult(conn, target, opts...)
+}
+
+// MigrateResult applies the migrations in the registry, see MigrateResult.
+func (r *Registry) MigrateResult(conn *sql.DB, target int, opts ...MigrateOptions) (result Result, err error) {
+	return r.MigrateResultContext(context.Background(), conn, target, opts...)
+}
+
+// MigrateResultContext applies the registered migrations with the context as described
+// by MigrateContext and returns a Result as described by MigrateResult.
+func MigrateResultContext(ctx context.Context, conn *sql.DB, target int, opts ...MigrateOptions) (result Result, err error) {
+	return DefaultRegistry.MigrateResultContext(ctx, conn, target, opts...)
+}
+
+// MigrateResultContext applies the migrations in the registry, see MigrateResultContext.
+func (r *Registry) MigrateResultContext(ctx context.Context, conn *sql.DB, target int, opts ...MigrateOptions) (result Result, err error) {
+	start := time.Now()
+	defer func() {
+		result.Elapsed = time.Since(start)
+	}()
+
+	opt := options(opts)
+	var migrations []Migration
+	if migrations, err = ordered(r.registered()); err != nil {
+		return result, err
+	}
+
+	if opt.ConnectRetries > 0 {
+		if err = ping(ctx, conn, opt.ConnectRetries, opt.ConnectBackoff); err != nil {
+			return result, err
+		}
+	}
+
+	repeatable := r.repeatables()
+	if !opt.Lock {
+		err = migrate(ctx, conn, migrations, repeatable, target, opt, &result)
+		return result, err
+	}
+
+	err = locked(ctx, conn, opt.LockTimeout, func(c *sql.Conn) error {
+		return migrate(ctx, c, migrations, repeatable, target, opt, &result)
+	})
+	return result, err
+}
+
+func migrate(ctx context.Context, conn executor, migrations, repeatable []Migration, target int, opt MigrateOptions, result *Result) (err error) {
+	if opt.Atomic && opt.ContinueOnError {
+		return errors.New("cannot migrate atomically and continue on error, specify only one option")
+	}
+
+	var status map[int]*record
+	if status, err = initialize(ctx, conn, migrations); err != nil {
+		return err
+	}
+	result.From = activeRevision(status)
+	result.To = result.From
+
+	if opt.AllowDirty || opt.Force {
+		if err = clearDirty(ctx, conn, status); err != nil {
+			return err
+		}
+	} else if err = checkDirty(status); err != nil {
+		return err
+	}
+
+	if !opt.Force {
+		if err = checkDrift(status, migrations); err != nil {
+			return err
+		}
+	}
+
+	if !opt.OutOfOrder {
+		if err = checkOrder(status, migrations, target); err != nil {
+			return err
+		}
+	}
+
+	pending := make([]Migration, 0, len(migrations))
+	for _, m := range migrations {
+		if target >= 0 && m.Revision > target {
+			continue
+		}
+
+		if status[m.Revision].active {
+			result.Skipped = append(result.Skipped, m.Revision)
+			continue
+		}
+
+		if err = checkSquashed(m, status); err != nil {
+			return err
+		}
+
+		if err = checkRequired(migrations, m, status, target); err != nil {
+			return err
+		}
+		pending = append(pending, m)
+	}
+
+	if opt.Atomic {
+		for _, m := range pending {
+			if err = opt.before(m); err != nil {
+				return err
+			}
+		}
+
+		err = migrateAtomic(ctx, conn, pending, opt.TxOptions)
+		for _, m := range pending {
+			opt.after(m, err)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		for _, m := range pending {
+			result.applied(m.Revision)
+		}
+		return repeat(ctx, conn, migrations, repeatable, target, opt, result)
+	}
+
+	if opt.ContinueOnError {
+		if err = migrateAll(ctx, conn, migrations, pending, opt, result); err != nil {
+			return err
+		}
+		return repeat(ctx, conn, migrations, repeatable, target, opt, result)
+	}
+
+	for _, m := range pending {
+		if err = opt.before(m); err != nil {
+			return err
+		}
+
+		err = m.up(ctx, conn, opt.TxOptions)
+		opt.after(m, err)
+		if err != nil {
+			return fmt.Errorf("migration to revision %d failed: %s", m.Revision, err)
+		}
+		result.applied(m.Revision)
+	}
+	return repeat(ctx, conn, migrations, repeatable, target, opt, result)
+}
+
+// Applies the repeatable migrations whose checksum differs from the checksum recorded
+// when they were last applied, in name order, once every versioned migration has been
+// applied; if the target revision is before the latest registered revision, repeatable
+// migrations are not applied since they may depend on the schema of later revisions.
+// Repeatable migrations that have not been applied before are assigned the next unused
+// negative revision. Each repeatable migration is applied in its own transaction (unless
+// it is marked notransaction), even if the versioned migrations were applied atomically.
+func repeat(ctx context.Context, conn executor, migrations, repeatable []Migration, target int, opt MigrateOptions, result *Result) (err error) {
+	if len(repeatable) == 0 {
+		return nil
+	}
+
+	for _, m := range migrations {
+		if target >= 0 && m.Revision > target {
+			return nil
+		}
+	}
+
+	var status map[string]*record
+	if status, err = readRepeatable(ctx, conn); err != nil {
+		return err
+	}
+
+	next := -1
+	for _, row := range status {
+		if row.revision <= next {
+			next = row.revision - 1
+		}
+	}
+
+	for _, m := range repeatable {
+		var checksum string
+		if checksum, err = m.Checksum(); err != nil {
+			return fmt.Errorf("could not compute repeatable migration %s checksum: %s", m.Name, err)
+		}
+
+		if row, ok := status[m.Name]; ok {
+			if !row.dirty && row.checksum.Valid && row.checksum.String == checksum {
+				continue
+			}
+			m.Revision = row.revision
+		} else {
+			m.Revision = next
+			next--
+		}
+
+		if err = opt.before(m); err != nil {
+			return err
+		}
+
+		err = m.up(ctx, conn, opt.TxOptions)
+		opt.after(m, err)
+		if err != nil {
+			return fmt.Errorf("repeatable migration %s failed: %s", m.Name, err)
+		}
+		result.Repeated = append(result.Repeated, m.Name)
+	}
+	return nil
+}
+
+// Attempts to apply every pending migration, collecting the errors of the migrations
+// that fail, see MigrateOptions.ContinueOnError.
+func migrateAll(ctx context.Context, conn executor, migrations, pending []Migration, opt MigrateOptions, result *Result) error {
+	var errs MultiError
+	failed := make(map[int]bool)
+	for _, m := range pending {
+		if revision, ok := failedRequirement(migrations, m, failed); ok {
+			failed[m.Revision] = true
+			errs = append(errs, fmt.Errorf("migration to revision %d skipped: requires revision %d which failed", m.Revision, revision))
+			continue
+		}
+
+		if err := opt.before(m); err != nil {
+			failed[m.Revision] = true
+			errs = append(errs, err)
+			continue
+		}
+
+		err := m.up(ctx, conn, opt.TxOptions)
+		opt.after(m, err)
+		if err != nil {
+			failed[m.Revision] = true
+			errs = append(errs, fmt.Errorf("migration to revision %d failed: %s", m.Revision, err))
+			continue
+		}
+		result.applied(m.Revision)
+	}
+	return errs.ErrorOrNil()
+}
+
+// Applies the pending migrations in a single transaction that is rolled back if any of
+// the migrations fail, see MigrateOptions.Atomic.
+func migrateAtomic(ctx context.Context, conn executor, pending []Migration, txopts *sql.TxOptions) (err error) {
+	if !dialect.TransactionalDDL() {
+		return fmt.Errorf("cannot migrate atomically: the %s dialect does not support transactional ddl", dialect.Name())
+	}
+
+	for _, m := range pending {
+		if !m.transactional("up") {
+			return fmt.Errorf("cannot migrate atomically: revision %d cannot be executed in a transaction", m.Revision)
+		}
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	var tx *sql.Tx
+	if tx, err = conn.BeginTx(ctx, txopts); err != nil {
+		return fmt.Errorf("could not begin transaction to apply migrations: %s", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	for _, m := range pending {
+		if err = m.upAtomic(ctx, tx); err != nil {
+			return fmt.Errorf("migration to revision %d failed, no migrations were applied: %s", m.Revision, err)
+		}
+	}
+	return nil
+}
+
+// Sync marks the registered migrations up to and including the through revision as
+// applied in the migrations table without executing their up SQL (use -1 to sync all
+// registered migrations). This reconciles the migrations table with a database whose
+// schema was changed out of band, e.g. by restoring a backup or applying the SQL
+// manually. The checksum of each synced migration is stored so drift can be detected,
+// but the elapsed time is left empty since the migration was not executed. All of the
+// migrations are synced in a single transaction.
+func Sync(conn *sql.DB, through int) (err error) {
+	return DefaultRegistry.Sync(conn, through)
+}
+
+// Sync marks the migrations in the registry as applied, see Sync.
+func (r *Registry) Sync(conn *sql.DB, through int) (err error) {
+	migrations := r.registered()
+	if err = verify(migrations); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var status map[int]*record
+	if status, err = initialize(ctx, conn, migrations); err != nil {
+		return err
+	}
+	return markApplied(ctx, conn, migrations, status, through)
+}
+
+// Baseline adopts tidal on an existing database whose schema already reflects the
+// registered migrations up to and including the specified revision. The migrations
+// table is created and those migrations are marked as applied without executing their
+// up SQL, the migrations after the revision can then be applied with Migrate. Unlike
+// Sync, Baseline requires that the revision is registered and that no migrations have
+// been applied to the database yet, so that it is only used to onboard a database.
+func Baseline(conn *sql.DB, revision int) (err error) {
+	return DefaultRegistry.Baseline(conn, revision)
+}
+
+// Baseline adopts the migrations in the registry on an existing database, see Baseline.
+func (r *Registry) Baseline(conn *sql.DB, revision int) (err error) {
+	migrations := r.registered()
+	if err = verify(migrations); err != nil {
+		return err
+	}
+
+	// Verified migrations are contiguous from revision 1 to the last revision
+	if revision < 1 || len(migrations) == 0 || revision > migrations[len(migrations)-1].Revision {
+		return &NotRegisteredError{Revision: revision}
+	}
+
+	ctx := context.Background()
+	var status map[int]*record
+	if status, err = initialize(ctx, conn, migrations); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if status[m.Revision].active {
+			return fmt.Errorf("cannot baseline database: revision %d has already been applied, use Sync instead", m.Revision)
+		}
+	}
+	return markApplied(ctx, conn, migrations, status, revision)
+}
+
+// Marks the migrations up to and including the through revision as applied in a single
+// transaction, skipping any migrations that are already active in the status.
+func markApplied(ctx context.Context, conn *sql.DB, migrations []Migration, status map[int]*record, through int) error {
+	return statusTx(ctx, conn, func(ctx context.Context, tx *sql.Tx) (err error) {
+		for _, m := range migrations {
+			if through >= 0 && m.Revision > through {
+				break
+			}
+
+			if status[m.Revision].active {
+				continue
+			}
+
+			var checksum string
+			if checksum, err = m.Checksum(); err != nil {
+				return fmt.Errorf("could not compute revision %d checksum: %s", m.Revision, err)
+			}
+
+			if _, err = tx.ExecContext(ctx, bind(upStatusSQL), true, timestamp(), checksum, nil, false, m.Versioned(), m.Revision); err != nil {
+				return fmt.Errorf("could not sync revision %d: %s", m.Revision, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Force marks the registered migration with the specified revision as applied or as
+// pending in the migrations table without executing any SQL, e.g. to recover from a
+// migration that failed partway through and left the database in a dirty state. After
+// fixing the database manually, force the revision to applied if the migration's
+// changes were completed or to pending so that it is applied again by Migrate (which
+// requires the OutOfOrder option if later revisions are applied). The migrations table
+// is created if it does not exist.
+func Force(conn *sql.DB, revision int, applied bool) (err error) {
+	return DefaultRegistry.Force(conn, revision, applied)
+}
+
+// Force marks the migration in the registry as applied or pending, see Force.
+func (r *Registry) Force(conn *sql.DB, revision int, applied bool) (err error) {
+	var m *Migration
+	migrations := r.registered()
+	for i := range migrations {
+		if migrations[i].Revision == revision {
+			m = &migrations[i]
+			break
+		}
+	}
+
+	if m == nil || revision < 1 {
+		return &NotRegisteredError{Revision: revision}
+	}
+
+	ctx := context.Background()
+	if _, err = initialize(ctx, conn, migrations); err != nil {
+		return err
+	}
+
+	return statusTx(ctx, conn, func(ctx context.Context, tx *sql.Tx) (err error) {
+		if !applied {
+			return m.downStatus(ctx, tx)
+		}
+
+		var checksum string
+		if checksum, err = m.Checksum(); err != nil {
+			return fmt.Errorf("could not compute revision %d checksum: %s", m.Revision, err)
+		}
+
+		if _, err = tx.ExecContext(ctx, bind(upStatusSQL), true, timestamp(), checksum, nil, false, m.Versioned(), m.Revision); err != nil {
+			return fmt.Errorf("could not force revision %d: %s", m.Revision, err)
+		}
+		return nil
+	})
+}
+
+// RollbackOptions modify the default behavior of Rollback and Migration.Down.
+type RollbackOptions struct {
+	// Force irreversible migrations to be marked as rolled back even though they do not
+	// define any down SQL to execute, and allow Migration.Down to roll back a migration
+	// while later revisions are still active (see ActiveSuccessorsError).
+	Force bool
+
+	// TxOptions are used to begin the transaction of each rollback, see MigrateOptions.
+	TxOptions *sql.TxOptions
+
+	// Lock the database before rolling back migrations so that a rollback cannot run
+	// while another process is migrating the database, see MigrateOptions.
+	Lock bool
+
+	// The maximum amount of time to wait to acquire the lock (DefaultLockTimeout if 0).
+	LockTimeout time.Duration
+}
+
+// Rollback the active registered migrations in descending revision order until the
+// database is at the target revision, e.g. all migrations after the target revision
+// are rolled back (use 0 to rollback all registered migrations). Migrations are rolled
+// back before the revisions they require; an error is returned if a migration that
+// would remain applied requires a revision after the target revision. Rollback will never
+// rollback the bootstrap migration so the migrations table remains intact. If a
+// rollback fails, the error will describe which revision failed; all migrations after
+// it will remain rolled back.
+//
+// If an active migration is irreversible (see Migration.Irreversible), the rollback
+// stops with an error that matches ErrIrreversible before it is rolled back unless the
+// Force option is specified, in which case it is marked as rolled back without
+// executing any SQL.
+//
+// If the Lock option is specified, the same database lock as Migrate is acquired on a
+// dedicated connection before any state is read and all migrations are rolled back on
+// that connection; the lock is released when Rollback returns.
+func Rollback(conn *sql.DB, target int, opts ...RollbackOptions) (err error) {
+	return DefaultRegistry.Rollback(conn, target, opts...)
+}
+
+// Rollback the migrations in the registry, see Rollback.
+func (r *Registry) Rollback(conn *sql.DB, target int, opts ...RollbackOptions) (err error) {
+	return r.RollbackContext(context.Background(), conn, target, opts...)
+}
+
+// RollbackContext rolls back the registered migrations as described by Rollback,
+// passing the context to every query, statement, and transaction that is executed on
+// the database as described by MigrateContext.
+func RollbackContext(ctx context.Context, conn *sql.DB, target int, opts ...RollbackOptions) (err error) {
+	return DefaultRegistry.RollbackContext(ctx, conn, target, opts...)
+}
+
+// RollbackContext rolls back the migrations in the registry, see RollbackContext.
+func (r *Registry) RollbackContext(ctx context.Context, conn *sql.DB, target int, opts ...RollbackOptions) (err error) {
+	_, err = r.RollbackResultContext(ctx, conn, target, opts...)
+	return err
+}
+
+// RollbackResult rolls back the registered migrations as described by Rollback and
+// returns a Result that summarizes the revisions that were rolled back, the revisions
+// after the target that were already rolled back (skipped), the revision of the database
+// before and after the rollback, and how long it took. The result is returned even if
+// a rollback fails so that callers know how far the rollback got.
+func RollbackResult(conn *sql.DB, target int, opts ...RollbackOptions) (result Result, err error) {
+	return DefaultRegistry.RollbackResult(conn, target, opts...)
+}
+
+// RollbackResult rolls back the migrations in the registry, see RollbackResult.
+func (r *Registry) RollbackResult(conn *sql.DB, target int, opts ...RollbackOptions) (result Result, err error) {
+	return r.RollbackResultContext(context.Background(), conn, target, opts...)
+}
+
+// RollbackResultContext rolls back the registered migrations with the context as
+// described by RollbackContext and returns a Result as described by RollbackResult.
+func RollbackResultContext(ctx context.Context, conn *sql.DB, target int, opts ...RollbackOptions) (result Result, err error) {
+	return DefaultRegistry.RollbackResultContext(ctx, conn, target, opts...)
+}
+
+// RollbackResultContext rolls back the migrations in the registry, see
+// RollbackResultContext.
+func (r *Registry) RollbackResultContext(ctx context.Context, conn *sql.DB, target int, opts ...RollbackOptions) (result Result, err error) {
+	start := time.Now()
+	defer func() {
+		result.Elapsed = time.Since(start)
+	}()
+
+	if target < 0 {
+		target = 0
+	}
+
+	opt := rollbackOptions(opts)
+	var migrations []Migration
+	if migrations, err = order(r.registered()); err != nil {
+		return result, err
+	}
+
+	if !opt.Lock {
+		err = rollback(ctx, conn, migrations, target, opt, &result)
+		return result, err
+	}
+
+	err = locked(ctx, conn, opt.LockTimeout, func(c *sql.Conn) error {
+		return rollback(ctx, c, migrations, target, opt, &result)
+	})
+	return result, err
+}
+
+// Rolls back the active migrations after the target revision on the connection.
+func rollback(ctx context.Context, conn executor, migrations []Migration, target int, opt RollbackOptions, result *Result) (err error) {
+	var active map[int]bool
+	if active, err = readActive(ctx, conn); err != nil {
+		return err
+	}
+
+	result.From = maxActive(active)
+	defer func() {
+		result.To = maxActive(active)
+	}()
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Revision <= target || m.Revision < 1 {
+			continue
+		}
+
+		if !active[m.Revision] {
+			result.Skipped = append(result.Skipped, m.Revision)
+			continue
+		}
+
+		if err = checkRequiredBy(migrations, m, active, target); err != nil {
+			return err
+		}
+
+		if err = m.down(ctx, conn, opt.TxOptions, opt.Force); err != nil {
+			return fmt.Errorf("rollback of revision %d failed: %w", m.Revision, err)
+		}
+
+		active[m.Revision] = false
+		result.RolledBack = append(result.RolledBack, m.Revision)
+	}
+	return nil
+}
+
+// MigrateToName applies registered migrations up to and including the migration with
+// the specified name as described by Migrate. See Lookup for how names are matched.
+func MigrateToName(conn *sql.DB, name string, opts ...MigrateOptions) (err error) {
+	return DefaultRegistry.MigrateToName(conn, name, opts...)
+}
+
+// MigrateToName applies the migrations in the registry up to the named migration.
+func (r *Registry) MigrateToName(conn *sql.DB, name string, opts ...MigrateOptions) (err error) {
+	var revision int
+	if revision, err = r.Lookup(name); err != nil {
+		return err
+	}
+	return r.Migrate(conn, revision, opts...)
+}
+
+// RollbackToName rolls back the registered migrations after the migration with the
+// specified name as described by Rollback, e.g. the named migration remains applied.
+// See Lookup for how names are matched.
+func RollbackToName(conn *sql.DB, name string, opts ...RollbackOptions) (err error) {
+	return DefaultRegistry.RollbackToName(conn, name, opts...)
+}
+
+// RollbackToName rolls back the migrations in the registry after the named migration.
+func (r *Registry) RollbackToName(conn *sql.DB, name string, opts ...RollbackOptions) (err error) {
+	var revision int
+	if revision, err = r.Lookup(name); err != nil {
+		return err
+	}
+	return r.Rollback(conn, revision, opts...)
+}
+
+// MigrateN applies the next n pending migrations after the current revision of the
+// database, or rolls back the last -n applied migrations if n is negative, e.g. to step
+// the database up or down by one revision during development. The steps are counted
+// over the migrations that are pending or applied in the database rather than by their
+// revision numbers, so an inactive revision below the current revision (e.g. one that
+// failed with ContinueOnError) is the next pending step and is never counted as applied;
+// an error is returned if there are fewer than n migrations to apply or roll back.
+// MigrateN(conn, 0) does nothing.
+func MigrateN(conn *sql.DB, n int) (err error) {
+	return DefaultRegistry.MigrateN(conn, n)
+}
+
+// MigrateN steps the database by n migrations in the registry, see MigrateN.
+func (r *Registry) MigrateN(conn *sql.DB, n int) (err error) {
+	if n == 0 {
+		return nil
+	}
+
+	var target int
+	if target, err = r.StepRevision(conn, n); err != nil {
+		return err
+	}
+
+	if n > 0 {
+		return r.Migrate(conn, target)
+	}
+	return r.Rollback(conn, target)
+}
+
+// StepRevision returns the target revision that MigrateN migrates or rolls back to when
+// stepping n migrations from the current revision of the database. Unlike
+// CurrentRevision, the current revision includes data-only migrations so that they are
+// counted as steps.
+func StepRevision(conn *sql.DB, n int) (revision int, err error) {
+	return DefaultRegistry.StepRevision(conn, n)
+}
+
+// StepRevision returns the target revision of stepping n migrations in the registry.
+func (r *Registry) StepRevision(conn *sql.DB, n int) (revision int, err error) {
+	ctx := context.Background()
+	var (
+		current int
+		active  map[int]bool
+	)
+
+	if err = checkInitialized(ctx, conn); err == nil {
+		if current, err = latestRevision(ctx, conn, false); err == nil {
+			active, err = readActive(ctx, conn)
+		}
+	}
+
+	if err != nil {
+		// Migrating up from an uninitialized database starts at revision 0
+		if n < 0 || !errors.Is(err, ErrUninitialized) {
+			return 0, err
+		}
+	}
+
+	// Inactive revisions below the current revision, e.g. after ContinueOnError, are
+	// counted as pending rather than applied
+	migrations := r.registered()
+	if n > 0 {
+		pending := make([]int, 0, len(migrations))
+		for _, m := range migrations {
+			if !active[m.Revision] {
+				pending = append(pending, m.Revision)
+			}
+		}
+
+		if n > len(pending) {
+			return 0, fmt.Errorf("cannot migrate %d revision(s) past revision %d, only %d pending", n, current, len(pending))
+		}
+		return pending[n-1], nil
+	}
+
+	// The applied revisions in descending order
+	applied := make([]int, 0, len(migrations))
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if active[migrations[i].Revision] {
+			applied = append(applied, migrations[i].Revision)
+		}
+	}
+
+	if -n > len(applied) {
+		return 0, fmt.Errorf("cannot roll back %d revision(s) from revision %d, only %d applied", -n, current, len(applied))
+	}
+
+	if -n == len(applied) {
+		return 0, nil
+	}
+	return applied[-n], nil
+}
+
+// Lookup returns the revision of the registered migration with the specified name.
+// Names are matched case-insensitively and underscores, hyphens, and spaces are treated
+// as equivalent, e.g. add_users_email_index matches the migration parsed from the file
+// 0004_Add_Users_Email_Index.sql. An error is returned if no registered migration has
+// the name or if more than one registered migration has the name.
+func Lookup(name string) (revision int, err error) {
+	return DefaultRegistry.Lookup(name)
+}
+
+// Lookup returns the revision of the named migration in the registry, see Lookup.
+func (r *Registry) Lookup(name string) (revision int, err error) {
+	key := normalizeName(name)
+	var matches []int
+	for _, m := range r.registered() {
+		if normalizeName(m.Name) == key {
+			matches = append(matches, m.Revision)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return 0, fmt.Errorf("no migration named %q has been registered", name)
+	case 1:
+		return matches[0], nil
+	default:
+		return 0, fmt.Errorf("migration name %q is ambiguous, it matches revisions %s", name, joinInts(matches))
+	}
+}
+
+// Normalizes a migration name for case-insensitive comparisons where underscores,
+// hyphens, and spaces are equivalent.
+func normalizeName(name string) string {
+	fields := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return r == '_' || r == '-' || unicode.IsSpace(r)
+	})
+	return strings.Join(fields, " ")
+}
+
+// Status returns all registered migrations in revision order with their state loaded
+// from the migrations table. Migrations that do not have a row in the migrations table
+// are returned unsynchronized (Synchronized returns false). If the migrations table
+// contains revisions that have not been registered, an error listing them is returned
+// along with the registered migrations.
+//
+// The Since and Until options limit the migrations that are returned to those that were
+// applied in a time window, e.g. to find the schema changes made by a deployment.
+func Status(conn *sql.DB, opts ...StatusOptions) (_ []Migration, err error) {
+	return DefaultRegistry.Status(conn, opts...)
+}
+
+// Status returns the migrations in the registry with their state, see Status.
+func (r *Registry) Status(conn *sql.DB, opts ...StatusOptions) (_ []Migration, err error) {
+	return r.StatusContext(context.Background(), conn, opts...)
+}
+
+// StatusContext returns all registered migrations with their state as described by
+// Status, using the context to query the migrations table.
+func StatusContext(ctx context.Context, conn *sql.DB, opts ...StatusOptions) (_ []Migration, err error) {
+	return DefaultRegistry.StatusContext(ctx, conn, opts...)
+}
+
+// StatusContext returns the migrations in the registry with their state, see
+// StatusContext.
+func (r *Registry) StatusContext(ctx context.Context, conn *sql.DB, opts ...StatusOptions) (_ []Migration, err error) {
+	var opt StatusOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	var status map[int]*record
+	if status, err = readStatus(ctx, conn); err != nil {
+		return nil, err
+	}
+
+	migrations := r.registered()
+	out := make([]Migration, 0, len(migrations))
+	for _, m := range migrations {
+		if row, ok := status[m.Revision]; ok {
+			m.Active = row.active
+			m.Applied = row.applied.Time
+			m.Created = row.created.Time
+			m.Elapsed = time.Duration(row.elapsed.Int64)
+			m.Dirty = row.dirty
+			m.dbsync = true
+			delete(status, m.Revision)
+		}
+
+		if opt.match(m) {
+			out = append(out, m)
+		}
+	}
+
+	for revision := range status {
+		if squashedRevision(migrations, revision) {
+			delete(status, revision)
+		}
+	}
+
+	if len(status) > 0 {
+		revisions := make([]int, 0, len(status))
+		for revision := range status {
+			revisions = append(revisions, revision)
+		}
+		sort.Ints(revisions)
+		return out, fmt.Errorf("migrations table contains %d unregistered revision(s): %s", len(revisions), joinInts(revisions))
+	}
+	return out, nil
+}
+
+// StatusOptions filter the migrations returned by Status.
+type StatusOptions struct {
+	// Since only returns migrations that were applied at or after this time.
+	Since time.Time
+
+	// Until only returns migrations that were applied at or before this time.
+	Until time.Time
+}
+
+// Returns true if the migration was applied in the time window of the options. If
+// neither Since nor Until is specified every migration matches, otherwise migrations
+// that are not applied (and so have no applied timestamp) never match.
+func (o StatusOptions) match(m Migration) bool {
+	if o.Since.IsZero() && o.Until.IsZero() {
+		return true
+	}
+
+	if m.Applied.IsZero() {
+		return false
+	}
+
+	if !o.Since.IsZero() && m.Applied.Before(o.Since) {
+		return false
+	}
+
+	if !o.Until.IsZero() && m.Applied.After(o.Until) {
+		return false
+	}
+	return true
+}
+
+// CurrentRevision returns the highest active revision recorded in the migrations table
+// or 0 if no migrations have been applied. Data-only migrations are not included since
+// they do not change the schema version of the database, see Migration.Versioned. If the
+// migrations table does not exist, an error that matches ErrUninitialized is returned.
+func CurrentRevision(conn *sql.DB) (revision int, err error) {
+	return CurrentRevisionContext(context.Background(), conn)
+}
+
+// CurrentRevisionContext returns the current revision of the database as described by
+// CurrentRevision, using the context to query the migrations table.
+func CurrentRevisionContext(ctx context.Context, conn *sql.DB) (revision int, err error) {
+	if err = checkInitialized(ctx, conn); err != nil {
+		return 0, err
+	}
+	return latestRevision(ctx, conn, true)
+}
+
+// The schema version of the migrations table that added the versioned column.
+const versionedSchemaVersion = 6
+
+// Returns the highest active revision in the migrations table, excluding data-only
+// migrations if versioned is true. Tables that have not been upgraded to record
+// data-only migrations yet do not have a versioned column, so every active revision in
+// them is versioned.
+func latestRevision(ctx context.Context, conn executor, versioned bool) (revision int, err error) {
+	query := "SELECT MAX(revision) FROM {table} WHERE active AND revision>0"
+	if versioned {
+		query += " AND versioned"
+	}
+
+	var current sql.NullInt64
+	if err = conn.QueryRowContext(ctx, bind(query)).Scan(&current); err != nil {
+		if version, _, verr := readSchemaVersion(ctx, conn); versioned && verr == nil && version < versionedSchemaVersion {
+			return latestRevision(ctx, conn, false)
+		}
+		return 0, fmt.Errorf("could not read migrations table: %s", err)
+	}
+	return int(current.Int64), nil
+}
+
+// Pending returns the registered migrations that have not been applied to the database
+// in revision order, e.g. to check if Migrate needs to be called at all. Pending
+// migrations with a lower revision than the current revision of the database, which
+// Migrate only applies with the OutOfOrder option (see OutOfOrder), are returned as
+// well. If the migrations table does not exist every registered migration is pending.
+// As with Status, an error is returned along with the pending migrations if the
+// migrations table contains revisions that have not been registered.
+func Pending(conn *sql.DB) (_ []Migration, err error) {
+	return DefaultRegistry.Pending(conn)
+}
+
+// Pending returns the migrations in the registry that have not been applied, see Pending.
+func (r *Registry) Pending(conn *sql.DB) (_ []Migration, err error) {
+	if err = checkInitialized(context.Background(), conn); err != nil {
+		if errors.Is(err, ErrUninitialized) {
+			return r.registered(), nil
+		}
+		return nil, err
+	}
+
+	var status []Migration
+	if status, err = r.Status(conn); status == nil {
+		return nil, err
+	}
+
+	pending := make([]Migration, 0, len(status))
+	for _, m := range status {
+		if !m.Active {
+			pending = append(pending, m)
+		}
+	}
+	return pending, err
+}
+
+// Returns an error that matches ErrUninitialized if the migrations table does not exist.
+func checkInitialized(ctx context.Context, conn executor) (err error) {
+	var exists bool
+	if err = conn.QueryRowContext(ctx, bind(dialect.TableExistsSQL()), table, tableSchema).Scan(&exists); err != nil {
+		return fmt.Errorf("could not check if the migrations table exists: %s", err)
+	}
+
+	if !exists {
+		return fmt.Errorf("%w: %q has not been created", ErrUninitialized, table)
+	}
+	return nil
+}
+
+// Returns the first options or the default options if none are specified.
+func options(opts []MigrateOptions) MigrateOptions {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return MigrateOptions{}
+}
+
+// Returns the first rollback options or the default options if none are specified.
+func rollbackOptions(opts []RollbackOptions) RollbackOptions {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return RollbackOptions{}
+}
+
+// OutOfOrder returns the registered revisions that are pending even though a later
+// revision has already been applied to the database, which Migrate refuses to apply
+// unless the OutOfOrder option is specified.
+func OutOfOrder(conn *sql.DB) (revisions []int, err error) {
+	return DefaultRegistry.OutOfOrder(conn)
+}
+
+// OutOfOrder returns the out of order revisions in the registry, see OutOfOrder.
+func (r *Registry) OutOfOrder(conn *sql.DB) (revisions []int, err error) {
+	var status map[int]*record
+	if status, err = readStatus(context.Background(), conn); err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	if migrations, err = order(r.registered()); err != nil {
+		return nil, err
+	}
+
+	revisions, _ = outOfOrder(status, migrations)
+	return revisions, nil
+}
+
+// Returns the registered revisions that are pending before the latest applied revision
+// in the order the migrations are applied, see order.
+func outOfOrder(status map[int]*record, migrations []Migration) (revisions []int, latest int) {
+	last := -1
+	for i, m := range migrations {
+		if row, ok := status[m.Revision]; ok && row.active {
+			last, latest = i, m.Revision
+		}
+	}
+
+	for _, m := range migrations[:last+1] {
+		if row, ok := status[m.Revision]; !ok || !row.active {
+			revisions = append(revisions, m.Revision)
+		}
+	}
+	return revisions, latest
+}
+
+// Returns an OutOfOrderError if migrating to the target revision would apply any
+// migrations below the latest applied revision.
+func checkOrder(status map[int]*record, migrations []Migration, target int) error {
+	revisions, latest := outOfOrder(status, migrations)
+	if target >= 0 {
+		applied := revisions[:0]
+		for _, revision := range revisions {
+			if revision <= target {
+				applied = append(applied, revision)
+			}
+		}
+		revisions = applied
+	}
+
+	if len(revisions) > 0 {
+		return &OutOfOrderError{Revisions: revisions, Latest: latest}
+	}
+	return nil
+}
+
+// Returns an error that matches ErrDirty if any revision was interrupted while it was
+// being applied or rolled back.
+func checkDirty(status map[int]*record) error {
+	var dirty []int
+	for revision, row := range status {
+		if row.dirty {
+			dirty = append(dirty, revision)
+		}
+	}
+
+	if len(dirty) == 0 {
+		return nil
+	}
+
+	sort.Ints(dirty)
+	return fmt.Errorf("%w: revision(s) %s did not finish being applied or rolled back, check the state of the database before migrating", ErrDirty, joinInts(dirty))
+}
+
+// Clears the dirty state of every revision in the migrations table.
+func clearDirty(ctx context.Context, conn executor, status map[int]*record) (err error) {
+	for revision, row := range status {
+		if !row.dirty {
+			continue
+		}
+
+		if _, err = conn.ExecContext(ctx, bind(dirtySQL), false, revision); err != nil {
+			return fmt.Errorf("could not clear dirty state of revision %d: %s", revision, err)
+		}
+		row.dirty = false
+	}
+	return nil
+}
+
+// Compares the checksums of the applied registered migrations with the checksums that
+// were stored in the database when they were applied.
+func checkDrift(status map[int]*record, migrations []Migration) (err error) {
+	for _, m := range migrations {
+		row, ok := status[m.Revision]
+		if !ok || !row.active || !row.checksum.Valid || appliedOriginals(m, status) {
+			continue
+		}
+
+		var checksum string
+		if checksum, err = m.Checksum(); err != nil {
+			return err
+		}
+
+		if checksum != row.checksum.String {
+			return fmt.Errorf("revision %d has been modified since it was applied: checksum %s does not match applied checksum %s", m.Revision, checksum, row.checksum.String)
+		}
+	}
+	return nil
+}
+
+// Ensures the migrations table exists, applying the bootstrap migration if necessary,
+// and that every registered migration has a row in the table. Returns the state of
+// every revision in the migrations table.
+func initialize(ctx context.Context, conn executor, migrations []Migration) (status map[int]*record, err error) {
+	if err = checkInitialized(ctx, conn); err != nil {
+		if !errors.Is(err, ErrUninitialized) {
+			return nil, err
+		}
+
+		// The migrations table does not exist, bootstrap it
+		bootstrap := BootstrapMigration(dialect)
+		if err = bootstrap.up(ctx, conn, nil); err != nil {
+			return nil, fmt.Errorf("could not create migrations table: %s", err)
+		}
+
+		if err = writeSchemaVersion(ctx, conn, false); err != nil {
+			return nil, err
+		}
+	} else if err = upgrade(ctx, conn); err != nil {
+		return nil, err
+	}
+
+	if status, err = readStatus(ctx, conn); err != nil {
+		return nil, err
+	}
+
+	for _, m := range migrations {
+		if _, ok := status[m.Revision]; ok {
+			continue
+		}
+
+		if _, err = conn.ExecContext(ctx, bind(createdSQL), m.Revision, m.Name, timestamp()); err != nil {
+			return nil, fmt.Errorf("could not add revision %d to migrations table: %s", m.Revision, err)
+		}
+		status[m.Revision] = &record{revision: m.Revision, name: m.Name}
+	}
+	return status, nil
+}
+
+// The version of the migrations table schema that is created by the bootstrap migration,
+// which must be incremented whenever an upgrade is added. Tables that were created
+// before the schema was versioned do not have a schema version row and are version 1.
+// Version 5 did not add a column but records repeatable migrations in rows with negative
+// revisions, which earlier versions of tidal would report as unregistered revisions.
+// Version 6 added the versioned column, see versionedSchemaVersion.
+const schemaVersion = 6
+
+// The schema version of the migrations table is stored in the name of a dedicated row
+// with revision 0, the revision of the bootstrap migration, which never has a row of its
+// own. The row is never active and is not returned when reading the migrations table.
+const (
+	schemaRevision = 0
+	schemaName     = "tidal schema version %d"
+)
+
+// Queries to read and update the schema version row of the migrations table.
+const (
+	schemaVersionSQL       = "SELECT name FROM {table} WHERE revision=$1"
+	updateSchemaVersionSQL = "UPDATE {table} SET name=$1 WHERE revision=$2"
+)
+
+// Columns that have been added to the migrations table since it was first released, the
+// schema version that added them, and the type used to add them to tables that were
+// created by earlier versions of tidal.
+var upgrades = []struct {
+	version int
+	column  string
+	ddl     string
+}{
+	{2, "checksum", "varchar(64)"},
+	{3, "elapsed", "bigint"},
+	{4, "dirty", "boolean NOT NULL DEFAULT false"},
+	{6, "versioned", "boolean NOT NULL DEFAULT true"},
+}
+
+// Upgrades a migrations table that was created by an earlier version of tidal to the
+// current schema version before any migrations are applied, adding the columns that are
+// missing so that existing tables remain backward compatible. Each column is only added
+// if it does not already exist so that the upgrade is idempotent, e.g. if a previous
+// upgrade was interrupted. An error that matches ErrSchemaVersion is returned if the
+// table was created by a newer version of tidal.
+func upgrade(ctx context.Context, conn executor) (err error) {
+	var (
+		version int
+		exists  bool
+	)
+	if version, exists, err = readSchemaVersion(ctx, conn); err != nil {
+		return err
+	}
+
+	if version > schemaVersion {
+		return fmt.Errorf("%w: the migrations table is at version %d but this version of tidal only supports up to version %d, upgrade tidal to migrate the database", ErrSchemaVersion, version, schemaVersion)
+	}
+
+	if version == schemaVersion {
+		return nil
+	}
+
+	for _, u := range upgrades {
+		if u.version <= version {
+			continue
+		}
+
+		var rows *sql.Rows
+		if rows, err = conn.QueryContext(ctx, bind("SELECT "+u.column+" FROM {table} WHERE 1=0")); err == nil {
+			rows.Close()
+			continue
+		}
+
+		if _, err = conn.ExecContext(ctx, bind("ALTER TABLE {table} ADD COLUMN "+u.column+" "+u.ddl)); err != nil {
+			return fmt.Errorf("could not add %s column to migrations table: %s", u.column, err)
+		}
+	}
+	return writeSchemaVersion(ctx, conn, exists)
+}
+
+// Returns the schema version of the migrations table and if the schema version row
+// exists; tables without a schema version row are version 1.
+func readSchemaVersion(ctx context.Context, conn executor) (version int, exists bool, err error) {
+	var name string
+	if err = conn.QueryRowContext(ctx, bind(schemaVersionSQL), schemaRevision).Scan(&name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 1, false, nil
+		}
+		return 0, false, fmt.Errorf("could not read migrations table schema version: %s", err)
+	}
+
+	if _, err = fmt.Sscanf(name, schemaName, &version); err != nil || version < 1 {
+		return 0, true, fmt.Errorf("%w: could not parse migrations table schema version %q", ErrSchemaVersion, name)
+	}
+	return version, true, nil
+}
+
+// Records the current schema version in the schema version row of the migrations table,
+// inserting the row if it does not exist.
+func writeSchemaVersion(ctx context.Context, conn executor, exists bool) (err error) {
+	name := fmt.Sprintf(schemaName, schemaVersion)
+	if exists {
+		_, err = conn.ExecContext(ctx, bind(updateSchemaVersionSQL), name, schemaRevision)
+	} else {
+		_, err = conn.ExecContext(ctx, bind(createdSQL), schemaRevision, name, timestamp())
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not update migrations table schema version: %s", err)
+	}
+	return nil
+}
+
+// record is a single row of the migrations table.
+type record struct {
+	revision int
+	name     string
+	active   bool
+	applied  sql.NullTime
+	created  sql.NullTime
+	checksum sql.NullString
+	elapsed  sql.NullInt64
+	dirty    bool
+}
+
+// Reads all of the rows in the migrations table keyed by revision, excluding the schema
+// version row and the rows of repeatable migrations. Tables that have not been upgraded
+// yet (which is done by Migrate) may be missing columns, in which case an error that
+// matches ErrSchemaVersion is returned.
+func readStatus(ctx context.Context, conn executor) (status map[int]*record, err error) {
+	var rows *sql.Rows
+	if rows, err = conn.QueryContext(ctx, bind("SELECT revision, name, active, applied, created, checksum, elapsed, dirty FROM {table} WHERE revision>$1"), schemaRevision); err != nil {
+		if version, _, verr := readSchemaVersion(ctx, conn); verr == nil && version < schemaVersion {
+			return nil, fmt.Errorf("%w: the migrations table is at version %d and must be upgraded to version %d by migrating the database", ErrSchemaVersion, version, schemaVersion)
+		}
+		return nil, fmt.Errorf("could not read migrations table: %s", err)
+	}
+	defer rows.Close()
+
+	status = make(map[int]*record)
+	for rows.Next() {
+		row := &record{}
+		if err = rows.Scan(&row.revision, &row.name, &row.active, &row.applied, &row.created, &row.checksum, &row.elapsed, &row.dirty); err != nil {
+			return nil, err
+		}
+		status[row.revision] = row
+	}
+	return status, rows.Err()
+}
+
+// Reads the rows of the repeatable migrations in the migrations table keyed by name;
+// repeatable migrations are recorded with negative revisions.
+func readRepeatable(ctx context.Context, conn executor) (status map[string]*record, err error) {
+	var rows *sql.Rows
+	if rows, err = conn.QueryContext(ctx, bind("SELECT revision, name, active, applied, created, checksum, elapsed, dirty FROM {table} WHERE revision<$1"), schemaRevision); err != nil {
+		return nil, fmt.Errorf("could not read repeatable migrations from migrations table: %s", err)
+	}
+	defer rows.Close()
+
+	status = make(map[string]*record)
+	for rows.Next() {
+		row := &record{}
+		if err = rows.Scan(&row.revision, &row.name, &row.active, &row.applied, &row.created, &row.checksum, &row.elapsed, &row.dirty); err != nil {
+			return nil, err
+		}
+		status[row.name] = row
+	}
+	return status, rows.Err()
+}
+
+// Reads the active state of all revisions stored in the migrations table.
+func readActive(ctx context.Context, conn executor) (active map[int]bool, err error) {
+	var status map[int]*record
+	if status, err = readStatus(ctx, conn); err != nil {
+		return nil, err
+	}
+
+	active = make(map[int]bool, len(status))
+	for revision, row := range status {
+		active[revision] = row.active
+	}
+	return active, nil
+}
+
+// Returns a comma separated list of the integers.
+func joinInts(a []int) string {
+	s := make([]string, len(a))
+	for i, n := range a {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,1542 @@
+package tidal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchema(t *testing.T) {
+	// The bootstrap schema must match the migrations schema file
+	data, err := ioutil.ReadFile("migrations/0000_migrations_schema.sql")
+	require.NoError(t, err)
+	require.Equal(t, string(data), strings.Replace(schema, "{table}", "migrations", -1))
+}
+
+func TestBootstrapMigration(t *testing.T) {
+	require.Equal(t, BootstrapMigration(Postgres), BootstrapMigration(nil))
+
+	for _, d := range []Dialect{Postgres, MySQL, SQLite} {
+		m := BootstrapMigration(d)
+		require.Equal(t, 0, m.Revision, d.Name())
+		require.Equal(t, "migrations schema", m.Name, d.Name())
+
+		upsql, err := m.UpSQL()
+		require.NoError(t, err, d.Name())
+		require.Contains(t, upsql, "CREATE TABLE IF NOT EXISTS migrations (", d.Name())
+
+		downsql, err := m.DownSQL()
+		require.NoError(t, err, d.Name())
+		require.Contains(t, downsql, "DROP TABLE IF EXISTS migrations", d.Name())
+	}
+
+	// MySQL cannot execute multiple statements so the table must be created by one
+	m := BootstrapMigration(MySQL)
+	upsql, err := m.UpSQL()
+	require.NoError(t, err)
+	require.Len(t, splitStatements(upsql), 1)
+	require.NotContains(t, upsql, `"`)
+
+	// The SQLite bootstrap migration can be applied and rolled back
+	conn, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+	defer SetDialect(Postgres)
+	SetDialect(SQLite)
+
+	m = BootstrapMigration(SQLite)
+	require.NoError(t, m.Up(conn))
+	require.NoError(t, checkInitialized(context.Background(), conn))
+	require.NoError(t, m.Down(conn))
+	require.True(t, errors.Is(checkInitialized(context.Background(), conn), ErrUninitialized))
+}
+
+func TestMigrate(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	registerTestMigration(t, "0003_create_roles.sql", "CREATE TABLE roles (id integer);", "DROP TABLE roles;")
+
+	// Migrate up to revision 2
+	require.NoError(t, Migrate(conn, 2))
+	active, err := readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: true, 2: true, 3: false}, active)
+
+	// Migrating again should skip the applied revisions
+	require.NoError(t, Migrate(conn, -1))
+	active, err = readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: true, 2: true, 3: true}, active)
+
+	// A failing migration should report the revision that failed
+	registerTestMigration(t, "0004_bad_sql.sql", "CREATE TABLEZ foo;", "")
+	err = Migrate(conn, -1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "revision 4")
+}
+
+func TestTableName(t *testing.T) {
+	defer Reset()
+	defer SetTableName("migrations")
+
+	for _, name := range []string{"", "1migrations", "migrations; DROP TABLE users", "public.migrations", `"migrations"`, "BillingMigrations"} {
+		require.Error(t, SetTableName(name), "expected %q to be invalid", name)
+	}
+	require.Equal(t, "migrations", TableName())
+
+	conn := openTestDB(t)
+	defer conn.Close()
+	require.NoError(t, SetTableName("billing_migrations"))
+	require.Equal(t, "billing_migrations", TableName())
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	require.NoError(t, Migrate(conn, -1))
+
+	var active bool
+	require.NoError(t, conn.QueryRow("SELECT active FROM billing_migrations WHERE revision=1").Scan(&active))
+	require.True(t, active)
+
+	// The default migrations table should not have been modified
+	var count int
+	require.NoError(t, conn.QueryRow("SELECT count(*) FROM migrations").Scan(&count))
+	require.Equal(t, 0, count)
+
+	// The bootstrap migration should use the configured table name
+	bootstrap := BootstrapMigration(Postgres)
+	upsql, err := bootstrap.UpSQL()
+	require.NoError(t, err)
+	require.Contains(t, upsql, "CREATE TABLE IF NOT EXISTS billing_migrations (")
+	require.NotContains(t, upsql, "{table}")
+}
+
+func TestTableSchema(t *testing.T) {
+	defer Reset()
+	defer SetTableSchema("")
+
+	for _, name := range []string{"1ops", "ops; DROP TABLE users", "public.ops", `"ops"`} {
+		require.Error(t, SetTableSchema(name), "expected %q to be invalid", name)
+	}
+	require.Equal(t, "", TableSchema())
+
+	conn := openTestDB(t)
+	defer conn.Close()
+	_, err := conn.Exec("ATTACH DATABASE ':memory:' AS ops")
+	require.NoError(t, err)
+
+	require.NoError(t, SetTableSchema("ops"))
+	require.Equal(t, "ops", TableSchema())
+	_, err = CurrentRevision(conn)
+	require.True(t, errors.Is(err, ErrUninitialized))
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	require.NoError(t, Migrate(conn, -1))
+
+	var active bool
+	require.NoError(t, conn.QueryRow("SELECT active FROM ops.migrations WHERE revision=1").Scan(&active))
+	require.True(t, active)
+
+	revision, err := CurrentRevision(conn)
+	require.NoError(t, err)
+	require.Equal(t, 1, revision)
+
+	// The migrations table in the main schema should not have been modified
+	var count int
+	require.NoError(t, conn.QueryRow("SELECT count(*) FROM main.migrations").Scan(&count))
+	require.Equal(t, 0, count)
+
+	require.NoError(t, Rollback(conn, 0))
+	require.NoError(t, conn.QueryRow("SELECT active FROM ops.migrations WHERE revision=1").Scan(&active))
+	require.False(t, active)
+
+	// The bootstrap migration should quote the schema for the dialect
+	for d, expected := range map[Dialect]string{
+		Postgres: `CREATE TABLE IF NOT EXISTS "ops".migrations (`,
+		MySQL:    "CREATE TABLE IF NOT EXISTS `ops`.migrations (",
+		SQLite:   `CREATE TABLE IF NOT EXISTS "ops".migrations (`,
+	} {
+		bootstrap := BootstrapMigration(d)
+		upsql, err := bootstrap.UpSQL()
+		require.NoError(t, err)
+		require.Contains(t, upsql, expected, d.Name())
+	}
+
+	// An unknown schema is reported rather than creating the table in the main schema
+	require.NoError(t, SetTableSchema("billing"))
+	_, err = CurrentRevision(conn)
+	require.Error(t, err)
+	require.False(t, errors.Is(err, ErrUninitialized))
+}
+
+func TestMigrateUpgrade(t *testing.T) {
+	defer Reset()
+	conn, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+	SetDialect(SQLite)
+
+	// A migrations table created by an earlier version of tidal
+	_, err = conn.Exec(`CREATE TABLE migrations (
+    "revision" integer NOT NULL,
+    "name" varchar(128) NOT NULL,
+    "active" boolean NOT NULL DEFAULT false,
+    "applied" TIMESTAMP,
+    "created" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("revision")
+)`)
+	require.NoError(t, err)
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+
+	// The table cannot be read until it has been upgraded by migrating
+	_, err = Status(conn)
+	require.True(t, errors.Is(err, ErrSchemaVersion))
+
+	ctx := context.Background()
+	version, exists, err := readSchemaVersion(ctx, conn)
+	require.NoError(t, err)
+	require.Equal(t, 1, version)
+	require.False(t, exists)
+
+	require.NoError(t, Migrate(conn, -1))
+
+	var (
+		checksum sql.NullString
+		elapsed  sql.NullInt64
+	)
+	require.NoError(t, conn.QueryRow("SELECT checksum, elapsed FROM migrations WHERE revision=1").Scan(&checksum, &elapsed))
+	require.True(t, checksum.Valid)
+	require.True(t, elapsed.Valid)
+
+	// The schema version is stored in a dedicated row that is not a migration
+	var name string
+	require.NoError(t, conn.QueryRow("SELECT name FROM migrations WHERE revision=0").Scan(&name))
+	require.Equal(t, "tidal schema version 6", name)
+
+	migrations, err := Status(conn)
+	require.NoError(t, err)
+	require.Len(t, migrations, 1)
+
+	current, err := CurrentRevision(conn)
+	require.NoError(t, err)
+	require.Equal(t, 1, current)
+
+	// Upgrading an up to date table is a no-op
+	require.NoError(t, upgrade(ctx, conn))
+
+	// A table that was upgraded by a newer version of tidal cannot be migrated
+	_, err = conn.Exec("UPDATE migrations SET name='tidal schema version 99' WHERE revision=0")
+	require.NoError(t, err)
+	err = Migrate(conn, -1)
+	require.True(t, errors.Is(err, ErrSchemaVersion))
+	require.Contains(t, err.Error(), "the migrations table is at version 99")
+}
+
+func TestMigrateNoversion(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	descriptor, err := NewDescriptor(strings.NewReader("-- migrate: up noversion\nINSERT INTO users VALUES (1);\n-- migrate: down\nDELETE FROM users;\n"), "0002_backfill_users.sql")
+	require.NoError(t, err)
+	require.NoError(t, RegisterDescriptor(descriptor))
+	registerTestMigration(t, "0003_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+
+	migrations := Migrations()
+	require.True(t, migrations[0].Versioned())
+	require.False(t, migrations[1].Versioned())
+
+	// Data-only migrations are applied and recorded but do not change the revision
+	require.NoError(t, Migrate(conn, 2))
+	current, err := CurrentRevision(conn)
+	require.NoError(t, err)
+	require.Equal(t, 1, current)
+
+	var versioned bool
+	require.NoError(t, conn.QueryRow("SELECT versioned FROM migrations WHERE revision=2 AND active").Scan(&versioned))
+	require.False(t, versioned)
+
+	// Data-only migrations are never applied twice and are counted as steps
+	target, err := StepRevision(conn, 1)
+	require.NoError(t, err)
+	require.Equal(t, 3, target)
+	require.NoError(t, Migrate(conn, -1))
+
+	var count int
+	require.NoError(t, conn.QueryRow("SELECT COUNT(*) FROM users").Scan(&count))
+	require.Equal(t, 1, count)
+
+	current, err = CurrentRevision(conn)
+	require.NoError(t, err)
+	require.Equal(t, 3, current)
+
+	// Data-only migrations are rolled back like any other migration
+	require.NoError(t, Rollback(conn, 1))
+	require.NoError(t, conn.QueryRow("SELECT COUNT(*) FROM users").Scan(&count))
+	require.Zero(t, count)
+
+	// Tables that have not been upgraded yet do not have a versioned column
+	conn = openTestDB(t)
+	defer conn.Close()
+	_, err = conn.Exec("DROP TABLE migrations; CREATE TABLE migrations (revision integer NOT NULL, name varchar(128) NOT NULL, active boolean NOT NULL DEFAULT false, applied TIMESTAMP, created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, checksum varchar(64), elapsed bigint, dirty boolean NOT NULL DEFAULT false, PRIMARY KEY (revision));")
+	require.NoError(t, err)
+	_, err = conn.Exec("INSERT INTO migrations (revision, name, active) VALUES (0, 'tidal schema version 5', false), (1, 'create users', true)")
+	require.NoError(t, err)
+
+	current, err = CurrentRevision(conn)
+	require.NoError(t, err)
+	require.Equal(t, 1, current)
+}
+
+func TestMigrateDirty(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	// A notransaction migration that fails part of the way through is left dirty
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	src := "-- migrate: up notransaction\nCREATE TABLE IF NOT EXISTS groups (id integer);\nINSERT INTO roles (id) VALUES (1);\n-- migrate: down\nDROP TABLE groups;\n"
+	descriptor, err := NewDescriptor(strings.NewReader(src), "0002_create_groups.sql")
+	require.NoError(t, err)
+	require.NoError(t, RegisterDescriptor(descriptor))
+
+	err = Migrate(conn, -1)
+	require.Error(t, err)
+	require.False(t, errors.Is(err, ErrDirty))
+
+	migrations, err := Status(conn)
+	require.NoError(t, err)
+	require.False(t, migrations[0].Dirty)
+	require.True(t, migrations[1].Dirty)
+
+	// The database must be checked before it can be migrated again
+	err = Migrate(conn, -1)
+	require.True(t, errors.Is(err, ErrDirty))
+	require.EqualError(t, err, "migrations table is dirty: revision(s) 2 did not finish being applied or rolled back, check the state of the database before migrating")
+
+	_, err = conn.Exec("CREATE TABLE roles (id integer)")
+	require.NoError(t, err)
+	require.NoError(t, Migrate(conn, -1, MigrateOptions{AllowDirty: true}))
+
+	migrations, err = Status(conn)
+	require.NoError(t, err)
+	require.True(t, migrations[1].Active)
+	require.False(t, migrations[1].Dirty)
+
+	// A transaction that is rolled back does not leave the revision dirty
+	registerTestMigration(t, "0003_create_perms.sql", "CREATE TABLE perms (id integer);\nINSERT INTO missing (id) VALUES (1);", "DROP TABLE perms;")
+	require.Error(t, Migrate(conn, -1))
+	require.NoError(t, Migrate(conn, 2))
+
+	// A revision that was interrupted by a crash is still dirty, Force also clears it
+	_, err = conn.Exec("UPDATE migrations SET dirty=true WHERE revision=1")
+	require.NoError(t, err)
+	require.True(t, errors.Is(Migrate(conn, 2), ErrDirty))
+	require.NoError(t, Migrate(conn, 2, MigrateOptions{Force: true}))
+	require.NoError(t, Migrate(conn, 2))
+}
+
+func TestSchemaVersion(t *testing.T) {
+	defer Reset()
+	conn, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+	SetDialect(SQLite)
+
+	// Bootstrapping the migrations table records the current schema version
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	require.NoError(t, Migrate(conn, -1))
+
+	version, exists, err := readSchemaVersion(context.Background(), conn)
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Equal(t, schemaVersion, version)
+
+	// Rolling back all migrations does not remove the schema version row
+	require.NoError(t, Rollback(conn, 0))
+	version, _, err = readSchemaVersion(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, schemaVersion, version)
+}
+
+func TestMigrateDrift(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	m := registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	require.NoError(t, Migrate(conn, -1))
+
+	var checksum string
+	require.NoError(t, conn.QueryRow("SELECT checksum FROM migrations WHERE revision=1").Scan(&checksum))
+	expected, err := m.Checksum()
+	require.NoError(t, err)
+	require.Equal(t, expected, checksum)
+	require.Len(t, checksum, 64)
+
+	// Modify the applied migration and register a new one
+	Reset()
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer, email text);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+
+	err = Migrate(conn, -1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "revision 1 has been modified since it was applied")
+
+	// A checksum directive in the migration file cannot hide the modification
+	Reset()
+	registerTestMigration(t, "0001_create_users.sql", "-- tidal: checksum="+checksum+"\nCREATE TABLE users (id integer, email text);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+
+	err = Migrate(conn, -1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "revision 1 has been modified since it was applied")
+
+	active, err := readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.False(t, active[2])
+
+	require.NoError(t, Migrate(conn, -1, MigrateOptions{Force: true}))
+	active, err = readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.True(t, active[2])
+}
+
+func TestSync(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	// The users table was created out of band, e.g. by restoring a backup
+	_, err := conn.Exec("CREATE TABLE users (id integer)")
+	require.NoError(t, err)
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	require.Error(t, Migrate(conn, -1), "expected the users table to already exist")
+
+	require.NoError(t, Sync(conn, 1))
+	status, err := Status(conn)
+	require.NoError(t, err)
+	require.True(t, status[0].Active)
+	require.False(t, status[0].Applied.IsZero())
+	require.Zero(t, status[0].Elapsed)
+	require.False(t, status[1].Active)
+
+	// The synced migration is not executed again but the others are applied
+	require.NoError(t, Migrate(conn, -1))
+	active, err := readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: true, 2: true}, active)
+
+	// Syncing applied migrations is a no-op
+	require.NoError(t, Sync(conn, -1))
+}
+
+func TestBaseline(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	// The existing schema was created before adopting tidal
+	_, err := conn.Exec("CREATE TABLE users (id integer); CREATE TABLE groups (id integer);")
+	require.NoError(t, err)
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	registerTestMigration(t, "0003_create_roles.sql", "CREATE TABLE roles (id integer);", "DROP TABLE roles;")
+
+	require.True(t, errors.Is(Baseline(conn, 4), ErrNotRegistered))
+	require.True(t, errors.Is(Baseline(conn, 0), ErrNotRegistered))
+
+	require.NoError(t, Baseline(conn, 2))
+	active, err := readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: true, 2: true, 3: false}, active)
+
+	// Baseline can only be used to onboard a database
+	require.EqualError(t, Baseline(conn, 3), "cannot baseline database: revision 1 has already been applied, use Sync instead")
+
+	require.NoError(t, Migrate(conn, -1))
+	active, err = readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: true, 2: true, 3: true}, active)
+}
+
+func TestMigrateAtomic(t *testing.T) {
+	defer Reset()
+	defer SetDialect(Postgres)
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	registerTestMigration(t, "0003_insert_roles.sql", "INSERT INTO roles (id) VALUES (1);", "")
+
+	// If any migration fails none of them are applied
+	err := Migrate(conn, -1, MigrateOptions{Atomic: true})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "migration to revision 3 failed, no migrations were applied")
+
+	active, err := readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: false, 2: false, 3: false}, active)
+
+	var n int
+	require.NoError(t, conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name IN ('users', 'groups')").Scan(&n))
+	require.Zero(t, n)
+
+	require.NoError(t, Migrate(conn, 2, MigrateOptions{Atomic: true}))
+	active, err = readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: true, 2: true, 3: false}, active)
+
+	SetDialect(MySQL)
+	require.EqualError(t, migrateAtomic(context.Background(), conn, registered(), nil), "cannot migrate atomically: the mysql dialect does not support transactional ddl")
+}
+
+func TestForce(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer); CREATE TABLE users (id integer);", "DROP TABLE groups;")
+	require.True(t, errors.Is(Force(conn, 3, true), ErrNotRegistered))
+	require.True(t, errors.Is(Force(conn, 0, false), ErrNotRegistered))
+
+	// The second migration fails after the groups table was created manually
+	require.Error(t, Migrate(conn, -1))
+	_, err := conn.Exec("CREATE TABLE groups (id integer)")
+	require.NoError(t, err)
+
+	require.NoError(t, Force(conn, 2, true))
+	status, err := Status(conn)
+	require.NoError(t, err)
+	require.True(t, status[1].Active)
+	require.False(t, status[1].Applied.IsZero())
+	require.NoError(t, Migrate(conn, -1), "the forced migration should not be applied again")
+
+	// A forced pending migration is applied again by Migrate
+	_, err = conn.Exec("DROP TABLE users")
+	require.NoError(t, err)
+	require.NoError(t, Force(conn, 1, false))
+	active, err := readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: false, 2: true}, active)
+
+	// Revision 2 is still applied so revision 1 is out of order
+	require.True(t, errors.Is(Migrate(conn, -1), ErrOutOfOrder))
+	require.NoError(t, Migrate(conn, -1, MigrateOptions{OutOfOrder: true}))
+	active, err = readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: true, 2: true}, active)
+}
+
+func TestMigrateTimeout(t *testing.T) {
+	defer Reset()
+	defer SetDialect(Postgres)
+
+	// The connection is discarded when the timeout expires so use a file database
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+	SetDialect(SQLite)
+	bootstrapTestDB(t, conn)
+
+	m := registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	_, ok := m.Timeout()
+	require.False(t, ok, "migrations have no timeout by default")
+
+	src := "-- migrate: up timeout=10ms\n" +
+		"CREATE TABLE numbers AS WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c LIMIT 100000000) SELECT x FROM c;\n" +
+		"-- migrate: down timeout=1m\nDROP TABLE numbers;\n"
+	descriptor, err := NewDescriptor(strings.NewReader(src), "0002_create_numbers.sql")
+	require.NoError(t, err)
+	require.NoError(t, RegisterDescriptor(descriptor))
+
+	m = Migration{Revision: 2, descriptor: descriptor}
+	timeout, ok := m.Timeout()
+	require.True(t, ok)
+	require.Equal(t, 10*time.Millisecond, timeout)
+
+	timeout, ok, err = m.timeout("down")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, time.Minute, timeout)
+
+	// The long running migration is aborted and rolled back when the timeout expires
+	require.Error(t, Migrate(conn, -1))
+	active, err := readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: true, 2: false}, active)
+
+	var n int
+	require.NoError(t, conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'numbers'").Scan(&n))
+	require.Zero(t, n)
+
+	// An invalid timeout is reported when the migration is applied
+	descriptor, err = NewDescriptor(strings.NewReader("-- migrate: up timeout=soon\nSELECT 1;\n"), "0001_select.sql")
+	require.NoError(t, err)
+	m = Migration{Revision: 1, descriptor: descriptor}
+	_, ok = m.Timeout()
+	require.False(t, ok)
+	require.EqualError(t, m.up(context.Background(), conn, nil), `invalid up timeout "soon" for revision 1`)
+}
+
+func TestMigrateSavepoint(t *testing.T) {
+	defer Reset()
+	defer SetDialect(Postgres)
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	register := func(filename, src string) Migration {
+		descriptor, err := NewDescriptor(strings.NewReader(src), filename)
+		require.NoError(t, err)
+		require.NoError(t, RegisterDescriptor(descriptor))
+		m := Migration{descriptor: descriptor}
+		m.Name, m.Revision, err = parseFilename(filename)
+		require.NoError(t, err)
+		return m
+	}
+
+	m := registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	require.False(t, m.ApplySavepoint())
+
+	m = register("0002_create_groups.sql", "-- migrate: up savepoint\nCREATE TABLE groups (id integer);\nINSERT INTO groups VALUES (1);\n-- migrate: down savepoint\nDROP TABLE groups;\n")
+	require.True(t, m.ApplySavepoint())
+	require.NoError(t, Migrate(conn, -1))
+
+	var n int
+	require.NoError(t, conn.QueryRow("SELECT COUNT(*) FROM groups").Scan(&n))
+	require.Equal(t, 1, n)
+
+	// A statement that fails after being retried rolls back the entire migration
+	register("0003_create_roles.sql", "-- migrate: up savepoint\nCREATE TABLE roles (id integer);\nINSERT INTO missing VALUES (1);\n")
+	err := Migrate(conn, -1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "could not exec revision 3 up statement 2: no such table: missing")
+	require.NoError(t, conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'roles'").Scan(&n))
+	require.Zero(t, n)
+
+	require.NoError(t, Rollback(conn, 1))
+	require.NoError(t, conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'groups'").Scan(&n))
+	require.Zero(t, n)
+
+	// Savepoints require a transaction and transactional ddl
+	m = Migration{Revision: 4, descriptor: m.descriptor}
+	SetDialect(MySQL)
+	require.EqualError(t, m.up(context.Background(), conn, nil), "revision 4 up uses savepoints but the mysql dialect does not support transactional ddl")
+	SetDialect(SQLite)
+
+	descriptor, err := NewDescriptor(strings.NewReader("-- migrate: up savepoint notransaction\nSELECT 1;\n"), "0005_select.sql")
+	require.NoError(t, err)
+	m = Migration{Revision: 5, descriptor: descriptor}
+	require.EqualError(t, m.up(context.Background(), conn, nil), "revision 5 up cannot use savepoints without a transaction")
+}
+
+func TestPending(t *testing.T) {
+	defer Reset()
+	conn, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+	SetDialect(SQLite)
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	registerTestMigration(t, "0003_create_roles.sql", "CREATE TABLE roles (id integer);", "DROP TABLE roles;")
+	registerTestMigration(t, "0004_create_perms.sql", "CREATE TABLE perms (id integer);", "DROP TABLE perms;")
+
+	revisions := func(migrations []Migration) []int {
+		out := make([]int, 0, len(migrations))
+		for _, m := range migrations {
+			out = append(out, m.Revision)
+		}
+		return out
+	}
+
+	// Every migration is pending if the migrations table does not exist
+	pending, err := Pending(conn)
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2, 3, 4}, revisions(pending))
+
+	require.NoError(t, Migrate(conn, 1))
+	pending, err = Pending(conn)
+	require.NoError(t, err)
+	require.Equal(t, []int{2, 3, 4}, revisions(pending))
+	require.True(t, pending[0].Synchronized())
+	require.False(t, pending[0].Active)
+
+	// Pending migrations below the current revision are included
+	require.NoError(t, Force(conn, 3, true))
+	pending, err = Pending(conn)
+	require.NoError(t, err)
+	require.Equal(t, []int{2, 4}, revisions(pending))
+
+	require.NoError(t, Migrate(conn, -1, MigrateOptions{OutOfOrder: true}))
+	pending, err = Pending(conn)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+}
+
+func TestMigrateHooks(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	registerTestMigration(t, "0003_create_roles.sql", "CREATE TABLE roles (id integer);", "DROP TABLE roles;")
+	registerTestMigration(t, "0004_create_perms.sql", "CREATE TABLE perms (id integer);", "DROP TABLE perms;")
+
+	var before, after []int
+	var failed []error
+	errFlag := errors.New("feature flag is not set")
+	opts := MigrateOptions{
+		BeforeEach: func(m Migration) error {
+			before = append(before, m.Revision)
+			if m.Revision == 2 {
+				return errFlag
+			}
+			return nil
+		},
+		AfterEach: func(m Migration, err error) {
+			after = append(after, m.Revision)
+			failed = append(failed, err)
+		},
+	}
+
+	// An error returned by BeforeEach aborts the migration before it is applied
+	require.NoError(t, Migrate(conn, 1, opts))
+	require.Equal(t, []int{1}, before)
+	require.Equal(t, []int{1}, after)
+	require.Equal(t, []error{nil}, failed)
+
+	before, after, failed = nil, nil, nil
+	err := Migrate(conn, -1, opts)
+	require.True(t, errors.Is(err, errFlag))
+	require.EqualError(t, err, "migration to revision 2 aborted: feature flag is not set")
+	require.Equal(t, []int{2}, before)
+	require.Empty(t, after)
+
+	active, err := readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.True(t, active[1])
+	require.False(t, active[2])
+
+	// With ContinueOnError the aborted migration is reported and the rest are applied
+	before, after, failed = nil, nil, nil
+	opts.ContinueOnError = true
+	err = Migrate(conn, 3, opts)
+	var errs MultiError
+	require.True(t, errors.As(err, &errs))
+	require.Len(t, errs, 1)
+	require.True(t, errors.Is(errs[0], errFlag))
+	require.Equal(t, []int{2, 3}, before)
+	require.Equal(t, []int{3}, after)
+
+	// AfterEach receives the error of a migration that fails, revision 2 is applied
+	before, after, failed = nil, nil, nil
+	_, err = conn.Exec("CREATE TABLE perms (id integer)")
+	require.NoError(t, err)
+	opts.ContinueOnError = false
+	opts.OutOfOrder = true
+	opts.BeforeEach = nil
+	err = Migrate(conn, 4, opts)
+	require.Error(t, err)
+	require.Equal(t, []int{2, 4}, after)
+	require.Len(t, failed, 2)
+	require.NoError(t, failed[0])
+	require.Error(t, failed[1])
+	require.Contains(t, err.Error(), failed[1].Error())
+
+	// Atomic migrations call BeforeEach for all of them before any are applied
+	fresh := openTestDB(t)
+	defer fresh.Close()
+	before, after, failed = nil, nil, nil
+	opts = MigrateOptions{
+		Atomic: true,
+		BeforeEach: func(m Migration) error {
+			before = append(before, m.Revision)
+			if m.Revision == 3 {
+				return errFlag
+			}
+			return nil
+		},
+		AfterEach: opts.AfterEach,
+	}
+	err = Migrate(fresh, -1, opts)
+	require.True(t, errors.Is(err, errFlag))
+	require.Equal(t, []int{1, 2, 3}, before)
+	require.Empty(t, after)
+
+	opts.BeforeEach = nil
+	require.NoError(t, Migrate(fresh, 2, opts))
+	require.Equal(t, []int{1, 2}, after)
+	require.Equal(t, []error{nil, nil}, failed)
+}
+
+func TestMigrateContinueOnError(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	// Revision 2 fails because the table already exists and revision 4 requires it
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE IF NOT EXISTS users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	registerTestMigration(t, "0003_create_roles.sql", "CREATE TABLE IF NOT EXISTS roles (id integer);", "DROP TABLE roles;")
+	registerTestMigration(t, "0004_create_members.sql", "-- migrate: requires 2\nCREATE TABLE IF NOT EXISTS members (id integer);", "DROP TABLE members;")
+	registerTestMigration(t, "0005_create_perms.sql", "CREATE TABLE IF NOT EXISTS perms (id integer);", "DROP TABLE perms;")
+
+	_, err := conn.Exec("CREATE TABLE groups (id integer)")
+	require.NoError(t, err)
+
+	// By default the first failure stops the migration
+	err = Migrate(conn, -1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "migration to revision 2 failed")
+	active, err := readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.False(t, active[3])
+
+	err = Migrate(conn, -1, MigrateOptions{ContinueOnError: true, OutOfOrder: true})
+	var errs MultiError
+	require.True(t, errors.As(err, &errs))
+	require.Len(t, errs, 2)
+	require.Contains(t, errs[0].Error(), "migration to revision 2 failed")
+	require.EqualError(t, errs[1], "migration to revision 4 skipped: requires revision 2 which failed")
+
+	active, err = readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: true, 3: true, 5: true}, trueRevisions(active))
+
+	err = Migrate(conn, -1, MigrateOptions{ContinueOnError: true, Atomic: true})
+	require.EqualError(t, err, "cannot migrate atomically and continue on error, specify only one option")
+}
+
+func TestMigrateResult(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	registerTestMigration(t, "0003_create_roles.sql", "CREATE TABLE roles (id integer);", "DROP TABLE roles;")
+	registerTestMigration(t, "0004_create_members.sql", "CREATE TABLE members (id integer);", "DROP TABLE members;")
+
+	result, err := MigrateResult(conn, 2)
+	require.NoError(t, err)
+	require.Equal(t, 0, result.From)
+	require.Equal(t, 2, result.To)
+	require.Equal(t, []int{1, 2}, result.Applied)
+	require.Empty(t, result.Skipped)
+	require.NotZero(t, result.Elapsed)
+	require.Contains(t, result.String(), "applied 2 migration(s), now at revision 2 in ")
+
+	// A failed migration still reports the migrations that were applied before it
+	_, err = conn.Exec("CREATE TABLE members (id integer)")
+	require.NoError(t, err)
+
+	result, err = MigrateResult(conn, -1)
+	require.Error(t, err)
+	require.Equal(t, 2, result.From)
+	require.Equal(t, 3, result.To)
+	require.Equal(t, []int{3}, result.Applied)
+	require.Equal(t, []int{1, 2}, result.Skipped)
+
+	_, err = conn.Exec("DROP TABLE members")
+	require.NoError(t, err)
+
+	result, err = MigrateResult(conn, -1)
+	require.NoError(t, err)
+	require.Equal(t, []int{4}, result.Applied)
+	require.Equal(t, 4, result.To)
+
+	result, err = MigrateResult(conn, -1)
+	require.NoError(t, err)
+	require.Empty(t, result.Applied)
+	require.Equal(t, 4, result.From)
+	require.Equal(t, 4, result.To)
+	require.Equal(t, "nothing to migrate, database is at revision 4", result.String())
+
+	// Rolling back reports the revisions in the order they were rolled back
+	result, err = RollbackResult(conn, 1)
+	require.NoError(t, err)
+	require.Equal(t, 4, result.From)
+	require.Equal(t, 1, result.To)
+	require.Equal(t, []int{4, 3, 2}, result.RolledBack)
+	require.Empty(t, result.Skipped)
+	require.Contains(t, result.String(), "rolled back 3 migration(s), now at revision 1 in ")
+
+	result, err = RollbackResult(conn, 0)
+	require.NoError(t, err)
+	require.Equal(t, []int{1}, result.RolledBack)
+	require.Equal(t, []int{4, 3, 2}, result.Skipped)
+	require.Equal(t, 0, result.To)
+}
+
+// Returns only the revisions that are true.
+func trueRevisions(active map[int]bool) map[int]bool {
+	revisions := make(map[int]bool)
+	for revision, ok := range active {
+		if ok {
+			revisions[revision] = true
+		}
+	}
+	return revisions
+}
+
+func TestMigrateOutOfOrder(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	registerTestMigration(t, "0003_create_roles.sql", "CREATE TABLE roles (id integer);", "DROP TABLE roles;")
+	registerTestMigration(t, "0004_create_perms.sql", "CREATE TABLE perms (id integer);", "DROP TABLE perms;")
+
+	// Revisions 1, 2, and 4 were applied before revision 3 was merged
+	require.NoError(t, Migrate(conn, 1))
+	_, err := conn.Exec("UPDATE migrations SET active=true WHERE revision=4")
+	require.NoError(t, err)
+
+	revisions, err := OutOfOrder(conn)
+	require.NoError(t, err)
+	require.Equal(t, []int{2, 3}, revisions)
+
+	// Migrating up to a revision before the stragglers is still allowed
+	require.NoError(t, Migrate(conn, 1))
+
+	err = Migrate(conn, 2)
+	require.True(t, errors.Is(err, ErrOutOfOrder))
+	require.EqualError(t, err, "migrations are out of order: revision(s) 2 are pending but revision 4 has already been applied")
+
+	var oerr *OutOfOrderError
+	require.True(t, errors.As(Migrate(conn, -1), &oerr))
+	require.Equal(t, []int{2, 3}, oerr.Revisions)
+	require.Equal(t, 4, oerr.Latest)
+
+	require.NoError(t, Migrate(conn, -1, MigrateOptions{OutOfOrder: true}))
+	active, err := readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: true, 2: true, 3: true, 4: true}, active)
+
+	revisions, err = OutOfOrder(conn)
+	require.NoError(t, err)
+	require.Empty(t, revisions)
+}
+
+func TestSetClock(t *testing.T) {
+	defer Reset()
+	defer SetClock(nil)
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	created := time.Date(2021, 6, 1, 12, 0, 0, 0, time.FixedZone("EDT", -4*60*60))
+	SetClock(func() time.Time { return created })
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	require.NoError(t, Migrate(conn, 0))
+
+	applied := created.Add(time.Hour)
+	SetClock(func() time.Time { return applied })
+	require.NoError(t, Migrate(conn, 1))
+
+	status, err := Status(conn)
+	require.NoError(t, err)
+	require.Equal(t, created.UTC(), status[0].Created.UTC())
+	require.Equal(t, applied.UTC(), status[0].Applied.UTC())
+	require.Equal(t, created.UTC(), status[1].Created.UTC())
+	require.True(t, status[1].Applied.IsZero())
+
+	// A nil clock resets the clock to the system clock
+	SetClock(nil)
+	require.NoError(t, Migrate(conn, -1))
+	status, err = Status(conn)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now(), status[1].Applied, time.Minute)
+}
+
+func TestMigrateN(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	registerTestMigration(t, "0003_create_roles.sql", "CREATE TABLE roles (id integer);", "DROP TABLE roles;")
+
+	current := func() int {
+		revision, err := CurrentRevision(conn)
+		require.NoError(t, err)
+		return revision
+	}
+
+	require.NoError(t, MigrateN(conn, 0))
+	require.Equal(t, 0, current())
+	require.NoError(t, MigrateN(conn, 1))
+	require.Equal(t, 1, current())
+	require.NoError(t, MigrateN(conn, 2))
+	require.Equal(t, 3, current())
+	require.EqualError(t, MigrateN(conn, 1), "cannot migrate 1 revision(s) past revision 3, only 0 pending")
+
+	require.NoError(t, MigrateN(conn, -1))
+	require.Equal(t, 2, current())
+	require.EqualError(t, MigrateN(conn, -3), "cannot roll back 3 revision(s) from revision 2, only 2 applied")
+	require.NoError(t, MigrateN(conn, -2))
+	require.Equal(t, 0, current())
+
+	revision, err := StepRevision(conn, 2)
+	require.NoError(t, err)
+	require.Equal(t, 2, revision)
+
+	// Revisions that failed to apply are not counted as applied steps
+	registerTestMigration(t, "0004_bad_sql.sql", "CREATE TABLEZ foo;", "")
+	registerTestMigration(t, "0005_create_teams.sql", "CREATE TABLE teams (id integer);", "DROP TABLE teams;")
+	registerTestMigration(t, "0006_create_orgs.sql", "CREATE TABLE orgs (id integer);", "DROP TABLE orgs;")
+	require.Error(t, Migrate(conn, -1, MigrateOptions{ContinueOnError: true}))
+
+	active, err := readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: true, 2: true, 3: true, 4: false, 5: true, 6: true}, active)
+
+	revision, err = StepRevision(conn, 1)
+	require.NoError(t, err)
+	require.Equal(t, 4, revision)
+
+	require.NoError(t, MigrateN(conn, -3))
+	active, err = readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: true, 2: true, 3: false, 4: false, 5: false, 6: false}, active)
+}
+
+func TestMigrateToName(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_add_users_email_index.sql", "CREATE TABLE email (id integer);", "DROP TABLE email;")
+	registerTestMigration(t, "0003_Create-Roles.sql", "CREATE TABLE roles (id integer);", "DROP TABLE roles;")
+	registerTestMigration(t, "0004_create_roles.sql", "CREATE TABLE roles2 (id integer);", "DROP TABLE roles2;")
+
+	for _, name := range []string{"add_users_email_index", "Add Users Email Index", "add-users-email_index", " add users  email index "} {
+		revision, err := Lookup(name)
+		require.NoError(t, err, "expected %q to match", name)
+		require.Equal(t, 2, revision)
+	}
+
+	_, err := Lookup("create_groups")
+	require.EqualError(t, err, `no migration named "create_groups" has been registered`)
+
+	_, err = Lookup("create roles")
+	require.EqualError(t, err, `migration name "create roles" is ambiguous, it matches revisions 3, 4`)
+	require.Error(t, MigrateToName(conn, "create roles"))
+
+	require.NoError(t, MigrateToName(conn, "ADD_USERS_EMAIL_INDEX"))
+	active, err := readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: true, 2: true, 3: false, 4: false}, active)
+
+	require.NoError(t, RollbackToName(conn, "create users"))
+	active, err = readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: true, 2: false, 3: false, 4: false}, active)
+}
+
+func TestStatus(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	require.NoError(t, Migrate(conn, 1))
+
+	registerTestMigration(t, "0003_create_roles.sql", "CREATE TABLE roles (id integer);", "DROP TABLE roles;")
+	status, err := Status(conn)
+	require.NoError(t, err)
+	require.Len(t, status, 3)
+
+	require.True(t, status[0].Active)
+	require.True(t, status[0].Synchronized())
+	require.False(t, status[0].Applied.IsZero())
+	require.False(t, status[0].Created.IsZero())
+	require.True(t, status[0].Elapsed > 0)
+
+	require.False(t, status[1].Active)
+	require.True(t, status[1].Synchronized())
+	require.True(t, status[1].Applied.IsZero())
+	require.Zero(t, status[1].Elapsed)
+
+	require.False(t, status[2].Active)
+	require.False(t, status[2].Synchronized())
+	require.True(t, status[2].Created.IsZero())
+
+	// Revisions in the database that are not registered should return an error
+	_, err = conn.Exec("INSERT INTO migrations (revision, name) VALUES (12, 'foo'), (10, 'bar')")
+	require.NoError(t, err)
+	status, err = Status(conn)
+	require.EqualError(t, err, "migrations table contains 2 unregistered revision(s): 10, 12")
+	require.Len(t, status, 3)
+}
+
+func TestStatusWindow(t *testing.T) {
+	defer Reset()
+	defer SetClock(nil)
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	release := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	registerTestMigration(t, "0003_create_roles.sql", "CREATE TABLE roles (id integer);", "DROP TABLE roles;")
+	registerTestMigration(t, "0004_create_teams.sql", "CREATE TABLE teams (id integer);", "DROP TABLE teams;")
+
+	for revision := 1; revision <= 3; revision++ {
+		applied := release.Add(time.Duration(revision-2) * 24 * time.Hour)
+		SetClock(func() time.Time { return applied })
+		require.NoError(t, Migrate(conn, revision))
+	}
+
+	revisions := func(status []Migration) []int {
+		out := make([]int, 0, len(status))
+		for _, m := range status {
+			out = append(out, m.Revision)
+		}
+		return out
+	}
+
+	// Without a window every registered migration is returned
+	status, err := Status(conn, StatusOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2, 3, 4}, revisions(status))
+
+	// Both ends of the window are inclusive and unapplied migrations are excluded
+	status, err = Status(conn, StatusOptions{Since: release})
+	require.NoError(t, err)
+	require.Equal(t, []int{2, 3}, revisions(status))
+
+	status, err = Status(conn, StatusOptions{Until: release})
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2}, revisions(status))
+
+	status, err = Status(conn, StatusOptions{Since: release.Add(-time.Hour), Until: release.Add(time.Hour)})
+	require.NoError(t, err)
+	require.Equal(t, []int{2}, revisions(status))
+
+	status, err = Status(conn, StatusOptions{Since: release.Add(72 * time.Hour)})
+	require.NoError(t, err)
+	require.Empty(t, status)
+}
+
+func TestCreated(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	// Applying a migration directly inserts its row in the migrations table
+	m := registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	require.NoError(t, m.Up(conn))
+
+	status, err := Status(conn)
+	require.NoError(t, err)
+	require.True(t, status[0].Active)
+	require.False(t, status[0].Created.IsZero())
+	require.Equal(t, status[0].Created, status[0].Applied)
+	created := status[0].Created
+
+	// Reapplying the migration after a rollback does not modify when it was created
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	require.NoError(t, Rollback(conn, 0))
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, Migrate(conn, -1))
+
+	status, err = Status(conn)
+	require.NoError(t, err)
+	require.Equal(t, created, status[0].Created)
+	require.True(t, status[0].Applied.After(created))
+	require.False(t, status[1].Created.IsZero())
+}
+
+func TestCurrentRevision(t *testing.T) {
+	defer Reset()
+	conn, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+	SetDialect(SQLite)
+
+	_, err = CurrentRevision(conn)
+	require.True(t, errors.Is(err, ErrUninitialized))
+	require.EqualError(t, err, `migrations table does not exist: "migrations" has not been created`)
+
+	bootstrapTestDB(t, conn)
+	rev, err := CurrentRevision(conn)
+	require.NoError(t, err)
+	require.Equal(t, 0, rev)
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	registerTestMigration(t, "0003_create_roles.sql", "CREATE TABLE roles (id integer);", "DROP TABLE roles;")
+	require.NoError(t, Migrate(conn, 2))
+
+	rev, err = CurrentRevision(conn)
+	require.NoError(t, err)
+	require.Equal(t, 2, rev)
+}
+
+func TestRollback(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	registerTestMigration(t, "0003_create_roles.sql", "CREATE TABLE roles (id integer);", "DROP TABLE roles;")
+	require.NoError(t, Migrate(conn, -1))
+
+	// Rollback to revision 1
+	require.NoError(t, Rollback(conn, 1))
+	active, err := readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: true, 2: false, 3: false}, active)
+
+	// Rolling back everything should leave the migrations table intact
+	require.NoError(t, Rollback(conn, -1))
+	active, err = readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: false, 2: false, 3: false}, active)
+
+	// A failing rollback should report the revision that failed
+	require.NoError(t, Migrate(conn, -1))
+	_, err = conn.Exec("DROP TABLE groups")
+	require.NoError(t, err)
+	err = Rollback(conn, 0)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "revision 2")
+}
+
+func TestDown(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	// The status queries must reference exactly as many placeholders as arguments
+	require.Equal(t, []string{"$1", "$2", "$3", "$4", "$5", "$6", "$7"}, placere.FindAllString(upStatusSQL, -1))
+	require.Equal(t, []string{"$1", "$2", "$3", "$4", "$5", "$6", "$7", "$8"}, placere.FindAllString(insertStatusSQL, -1))
+	require.Equal(t, []string{"$1", "$2", "$3"}, placere.FindAllString(downStatusSQL, -1))
+	require.Equal(t, []string{"$1", "$2"}, placere.FindAllString(dirtySQL, -1))
+
+	m := registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	require.NoError(t, Migrate(conn, -1))
+
+	_, err := conn.Exec("INSERT INTO users (id) VALUES (1)")
+	require.NoError(t, err)
+
+	require.NoError(t, m.Down(conn))
+
+	var (
+		active  bool
+		applied sql.NullTime
+	)
+	require.NoError(t, conn.QueryRow("SELECT active, applied FROM migrations WHERE revision=1").Scan(&active, &applied))
+	require.False(t, active)
+	require.False(t, applied.Valid)
+
+	// The users table should no longer exist
+	_, err = conn.Exec("INSERT INTO users (id) VALUES (2)")
+	require.Error(t, err)
+}
+
+func TestDownSuccessors(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	roles := registerTestMigration(t, "0002_create_roles.sql", "CREATE TABLE roles (id integer);", "DROP TABLE roles;")
+	groups := registerTestMigration(t, "0003_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	require.NoError(t, Migrate(conn, -1))
+
+	// A revision cannot be rolled back while later revisions are still active
+	err := roles.Down(conn)
+	require.True(t, errors.Is(err, ErrActiveSuccessors))
+	require.EqualError(t, err, "cannot roll back revision 2: later revision(s) 3 are still active, roll back to revision 1 instead")
+
+	var target *ActiveSuccessorsError
+	require.True(t, errors.As(err, &target))
+	require.Equal(t, 2, target.Revision)
+	require.Equal(t, []int{3}, target.Successors)
+
+	active, err := readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: true, 2: true, 3: true}, active)
+
+	// The last active revision can be rolled back, after which its predecessor can be
+	require.NoError(t, groups.Down(conn))
+	require.NoError(t, roles.Down(conn))
+
+	// Force allows a revision to be rolled back out from under its successors
+	require.NoError(t, Migrate(conn, -1))
+	require.NoError(t, roles.Down(conn, RollbackOptions{Force: true}))
+
+	active, err = readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: true, 2: false, 3: true}, active)
+}
+
+func TestIrreversible(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	users := registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	cleanup := registerTestMigration(t, "0002_cleanup_users.sql", "DELETE FROM users;", "-- the deleted users cannot be restored")
+	noop := registerTestMigration(t, "0003_noop.sql", "-- nothing to apply", "-- nothing to rollback")
+	require.False(t, users.Irreversible())
+	require.False(t, users.Empty())
+	require.True(t, cleanup.Irreversible())
+	require.False(t, cleanup.Empty())
+	require.True(t, noop.Irreversible())
+	require.True(t, noop.Empty())
+
+	// An empty up migration is marked active without executing anything
+	require.NoError(t, Migrate(conn, -1))
+	active, err := readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: true, 2: true, 3: true}, active)
+
+	// Irreversible migrations cannot be rolled back without force
+	err = noop.Down(conn)
+	require.True(t, errors.Is(err, ErrIrreversible))
+
+	err = Rollback(conn, 0)
+	require.True(t, errors.Is(err, ErrIrreversible))
+	require.Contains(t, err.Error(), "revision 3")
+
+	active, err = readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: true, 2: true, 3: true}, active)
+
+	// Forcing the rollback marks the irreversible migrations as rolled back
+	require.NoError(t, Rollback(conn, 0, RollbackOptions{Force: true}))
+	active, err = readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: false, 2: false, 3: false}, active)
+}
+
+func TestNoTransaction(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	src := "-- migrate: up notransaction\nCREATE TABLE users (id integer);\nCREATE INDEX users_id ON users (id);\n-- migrate: down\nDROP TABLE users;\n"
+	descriptor, err := NewDescriptor(strings.NewReader(src), "0001_create_users.sql")
+	require.NoError(t, err)
+	require.NoError(t, RegisterDescriptor(descriptor))
+
+	m := registered()[0]
+	require.False(t, m.Transactional())
+	require.False(t, m.transactional("up"))
+	require.True(t, m.transactional("down"))
+
+	require.NoError(t, Migrate(conn, -1))
+	active, err := readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.True(t, active[1])
+
+	require.NoError(t, Rollback(conn, 0))
+	active, err = readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.False(t, active[1])
+
+	// Default migrations are transactional
+	m = registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	require.True(t, m.Transactional())
+}
+
+func TestConcurrently(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer, email text);", "DROP TABLE users;")
+	src := "-- migrate: up concurrently\nCREATE INDEX users_email ON users (email);\nCREATE INDEX users_id ON users (id);\n-- migrate: down concurrently\nDROP INDEX users_email;\nDROP INDEX users_id;\n"
+	descriptor, err := NewDescriptor(strings.NewReader(src), "0002_users_email_index.sql")
+	require.NoError(t, err)
+	require.NoError(t, RegisterDescriptor(descriptor))
+
+	m := registered()[1]
+	require.True(t, m.Concurrent())
+	require.False(t, m.Transactional())
+	require.False(t, m.transactional("up"))
+	require.False(t, m.transactional("down"))
+
+	require.NoError(t, Migrate(conn, 1))
+
+	// SQLite does not build indexes concurrently, so the statements are only executed
+	// outside of a transaction: the only transaction updates the migrations table
+	ctx := context.Background()
+	exec := &txRecorder{DB: conn}
+	require.NoError(t, m.up(ctx, exec, &sql.TxOptions{Isolation: sql.LevelSerializable}))
+	require.Equal(t, []*sql.TxOptions{nil}, exec.opts)
+
+	active, err := readActive(ctx, conn)
+	require.NoError(t, err)
+	require.True(t, active[2])
+
+	exec.opts = nil
+	require.NoError(t, m.down(ctx, exec, nil, false))
+	require.Len(t, exec.opts, 1)
+
+	// Concurrent migrations cannot be applied atomically
+	err = Migrate(conn, -1, MigrateOptions{Atomic: true})
+	require.EqualError(t, err, "cannot migrate atomically: revision 2 cannot be executed in a transaction")
+}
+
+func TestConcurrentIndexes(t *testing.T) {
+	stmts := []string{
+		"CREATE TABLE users (id integer, email text);",
+		"CREATE INDEX CONCURRENTLY users_id ON users (id);",
+		"-- index the email addresses\ncreate unique index concurrently if not exists users_email on users (email);",
+		"CREATE INDEX CONCURRENTLY \"Users Name\" ON users (name);",
+		"REINDEX INDEX CONCURRENTLY public.users_id;",
+		"CREATE INDEX users_created ON users (created);",
+		"DROP INDEX CONCURRENTLY users_id;",
+	}
+	require.Equal(t, []string{"users_id", "users_email", "\"Users Name\"", "public.users_id"}, concurrentIndexes(stmts))
+
+	// Other dialects do not check the indexes, even if the migration is concurrent
+	m, err := Open("testdata/concurrent/0001_users_email_index.sql")
+	require.NoError(t, err)
+	require.True(t, m.Concurrent())
+	require.Equal(t, []string{"concurrently"}, m.Options("down"))
+
+	SetDialect(SQLite)
+	defer SetDialect(Postgres)
+	require.NoError(t, m.checkIndexes(context.Background(), nil, "up", stmts))
+}
+
+func TestUpDownContext(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	m := registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	_, err := initialize(context.Background(), conn, registered())
+	require.NoError(t, err)
+
+	// A cancelled context should prevent the migration from being applied
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Error(t, m.UpContext(ctx, conn))
+	active, err := readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.False(t, active[1])
+
+	require.NoError(t, m.UpContext(context.Background(), conn))
+	active, err = readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.True(t, active[1])
+
+	require.Error(t, m.DownContext(ctx, conn))
+	require.NoError(t, m.DownContext(context.Background(), conn))
+	active, err = readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.False(t, active[1])
+}
+
+func TestTxOptions(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+
+	// The transaction options should be used to begin every migration transaction
+	ctx := context.Background()
+	txopts := &sql.TxOptions{Isolation: sql.LevelSerializable}
+	exec := &txRecorder{DB: conn}
+	require.NoError(t, migrate(ctx, exec, registered(), nil, -1, MigrateOptions{TxOptions: txopts}, &Result{}))
+	require.Equal(t, []*sql.TxOptions{txopts, txopts}, exec.opts)
+
+	exec.opts = nil
+	txopts = &sql.TxOptions{Isolation: sql.LevelReadCommitted}
+	m := registered()[1]
+	require.NoError(t, m.down(ctx, exec, txopts, false))
+	require.Equal(t, []*sql.TxOptions{txopts}, exec.opts)
+
+	// Without options the default transaction options are used
+	exec.opts = nil
+	require.NoError(t, m.up(ctx, exec, nil))
+	require.Equal(t, []*sql.TxOptions{nil}, exec.opts)
+}
+
+// Records the options used to begin transactions.
+type txRecorder struct {
+	*sql.DB
+	opts []*sql.TxOptions
+}
+
+func (r *txRecorder) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	r.opts = append(r.opts, opts)
+	return r.DB.BeginTx(ctx, opts)
+}
+
+// Opens an in-memory sqlite3 database with the migrations table already created.
+func openTestDB(t *testing.T) *sql.DB {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+
+	// Each connection to :memory: is a new database so limit the pool to one
+	conn.SetMaxOpenConns(1)
+	SetDialect(SQLite)
+
+	bootstrapTestDB(t, conn)
+	return conn
+}
+
+// Creates the migrations table with the SQLite bootstrap migration.
+func bootstrapTestDB(t *testing.T, conn *sql.DB) {
+	bootstrap := BootstrapMigration(SQLite)
+	require.NoError(t, bootstrap.Up(conn))
+}
+
+// Creates and registers a migration from the specified up and down sql.
+func registerTestMigration(t *testing.T, filename, up, down string) Migration {
+	m := newTestMigration(t, filename, up, down)
+	require.NoError(t, RegisterDescriptor(m.descriptor))
+	return m
+}
+
+// Creates a migration that is not registered from the specified up and down sql.
+func newTestMigration(t *testing.T, filename, up, down string) Migration {
+	src := "-- migrate: up\n" + up + "\n-- migrate: down\n" + down + "\n"
+	descriptor, err := NewDescriptor(strings.NewReader(src), filename)
+	require.NoError(t, err)
+
+	m := Migration{descriptor: descriptor}
+	m.Name, m.Revision, err = parseFilename(filename)
+	require.NoError(t, err)
+	return m
+}
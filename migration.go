@@ -2,10 +2,17 @@ package tidal
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
+	"math"
 	"os"
+	pathpkg "path"
 	"path/filepath"
 	"regexp"
 	"sort"
@@ -15,34 +22,239 @@ import (
 	"time"
 )
 
-// Used to parse a migration filename's components
-var fnamere = regexp.MustCompile(`^(\d+)[_-]([\w\d_-]+)\.sql$`)
+// executor is implemented by both *sql.DB and *sql.Conn so that migrations can either be
+// executed using the connection pool or on a single dedicated connection.
+type executor interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Queries to update the migrations status table when a migration is applied or rolled
+// back. If a migration is applied before it has a row in the migrations table (e.g. by
+// calling Up directly rather than Migrate) the row is inserted with its created time.
+const (
+	upStatusSQL     = "UPDATE {table} SET active=$1, applied=$2, checksum=$3, elapsed=$4, dirty=$5, versioned=$6 WHERE revision=$7"
+	insertStatusSQL = "INSERT INTO {table} (revision, name, active, applied, created, checksum, elapsed, versioned) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)"
+	createdSQL      = "INSERT INTO {table} (revision, name, created) VALUES ($1, $2, $3)"
+	downStatusSQL   = "UPDATE {table} SET active=$1, applied=NULL, elapsed=NULL, dirty=$2 WHERE revision=$3"
+	dirtySQL        = "UPDATE {table} SET dirty=$1 WHERE revision=$2"
+)
+
+// Used to parse a migration filename's components, split-file migrations that define
+// the up and down SQL in separate files also specify the direction, e.g. .up.sql.
+var fnamere = regexp.MustCompile(`^(\d+)[_-]([\w\d_-]+)(?:\.(up|down))?\.sql$`)
+
+// Used to parse the name of a repeatable migration filename, e.g. R_update_views.sql or
+// R__update_views.sql as named by Flyway, see Migration.Repeatable.
+var repeatre = regexp.MustCompile(`^R__?([\w\d_-]+)\.sql$`)
 
 // Open a migration SQL file and parse it into a Migration object.
+//
+// Migrations may also be split into two files that separately define the up and down
+// SQL, e.g. 0001_create_users.up.sql and 0001_create_users.down.sql. Opening either
+// half of a split-file migration opens both files and returns a single Migration; if
+// only one half exists then the other direction is treated as empty (e.g. a migration
+// without a down file is irreversible). Split files should not contain migrate
+// directives since the direction is specified by the filename.
 func Open(path string) (m Migration, err error) {
-	filename := filepath.Base(path)
-	if !fnamere.MatchString(filename) {
-		return m, fmt.Errorf("could not parse %q as a migration filename", filename)
-	}
+	return OpenFS(os.DirFS(filepath.Dir(path)), filepath.Base(path))
+}
 
-	if m.Name, m.Revision, err = parseFilename(filename); err != nil {
+// OpenFS opens a migration SQL file from the filesystem and parses it into a Migration
+// object. This allows migrations to be loaded from any fs.FS, e.g. an embed.FS using
+// a //go:embed migrations/*.sql directive, rather than from generated descriptors.
+func OpenFS(fsys fs.FS, path string) (m Migration, err error) {
+	filename := pathpkg.Base(path)
+
+	var direction string
+	if m, direction, err = newMigration(filename); err != nil {
 		return m, err
 	}
 
+	if direction != "" {
+		return openSplit(fsys, path, direction, m)
+	}
+
 	// Now read the file and compress the contents into a descriptor
-	var f *os.File
-	if f, err = os.Open(path); err != nil {
+	var f fs.File
+	if f, err = fsys.Open(path); err != nil {
 		return m, err
 	}
 	defer f.Close()
 
-	if m.descriptor, err = NewDescriptor(f, filename); err != nil {
+	var src io.Reader = f
+	if m.repeatable {
+		var data []byte
+		if data, err = ioutil.ReadAll(limitReader(f, filename)); err != nil {
+			return m, err
+		}
+		src = repeatableSource(string(data))
+	}
+
+	if m.descriptor, err = NewDescriptor(src, filename); err != nil {
+		return m, err
+	}
+
+	return m, nil
+}
+
+// OpenString parses the SQL content of a migration into a Migration object without
+// reading it from disk, e.g. to define migrations in unit tests or to generate them
+// programmatically. The filename must be a valid migration filename since the revision
+// and name of the migration are parsed from it, e.g. 0001_create_users.sql. If the
+// filename is half of a split-file migration, e.g. 0001_create_users.up.sql, the content
+// only defines the SQL of that direction and the other direction is empty.
+func OpenString(filename, content string) (m Migration, err error) {
+	filename = pathpkg.Base(filename)
+
+	var direction string
+	if m, direction, err = newMigration(filename); err != nil {
 		return m, err
 	}
 
+	if direction != "" {
+		filename = strings.TrimSuffix(filename, "."+direction+".sql") + ".sql"
+		content = "-- migrate: " + direction + "\n" + content
+	}
+
+	var src io.Reader = strings.NewReader(content)
+	if m.repeatable {
+		src = repeatableSource(content)
+	}
+
+	if m.descriptor, err = NewDescriptor(src, filename); err != nil {
+		return m, err
+	}
 	return m, nil
 }
 
+// Returns a migration with the name and revision parsed from the filename along with
+// the direction if the filename is half of a split-file migration.
+func newMigration(filename string) (m Migration, direction string, err error) {
+	if m.Name, m.repeatable = parseRepeatable(filename); m.repeatable {
+		return m, "", nil
+	}
+
+	groups := fnamere.FindStringSubmatch(filename)
+	if groups == nil {
+		return m, "", fmt.Errorf("could not parse %q as a migration filename", filename)
+	}
+
+	if m.Name, m.Revision, err = parseFilename(filename); err != nil {
+		return m, "", err
+	}
+	return m, groups[3], nil
+}
+
+// Reads both halves of a split-file migration and combines them into a descriptor with
+// up and down directives, as though the migration had been defined in a single file.
+func openSplit(fsys fs.FS, path, direction string, m Migration) (_ Migration, err error) {
+	prefix := strings.TrimSuffix(path, "."+direction+".sql")
+
+	// The requested half must exist, the other half is optional
+	halves := make(map[string][]byte, 2)
+	for _, target := range []string{"up", "down"} {
+		var data []byte
+		if data, err = readFile(fsys, prefix+"."+target+".sql"); err != nil {
+			if target != direction && errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return m, err
+		}
+		halves[target] = data
+	}
+
+	var src bytes.Buffer
+	for _, target := range []string{"up", "down"} {
+		fmt.Fprintf(&src, "-- migrate: %s\n", target)
+		src.Write(halves[target])
+		if n := len(halves[target]); n > 0 && halves[target][n-1] != '\n' {
+			src.WriteByte('\n')
+		}
+	}
+
+	if m.descriptor, err = NewDescriptor(&src, pathpkg.Base(prefix)+".sql"); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+// RegisterOptions modify the default behavior of RegisterDir and RegisterFS.
+type RegisterOptions struct {
+	// Recursive registers the migration files in every subdirectory of the directory
+	// as well, e.g. if migrations are organized into subdirectories by domain. The
+	// migrations are merged into a single set ordered by revision so revisions must be
+	// unique across all of the directories. Hidden subdirectories are skipped.
+	Recursive bool
+
+	// SkipUnreadable skips subdirectories that cannot be read, e.g. because of their
+	// permissions, rather than returning an error. The directory itself must always be
+	// readable. Only applies to recursive registration.
+	SkipUnreadable bool
+}
+
+// RegisterDir opens and registers every migration file in the specified directory in
+// revision order; files that do not match the migration filename pattern and
+// subdirectories are ignored unless the Recursive option is specified. If any of the
+// files cannot be opened or if multiple files define the same revision (e.g. 1_foo.sql
+// and 0001_bar.sql) then no migrations are registered and an error describing every
+// failure is returned.
+func RegisterDir(dir string, opts ...RegisterOptions) error {
+	return DefaultRegistry.RegisterDir(dir, opts...)
+}
+
+// RegisterDir registers every migration file in the directory with the registry, see
+// RegisterDir.
+func (r *Registry) RegisterDir(dir string, opts ...RegisterOptions) error {
+	return r.RegisterFS(os.DirFS(dir), ".", opts...)
+}
+
+// RegisterFS opens and registers every migration file in the specified directory of
+// the filesystem as described by RegisterDir. Use "." to register migrations in the
+// root of fsys.
+func RegisterFS(fsys fs.FS, dir string, opts ...RegisterOptions) (err error) {
+	return DefaultRegistry.RegisterFS(fsys, dir, opts...)
+}
+
+// RegisterFS registers every migration file in the directory of the filesystem with the
+// registry, see RegisterFS.
+func (r *Registry) RegisterFS(fsys fs.FS, dir string, opts ...RegisterOptions) (err error) {
+	var opt RegisterOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	var filenames []string
+	if filenames, err = migrationFiles(fsys, dir, opt); err != nil {
+		return err
+	}
+
+	filenames, errs := uniqueRevisions(filenames)
+	opened := make([]Migration, 0, len(filenames))
+	for _, filename := range filenames {
+		var m Migration
+		if m, err = OpenFS(fsys, pathpkg.Join(dir, filename)); err != nil {
+			errs = append(errs, fmt.Errorf("could not open %s: %s", filename, err))
+			continue
+		}
+		opened = append(opened, m)
+	}
+
+	if err = errs.ErrorOrNil(); err != nil {
+		return err
+	}
+
+	sort.Sort(ByRevision(opened))
+	for _, m := range opened {
+		if err = r.Register(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Migration defines how changes to the database are applied (up) or rolled back (down).
 // Each migration is defined by two distinct pieces of SQL code, one for up and one for
 // down, which are are parsed from a single SQL file, delimited by tidal-parseable
@@ -57,26 +269,90 @@ func Open(path string) (m Migration, err error) {
 // revision is Revision 1). The table is updated with migrate and sync commands.
 //
 // Migrations are identified by a unique revision number that specifies the sequence
-// which migrations must be applied. For now that means that migrations can only be
-// applied linearly (and not as a directed acyclic graph with multiple dependencies).
-// Future work is required to create a migration DAG structure.
+// which migrations must be applied. A migration can also declare that it depends on
+// other revisions with a requires directive, e.g. -- migrate: requires 3,5, in which
+// case those revisions are always applied before it, even if they have a higher
+// revision number (see Requires). Migrations are applied in ascending revision order
+// otherwise, so the revisions and their dependencies must form a directed acyclic graph.
 type Migration struct {
-	Revision   int        // the unique id of the migration, prefix from the migration file
-	Name       string     // the human readable name of the migration, suffix of the migration file
-	Active     bool       // if the migration has been applied and is part of the active schema
-	Applied    time.Time  // the timestamp the migration was applied
-	Created    time.Time  // the timestamp the migration was added to the database
-	descriptor Descriptor // contains the gzip compressed data to minimize compile time size
-	dbsync     bool       // if the migration has been synchronized to the database
+	Revision   int           `json:"revision"`        // the unique id of the migration, prefix from the migration file
+	Name       string        `json:"name"`            // the human readable name of the migration, suffix of the migration file
+	Active     bool          `json:"active"`          // if the migration has been applied and is part of the active schema
+	Applied    time.Time     `json:"applied"`         // the timestamp the migration was applied
+	Created    time.Time     `json:"created"`         // the timestamp the migration was added to the database
+	Elapsed    time.Duration `json:"elapsed"`         // the time it took to execute the up sql when applied
+	Dirty      bool          `json:"dirty,omitempty"` // if the migration was interrupted while being applied or rolled back
+	descriptor Descriptor    // contains the gzip compressed data to minimize compile time size
+	dbsync     bool          // if the migration has been synchronized to the database
+	repeatable bool          // if the migration is applied whenever its checksum changes
+}
+
+// MarshalJSON encodes the status of the migration, e.g. as returned by Status. The
+// applied and created timestamps are encoded as null if they are zero (e.g. if the
+// migration has not been applied) and elapsed is encoded in nanoseconds.
+func (m Migration) MarshalJSON() ([]byte, error) {
+	type migration Migration
+	return json.Marshal(struct {
+		migration
+		Applied *time.Time        `json:"applied"`
+		Created *time.Time        `json:"created"`
+		Meta    map[string]string `json:"meta,omitempty"`
+	}{
+		migration: migration(m),
+		Applied:   nullTime(m.Applied),
+		Created:   nullTime(m.Created),
+		Meta:      m.Meta(),
+	})
+}
+
+func nullTime(ts time.Time) *time.Time {
+	if ts.IsZero() {
+		return nil
+	}
+	return &ts
 }
 
 // Up applies the migration to the database. The migration creates a transaction that
 // executes the SQL UP code as well as an update to the migrations table reflecting the
 // change in state. Both of these SQL commands must be executed together without error
-// otherwise the entire transaction is rolled back.
-func (m *Migration) Up(conn *sql.DB) (err error) {
+// otherwise the entire transaction is rolled back. Only the TxOptions of the options
+// are used when applying a single migration.
+func (m *Migration) Up(conn *sql.DB, opts ...MigrateOptions) (err error) {
+	return m.UpContext(context.Background(), conn, opts...)
+}
+
+// UpContext applies the migration to the database as described by Up, using the
+// context to begin the transaction and execute the SQL so that the migration can be
+// cancelled or bounded by a deadline. If the context is cancelled before the
+// transaction is committed, the transaction is rolled back.
+//
+// If the up migration is marked notransaction or concurrently, its statements are
+// executed directly on the database and the migrations table is updated in its own
+// transaction afterward.
+func (m *Migration) UpContext(ctx context.Context, conn *sql.DB, opts ...MigrateOptions) (err error) {
+	return m.up(ctx, conn, options(opts).TxOptions)
+}
+
+func (m *Migration) up(ctx context.Context, conn executor, txopts *sql.TxOptions) (err error) {
+	defer m.log("up")(&err)
+
+	var cancel context.CancelFunc
+	if ctx, cancel, err = m.withTimeout(ctx, "up"); err != nil {
+		return err
+	}
+	defer cancel()
+
+	if err = m.markDirty(ctx, conn, true); err != nil {
+		return err
+	}
+
+	if !m.transactional("up") {
+		return m.upNoTx(ctx, conn)
+	}
+	defer m.cleanRollback(ctx, conn, &err)
+
 	var tx *sql.Tx
-	if tx, err = conn.Begin(); err != nil {
+	if tx, err = conn.BeginTx(ctx, txopts); err != nil {
 		return fmt.Errorf("could not begin transaction to apply revision %d: %s", m.Revision, err)
 	}
 
@@ -96,44 +372,176 @@ func (m *Migration) Up(conn *sql.DB) (err error) {
 	}()
 
 	// Execute up transaction
-	err = m.upTx(tx)
+	err = m.upTx(ctx, tx)
 	return err
 }
 
-func (m *Migration) upTx(tx *sql.Tx) (err error) {
-	var sql string
-	if sql, err = m.UpSQL(); err != nil {
+func (m *Migration) upTx(ctx context.Context, tx *sql.Tx) (err error) {
+	start := time.Now()
+	if err = m.exec(ctx, tx, "up"); err != nil {
+		return err
+	}
+	return m.upStatus(ctx, tx, time.Since(start))
+}
+
+// Executes the up statements in a transaction shared with other migrations.
+func (m *Migration) upAtomic(ctx context.Context, tx *sql.Tx) (err error) {
+	defer m.log("up")(&err)
+
+	var cancel context.CancelFunc
+	if ctx, cancel, err = m.withTimeout(ctx, "up"); err != nil {
+		return err
+	}
+	defer cancel()
+	return m.upTx(ctx, tx)
+}
+
+// Executes the up statements outside of a transaction, then updates the status table.
+func (m *Migration) upNoTx(ctx context.Context, conn executor) (err error) {
+	if m.option("up", "savepoint") {
+		return fmt.Errorf("revision %d up cannot use savepoints without a transaction", m.Revision)
+	}
+
+	var query string
+	if query, err = m.render("up"); err != nil {
 		return fmt.Errorf("could not parse revision %d up sql: %s", m.Revision, err)
 	}
+	stmts := splitStatements(query)
 
-	if _, err = tx.Exec(sql); err != nil {
-		return fmt.Errorf("could not exec revision %d up: %s", m.Revision, err)
+	start := time.Now()
+	for _, stmt := range stmts {
+		if _, err = conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("could not exec revision %d up: %s", m.Revision, err)
+		}
 	}
 
-	// If this is an application migration, update the migrations status table
-	if m.Revision > 0 {
-		sql := "UPDATE migrations SET active=$1, applied=$2 WHERE revision=$3"
-		if _, err = tx.Exec(sql, true, time.Now().UTC(), m.Revision); err != nil {
+	if err = m.checkIndexes(ctx, conn, "up", stmts); err != nil {
+		return err
+	}
+
+	elapsed := time.Since(start)
+	return statusTx(ctx, conn, func(ctx context.Context, tx *sql.Tx) error {
+		return m.upStatus(ctx, tx, elapsed)
+	})
+}
+
+// If this is an application migration, update the migrations status table, storing the
+// time it took to execute the up sql.
+func (m *Migration) upStatus(ctx context.Context, tx *sql.Tx, elapsed time.Duration) (err error) {
+	if m.Revision != schemaRevision {
+		var checksum string
+		if checksum, err = m.Checksum(); err != nil {
+			return fmt.Errorf("could not compute revision %d checksum: %s", m.Revision, err)
+		}
+
+		now := timestamp()
+		var result sql.Result
+		if result, err = tx.ExecContext(ctx, bind(upStatusSQL), true, now, checksum, int64(elapsed), false, m.Versioned(), m.Revision); err != nil {
 			return fmt.Errorf("could not update migration status of revision %d: %s", m.Revision, err)
 		}
+
+		// The migration is being applied for the first time so it is also created
+		if n, _ := result.RowsAffected(); n == 0 {
+			if _, err = tx.ExecContext(ctx, bind(insertStatusSQL), m.Revision, m.Name, true, now, now, checksum, int64(elapsed), m.Versioned()); err != nil {
+				return fmt.Errorf("could not insert migration status of revision %d: %s", m.Revision, err)
+			}
+		}
 	}
 
 	return nil
 }
 
 // UpSQL returns the sql statement defined for applying the migration to the specific
-// revision. This requires parsing the underlying descriptor correctly.
+// revision. This requires parsing the underlying descriptor correctly. Use Empty to
+// check if the returned sql contains any statements to execute.
 func (m *Migration) UpSQL() (string, error) {
 	return m.descriptor.Up()
 }
 
+// UpStatements returns the up sql of the migration split into individual statements.
+// Statements are split on semicolons, ignoring semicolons in comments, quoted strings,
+// and dollar quoted function bodies.
+func (m *Migration) UpStatements() ([]string, error) {
+	return m.descriptor.UpStatements()
+}
+
 // Down rolls back the migration from the database. The migration creates a transaction
 // that executes the SQL DOWN code as well as an update to the migrations table reflecting
 // the change in state. Both of these SQL commands must be executed together without
 // error, otherwise the entire transaction is rolled back.
-func (m *Migration) Down(conn *sql.DB) (err error) {
+//
+// If the migration is irreversible because it does not define any down SQL, an error
+// that matches ErrIrreversible is returned unless the Force option is specified, in
+// which case the migration is marked as rolled back without executing any SQL. If any
+// revision after the migration is still active, an ActiveSuccessorsError is returned
+// without rolling back the migration unless the Force option is specified; use Rollback
+// to roll back the migration along with every revision after it.
+func (m *Migration) Down(conn *sql.DB, opts ...RollbackOptions) (err error) {
+	return m.DownContext(context.Background(), conn, opts...)
+}
+
+// DownContext rolls back the migration from the database as described by Down, using
+// the context to begin the transaction and execute the SQL so that the rollback can be
+// cancelled or bounded by a deadline. If the context is cancelled before the
+// transaction is committed, the transaction is rolled back.
+//
+// If the down migration is marked notransaction or concurrently, its statements are
+// executed directly on the database and the migrations table is updated in its own
+// transaction afterward.
+func (m *Migration) DownContext(ctx context.Context, conn *sql.DB, opts ...RollbackOptions) (err error) {
+	opt := rollbackOptions(opts)
+	if !opt.Force {
+		if err = m.checkSuccessors(ctx, conn); err != nil {
+			return err
+		}
+	}
+	return m.down(ctx, conn, opt.TxOptions, opt.Force)
+}
+
+// Returns an ActiveSuccessorsError if any revision after the migration is still active.
+func (m *Migration) checkSuccessors(ctx context.Context, conn executor) (err error) {
+	var active map[int]bool
+	if active, err = readActive(ctx, conn); err != nil {
+		return err
+	}
+
+	var successors []int
+	for revision, ok := range active {
+		if ok && revision > m.Revision {
+			successors = append(successors, revision)
+		}
+	}
+
+	if len(successors) > 0 {
+		sort.Ints(successors)
+		return &ActiveSuccessorsError{Revision: m.Revision, Successors: successors}
+	}
+	return nil
+}
+
+func (m *Migration) down(ctx context.Context, conn executor, txopts *sql.TxOptions, force bool) (err error) {
+	defer m.log("down")(&err)
+	if !force && m.Irreversible() {
+		return fmt.Errorf("%w: revision %d does not define a down migration", ErrIrreversible, m.Revision)
+	}
+
+	var cancel context.CancelFunc
+	if ctx, cancel, err = m.withTimeout(ctx, "down"); err != nil {
+		return err
+	}
+	defer cancel()
+
+	if err = m.markDirty(ctx, conn, true); err != nil {
+		return err
+	}
+
+	if !m.transactional("down") {
+		return m.downNoTx(ctx, conn)
+	}
+	defer m.cleanRollback(ctx, conn, &err)
+
 	var tx *sql.Tx
-	if tx, err = conn.Begin(); err != nil {
+	if tx, err = conn.BeginTx(ctx, txopts); err != nil {
 		return fmt.Errorf("could not begin transaction to rollback revision %d: %s", m.Revision, err)
 	}
 
@@ -153,26 +561,112 @@ func (m *Migration) Down(conn *sql.DB) (err error) {
 	}()
 
 	// Execute down transaction
-	err = m.downTx(tx)
+	err = m.downTx(ctx, tx)
 	return err
 }
 
-func (m *Migration) downTx(tx *sql.Tx) (err error) {
-	var sql string
-	if sql, err = m.DownSQL(); err != nil {
+func (m *Migration) downTx(ctx context.Context, tx *sql.Tx) (err error) {
+	if err = m.exec(ctx, tx, "down"); err != nil {
+		return err
+	}
+	return m.downStatus(ctx, tx)
+}
+
+// Executes the statements of the specified direction in the transaction. If the
+// direction is marked with the savepoint option, each statement is executed in its own
+// savepoint; a statement that fails is rolled back to its savepoint and retried once
+// without aborting the statements that were already executed in the transaction.
+func (m *Migration) exec(ctx context.Context, tx *sql.Tx, direction string) (err error) {
+	if m.option(direction, "savepoint") {
+		return m.execSavepoints(ctx, tx, direction)
+	}
+
+	var stmts []string
+	if stmts, err = m.statements(direction); err != nil {
+		return fmt.Errorf("could not parse revision %d %s sql: %s", m.Revision, direction, err)
+	}
+
+	for _, stmt := range stmts {
+		if _, err = tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("could not exec revision %d %s: %s", m.Revision, direction, err)
+		}
+	}
+	return nil
+}
+
+// Executes each statement of the specified direction inside of a savepoint. Savepoints
+// require transactional DDL, otherwise the schema changes of a statement would be
+// committed implicitly and could not be rolled back to the savepoint.
+func (m *Migration) execSavepoints(ctx context.Context, tx *sql.Tx, direction string) (err error) {
+	if !dialect.TransactionalDDL() {
+		return fmt.Errorf("revision %d %s uses savepoints but the %s dialect does not support transactional ddl", m.Revision, direction, dialect.Name())
+	}
+
+	var query string
+	if query, err = m.render(direction); err != nil {
+		return fmt.Errorf("could not parse revision %d %s sql: %s", m.Revision, direction, err)
+	}
+
+	for i, stmt := range splitStatements(query) {
+		savepoint := fmt.Sprintf("tidal_%d_%d", m.Revision, i+1)
+		for attempt := 0; ; attempt++ {
+			if _, err = tx.ExecContext(ctx, "SAVEPOINT "+savepoint); err != nil {
+				return fmt.Errorf("could not create savepoint for revision %d %s: %s", m.Revision, direction, err)
+			}
+
+			if _, err = tx.ExecContext(ctx, stmt); err == nil {
+				break
+			}
+
+			if _, rerr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+savepoint); rerr != nil {
+				return fmt.Errorf("could not exec revision %d %s: %s (rollback to savepoint failed: %s)", m.Revision, direction, err, rerr)
+			}
+
+			if attempt > 0 {
+				return fmt.Errorf("could not exec revision %d %s statement %d: %s", m.Revision, direction, i+1, err)
+			}
+		}
+
+		if _, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT "+savepoint); err != nil {
+			return fmt.Errorf("could not release savepoint for revision %d %s: %s", m.Revision, direction, err)
+		}
+	}
+	return nil
+}
+
+// Executes the down statements outside of a transaction, then updates the status table.
+func (m *Migration) downNoTx(ctx context.Context, conn executor) (err error) {
+	if m.option("down", "savepoint") {
+		return fmt.Errorf("revision %d down cannot use savepoints without a transaction", m.Revision)
+	}
+
+	var query string
+	if query, err = m.render("down"); err != nil {
 		return fmt.Errorf("could not parse revision %d down sql: %s", m.Revision, err)
 	}
+	stmts := splitStatements(query)
 
-	if _, err = tx.Exec(sql); err != nil {
-		return fmt.Errorf("could not exec revision %d down: %s", m.Revision, err)
+	for _, stmt := range stmts {
+		if _, err = conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("could not exec revision %d down: %s", m.Revision, err)
+		}
+	}
+
+	if err = m.checkIndexes(ctx, conn, "down", stmts); err != nil {
+		return err
 	}
 
-	// If this is an application migration, update the migrations status table
+	return statusTx(ctx, conn, m.downStatus)
+}
+
+// If this is an application migration, update the migrations status table
+func (m *Migration) downStatus(ctx context.Context, tx *sql.Tx) (err error) {
 	if m.Revision > 0 {
-		sql := "UPDATE migrations SET active=$1, applied=NULL WHERE revision=$3"
-		if _, err = tx.Exec(sql, false, m.Revision); err != nil {
+		sql := bind(downStatusSQL)
+		if _, err = tx.ExecContext(ctx, sql, false, false, m.Revision); err != nil {
 			return fmt.Errorf("could not update migration status of revision %d: %s", m.Revision, err)
 		}
+		return m.downSquashed(ctx, tx)
 	}
 
 	return nil
@@ -180,10 +674,322 @@ func (m *Migration) downTx(tx *sql.Tx) (err error) {
 
 // DownSQL returns the sql statement defined for rolling back the migration to a state
 // before this specific revision. This requires parsing the underlying descriptor correctly.
+// If the migration does not define a down migration the returned sql is empty or only
+// contains comments; use Irreversible to check if there is any SQL to execute.
 func (m *Migration) DownSQL() (string, error) {
 	return m.descriptor.Down()
 }
 
+// DownStatements returns the down sql of the migration split into individual statements.
+func (m *Migration) DownStatements() ([]string, error) {
+	return m.descriptor.DownStatements()
+}
+
+// Source returns the complete SQL source of the migration, including the directive
+// comments that are omitted from UpSQL and DownSQL. The source of a split-file migration
+// combines both files, delimited by -- migrate: up and -- migrate: down directives.
+func (m *Migration) Source() (string, error) {
+	return m.descriptor.Source()
+}
+
+// Options returns the lower cased options of the up or down directive of the migration,
+// e.g. [notransaction] for -- migrate: up notransaction, or nil if there are none.
+func (m *Migration) Options(direction string) []string {
+	opts, err := m.descriptor.Options(direction)
+	if err != nil {
+		return nil
+	}
+	return opts
+}
+
+// Transactional returns false if either the up or down migration is marked with the
+// notransaction directive, e.g. -- migrate: up notransaction. These migrations contain
+// statements that cannot be executed inside of a transaction block such as
+// CREATE INDEX CONCURRENTLY and are executed directly on the database. Migrations marked
+// with the concurrently option are not transactional either, see Concurrent.
+func (m *Migration) Transactional() bool {
+	return m.transactional("up") && m.transactional("down")
+}
+
+// Repeatable returns true if the migration was parsed from a repeatable migration file,
+// e.g. R_update_views.sql, rather than from a versioned migration file with a revision.
+// Repeatable migrations are typically used for views, functions, and stored procedures
+// that are redefined in place with CREATE OR REPLACE. They do not have a revision, so
+// they are not verified, planned, or rolled back with the versioned migrations, and
+// they must not define any down SQL. Instead, Migrate applies every registered
+// repeatable migration in name order after the versioned migrations whenever its
+// checksum differs from the checksum recorded when it was last applied (or if it has
+// never been applied). Repeatable migrations are recorded in the migrations table with a
+// negative revision that is assigned when they are first applied, which is the revision
+// passed to the Logger and the hooks of MigrateOptions.
+//
+// A repeatable migration file that does not contain any migrate directives is treated
+// as the up SQL of the migration.
+func (m *Migration) Repeatable() bool {
+	return m.repeatable
+}
+
+// Versioned returns false if the migration is a data-only migration that is marked with
+// the noversion option, e.g. -- migrate: up noversion, such as a backfill of existing
+// rows that does not change the schema. Data-only migrations are applied, recorded, and
+// rolled back like any other migration so Migrate never applies them twice, but they
+// are recorded as unversioned in the migrations table so that they do not change the
+// revision reported by CurrentRevision and do not have to be applied for the database
+// to be reported as ready by the StatusHandler.
+func (m *Migration) Versioned() bool {
+	return !m.option("up", "noversion")
+}
+
+// Empty returns true if the migration does not define any up SQL statements. Applying
+// an empty migration only marks it as active in the migrations table.
+func (m *Migration) Empty() bool {
+	empty, err := m.descriptor.Empty("up")
+	return err == nil && empty
+}
+
+// Irreversible returns true if the migration does not define any down SQL statements,
+// either because the -- migrate: down directive is missing or because it only contains
+// comments. Irreversible migrations cannot be rolled back unless forced.
+func (m *Migration) Irreversible() bool {
+	empty, err := m.descriptor.Empty("down")
+	return err == nil && empty
+}
+
+// ApplySavepoint returns true if each statement of the up migration is executed in its
+// own savepoint, which is enabled with the savepoint option, e.g. -- migrate: up savepoint.
+// Savepoints are only supported by dialects with transactional DDL, e.g. Postgres and
+// SQLite, and cannot be combined with the notransaction option.
+func (m *Migration) ApplySavepoint() bool {
+	return m.option("up", "savepoint")
+}
+
+// Returns false if the specified direction is marked with the notransaction option or
+// the concurrently option, which implies notransaction.
+func (m *Migration) transactional(direction string) bool {
+	return !m.option(direction, "notransaction") && !m.option(direction, "concurrently")
+}
+
+// Timeout returns the maximum amount of time that the up migration may take to execute,
+// specified with the timeout option, e.g. -- migrate: up timeout=30s, and false if the
+// up migration does not have a timeout. The down migration may specify its own timeout
+// on its directive. If the timeout expires the migration is aborted and rolled back.
+func (m *Migration) Timeout() (time.Duration, bool) {
+	timeout, ok, err := m.timeout("up")
+	return timeout, ok && err == nil
+}
+
+// Parses the timeout option of the specified direction.
+func (m *Migration) timeout(direction string) (timeout time.Duration, ok bool, err error) {
+	opts, err := m.descriptor.Options(direction)
+	if err != nil {
+		return 0, false, err
+	}
+
+	for _, opt := range opts {
+		if strings.HasPrefix(opt, "timeout=") {
+			if timeout, err = time.ParseDuration(strings.TrimPrefix(opt, "timeout=")); err != nil || timeout <= 0 {
+				return 0, false, fmt.Errorf("invalid %s timeout %q for revision %d", direction, strings.TrimPrefix(opt, "timeout="), m.Revision)
+			}
+			return timeout, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// Returns a context bounded by the timeout of the specified direction, if any.
+func (m *Migration) withTimeout(ctx context.Context, direction string) (context.Context, context.CancelFunc, error) {
+	timeout, ok, err := m.timeout(direction)
+	if err != nil {
+		return ctx, func() {}, err
+	}
+
+	if !ok {
+		return ctx, func() {}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, nil
+}
+
+// Returns true if the directive of the specified direction has the option.
+func (m *Migration) option(direction, option string) bool {
+	opts, err := m.descriptor.Options(direction)
+	if err != nil {
+		return false
+	}
+
+	for _, opt := range opts {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the sql of the specified direction to execute. If the direction is marked
+// with the template option, e.g. -- migrate: up template, the ${NAME} placeholders are
+// substituted with the variables specified by SetVariables.
+func (m *Migration) render(direction string) (sql string, err error) {
+	if direction == "up" {
+		sql, err = m.UpSQL()
+	} else {
+		sql, err = m.DownSQL()
+	}
+
+	if err != nil || !m.option(direction, "template") {
+		return sql, err
+	}
+
+	return substitute(sql)
+}
+
+// Marks the revision as dirty before its statements are executed, or as clean again. The
+// status update of a migration that succeeds marks it as clean in the same transaction,
+// so a migration that is interrupted, e.g. because the process crashed, is left dirty.
+func (m *Migration) markDirty(ctx context.Context, conn executor, dirty bool) (err error) {
+	if m.Revision != schemaRevision {
+		if _, err = conn.ExecContext(ctx, bind(dirtySQL), dirty, m.Revision); err != nil {
+			return fmt.Errorf("could not update dirty state of revision %d: %s", m.Revision, err)
+		}
+	}
+	return nil
+}
+
+// If the transaction of the migration failed and was rolled back, none of its changes
+// were applied unless the dialect implicitly commits DDL, so the revision is marked as
+// clean again. The context of the migration may have been cancelled so it is detached.
+func (m *Migration) cleanRollback(ctx context.Context, conn executor, err *error) {
+	if *err != nil && dialect.TransactionalDDL() {
+		m.markDirty(detach(ctx), conn, false)
+	}
+}
+
+// Returns a context that carries the values of the parent context, e.g. the trace of
+// the caller, but that is never cancelled and has no deadline, so that cleanup such as
+// releasing a lock is still performed after the parent context is done.
+func detach(parent context.Context) context.Context {
+	return detachedContext{parent}
+}
+
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (deadline time.Time, ok bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}                   { return nil }
+func (detachedContext) Err() error                              { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// Executes the status update function in its own transaction, used to update the
+// migrations table for migrations that are not executed in a transaction.
+func statusTx(ctx context.Context, conn executor, update func(context.Context, *sql.Tx) error) (err error) {
+	var tx *sql.Tx
+	if tx, err = conn.BeginTx(ctx, nil); err != nil {
+		return fmt.Errorf("could not begin transaction to update migration status: %s", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	err = update(ctx, tx)
+	return err
+}
+
+// Returns the statements to execute in the specified direction; the sql is only split
+// into individual statements if the dialect cannot execute multiple statements at once
+// or if it uses a separator other than a semicolon.
+// No statements are returned if the sql only contains whitespace and comments.
+func (m *Migration) statements(direction string) (_ []string, err error) {
+	var sql string
+	if sql, err = m.render(direction); err != nil {
+		return nil, err
+	}
+
+	stmts := splitStatements(sql)
+	if requiresSplit(dialect) || len(stmts) == 0 {
+		return stmts, nil
+	}
+	return []string{sql}, nil
+}
+
+// Checksum returns the hex encoded SHA-256 hash of the up and down SQL of the migration.
+// The checksum is stored in the migrations table when the migration is applied so that
+// changes to the migration after it has been applied can be detected. If the comments of
+// the migration were stripped (see Descriptor.StripComments), the checksum of the
+// original SQL that is recorded in the header of the descriptor is returned.
+func (m *Migration) Checksum() (checksum string, err error) {
+	if checksum, err = m.descriptor.originalChecksum(); err != nil || checksum != "" {
+		return checksum, err
+	}
+	return m.descriptor.checksum()
+}
+
+// Equal returns true if both migrations have the same revision and name and their
+// decompressed up and down SQL, the options of their migrate directives, and their
+// required revisions are identical, e.g. to check that a migration embedded in the
+// application matches the migration file on disk. Comments, package, and metadata
+// directives outside of the up and down SQL are ignored, as are the status fields
+// (Active, Applied, Created, Elapsed, and Dirty) that are read from the database. Use
+// Descriptor.Equal to compare the complete source of the migrations.
+func (m Migration) Equal(other Migration) bool {
+	if m.Revision != other.Revision || m.Name != other.Name {
+		return false
+	}
+
+	for _, direction := range []string{"up", "down"} {
+		sql, err := m.descriptor.readBetween(direction)
+		if err != nil {
+			return bytes.Equal(m.descriptor, other.descriptor)
+		}
+
+		osql, err := other.descriptor.readBetween(direction)
+		if err != nil || sql != osql {
+			return false
+		}
+
+		opts, _ := m.descriptor.Options(direction)
+		oopts, _ := other.descriptor.Options(direction)
+		sort.Strings(opts)
+		sort.Strings(oopts)
+		if strings.Join(opts, " ") != strings.Join(oopts, " ") {
+			return false
+		}
+	}
+	return joinInts(m.Requires()) == joinInts(other.Requires())
+}
+
+// Meta returns the metadata of the migration specified by -- tidal: key=value directives,
+// e.g. -- tidal: author=jsmith, or an empty map if the migration has no metadata.
+func (m *Migration) Meta() map[string]string {
+	meta, err := m.descriptor.Meta()
+	if err != nil {
+		return make(map[string]string)
+	}
+	return meta
+}
+
+// Requires returns the revisions that must be applied before the migration, specified
+// by -- migrate: requires directives, or nil if the migration has no dependencies other
+// than the revisions before it. Invalid requires directives are reported by Verify.
+func (m *Migration) Requires() []int {
+	revisions, err := m.descriptor.Requires()
+	if err != nil {
+		return nil
+	}
+	return revisions
+}
+
 // Package returns the parsed package directive from the descriptor if it has one.
 func (m *Migration) Package() (string, error) {
 	return m.descriptor.Package()
@@ -194,10 +1000,12 @@ func (m *Migration) Synchronized() bool {
 	return m.dbsync
 }
 
-// Predecessors returns the number of migrations before this migration.
+// Predecessors returns the number of migrations registered with the default registry
+// before this migration.
 func (m *Migration) Predecessors() (n int, err error) {
+	migrations := registered()
 	if len(migrations) == 0 {
-		return 0, fmt.Errorf("revision %d was not registered", m.Revision)
+		return 0, &NotRegisteredError{Revision: m.Revision}
 	}
 
 	for _, o := range migrations {
@@ -205,19 +1013,21 @@ func (m *Migration) Predecessors() (n int, err error) {
 			break
 		}
 		if o.Revision > m.Revision {
-			return 0, fmt.Errorf("revision %d was not registered", m.Revision)
+			return 0, &NotRegisteredError{Revision: m.Revision}
 		}
 		n++
 	}
 
 	if n == len(migrations) && migrations[n-1].Revision != m.Revision {
-		return 0, fmt.Errorf("revision %d was not registered", m.Revision)
+		return 0, &NotRegisteredError{Revision: m.Revision}
 	}
 	return n, nil
 }
 
-// Successors returns the number of migrations after this migration.
+// Successors returns the number of migrations registered with the default registry
+// after this migration.
 func (m *Migration) Successors() (n int, err error) {
+	migrations := registered()
 	i := sort.Search(len(migrations), func(i int) bool {
 		return m.Revision <= migrations[i].Revision
 	})
@@ -228,11 +1038,14 @@ func (m *Migration) Successors() (n int, err error) {
 		}
 		return len(migrations[i+1:]), nil
 	}
-	return 0, fmt.Errorf("revision %d was not registered", m.Revision)
+	return 0, &NotRegisteredError{Revision: m.Revision}
 }
 
-const sqldata = `-- Revision {{ .Revision }} generated on {{ .Timestamp }}{{ if .PackageName }}
--- package: {{ .PackageName }}{{ end }}
+// DefaultCreateTemplate is the text/template used by Create to scaffold new migration
+// files unless a template is specified with CreateOptions, see CreateData for the data
+// that is available to templates.
+const DefaultCreateTemplate = `-- Revision {{ .Revision }} generated on {{ .Timestamp }}{{ if .Package }}
+-- package: {{ .Package }}{{ end }}
 -- migrate: up
 -- insert up migration sql here
 
@@ -242,13 +1055,24 @@ const sqldata = `-- Revision {{ .Revision }} generated on {{ .Timestamp }}{{ if
 -- migrate: end
 `
 
-var sqldataTemplate = template.Must(template.New("").Parse(sqldata))
+var sqldataTemplate = template.Must(template.New("").Parse(DefaultCreateTemplate))
+
+// CreateData is the data that the template of a new migration file is executed with.
+type CreateData struct {
+	Revision  int    // the revision of the new migration
+	Name      string // the human readable name of the migration, e.g. add users
+	Date      string // the date the migration was created, e.g. 2021-06-01
+	Timestamp string // the time the migration was created, e.g. 2021-06-01 15:04:05 -0700
+	Package   string // the package of the generated code, if specified
+}
 
-// sqldataContext is used to populate the sqldata template
-type sqldataContext struct {
-	Revision    int
-	Timestamp   string
-	PackageName string
+// CreateOptions modify the default behavior of Create.
+type CreateOptions struct {
+	// Template is the source of a text/template that is executed with CreateData to
+	// produce the contents of the new migration file, e.g. to add a license header or a
+	// standard comment block (DefaultCreateTemplate if empty). The template must
+	// produce a -- migrate: up directive.
+	Template string
 }
 
 // Create a new SQL migration file for code generation. The migration file is an ANSI
@@ -257,74 +1081,238 @@ type sqldataContext struct {
 // base using compressed Descriptors, which are registered as migrations at runtime.
 // This helper utility adds the next migration sql file revision (based on the latest
 // registered revision and the maximum revision number from sibling files) and writes
-// out an empty template to the migrations directory.
-func Create(migrationsDirectory, name, packageName string) (err error) {
+// out an empty migration to the migrations directory, scaffolded from the Template
+// option or from DefaultCreateTemplate. The path of the created file is returned;
+// Create will not overwrite an existing file.
+func Create(migrationsDirectory, name, packageName string, opts ...CreateOptions) (outpath string, err error) {
+	tmpl := sqldataTemplate
+	if len(opts) > 0 && opts[0].Template != "" {
+		if tmpl, err = template.New("").Parse(opts[0].Template); err != nil {
+			return "", fmt.Errorf("could not parse migration template: %s", err)
+		}
+	}
+
 	var latestRevision int
-	if len(migrations) > 0 {
-		latestRevision = migrations[len(migrations)-1].Revision
+	if registered := registered(); len(registered) > 0 {
+		latestRevision = registered[len(registered)-1].Revision
 	}
 
 	var listing []os.FileInfo
 	if listing, err = ioutil.ReadDir(migrationsDirectory); err != nil {
-		return err
+		return "", err
 	}
 
 	for _, finfo := range listing {
 		filename := finfo.Name()
-		if !strings.HasSuffix(filename, ".sql") {
+		if !fnamere.MatchString(filename) {
 			continue
 		}
 
 		_, revision, err := parseFilename(filename)
 		if err != nil {
-			return err
+			return "", err
 		}
 		if revision > latestRevision {
 			latestRevision = revision
 		}
 	}
 
+	// Determine the write path
+	now := nowFunc().Local()
+	if name == "" {
+		name = fmt.Sprintf("auto_%s", now.Format("200601021504"))
+	}
+	name = strings.Replace(strings.TrimSpace(name), " ", "_", -1)
+	outpath = filepath.Join(migrationsDirectory, fmt.Sprintf("%04d_%s.sql", latestRevision+1, name))
+
+	if !fnamere.MatchString(filepath.Base(outpath)) {
+		return "", fmt.Errorf("%q is not a valid migration name", name)
+	}
+
 	// Create the template context
-	now := time.Now().Local()
-	ctx := &sqldataContext{
-		Revision:    latestRevision + 1,
-		Timestamp:   now.Format("2006-01-02 15:04:05 -0700"),
-		PackageName: packageName,
+	ctx := &CreateData{
+		Revision:  latestRevision + 1,
+		Name:      strings.Replace(name, "_", " ", -1),
+		Date:      now.Format("2006-01-02"),
+		Timestamp: now.Format("2006-01-02 15:04:05 -0700"),
+		Package:   packageName,
 	}
 
 	// Execute the template
 	builder := &bytes.Buffer{}
-	if err = sqldataTemplate.Execute(builder, ctx); err != nil {
-		return err
+	if err = tmpl.Execute(builder, ctx); err != nil {
+		return "", fmt.Errorf("could not execute migration template: %s", err)
 	}
 
-	// Determine the write path
-	if name == "" {
-		name = fmt.Sprintf("auto_%s", now.Format("200601021504"))
+	// Ensure that the scaffolded migration can be parsed
+	var up bool
+	for _, line := range strings.Split(builder.String(), "\n") {
+		if groups := migre.FindStringSubmatch(line); groups != nil && strings.ToLower(groups[1]) == "up" {
+			up = true
+			break
+		}
+	}
+
+	if !up {
+		return "", errors.New("migration template does not produce a -- migrate: up directive")
 	}
-	name = strings.Replace(name, " ", "_", -1)
-	outpath := filepath.Join(migrationsDirectory, fmt.Sprintf("%04d_%s.sql", latestRevision+1, name))
 
-	// Create the generated migration template file
+	// Create the generated migration template file, refusing to overwrite a file
 	var f *os.File
-	if f, err = os.Create(outpath); err != nil {
-		return err
+	if f, err = os.OpenFile(outpath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644); err != nil {
+		return "", err
 	}
 	defer f.Close()
 
 	if _, err = f.Write(builder.Bytes()); err != nil {
-		return err
+		return "", err
 	}
 
-	return nil
+	return outpath, nil
 }
 
-// helper function parse a filename or path into Migration metadata
+// helper function parse a filename or path into Migration metadata. Revision 0 is
+// reserved for the bootstrap migration that creates the migrations table and the
+// revision must fit into a 32-bit integer, the type of the revision column of the
+// migrations table (and of an int on 32-bit platforms).
 func parseFilename(filename string) (name string, revision int, err error) {
 	groups := fnamere.FindStringSubmatch(filename)
+	if groups == nil {
+		return "", 0, fmt.Errorf("could not parse %q as a migration filename", filename)
+	}
+
 	name = strings.Replace(groups[2], "_", " ", -1)
-	if revision, err = strconv.Atoi(groups[1]); err != nil {
+
+	var n int64
+	if n, err = strconv.ParseInt(groups[1], 10, 32); err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return "", 0, fmt.Errorf("revision %s of %s is too large, revisions must be at most %d", groups[1], filename, math.MaxInt32)
+		}
 		return "", 0, fmt.Errorf("could not parse %q to revision number: %s", groups[1], err)
 	}
+
+	if revision = int(n); revision == 0 {
+		return "", 0, fmt.Errorf("revision 0 of %s is reserved for the migrations table, application revisions start at 1", filename)
+	}
 	return name, revision, nil
 }
+
+// Returns the name of the repeatable migration and true if the filename is the filename
+// of a repeatable migration, e.g. R_update_views.sql.
+func parseRepeatable(filename string) (name string, ok bool) {
+	groups := repeatre.FindStringSubmatch(filename)
+	if groups == nil {
+		return "", false
+	}
+	return strings.Replace(groups[1], "_", " ", -1), true
+}
+
+// Repeatable migrations only define up SQL, so a file without any migrate directives
+// is treated as the up SQL of the migration, e.g. a file that only contains a CREATE OR
+// REPLACE VIEW statement.
+func repeatableSource(content string) io.Reader {
+	for _, line := range strings.Split(content, "\n") {
+		if migre.MatchString(line) {
+			return strings.NewReader(content)
+		}
+	}
+	return strings.NewReader("-- migrate: up\n" + content)
+}
+
+// MigrationFiles returns the paths relative to dir of the files in the directory that
+// RegisterDir would open with the same options, i.e. the files that match the migration
+// filename pattern, e.g. so that tools can check the filenames of the migrations.
+func MigrationFiles(dir string, opts ...RegisterOptions) (paths []string, err error) {
+	var opt RegisterOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	return migrationFiles(os.DirFS(dir), ".", opt)
+}
+
+// Returns the paths relative to dir of the files in the directory of the filesystem that
+// match the migration filename pattern, including the files in subdirectories if the
+// recursive option is specified (hidden subdirectories are skipped). Directories that
+// cannot be read are returned as a DirectoryError unless they are subdirectories and
+// the SkipUnreadable option is specified.
+func migrationFiles(fsys fs.FS, dir string, opt RegisterOptions) (paths []string, err error) {
+	err = fs.WalkDir(fsys, dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path != dir && opt.SkipUnreadable && (entry == nil || entry.IsDir()) {
+				return fs.SkipDir
+			}
+			return &DirectoryError{Path: path, Err: err}
+		}
+
+		if entry.IsDir() {
+			if path != dir && (!opt.Recursive || strings.HasPrefix(entry.Name(), ".")) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if fnamere.MatchString(entry.Name()) || repeatre.MatchString(entry.Name()) {
+			rel := strings.TrimPrefix(path, dir+"/")
+			if dir == "." {
+				rel = path
+			}
+			paths = append(paths, rel)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// Returns the migration filenames that should be opened, one per revision, along with
+// an error for every revision that is defined by more than one migration, e.g. both
+// 1_create_users.sql and 0001_add_users.sql are revision 1. The up and down halves of a
+// split-file migration define the same migration so only one of them is returned.
+// Files that cannot be parsed are returned since they are reported when opened.
+func uniqueRevisions(filenames []string) (unique []string, errs MultiError) {
+	seen := make(map[int]string, len(filenames))
+	split := make(map[string]bool)
+	unique = make([]string, 0, len(filenames))
+	for _, filename := range filenames {
+		if base := splitBase(filename); base != "" {
+			if split[base] {
+				continue
+			}
+			split[base] = true
+		}
+
+		_, revision, err := parseFilename(pathpkg.Base(filename))
+		if err != nil {
+			unique = append(unique, filename)
+			continue
+		}
+
+		if other, ok := seen[revision]; ok {
+			errs = append(errs, fmt.Errorf("revision %d is defined by both %s and %s", revision, other, filename))
+			continue
+		}
+
+		seen[revision] = filename
+		unique = append(unique, filename)
+	}
+	return unique, errs
+}
+
+// Reads the file from the filesystem, returning an error that matches ErrFileTooLarge
+// rather than reading the entire file if it is larger than the maximum file size.
+func readFile(fsys fs.FS, path string) (_ []byte, err error) {
+	var f fs.File
+	if f, err = fsys.Open(path); err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(limitReader(f, pathpkg.Base(path)))
+}
+
+// Returns the filename without the direction if it is half of a split-file migration.
+func splitBase(filename string) string {
+	groups := fnamere.FindStringSubmatch(pathpkg.Base(filename))
+	if groups == nil || groups[3] == "" {
+		return ""
+	}
+	return strings.TrimSuffix(filename, "."+groups[3]+".sql")
+}
@@ -1,7 +1,16 @@
 package tidal_test
 
 import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	. "github.com/rotationalio/tidal"
 	"github.com/stretchr/testify/require"
@@ -32,6 +41,414 @@ func TestOpen(t *testing.T) {
 	require.EqualError(t, err, `could not parse "foo.txt" as a migration filename`)
 }
 
+func TestOpenString(t *testing.T) {
+	m, err := OpenString("0003_create_roles.sql", "-- migrate: up\nCREATE TABLE roles (id integer);\n-- migrate: down\nDROP TABLE roles;\n")
+	require.NoError(t, err)
+	require.Equal(t, 3, m.Revision)
+	require.Equal(t, "create roles", m.Name)
+
+	upsql, err := m.UpSQL()
+	require.NoError(t, err)
+	require.Equal(t, "CREATE TABLE roles (id integer);\n", upsql)
+
+	dnsql, err := m.DownSQL()
+	require.NoError(t, err)
+	require.Equal(t, "DROP TABLE roles;\n", dnsql)
+
+	// The migration is identical to the same migration opened from a file
+	data, err := ioutil.ReadFile("testdata/0001_test_migration.sql")
+	require.NoError(t, err)
+	m, err = OpenString("testdata/0001_test_migration.sql", string(data))
+	require.NoError(t, err)
+	o, err := Open("testdata/0001_test_migration.sql")
+	require.NoError(t, err)
+
+	expected, err := o.Checksum()
+	require.NoError(t, err)
+	checksum, err := m.Checksum()
+	require.NoError(t, err)
+	require.Equal(t, expected, checksum)
+
+	// The content of a split-file migration only defines one direction
+	m, err = OpenString("0004_create_perms.up.sql", "CREATE TABLE perms (id integer);")
+	require.NoError(t, err)
+	require.Equal(t, 4, m.Revision)
+	require.Equal(t, "create perms", m.Name)
+	require.True(t, m.Irreversible())
+
+	upsql, err = m.UpSQL()
+	require.NoError(t, err)
+	require.Equal(t, "CREATE TABLE perms (id integer);\n", upsql)
+
+	_, err = OpenString("create_users.sql", "-- migrate: up\nSELECT 1;\n")
+	require.EqualError(t, err, `could not parse "create_users.sql" as a migration filename`)
+}
+
+func TestMigrationEqual(t *testing.T) {
+	// A migration is equal to the same migration opened from a file or a string
+	data, err := ioutil.ReadFile("testdata/0001_test_migration.sql")
+	require.NoError(t, err)
+	m, err := OpenString("testdata/0001_test_migration.sql", string(data))
+	require.NoError(t, err)
+	o, err := Open("testdata/0001_test_migration.sql")
+	require.NoError(t, err)
+	require.True(t, m.Equal(o))
+	require.True(t, o.Equal(m))
+
+	// Status fields read from the database are ignored
+	o.Active = true
+	o.Applied = time.Now()
+	o.Dirty = true
+	require.True(t, m.Equal(o))
+
+	base := "-- migrate: up\nCREATE TABLE roles (id integer);\n-- migrate: down\nDROP TABLE roles;\n"
+	m, err = OpenString("0003_create_roles.sql", base)
+	require.NoError(t, err)
+
+	// Metadata outside of the sql is ignored
+	o, err = OpenString("0003_create_roles.sql", "-- tidal: author=jsmith\n"+base)
+	require.NoError(t, err)
+	require.True(t, m.Equal(o))
+
+	tests := []struct {
+		filename string
+		content  string
+	}{
+		{"0004_create_roles.sql", base},
+		{"0003_create_role.sql", base},
+		{"0003_create_roles.sql", "-- migrate: up\nCREATE TABLE roles (id bigint);\n-- migrate: down\nDROP TABLE roles;\n"},
+		{"0003_create_roles.sql", "-- migrate: up\nCREATE TABLE roles (id integer);\n-- migrate: down\nDROP TABLE IF EXISTS roles;\n"},
+		{"0003_create_roles.sql", "-- migrate: up notransaction\nCREATE TABLE roles (id integer);\n-- migrate: down\nDROP TABLE roles;\n"},
+		{"0003_create_roles.sql", "-- migrate: requires 1\n" + base},
+	}
+
+	for i, tc := range tests {
+		o, err = OpenString(tc.filename, tc.content)
+		require.NoError(t, err, "test case %d failed", i)
+		require.False(t, m.Equal(o), "test case %d failed", i)
+	}
+}
+
+func TestOpenFS(t *testing.T) {
+	defer Reset()
+	fsys := fstest.MapFS{
+		"migrations/0001_create_users.sql":  {Data: []byte("-- migrate: up\nCREATE TABLE users (id integer);\n-- migrate: down\nDROP TABLE users;\n")},
+		"migrations/0002_create_groups.sql": {Data: []byte("-- migrate: up\nCREATE TABLE groups (id integer);\n-- migrate: down\nDROP TABLE groups;\n")},
+		"migrations/README.md":              {Data: []byte("# Migrations")},
+		"migrations/archive/0003_old.sql":   {Data: []byte("-- migrate: up\nSELECT 1;\n")},
+	}
+
+	m, err := OpenFS(fsys, "migrations/0002_create_groups.sql")
+	require.NoError(t, err)
+	require.Equal(t, 2, m.Revision)
+	require.Equal(t, "create groups", m.Name)
+
+	upsql, err := m.UpSQL()
+	require.NoError(t, err)
+	require.Equal(t, "CREATE TABLE groups (id integer);\n", upsql)
+
+	_, err = OpenFS(fsys, "migrations/README.md")
+	require.EqualError(t, err, `could not parse "README.md" as a migration filename`)
+
+	require.NoError(t, RegisterFS(fsys, "migrations"))
+	require.NoError(t, Verify())
+	m = Migration{Revision: 2}
+	n, err := m.Predecessors()
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	n, err = m.Successors()
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	// Registering the same migrations again should fail
+	require.Error(t, RegisterFS(fsys, "migrations"))
+}
+
+func TestOpenRevision(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0000_x.sql":                                {Data: []byte("-- migrate: up\nSELECT 1;\n")},
+		"2147483647_max.sql":                        {Data: []byte("-- migrate: up\nSELECT 1;\n")},
+		"2147483648_overflow.sql":                   {Data: []byte("-- migrate: up\nSELECT 1;\n")},
+		"000000000000000000000001_ok.sql":           {Data: []byte("-- migrate: up\nSELECT 1;\n")},
+		"123456789012345678901234567890_absurd.sql": {Data: []byte("-- migrate: up\nSELECT 1;\n")},
+	}
+
+	// Revision 0 is reserved for the migrations table
+	_, err := OpenFS(fsys, "0000_x.sql")
+	require.EqualError(t, err, "revision 0 of 0000_x.sql is reserved for the migrations table, application revisions start at 1")
+
+	// Revisions must fit into the revision column of the migrations table
+	m, err := OpenFS(fsys, "2147483647_max.sql")
+	require.NoError(t, err)
+	require.Equal(t, 2147483647, m.Revision)
+
+	_, err = OpenFS(fsys, "2147483648_overflow.sql")
+	require.EqualError(t, err, "revision 2147483648 of 2147483648_overflow.sql is too large, revisions must be at most 2147483647")
+
+	_, err = OpenFS(fsys, "123456789012345678901234567890_absurd.sql")
+	require.EqualError(t, err, "revision 123456789012345678901234567890 of 123456789012345678901234567890_absurd.sql is too large, revisions must be at most 2147483647")
+
+	// Leading zeros do not count towards the size of the revision
+	m, err = OpenFS(fsys, "000000000000000000000001_ok.sql")
+	require.NoError(t, err)
+	require.Equal(t, 1, m.Revision)
+}
+
+func TestRegisterDir(t *testing.T) {
+	defer Reset()
+	dir, err := ioutil.TempDir("", "tidal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"0002_create_groups.sql": "-- migrate: up\nCREATE TABLE groups (id integer);\n-- migrate: down\nDROP TABLE groups;\n",
+		"0001_create_users.sql":  "-- migrate: up\nCREATE TABLE users (id integer);\n-- migrate: down\nDROP TABLE users;\n",
+		"notes.txt":              "not a migration",
+	}
+	for name, data := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644))
+	}
+
+	require.NoError(t, RegisterDir(dir))
+	require.NoError(t, Verify())
+
+	migrations := Migrations()
+	require.Len(t, migrations, 2)
+	require.Equal(t, 1, migrations[0].Revision)
+	require.Equal(t, 2, migrations[1].Revision)
+
+	// All of the files that cannot be opened are reported and nothing is registered
+	Reset()
+	for _, name := range []string{"99999999999999999990_too_big.sql", "99999999999999999991_also_too_big.sql"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte("-- migrate: up\nSELECT 1;\n"), 0644))
+	}
+
+	err = RegisterDir(dir)
+	require.Error(t, err)
+
+	var errs MultiError
+	require.True(t, errors.As(err, &errs))
+	require.Len(t, errs, 2)
+	require.Contains(t, err.Error(), "2 errors occurred")
+	require.Contains(t, err.Error(), "99999999999999999990_too_big.sql")
+	require.Contains(t, err.Error(), "99999999999999999991_also_too_big.sql")
+	require.Empty(t, Migrations())
+
+	require.Error(t, RegisterDir(filepath.Join(dir, "missing")))
+}
+
+func TestMigrationFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "billing"), 0755))
+	for _, name := range []string{"1_create_users.sql", "R_user_views.sql", "notes.txt", "billing/10_create_invoices.sql"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte("-- migrate: up\nSELECT 1;\n"), 0644))
+	}
+
+	paths, err := MigrationFiles(dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"1_create_users.sql", "R_user_views.sql"}, paths)
+
+	paths, err = MigrationFiles(dir, RegisterOptions{Recursive: true})
+	require.NoError(t, err)
+	require.Equal(t, []string{"1_create_users.sql", "R_user_views.sql", "billing/10_create_invoices.sql"}, paths)
+}
+
+func TestRegisterDirCollisions(t *testing.T) {
+	defer Reset()
+	fsys := fstest.MapFS{
+		"0001_create_users.sql": {Data: []byte("-- migrate: up\nCREATE TABLE users (id integer);\n")},
+		"1_add_users.sql":       {Data: []byte("-- migrate: up\nCREATE TABLE users (id integer);\n")},
+		"0002_create_roles.sql": {Data: []byte("-- migrate: up\nCREATE TABLE roles (id integer);\n")},
+	}
+
+	// Both filenames that resolve to the same revision are reported
+	err := RegisterFS(fsys, ".")
+	require.EqualError(t, err, "revision 1 is defined by both 0001_create_users.sql and 1_add_users.sql")
+	require.Empty(t, Migrations())
+}
+
+func TestRegisterFSRecursive(t *testing.T) {
+	defer Reset()
+	fsys := fstest.MapFS{
+		"migrations/0001_create_users.sql":              {Data: []byte("-- migrate: up\nCREATE TABLE users (id integer);\n")},
+		"migrations/billing/0003_create_invoices.sql":   {Data: []byte("-- migrate: up\nCREATE TABLE invoices (id integer);\n")},
+		"migrations/billing/0002_create_plans.up.sql":   {Data: []byte("CREATE TABLE plans (id integer);\n")},
+		"migrations/billing/0002_create_plans.down.sql": {Data: []byte("DROP TABLE plans;\n")},
+		"migrations/.archive/0004_create_old.sql":       {Data: []byte("-- migrate: up\nCREATE TABLE old (id integer);\n")},
+	}
+
+	// Subdirectories are ignored by default
+	require.NoError(t, RegisterFS(fsys, "migrations"))
+	require.Len(t, Migrations(), 1)
+
+	Reset()
+	require.NoError(t, RegisterFS(fsys, "migrations", RegisterOptions{Recursive: true}))
+	migrations := Migrations()
+	require.Len(t, migrations, 3)
+	for i, name := range []string{"create users", "create plans", "create invoices"} {
+		require.Equal(t, i+1, migrations[i].Revision)
+		require.Equal(t, name, migrations[i].Name)
+	}
+	require.False(t, migrations[1].Irreversible())
+
+	// Revisions must be unique across directories
+	Reset()
+	fsys["migrations/accounts/0003_create_roles.sql"] = &fstest.MapFile{Data: []byte("-- migrate: up\nCREATE TABLE roles (id integer);\n")}
+	err := RegisterFS(fsys, "migrations", RegisterOptions{Recursive: true})
+	require.EqualError(t, err, "revision 3 is defined by both accounts/0003_create_roles.sql and billing/0003_create_invoices.sql")
+	require.Empty(t, Migrations())
+}
+
+func TestRegisterDirUnreadable(t *testing.T) {
+	defer Reset()
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "0001_create_users.sql"), []byte("-- migrate: up\nCREATE TABLE users (id integer);\n"), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "billing"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "billing", "0002_create_plans.sql"), []byte("-- migrate: up\nCREATE TABLE plans (id integer);\n"), 0644))
+	require.NoError(t, os.Chmod(filepath.Join(dir, "billing"), 0))
+	defer os.Chmod(filepath.Join(dir, "billing"), 0755)
+
+	// Permissions are not enforced for root so deny access to the subdirectory explicitly
+	var fsys fs.FS = os.DirFS(dir)
+	if os.Geteuid() == 0 {
+		fsys = deniedFS{FS: fsys, denied: "billing"}
+	}
+
+	err := RegisterFS(fsys, ".", RegisterOptions{Recursive: true})
+	require.EqualError(t, err, `could not read migrations directory "billing": permission denied`)
+	require.True(t, errors.Is(err, fs.ErrPermission))
+
+	var derr *DirectoryError
+	require.True(t, errors.As(err, &derr))
+	require.Equal(t, "billing", derr.Path)
+	require.Empty(t, Migrations())
+
+	require.NoError(t, RegisterFS(fsys, ".", RegisterOptions{Recursive: true, SkipUnreadable: true}))
+	require.Len(t, Migrations(), 1)
+
+	// The migrations directory itself must always be readable
+	Reset()
+	err = RegisterFS(deniedFS{FS: os.DirFS(dir), denied: "."}, ".", RegisterOptions{Recursive: true, SkipUnreadable: true})
+	require.EqualError(t, err, `could not read migrations directory ".": permission denied`)
+}
+
+// Returns a permission error when opening the denied path.
+type deniedFS struct {
+	fs.FS
+	denied string
+}
+
+func (f deniedFS) Open(name string) (fs.File, error) {
+	if name == f.denied {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return f.FS.Open(name)
+}
+
+func TestOpenSplit(t *testing.T) {
+	defer Reset()
+	fsys := fstest.MapFS{
+		"0001_create_users.up.sql":    {Data: []byte("CREATE TABLE users (id integer);\n")},
+		"0001_create_users.down.sql":  {Data: []byte("DROP TABLE users;")},
+		"0002_cleanup_users.up.sql":   {Data: []byte("DELETE FROM users;\n")},
+		"0003_restore_users.down.sql": {Data: []byte("INSERT INTO users (id) VALUES (1);\n")},
+	}
+
+	// Opening either half of a split-file migration returns the combined migration
+	for _, path := range []string{"0001_create_users.up.sql", "0001_create_users.down.sql"} {
+		m, err := OpenFS(fsys, path)
+		require.NoError(t, err)
+		require.Equal(t, 1, m.Revision)
+		require.Equal(t, "create users", m.Name)
+
+		upsql, err := m.UpSQL()
+		require.NoError(t, err)
+		require.Equal(t, "CREATE TABLE users (id integer);\n", upsql)
+
+		dnsql, err := m.DownSQL()
+		require.NoError(t, err)
+		require.Equal(t, "DROP TABLE users;\n", dnsql)
+		require.False(t, m.Irreversible())
+	}
+
+	// A missing down file is irreversible and a missing up file is empty
+	m, err := OpenFS(fsys, "0002_cleanup_users.up.sql")
+	require.NoError(t, err)
+	require.True(t, m.Irreversible())
+	require.False(t, m.Empty())
+
+	m, err = OpenFS(fsys, "0003_restore_users.down.sql")
+	require.NoError(t, err)
+	require.False(t, m.Irreversible())
+	require.True(t, m.Empty())
+
+	_, err = OpenFS(fsys, "0004_missing.up.sql")
+	require.Error(t, err)
+
+	// Both halves of a split-file migration are registered as one migration
+	require.NoError(t, RegisterFS(fsys, "."))
+	migrations := Migrations()
+	require.Len(t, migrations, 3)
+	for i, m := range migrations {
+		require.Equal(t, i+1, m.Revision)
+	}
+
+	// A split-file migration still collides with a single file migration
+	Reset()
+	fsys["0001_add_users.sql"] = &fstest.MapFile{Data: []byte("-- migrate: up\nSELECT 1;\n")}
+	err = RegisterFS(fsys, ".")
+	require.EqualError(t, err, "revision 1 is defined by both 0001_add_users.sql and 0001_create_users.down.sql")
+}
+
+func TestMigrationSource(t *testing.T) {
+	m, err := Open("testdata/0001_test_migration.sql")
+	require.NoError(t, err)
+
+	data, err := ioutil.ReadFile("testdata/0001_test_migration.sql")
+	require.NoError(t, err)
+
+	src, err := m.Source()
+	require.NoError(t, err)
+	require.Equal(t, string(data), src)
+	require.Empty(t, m.Options("up"))
+
+	// The source of a split-file migration combines both halves
+	fsys := fstest.MapFS{
+		"0001_create_index.up.sql":   {Data: []byte("CREATE INDEX CONCURRENTLY users_email ON users (email);\n")},
+		"0001_create_index.down.sql": {Data: []byte("DROP INDEX users_email;\n")},
+	}
+
+	m, err = OpenFS(fsys, "0001_create_index.up.sql")
+	require.NoError(t, err)
+	src, err = m.Source()
+	require.NoError(t, err)
+	require.Equal(t, "-- migrate: up\nCREATE INDEX CONCURRENTLY users_email ON users (email);\n-- migrate: down\nDROP INDEX users_email;\n", src)
+
+	fsys["0002_create_index.sql"] = &fstest.MapFile{Data: []byte("-- migrate: up NoTransaction timeout=30s\nCREATE INDEX CONCURRENTLY users_name ON users (name);\n")}
+	m, err = OpenFS(fsys, "0002_create_index.sql")
+	require.NoError(t, err)
+	require.Equal(t, []string{"notransaction", "timeout=30s"}, m.Options("up"))
+	require.Empty(t, m.Options("down"))
+}
+
+func TestMigrationJSON(t *testing.T) {
+	applied := time.Date(2020, 8, 14, 12, 30, 0, 0, time.UTC)
+	m := Migration{Revision: 2, Name: "create users", Active: true, Applied: applied, Created: applied, Elapsed: 1500 * time.Microsecond}
+	data, err := json.Marshal(m)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"revision": 2, "name": "create users", "active": true, "applied": "2020-08-14T12:30:00Z", "created": "2020-08-14T12:30:00Z", "elapsed": 1500000}`, string(data))
+
+	// Zero timestamps are encoded as null
+	data, err = json.Marshal([]Migration{{Revision: 3, Name: "create groups"}})
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"revision": 3, "name": "create groups", "active": false, "applied": null, "created": null, "elapsed": 0}]`, string(data))
+
+	var decoded []Migration
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.True(t, decoded[0].Applied.IsZero())
+	require.Equal(t, 3, decoded[0].Revision)
+}
+
 func TestPredecessors(t *testing.T) {
 	defer Reset()
 	target := Migration{Revision: 3}
@@ -39,6 +456,11 @@ func TestPredecessors(t *testing.T) {
 	// No migrations registered
 	_, err := target.Predecessors()
 	require.EqualError(t, err, "revision 3 was not registered")
+	require.True(t, errors.Is(err, ErrNotRegistered))
+
+	var nrerr *NotRegisteredError
+	require.True(t, errors.As(err, &nrerr))
+	require.Equal(t, 3, nrerr.Revision)
 
 	// Predecessors registered, but target not registered
 	require.NoError(t, Register(Migration{Revision: 1}))
@@ -70,6 +492,7 @@ func TestSuccessors(t *testing.T) {
 	// No migrations registered
 	_, err := target.Successors()
 	require.EqualError(t, err, "revision 3 was not registered")
+	require.True(t, errors.Is(err, ErrNotRegistered))
 
 	// Predecessors registered, but target not registered
 	require.NoError(t, Register(Migration{Revision: 1}))
@@ -93,3 +516,66 @@ func TestSuccessors(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, 0, n)
 }
+
+func TestCreate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tidal-create")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Non-migration files should be ignored when determining the next revision
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "0003_existing.sql"), nil, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "schema.sql"), nil, 0644))
+
+	path, err := Create(dir, "add users", "foo")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "0004_add_users.sql"), path)
+
+	m, err := Open(path)
+	require.NoError(t, err)
+	require.Equal(t, 4, m.Revision)
+	require.Equal(t, "add users", m.Name)
+
+	pkg, err := m.Package()
+	require.NoError(t, err)
+	require.Equal(t, "foo", pkg)
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "-- migrate: up\n")
+	require.Contains(t, string(data), "-- migrate: down\n")
+
+}
+
+func TestCreateTemplate(t *testing.T) {
+	dir := t.TempDir()
+	SetClock(func() time.Time { return time.Date(2021, 6, 1, 15, 4, 5, 0, time.Local) })
+	defer SetClock(nil)
+
+	tmpl := "-- Copyright {{ .Date }} Acme, Inc.\n-- {{ .Name }} (revision {{ .Revision }}){{ if .Package }}\n-- package: {{ .Package }}{{ end }}\n-- migrate: up\n\n-- migrate: down\n"
+	path, err := Create(dir, "add users", "foo", CreateOptions{Template: tmpl})
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "0001_add_users.sql"), path)
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "-- Copyright 2021-06-01 Acme, Inc.\n-- add users (revision 1)\n-- package: foo\n-- migrate: up\n\n-- migrate: down\n", string(data))
+
+	// The template must be valid and produce an up directive
+	_, err = Create(dir, "add groups", "", CreateOptions{Template: "{{ .Revision"})
+	require.Error(t, err)
+
+	_, err = Create(dir, "add groups", "", CreateOptions{Template: "-- {{ .Author }}\n-- migrate: up\n"})
+	require.Error(t, err)
+
+	_, err = Create(dir, "add groups", "", CreateOptions{Template: "-- {{ .Name }}\n"})
+	require.EqualError(t, err, "migration template does not produce a -- migrate: up directive")
+
+	// A failed template does not create a file and the default template is used otherwise
+	path, err = Create(dir, "add groups", "", CreateOptions{})
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "0002_add_groups.sql"), path)
+
+	data, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(data), "-- Revision 2 generated on 2021-06-01 15:04:05"))
+}
@@ -7,15 +7,23 @@ CREATE TABLE IF NOT EXISTS migrations (
     "active" boolean NOT NULL DEFAULT false,
     "applied" TIMESTAMP WITH TIME ZONE,
     "created" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "checksum" varchar(64),
+    "elapsed" bigint,
+    "dirty" boolean NOT NULL DEFAULT false,
+    "versioned" boolean NOT NULL DEFAULT true,
     PRIMARY KEY ("revision")
 ) WITHOUT OIDS;
 
-COMMENT ON TABLE "migrations" IS 'Manages the state of database by enabling migrations and rollbacks';
-COMMENT ON COLUMN "migrations"."revision" IS 'The revision id parsed from the filename of the migration';
-COMMENT ON COLUMN "migrations"."name" IS 'The name of the migration parsed from the filename of the migration';
-COMMENT ON COLUMN "migrations"."active" IS 'If the migration has been applied, set to false on rollbacks or if not applied';
-COMMENT ON COLUMN "migrations"."applied" IS 'Timestamp when the migration was applied, null if rolledback or not applied';
-COMMENT ON COLUMN "migrations"."created" IS 'Timestamp when the migration was created';
+COMMENT ON TABLE migrations IS 'Manages the state of database by enabling migrations and rollbacks';
+COMMENT ON COLUMN migrations."revision" IS 'The revision id parsed from the filename of the migration';
+COMMENT ON COLUMN migrations."name" IS 'The name of the migration parsed from the filename of the migration';
+COMMENT ON COLUMN migrations."active" IS 'If the migration has been applied, set to false on rollbacks or if not applied';
+COMMENT ON COLUMN migrations."applied" IS 'Timestamp when the migration was applied, null if rolledback or not applied';
+COMMENT ON COLUMN migrations."created" IS 'Timestamp when the migration was created';
+COMMENT ON COLUMN migrations."checksum" IS 'SHA-256 checksum of the up and down sql when the migration was applied';
+COMMENT ON COLUMN migrations."elapsed" IS 'Nanoseconds taken to execute the up sql when the migration was applied';
+COMMENT ON COLUMN migrations."dirty" IS 'If the migration was interrupted while being applied or rolled back';
+COMMENT ON COLUMN migrations."versioned" IS 'If the migration changes the schema version, false for data-only migrations';
 
 -- The down migration will take the database all the way back to a blank slate
 -- migrate: down
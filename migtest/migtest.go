@@ -0,0 +1,84 @@
+/*
+Package migtest provides helpers for tests that need a database with a schema created by
+the registered tidal migrations, e.g. to test application models against a real schema.
+
+A typical test opens a connection to a test database, configures the tidal dialect, and
+then calls Fresh to apply every registered migration; the migrations are rolled back
+when the test completes so that each test starts with an empty schema:
+
+	func TestModels(t *testing.T) {
+		conn := openTestDatabase(t)
+		migtest.Fresh(t, conn)
+		// ... test against the migrated schema
+	}
+
+Tests that define their own migrations rather than using the migrations registered by
+the application should call Use to replace the registered migrations for the duration
+of the test, after which the original registered migrations are restored.
+*/
+package migtest
+
+import (
+	"database/sql"
+	"testing"
+	"testing/fstest"
+
+	"github.com/rotationalio/tidal"
+)
+
+// Fresh applies all registered migrations to the database, failing the test if any of
+// them cannot be applied, and registers a cleanup function that rolls back all of the
+// migrations when the test and its subtests complete. Irreversible migrations are
+// forced to be marked as rolled back. The migrations table itself is not dropped.
+func Fresh(t testing.TB, conn *sql.DB) {
+	t.Helper()
+	if err := tidal.Migrate(conn, -1); err != nil {
+		t.Fatalf("could not apply migrations: %s", err)
+	}
+
+	t.Cleanup(func() {
+		if err := tidal.Rollback(conn, 0, tidal.RollbackOptions{Force: true}); err != nil {
+			t.Errorf("could not rollback migrations: %s", err)
+		}
+	})
+}
+
+// Use resets the registered migrations and registers the specified migrations in their
+// place for the duration of the test. When the test completes the registry is reset
+// again and the previously registered migrations, including repeatable migrations, are
+// restored, so that migrations registered by one test do not pollute the registry of
+// other tests. Tests that call Use must not be run in parallel with other tests that
+// use the registry.
+func Use(t testing.TB, migrations ...tidal.Migration) {
+	t.Helper()
+	previous := append(tidal.Migrations(), tidal.Repeatables()...)
+	tidal.Reset()
+
+	t.Cleanup(func() {
+		tidal.Reset()
+		for _, m := range previous {
+			if err := tidal.Register(m); err != nil {
+				t.Errorf("could not restore registered migrations: %s", err)
+			}
+		}
+	})
+
+	for _, m := range migrations {
+		if err := tidal.Register(m); err != nil {
+			t.Fatalf("could not register migration: %s", err)
+		}
+	}
+}
+
+// Migration creates a migration from SQL with -- migrate: up and -- migrate: down
+// directives, as though it were read from a migration file with the specified
+// filename, e.g. 0001_create_users.sql. The test fails if the migration is invalid.
+func Migration(t testing.TB, filename, sql string) tidal.Migration {
+	t.Helper()
+	fsys := fstest.MapFS{filename: {Data: []byte(sql)}}
+	m, err := tidal.OpenFS(fsys, filename)
+	if err != nil {
+		t.Fatalf("could not create migration: %s", err)
+	}
+	return m
+}
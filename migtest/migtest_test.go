@@ -0,0 +1,60 @@
+package migtest_test
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/rotationalio/tidal"
+	"github.com/rotationalio/tidal/migtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFresh(t *testing.T) {
+	defer tidal.SetDialect(tidal.Postgres)
+	tidal.SetDialect(tidal.SQLite)
+
+	conn, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+
+	bootstrap := tidal.BootstrapMigration(tidal.SQLite)
+	require.NoError(t, bootstrap.Up(conn))
+
+	// The application's registered migrations are restored after the test
+	app := migtest.Migration(t, "0001_create_app.sql", "-- migrate: up\nCREATE TABLE app (id integer);\n-- migrate: down\nDROP TABLE app;\n")
+	require.NoError(t, tidal.Register(app))
+	view := migtest.Migration(t, "R_app_ids.sql", "CREATE VIEW IF NOT EXISTS app_ids AS SELECT id FROM app;\n")
+	require.NoError(t, tidal.Register(view))
+	defer tidal.Reset()
+
+	t.Run("Migrated", func(t *testing.T) {
+		migtest.Use(t,
+			migtest.Migration(t, "0001_create_users.sql", "-- migrate: up\nCREATE TABLE users (id integer);\n-- migrate: down\nDROP TABLE users;\n"),
+			migtest.Migration(t, "0002_cleanup_users.sql", "-- migrate: up\nDELETE FROM users;\n"),
+		)
+		require.Len(t, tidal.Migrations(), 2)
+		require.Empty(t, tidal.Repeatables())
+
+		migtest.Fresh(t, conn)
+		_, err := conn.Exec("INSERT INTO users (id) VALUES (1)")
+		require.NoError(t, err)
+	})
+
+	// The migrations should have been rolled back, including the irreversible one
+	_, err = conn.Exec("INSERT INTO users (id) VALUES (1)")
+	require.Error(t, err)
+
+	var active int
+	require.NoError(t, conn.QueryRow("SELECT count(*) FROM migrations WHERE active").Scan(&active))
+	require.Equal(t, 0, active)
+
+	migrations := tidal.Migrations()
+	require.Len(t, migrations, 1)
+	require.Equal(t, "create app", migrations[0].Name)
+
+	repeatables := tidal.Repeatables()
+	require.Len(t, repeatables, 1)
+	require.Equal(t, "app ids", repeatables[0].Name)
+}
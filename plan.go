@@ -0,0 +1,175 @@
+package tidal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Step is a single migration that would be executed to bring the database to a target
+// revision, along with the direction it would be executed in ("up" or "down") and the
+// SQL that would be executed. Transactional is false if the migration must be executed
+// outside of a transaction in that direction (see the notransaction directive).
+type Step struct {
+	Revision      int
+	Name          string
+	Direction     string
+	SQL           string
+	Transactional bool
+}
+
+// Plan returns the ordered steps required to bring the database from its current state
+// to the target revision without executing anything or modifying the migrations table.
+// Active migrations after the target revision are rolled back first, in descending
+// revision order, then inactive migrations up to and including the target revision are
+// applied in ascending revision order (use -1 to target all registered migrations).
+// Migrations with requires directives are ordered as they are by Migrate and Rollback.
+// If the target is behind the current revision, the plan only contains down steps. If
+// the migrations table does not exist, no migrations are active.
+func Plan(conn *sql.DB, target int) (steps []Step, err error) {
+	return DefaultRegistry.Plan(conn, target)
+}
+
+// Plan returns the steps to bring the database to the target revision using the
+// migrations in the registry, see Plan.
+func (r *Registry) Plan(conn *sql.DB, target int) (steps []Step, err error) {
+	var migrations []Migration
+	if migrations, err = ordered(r.registered()); err != nil {
+		return nil, err
+	}
+
+	var status map[int]*record
+	if status, err = planStatus(context.Background(), conn); err != nil {
+		return nil, err
+	}
+
+	if target >= 0 {
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+			if m.Revision <= target {
+				continue
+			}
+
+			if row, ok := status[m.Revision]; !ok || !row.active {
+				continue
+			}
+
+			var step Step
+			if step, err = newStep(m, "down"); err != nil {
+				return nil, err
+			}
+			steps = append(steps, step)
+		}
+	}
+
+	var ups []Step
+	if ups, err = plan(migrations, status, target); err != nil {
+		return nil, err
+	}
+	return append(steps, ups...), nil
+}
+
+// PlanMigrate returns the ordered steps that Migrate would execute to apply registered
+// migrations up to and including the target revision (use -1 for all registered
+// migrations) without executing anything or modifying the migrations table. If the
+// migrations table does not exist, every registered migration up to the target is
+// planned; the migrations table itself is created by Migrate and is not a step.
+func PlanMigrate(conn *sql.DB, target int) (steps []Step, err error) {
+	return DefaultRegistry.PlanMigrate(conn, target)
+}
+
+// PlanMigrate returns the steps that Migrate would execute for the registry.
+func (r *Registry) PlanMigrate(conn *sql.DB, target int) (steps []Step, err error) {
+	var migrations []Migration
+	if migrations, err = ordered(r.registered()); err != nil {
+		return nil, err
+	}
+
+	var status map[int]*record
+	if status, err = planStatus(context.Background(), conn); err != nil {
+		return nil, err
+	}
+	return plan(migrations, status, target)
+}
+
+// Returns the up steps for the inactive migrations up to and including the target.
+func plan(migrations []Migration, status map[int]*record, target int) (steps []Step, err error) {
+	for _, m := range migrations {
+		if target >= 0 && m.Revision > target {
+			continue
+		}
+
+		if row, ok := status[m.Revision]; ok && row.active {
+			continue
+		}
+
+		var step Step
+		if step, err = newStep(m, "up"); err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// Reads the migrations table, returning an empty status if it does not exist.
+func planStatus(ctx context.Context, conn executor) (status map[int]*record, err error) {
+	if err = checkInitialized(ctx, conn); err != nil {
+		if errors.Is(err, ErrUninitialized) {
+			return make(map[int]*record), nil
+		}
+		return nil, err
+	}
+	return readStatus(ctx, conn)
+}
+
+// Creates a step to execute the migration in the specified direction.
+func newStep(m Migration, direction string) (step Step, err error) {
+	step = Step{Revision: m.Revision, Name: m.Name, Direction: direction, Transactional: m.transactional(direction)}
+	if step.SQL, err = m.render(direction); err != nil {
+		return step, fmt.Errorf("could not parse revision %d %s sql: %s", m.Revision, direction, err)
+	}
+	return step, nil
+}
+
+// Validate executes the SQL of the steps in order in a single transaction that is always
+// rolled back, e.g. to check the steps returned by Plan or PlanMigrate against the
+// database without modifying it. This catches errors that cannot be found by parsing
+// the SQL such as references to missing tables or columns. If the SQL of a step fails
+// to execute, the returned error describes the step and wraps the driver error.
+//
+// Steps that are not transactional cannot be executed in the transaction and are
+// skipped, so later steps that depend on them may fail to validate. Validation requires
+// a dialect with transactional DDL since otherwise schema changes are committed
+// implicitly; an error is returned for dialects such as MySQL.
+func Validate(conn *sql.DB, steps []Step) (err error) {
+	if !dialect.TransactionalDDL() {
+		return fmt.Errorf("cannot validate migrations: the %s dialect does not support transactional ddl", dialect.Name())
+	}
+
+	ctx := context.Background()
+	var tx *sql.Tx
+	if tx, err = conn.BeginTx(ctx, nil); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, step := range steps {
+		if !step.Transactional {
+			continue
+		}
+
+		stmts := splitStatements(step.SQL)
+		if !requiresSplit(dialect) && len(stmts) > 0 {
+			stmts = []string{step.SQL}
+		}
+
+		for _, stmt := range stmts {
+			if _, err = tx.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("revision %d %s sql failed to execute: %w", step.Revision, step.Direction, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package tidal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanMigrate(t *testing.T) {
+	defer Reset()
+	defer SetDialect(Postgres)
+
+	// The migrations table does not exist so every registered migration is planned
+	conn, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+	SetDialect(SQLite)
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	registerTestMigration(t, "0003_create_roles.sql", "CREATE TABLE roles (id integer);", "DROP TABLE roles;")
+
+	steps, err := PlanMigrate(conn, -1)
+	require.NoError(t, err)
+	require.Len(t, steps, 3)
+	require.Equal(t, Step{Revision: 1, Name: "create users", Direction: "up", SQL: "CREATE TABLE users (id integer);\n", Transactional: true}, steps[0])
+	require.Equal(t, 2, steps[1].Revision)
+	require.Equal(t, 3, steps[2].Revision)
+
+	// Planning must not create the migrations table
+	err = checkInitialized(context.Background(), conn)
+	require.True(t, errors.Is(err, ErrUninitialized))
+
+	// Applied migrations are not planned and the target is respected
+	bootstrapTestDB(t, conn)
+	require.NoError(t, Migrate(conn, 1))
+	steps, err = PlanMigrate(conn, 2)
+	require.NoError(t, err)
+	require.Len(t, steps, 1)
+	require.Equal(t, 2, steps[0].Revision)
+	require.Equal(t, "CREATE TABLE groups (id integer);\n", steps[0].SQL)
+
+	// Nothing is executed by the plan
+	steps, err = PlanMigrate(conn, -1)
+	require.NoError(t, err)
+	require.Len(t, steps, 2)
+
+	require.NoError(t, Migrate(conn, -1))
+	steps, err = PlanMigrate(conn, -1)
+	require.NoError(t, err)
+	require.Empty(t, steps)
+}
+
+func TestPlan(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	registerTestMigration(t, "0003_create_roles.sql", "CREATE TABLE roles (id integer);", "DROP TABLE roles;")
+
+	directions := func(steps []Step) (s []string) {
+		for _, step := range steps {
+			s = append(s, fmt.Sprintf("%d %s", step.Revision, step.Direction))
+		}
+		return s
+	}
+
+	// Nothing is applied so every migration up to the target is applied
+	steps, err := Plan(conn, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"1 up", "2 up"}, directions(steps))
+
+	steps, err = Plan(conn, -1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"1 up", "2 up", "3 up"}, directions(steps))
+
+	// A target behind the current revision produces down steps in descending order
+	require.NoError(t, Migrate(conn, -1))
+	steps, err = Plan(conn, 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"3 down", "2 down"}, directions(steps))
+	require.Equal(t, Step{Revision: 3, Name: "create roles", Direction: "down", SQL: "DROP TABLE roles;\n", Transactional: true}, steps[0])
+
+	steps, err = Plan(conn, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"3 down", "2 down", "1 down"}, directions(steps))
+
+	steps, err = Plan(conn, 3)
+	require.NoError(t, err)
+	require.Empty(t, steps)
+
+	// Gaps in the applied migrations are rolled back and applied as necessary
+	require.NoError(t, registered()[0].Down(conn, RollbackOptions{Force: true}))
+	steps, err = Plan(conn, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"3 down", "1 up"}, directions(steps))
+}
+
+func TestValidate(t *testing.T) {
+	defer Reset()
+	defer SetDialect(Postgres)
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_add_users_index.sql", "CREATE INDEX users_id ON users (id);", "DROP INDEX users_id;")
+	registerTestMigration(t, "0003_create_roles.sql", "INSERT INTO roles (id) VALUES (1);", "")
+
+	// Later steps are validated against the changes made by earlier steps
+	steps, err := PlanMigrate(conn, 2)
+	require.NoError(t, err)
+	require.NoError(t, Validate(conn, steps))
+
+	// Nothing is committed by validation
+	var n int
+	require.NoError(t, conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name='users'").Scan(&n))
+	require.Zero(t, n)
+
+	steps, err = PlanMigrate(conn, -1)
+	require.NoError(t, err)
+	err = Validate(conn, steps)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "revision 3 up sql failed to execute: no such table: roles")
+
+	// Rollbacks can be validated as well
+	require.NoError(t, Migrate(conn, 2))
+	steps, err = Plan(conn, 0)
+	require.NoError(t, err)
+	require.Len(t, steps, 2)
+	require.NoError(t, Validate(conn, steps))
+	require.NoError(t, conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name='users'").Scan(&n))
+	require.Equal(t, 1, n)
+
+	SetDialect(MySQL)
+	require.EqualError(t, Validate(conn, steps), "cannot validate migrations: the mysql dialect does not support transactional ddl")
+}
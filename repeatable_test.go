@@ -0,0 +1,195 @@
+package tidal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenRepeatable(t *testing.T) {
+	for _, filename := range []string{"R_user_names.sql", "R__user_names.sql"} {
+		m, err := OpenString(filename, "CREATE VIEW IF NOT EXISTS user_names AS SELECT id FROM users;\n")
+		require.NoError(t, err, filename)
+		require.True(t, m.Repeatable())
+		require.Equal(t, "user names", m.Name)
+		require.Zero(t, m.Revision)
+		require.True(t, m.Irreversible())
+
+		// A file without any directives is the up sql of the repeatable migration
+		up, err := m.UpSQL()
+		require.NoError(t, err)
+		require.Equal(t, "CREATE VIEW IF NOT EXISTS user_names AS SELECT id FROM users;\n", up)
+	}
+
+	// Repeatable migrations can also use directives, e.g. to specify options
+	m, err := OpenString("R_user_names.sql", "-- migrate: up notransaction\nCREATE VIEW user_names AS SELECT id FROM users;\n")
+	require.NoError(t, err)
+	require.False(t, m.Transactional())
+
+	// Versioned migrations are not repeatable
+	m, err = OpenString("0001_create_users.sql", "-- migrate: up\nCREATE TABLE users (id integer);\n")
+	require.NoError(t, err)
+	require.False(t, m.Repeatable())
+
+	// Repeatable migrations are registered from a directory with the versioned migrations
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "0001_create_users.sql"), []byte("-- migrate: up\nCREATE TABLE users (id integer);\n-- migrate: down\nDROP TABLE users;\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "R__user_names.sql"), []byte("CREATE VIEW user_names AS SELECT id FROM users;\n"), 0644))
+
+	registry := &Registry{}
+	require.NoError(t, registry.RegisterDir(dir))
+	require.Len(t, registry.Migrations(), 1)
+	require.Len(t, registry.Repeatables(), 1)
+	require.NoError(t, registry.Verify())
+
+	// Repeatable migrations are linted without a down section or revision
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "R_user_ids.sql"), []byte("-- migrate: up\nCREATE VIEW user_ids AS SELECT id FROM users;\n-- migrate: down\nDROP VIEW IF EXISTS user_ids;\n"), 0644))
+	issues, err := Lint(dir)
+	require.NoError(t, err)
+	require.Len(t, issues, 2)
+	require.Equal(t, "R_user_ids.sql", issues[0].Path)
+	require.Equal(t, "repeatable migrations cannot define down sql, they are never rolled back", issues[0].Message)
+	require.Equal(t, "0001_create_users.sql", issues[1].Path)
+	require.Contains(t, issues[1].Message, "does not use IF EXISTS")
+}
+
+func TestRegisterRepeatable(t *testing.T) {
+	registry := &Registry{}
+	names, err := OpenString("R_user_names.sql", "CREATE VIEW user_names AS SELECT id FROM users;")
+	require.NoError(t, err)
+	require.NoError(t, registry.Register(names))
+
+	emails, err := OpenString("R_user_emails.sql", "CREATE VIEW user_emails AS SELECT id FROM users;")
+	require.NoError(t, err)
+	require.NoError(t, registry.Register(emails))
+
+	// Repeatable migrations are sorted by name and are not versioned migrations
+	repeatables := registry.Repeatables()
+	require.Len(t, repeatables, 2)
+	require.Equal(t, "user emails", repeatables[0].Name)
+	require.Equal(t, "user names", repeatables[1].Name)
+	require.Empty(t, registry.Migrations())
+
+	// Names must be unique
+	err = registry.Register(names)
+	require.True(t, errors.Is(err, ErrDuplicateRevision))
+	require.EqualError(t, err, `cannot register repeatable migration "user names": revision already exists`)
+
+	// Repeatable migrations are never rolled back
+	down, err := OpenString("R_user_ids.sql", "-- migrate: up\nCREATE VIEW user_ids AS SELECT id FROM users;\n-- migrate: down\nDROP VIEW user_ids;\n")
+	require.NoError(t, err)
+	require.EqualError(t, registry.Register(down), `cannot register repeatable migration "user ids": repeatable migrations cannot define down sql`)
+
+	// Descriptors of repeatable migrations are registered by filename
+	require.NoError(t, registry.Reset())
+	require.NoError(t, registry.RegisterDescriptor(names.descriptor))
+	require.Len(t, registry.Repeatables(), 1)
+}
+
+func TestMigrateRepeatable(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer, name text);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_add_email.sql", "ALTER TABLE users ADD COLUMN email text;", "")
+	registerRepeatable := func(filename, content string) {
+		m, err := OpenString(filename, content)
+		require.NoError(t, err)
+		require.NoError(t, Register(m))
+	}
+	registerRepeatable("R_user_names.sql", "DROP VIEW IF EXISTS user_names; CREATE VIEW user_names AS SELECT name FROM users;")
+	registerRepeatable("R_user_emails.sql", "DROP VIEW IF EXISTS user_emails; CREATE VIEW user_emails AS SELECT email FROM users;")
+
+	// Repeatable migrations are not applied until every revision has been applied
+	result, err := MigrateResult(conn, 1)
+	require.NoError(t, err)
+	require.Empty(t, result.Repeated)
+
+	buf := &bytes.Buffer{}
+	SetLogger(NewReporter(buf))
+	defer SetLogger(nil)
+
+	// Repeatable migrations are applied in name order after the versioned migrations
+	result, err = MigrateResult(conn, -1)
+	require.NoError(t, err)
+	require.Equal(t, []int{2}, result.Applied)
+	require.Equal(t, []string{"user emails", "user names"}, result.Repeated)
+	require.Regexp(t, `^applied 1 migration\(s\) and 2 repeatable migration\(s\), now at revision 2 in `, result.String())
+	require.Contains(t, buf.String(), "✓ repeatable up: user emails")
+
+	_, err = conn.Exec("SELECT email FROM user_emails")
+	require.NoError(t, err)
+
+	// Repeatable migrations are recorded with negative revisions
+	var checksums int
+	require.NoError(t, conn.QueryRow("SELECT COUNT(*) FROM migrations WHERE revision<0 AND checksum IS NOT NULL").Scan(&checksums))
+	require.Equal(t, 2, checksums)
+
+	// The repeatable migrations do not affect the status or revision of the database
+	status, err := Status(conn)
+	require.NoError(t, err)
+	require.Len(t, status, 2)
+
+	current, err := CurrentRevision(conn)
+	require.NoError(t, err)
+	require.Equal(t, 2, current)
+
+	// Unchanged repeatable migrations are not applied again
+	result, err = MigrateResult(conn, -1)
+	require.NoError(t, err)
+	require.Empty(t, result.Repeated)
+	require.Equal(t, "nothing to migrate, database is at revision 2", result.String())
+
+	// A repeatable migration is applied again when its checksum changes
+	rows, err := readRepeatable(context.Background(), conn)
+	require.NoError(t, err)
+	revision := rows["user names"].revision
+
+	DefaultRegistry.mu.Lock()
+	DefaultRegistry.repeatable = nil
+	DefaultRegistry.mu.Unlock()
+	registerRepeatable("R_user_names.sql", "DROP VIEW IF EXISTS user_names; CREATE VIEW user_names AS SELECT name, email FROM users;")
+	registerRepeatable("R_user_emails.sql", "DROP VIEW IF EXISTS user_emails; CREATE VIEW user_emails AS SELECT email FROM users;")
+	registerRepeatable("R_user_ids.sql", "DROP VIEW IF EXISTS user_ids; CREATE VIEW user_ids AS SELECT id FROM users;")
+
+	result, err = MigrateResult(conn, -1)
+	require.NoError(t, err)
+	require.Empty(t, result.Applied)
+	require.Equal(t, []string{"user ids", "user names"}, result.Repeated)
+	require.Regexp(t, `^applied 2 repeatable migration\(s\), database is at revision 2 in `, result.String())
+
+	_, err = conn.Exec("SELECT name, email FROM user_names")
+	require.NoError(t, err)
+
+	rows, err = readRepeatable(context.Background(), conn)
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	require.Equal(t, revision, rows["user names"].revision, "the revision of a repeatable migration must not change")
+	require.Equal(t, -3, rows["user ids"].revision)
+
+	// Repeatable migrations that fail are applied again by the next migration
+	DefaultRegistry.mu.Lock()
+	DefaultRegistry.repeatable = nil
+	DefaultRegistry.mu.Unlock()
+	registerRepeatable("R_user_ids.sql", "CREATE VIEWZ user_ids;")
+
+	err = Migrate(conn, -1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "repeatable migration user ids failed")
+
+	rows, err = readRepeatable(context.Background(), conn)
+	require.NoError(t, err)
+	require.False(t, rows["user ids"].dirty)
+
+	// Rolling back the versioned migrations does not roll back repeatable migrations
+	require.NoError(t, Rollback(conn, 0, RollbackOptions{Force: true}))
+	rows, err = readRepeatable(context.Background(), conn)
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+}
@@ -0,0 +1,109 @@
+package tidal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ANSI escape sequences used to color the output of the Reporter.
+const (
+	colorReset = "\033[0m"
+	colorRed   = "\033[31m"
+	colorGreen = "\033[32m"
+	colorFaint = "\033[2m"
+)
+
+// ReporterOptions modify the default behavior of NewReporter.
+type ReporterOptions struct {
+	// NoColor disables colored output even if the writer is a terminal.
+	NoColor bool
+
+	// Verbose prints the SQL of each migration before it is executed.
+	Verbose bool
+}
+
+// Reporter is a Logger that prints a single line of progress to an io.Writer for each
+// migration that is applied or rolled back, e.g. for command line tools:
+//
+//	✓ revision 1 up: create users (12ms)
+//	✗ revision 2 up: bad sql failed after 3ms
+//
+// The error of a failed migration is not printed since it is returned by Migrate or
+// Rollback. Use SetLogger to send migration progress events to the reporter.
+type Reporter struct {
+	w       io.Writer
+	color   bool
+	verbose bool
+}
+
+// NewReporter returns a Reporter that writes migration progress to w. The output is
+// colored if w is a terminal unless the NoColor option is specified or the NO_COLOR
+// environment variable is set, so that the output is plain when it is piped or
+// redirected to a file.
+func NewReporter(w io.Writer, opts ...ReporterOptions) *Reporter {
+	var opt ReporterOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	_, nocolor := os.LookupEnv("NO_COLOR")
+	return &Reporter{
+		w:       w,
+		color:   !opt.NoColor && !nocolor && isTerminal(w),
+		verbose: opt.Verbose,
+	}
+}
+
+// Start prints the SQL of the migration if the reporter is verbose.
+func (r *Reporter) Start(revision int, name, direction, sql string) {
+	if r.verbose {
+		fmt.Fprintln(r.w, r.paint(colorFaint, strings.TrimRight(sql, "\n")))
+	}
+}
+
+// Finish prints a line with the outcome and the elapsed time of the migration.
+func (r *Reporter) Finish(revision int, name, direction string, elapsed time.Duration, err error) {
+	if err != nil {
+		fmt.Fprintf(r.w, "%s %s: %s failed after %s\n", r.paint(colorRed, "✗"), label(revision, direction), name, round(elapsed))
+		return
+	}
+	fmt.Fprintf(r.w, "%s %s: %s %s\n", r.paint(colorGreen, "✓"), label(revision, direction), name, r.paint(colorFaint, "("+round(elapsed).String()+")"))
+}
+
+// Summary prints the summary of the migrations that were applied or rolled back.
+func (r *Reporter) Summary(result Result) {
+	fmt.Fprintln(r.w, result)
+}
+
+// Wraps the text in the ANSI color if the output of the reporter is colored.
+func (r *Reporter) paint(color, text string) string {
+	if !r.color {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// Rounds durations to the millisecond unless they are shorter than a millisecond.
+func round(elapsed time.Duration) time.Duration {
+	if elapsed < time.Millisecond {
+		return elapsed
+	}
+	return elapsed.Round(time.Millisecond)
+}
+
+// Returns true if the writer is a terminal rather than a pipe or a file.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
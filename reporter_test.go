@@ -0,0 +1,82 @@
+package tidal
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReporter(t *testing.T) {
+	// A buffer is not a terminal so the output is not colored
+	buf := &bytes.Buffer{}
+	r := NewReporter(buf)
+	require.False(t, r.color)
+
+	r.Start(1, "create users", "up", "CREATE TABLE users (id integer);\n")
+	r.Finish(1, "create users", "up", 1234567*time.Nanosecond, nil)
+	r.Start(2, "bad sql", "up", "CREATE TABLEZ foo;")
+	r.Finish(2, "bad sql", "up", 500*time.Microsecond, errors.New("syntax error"))
+	r.Summary(Result{To: 1, Applied: []int{1}, Elapsed: 1500 * time.Millisecond})
+	require.Equal(t, "✓ revision 1 up: create users (1ms)\n✗ revision 2 up: bad sql failed after 500µs\napplied 1 migration(s), now at revision 1 in 1.5s\n", buf.String())
+
+	// Verbose reporters print the SQL before each migration
+	buf.Reset()
+	r = NewReporter(buf, ReporterOptions{Verbose: true})
+	r.Start(1, "create users", "down", "DROP TABLE users;\n")
+	r.Finish(1, "create users", "down", 2*time.Millisecond, nil)
+	require.Equal(t, "DROP TABLE users;\n✓ revision 1 down: create users (2ms)\n", buf.String())
+
+	// Colored output wraps the status and elapsed time in ANSI escape sequences
+	buf.Reset()
+	r = &Reporter{w: buf, color: true}
+	r.Finish(1, "create users", "up", 2*time.Millisecond, nil)
+	r.Finish(2, "bad sql", "up", 3*time.Millisecond, errors.New("syntax error"))
+	require.Equal(t, "\033[32m✓\033[0m revision 1 up: create users \033[2m(2ms)\033[0m\n\033[31m✗\033[0m revision 2 up: bad sql failed after 3ms\n", buf.String())
+}
+
+func TestReporterNoColor(t *testing.T) {
+	// Files that are not terminals are never colored
+	f, err := os.Create(t.TempDir() + "/progress.log")
+	require.NoError(t, err)
+	defer f.Close()
+	require.False(t, isTerminal(f))
+	require.False(t, NewReporter(f).color)
+
+	// A terminal is colored unless NO_COLOR or the NoColor option is specified
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		t.Skip("no terminal is available")
+	}
+	defer tty.Close()
+	require.True(t, isTerminal(tty))
+	require.False(t, NewReporter(tty, ReporterOptions{NoColor: true}).color)
+
+	if _, ok := os.LookupEnv("NO_COLOR"); !ok {
+		require.True(t, NewReporter(tty).color)
+		os.Setenv("NO_COLOR", "1")
+		defer os.Unsetenv("NO_COLOR")
+		require.False(t, NewReporter(tty).color)
+	}
+}
+
+func TestReporterMigrate(t *testing.T) {
+	defer Reset()
+	defer SetLogger(nil)
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	buf := &bytes.Buffer{}
+	SetLogger(NewReporter(buf))
+
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	require.NoError(t, Migrate(conn, -1))
+	require.Regexp(t, `^✓ revision 1 up: create users \(.+\)\n$`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, Rollback(conn, 0))
+	require.Regexp(t, `^✓ revision 1 down: create users \(.+\)\n$`, buf.String())
+}
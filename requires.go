@@ -0,0 +1,160 @@
+package tidal
+
+import (
+	"fmt"
+)
+
+// Verifies the migrations and returns them in the order they must be applied, see order.
+func ordered(migrations []Migration) (_ []Migration, err error) {
+	if err = verify(migrations); err != nil {
+		return nil, err
+	}
+	return order(migrations)
+}
+
+// Returns the migrations, which must be sorted by revision, in the order they must be
+// applied so that every migration is applied after the revisions it requires. Of the
+// migrations whose dependencies have been satisfied, the lowest revision is always
+// applied next, so migrations without requires directives are applied in revision
+// order. An error is returned if a required revision is not registered or if the
+// dependencies of the migrations are circular.
+func order(migrations []Migration) (_ []Migration, err error) {
+	// Count the dependencies of each migration and find the migrations that depend on it
+	index := make(map[int]int, len(migrations))
+	for i, m := range migrations {
+		index[m.Revision] = i
+	}
+
+	pending := make([]int, len(migrations))
+	dependents := make([][]int, len(migrations))
+	for i, m := range migrations {
+		// Migrations without a descriptor (e.g. when testing) have no dependencies
+		if len(m.descriptor) == 0 {
+			continue
+		}
+
+		var requires []int
+		if requires, err = m.descriptor.Requires(); err != nil {
+			return nil, fmt.Errorf("revision %d: %s", m.Revision, err)
+		}
+
+		seen := make(map[int]bool)
+		for _, revision := range requires {
+			dep, ok := resolve(migrations, index, revision)
+			if !ok {
+				return nil, fmt.Errorf("revision %d requires revision %d which is not registered", m.Revision, revision)
+			}
+
+			if seen[dep] {
+				continue
+			}
+
+			seen[dep] = true
+			pending[i]++
+			dependents[dep] = append(dependents[dep], i)
+		}
+	}
+
+	// Repeatedly apply the lowest revision whose dependencies have all been applied
+	sorted := make([]Migration, 0, len(migrations))
+	done := make([]bool, len(migrations))
+	for len(sorted) < len(migrations) {
+		next := -1
+		for i := range migrations {
+			if !done[i] && pending[i] == 0 {
+				next = i
+				break
+			}
+		}
+
+		if next < 0 {
+			var cycle []int
+			for i, m := range migrations {
+				if !done[i] {
+					cycle = append(cycle, m.Revision)
+				}
+			}
+			return nil, fmt.Errorf("circular dependencies between revision(s) %s", joinInts(cycle))
+		}
+
+		done[next] = true
+		sorted = append(sorted, migrations[next])
+		for _, i := range dependents[next] {
+			pending[i]--
+		}
+	}
+	return sorted, nil
+}
+
+// Returns the index of the migration that applies the required revision, which is the
+// squashed migration that replaced it if the revision was squashed.
+func resolve(migrations []Migration, index map[int]int, revision int) (i int, ok bool) {
+	if i, ok = index[revision]; ok {
+		return i, true
+	}
+
+	for i, m := range migrations {
+		if from, ok := m.Squashed(); ok && revision >= from && revision < m.Revision {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Returns the revisions of the registered migrations that apply the revisions required
+// by the migration, taking squashed migrations into account.
+func required(migrations []Migration, m Migration) (revisions []int) {
+	index := make(map[int]int, len(migrations))
+	for i, m := range migrations {
+		index[m.Revision] = i
+	}
+
+	for _, revision := range m.Requires() {
+		if i, ok := resolve(migrations, index, revision); ok {
+			revisions = append(revisions, migrations[i].Revision)
+		}
+	}
+	return revisions
+}
+
+// Returns an error if the migration requires a revision after the target revision that
+// has not been applied, since migrating to the target does not apply it.
+func checkRequired(migrations []Migration, m Migration, status map[int]*record, target int) error {
+	if target < 0 {
+		return nil
+	}
+
+	for _, revision := range required(migrations, m) {
+		if row, ok := status[revision]; revision > target && (!ok || !row.active) {
+			return fmt.Errorf("revision %d requires revision %d which is after the target revision %d", m.Revision, revision, target)
+		}
+	}
+	return nil
+}
+
+// Returns an error if rolling back the migration to the target revision would leave an
+// active migration whose required revisions are no longer applied.
+func checkRequiredBy(migrations []Migration, m Migration, active map[int]bool, target int) error {
+	for _, dependent := range migrations {
+		if dependent.Revision > target || !active[dependent.Revision] {
+			continue
+		}
+
+		for _, revision := range required(migrations, dependent) {
+			if revision == m.Revision {
+				return fmt.Errorf("cannot roll back revision %d: revision %d requires it and would remain applied", m.Revision, dependent.Revision)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the first revision required by the migration that failed to be applied.
+func failedRequirement(migrations []Migration, m Migration, failed map[int]bool) (revision int, ok bool) {
+	for _, revision = range required(migrations, m) {
+		if failed[revision] {
+			return revision, true
+		}
+	}
+	return 0, false
+}
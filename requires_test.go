@@ -0,0 +1,89 @@
+package tidal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrder(t *testing.T) {
+	defer Reset()
+
+	// Revision 2 requires revision 4, which in turn requires revision 3
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_admins.sql", "-- migrate: requires 4\nCREATE VIEW admins AS SELECT * FROM roles;", "DROP VIEW admins;")
+	registerTestMigration(t, "0003_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	registerTestMigration(t, "0004_create_roles.sql", "-- migrate: requires 3\nCREATE TABLE roles (id integer, group_id integer);", "DROP TABLE roles;")
+	registerTestMigration(t, "0005_create_perms.sql", "CREATE TABLE perms (id integer);", "DROP TABLE perms;")
+	require.NoError(t, Verify())
+
+	migrations, err := order(Migrations())
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 3, 4, 2, 5}, revisions(migrations))
+	require.Equal(t, []int{4}, migrations[3].Requires())
+	require.Empty(t, migrations[4].Requires())
+
+	// A revision that is not registered cannot be required
+	registerTestMigration(t, "0006_create_tokens.sql", "-- migrate: requires 9\nCREATE TABLE tokens (id integer);", "DROP TABLE tokens;")
+	require.EqualError(t, Verify(), "revision 6 requires revision 9 which is not registered")
+	Reset()
+
+	// Circular dependencies cannot be ordered
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "-- migrate: requires 3\nCREATE TABLE groups (id integer);", "DROP TABLE groups;")
+	registerTestMigration(t, "0003_create_roles.sql", "-- migrate: requires 2\nCREATE TABLE roles (id integer);", "DROP TABLE roles;")
+	require.EqualError(t, Verify(), "circular dependencies between revision(s) 2, 3")
+	require.EqualError(t, Migrate(openTestDB(t), -1), "circular dependencies between revision(s) 2, 3")
+}
+
+func TestMigrateRequires(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	// The admins view cannot be created before the roles table it selects from
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_admins.sql", "-- migrate: requires 3\nCREATE VIEW admins AS SELECT * FROM roles;", "DROP VIEW admins;")
+	registerTestMigration(t, "0003_create_roles.sql", "CREATE TABLE roles (id integer);", "DROP TABLE roles;")
+
+	steps, err := PlanMigrate(conn, -1)
+	require.NoError(t, err)
+	require.Len(t, steps, 3)
+	require.Equal(t, []int{1, 3, 2}, []int{steps[0].Revision, steps[1].Revision, steps[2].Revision})
+
+	// Migrating to a revision before the required revision is an error
+	err = Migrate(conn, 2)
+	require.EqualError(t, err, "revision 2 requires revision 3 which is after the target revision 2")
+	require.NoError(t, Migrate(conn, 1))
+
+	require.NoError(t, Migrate(conn, -1))
+	revision, err := CurrentRevision(conn)
+	require.NoError(t, err)
+	require.Equal(t, 3, revision)
+
+	revisions, err := OutOfOrder(conn)
+	require.NoError(t, err)
+	require.Empty(t, revisions)
+
+	// The required revision cannot be rolled back without its dependents
+	err = Rollback(conn, 2)
+	require.EqualError(t, err, "cannot roll back revision 3: revision 2 requires it and would remain applied")
+
+	// Dependents are rolled back before the revisions they require
+	require.NoError(t, Rollback(conn, 1))
+	active, err := readActive(context.Background(), conn)
+	require.NoError(t, err)
+	require.True(t, active[1])
+	require.False(t, active[2])
+	require.False(t, active[3])
+}
+
+// Returns the revisions of the migrations in order.
+func revisions(migrations []Migration) []int {
+	revisions := make([]int, 0, len(migrations))
+	for _, m := range migrations {
+		revisions = append(revisions, m.Revision)
+	}
+	return revisions
+}
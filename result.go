@@ -0,0 +1,69 @@
+package tidal
+
+import (
+	"fmt"
+	"time"
+)
+
+// Result summarizes the migrations that were applied by MigrateResult or rolled back by
+// RollbackResult. The result is populated even if an error is returned, in which case
+// it describes the migrations that succeeded before the failure and the revision that
+// the database was left at.
+type Result struct {
+	From       int           // the current revision of the database before migrating
+	To         int           // the current revision of the database after migrating
+	Applied    []int         // the revisions that were applied, in the order they were applied
+	RolledBack []int         // the revisions that were rolled back, in the order they were rolled back
+	Skipped    []int         // the revisions that were already in the requested state
+	Repeated   []string      // the names of the repeatable migrations that were applied
+	Elapsed    time.Duration // the total time it took to migrate the database
+}
+
+// String returns a summary of the result, e.g. applied 3 migration(s), now at revision 7
+// in 1.2s.
+func (r Result) String() string {
+	elapsed := round(r.Elapsed)
+	repeated := ""
+	if len(r.Repeated) > 0 {
+		repeated = fmt.Sprintf(" and %d repeatable migration(s)", len(r.Repeated))
+	}
+
+	switch {
+	case len(r.Applied) > 0:
+		return fmt.Sprintf("applied %d migration(s)%s, now at revision %d in %s", len(r.Applied), repeated, r.To, elapsed)
+	case len(r.Repeated) > 0:
+		return fmt.Sprintf("applied %d repeatable migration(s), database is at revision %d in %s", len(r.Repeated), r.To, elapsed)
+	case len(r.RolledBack) > 0:
+		return fmt.Sprintf("rolled back %d migration(s), now at revision %d in %s", len(r.RolledBack), r.To, elapsed)
+	default:
+		return fmt.Sprintf("nothing to migrate, database is at revision %d", r.To)
+	}
+}
+
+// Records that the revision was applied, updating the current revision.
+func (r *Result) applied(revision int) {
+	r.Applied = append(r.Applied, revision)
+	if revision > r.To {
+		r.To = revision
+	}
+}
+
+// Returns the highest active revision in the migrations table.
+func activeRevision(status map[int]*record) (revision int) {
+	for rev, row := range status {
+		if row.active && rev > revision {
+			revision = rev
+		}
+	}
+	return revision
+}
+
+// Returns the highest revision that is active.
+func maxActive(active map[int]bool) (revision int) {
+	for rev, ok := range active {
+		if ok && rev > revision {
+			revision = rev
+		}
+	}
+	return revision
+}
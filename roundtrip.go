@@ -0,0 +1,186 @@
+package tidal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Queries that select a sorted description of each table and column, index, view, and
+// trigger in the current schema excluding the table bound to the first placeholder.
+const (
+	postgresSnapshotSQL = `SELECT 'column ' || table_name || '.' || column_name || ' ' || data_type || ' ' || is_nullable || ' ' || COALESCE(column_default, '') FROM information_schema.columns WHERE table_schema=current_schema() AND table_name<>$1
+UNION ALL SELECT 'index ' || indexdef FROM pg_indexes WHERE schemaname=current_schema() AND tablename<>$1
+UNION ALL SELECT 'view ' || table_name || ' ' || COALESCE(view_definition, '') FROM information_schema.views WHERE table_schema=current_schema()
+UNION ALL SELECT 'trigger ' || trigger_name || ' ' || event_object_table || ' ' || event_manipulation FROM information_schema.triggers WHERE trigger_schema=current_schema()
+ORDER BY 1`
+
+	mysqlSnapshotSQL = `SELECT CONCAT('column ', table_name, '.', column_name, ' ', column_type, ' ', is_nullable, ' ', COALESCE(column_default, '')) FROM information_schema.columns WHERE table_schema=DATABASE() AND table_name<>$1
+UNION ALL SELECT CONCAT('index ', table_name, '.', index_name, ' ', seq_in_index, ' ', column_name, ' ', non_unique) FROM information_schema.statistics WHERE table_schema=DATABASE() AND table_name<>$1
+UNION ALL SELECT CONCAT('trigger ', trigger_name, ' ', event_object_table, ' ', event_manipulation) FROM information_schema.triggers WHERE trigger_schema=DATABASE()
+ORDER BY 1`
+
+	sqliteSnapshotSQL = `SELECT type || ' ' || name || ' ' || COALESCE(sql, '') FROM sqlite_master WHERE tbl_name<>$1 AND name NOT LIKE 'sqlite_%' ORDER BY 1`
+)
+
+// RoundTripOptions modify the default behavior of TestRoundTrip.
+type RoundTripOptions struct {
+	// CompareSchema snapshots the tables, columns, indexes, views, and triggers of the
+	// database before each migration is applied and after it is rolled back and returns
+	// a SchemaMismatchError if they differ, e.g. if the down SQL does not drop an index
+	// that is created by the up SQL. Only the Postgres, MySQL, and SQLite dialects can
+	// snapshot the schema.
+	CompareSchema bool
+
+	// Recursive includes the migration files in subdirectories of the migrations
+	// directory, see RegisterOptions.
+	Recursive bool
+}
+
+// TestRoundTrip checks that the down SQL of each migration in the migrations directory
+// reverses its up SQL by applying each migration in revision order, immediately rolling
+// it back, then applying it again, which fails if, e.g., the down SQL does not drop a
+// table that the up SQL creates so the second up cannot create it again. Irreversible
+// migrations are only applied and repeatable migrations are not tested since they are
+// never rolled back.
+//
+// The migrations are applied to conn, which must be a disposable database with no
+// migrations applied since every migration is left applied once the test succeeds.
+// The migrations are registered with a new registry rather than the default registry.
+func TestRoundTrip(conn *sql.DB, dir string, opts ...RoundTripOptions) (err error) {
+	var opt RoundTripOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	registry := &Registry{}
+	if err = registry.RegisterDir(dir, RegisterOptions{Recursive: opt.Recursive}); err != nil {
+		return err
+	}
+
+	// Repeatable migrations would be applied with the latest revision but not rolled back
+	registry.repeatable = nil
+	migrations := registry.registered()
+	if len(migrations) == 0 {
+		return fmt.Errorf("no migrations found in %q", dir)
+	}
+
+	var current int
+	if current, err = CurrentRevision(conn); err != nil && !errors.Is(err, ErrUninitialized) {
+		return err
+	}
+
+	if current > 0 {
+		return fmt.Errorf("database is at revision %d, round trip tests require a database with no migrations applied", current)
+	}
+
+	ctx := context.Background()
+	previous := 0
+	for _, m := range migrations {
+		var before []string
+		if opt.CompareSchema {
+			if before, err = snapshot(ctx, conn); err != nil {
+				return err
+			}
+		}
+
+		if err = registry.Migrate(conn, m.Revision); err != nil {
+			return fmt.Errorf("round trip of revision %d failed to apply: %w", m.Revision, err)
+		}
+
+		if m.Irreversible() {
+			previous = m.Revision
+			continue
+		}
+
+		if err = registry.Rollback(conn, previous); err != nil {
+			return fmt.Errorf("round trip of revision %d failed to roll back: %w", m.Revision, err)
+		}
+
+		if opt.CompareSchema {
+			var after []string
+			if after, err = snapshot(ctx, conn); err != nil {
+				return err
+			}
+
+			if mismatch := compareSchema(m.Revision, before, after); mismatch != nil {
+				return mismatch
+			}
+		}
+
+		if err = registry.Migrate(conn, m.Revision); err != nil {
+			return fmt.Errorf("round trip of revision %d failed to apply after it was rolled back: %w", m.Revision, err)
+		}
+		previous = m.Revision
+	}
+	return nil
+}
+
+// Returns the sorted description of the schema of the database for the current dialect,
+// excluding the migrations table whose rows are modified by every migration.
+func snapshot(ctx context.Context, conn *sql.DB) (schema []string, err error) {
+	// MySQL placeholders are positional so the table name is bound once per use
+	query, args := "", []interface{}{TableName()}
+	switch dialect.Name() {
+	case Postgres.Name():
+		query = postgresSnapshotSQL
+	case MySQL.Name():
+		query = mysqlSnapshotSQL
+		args = append(args, TableName())
+	case SQLite.Name():
+		query = sqliteSnapshotSQL
+	default:
+		return nil, fmt.Errorf("cannot compare the schema of %s databases", dialect.Name())
+	}
+
+	var rows *sql.Rows
+	if rows, err = conn.QueryContext(ctx, bind(query), args...); err != nil {
+		return nil, fmt.Errorf("could not snapshot schema: %s", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var object string
+		if err = rows.Scan(&object); err != nil {
+			return nil, fmt.Errorf("could not snapshot schema: %s", err)
+		}
+		schema = append(schema, object)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not snapshot schema: %s", err)
+	}
+	return schema, nil
+}
+
+// Returns a SchemaMismatchError if the schema after the revision was rolled back is not
+// the same as the schema before it was applied, otherwise nil.
+func compareSchema(revision int, before, after []string) *SchemaMismatchError {
+	counts := make(map[string]int, len(before))
+	for _, object := range before {
+		counts[object]++
+	}
+	for _, object := range after {
+		counts[object]--
+	}
+
+	mismatch := &SchemaMismatchError{Revision: revision}
+	for object, count := range counts {
+		for ; count > 0; count-- {
+			mismatch.Missing = append(mismatch.Missing, object)
+		}
+		for ; count < 0; count++ {
+			mismatch.Remaining = append(mismatch.Remaining, object)
+		}
+	}
+
+	if len(mismatch.Missing) == 0 && len(mismatch.Remaining) == 0 {
+		return nil
+	}
+
+	sort.Strings(mismatch.Missing)
+	sort.Strings(mismatch.Remaining)
+	return mismatch
+}
@@ -0,0 +1,87 @@
+package tidal
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTripMigrations(t *testing.T) {
+	writeMigrations := func(files map[string]string) string {
+		dir := t.TempDir()
+		for name, src := range files {
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644))
+		}
+		return dir
+	}
+
+	dir := writeMigrations(map[string]string{
+		"0001_create_users.sql":  "-- migrate: up\nCREATE TABLE users (id integer, email text);\n-- migrate: down\nDROP TABLE users;\n",
+		"0002_users_email.sql":   "-- migrate: up\nCREATE INDEX users_email ON users (email);\n-- migrate: down\nDROP INDEX users_email;\n",
+		"0003_seed_users.sql":    "-- migrate: up\nINSERT INTO users (id) VALUES (1);\n",
+		"R_user_emails.sql":      "CREATE VIEW user_emails AS SELECT email FROM users;\n",
+		"0004_create_groups.sql": "-- migrate: up\nCREATE TABLE groups (id integer);\n-- migrate: down\nDROP TABLE groups;\n",
+	})
+
+	// Every migration is left applied after the round trip
+	conn := openTestDB(t)
+	defer conn.Close()
+	require.NoError(t, TestRoundTrip(conn, dir, RoundTripOptions{CompareSchema: true}))
+	current, err := CurrentRevision(conn)
+	require.NoError(t, err)
+	require.Equal(t, 4, current)
+	require.Empty(t, Migrations(), "the default registry must not be modified")
+
+	// Repeatable migrations are not applied during the round trip
+	_, err = conn.Exec("SELECT email FROM user_emails")
+	require.Error(t, err)
+
+	// The database must not have any migrations applied
+	err = TestRoundTrip(conn, dir)
+	require.EqualError(t, err, "database is at revision 4, round trip tests require a database with no migrations applied")
+
+	// A down migration that does not reverse the up migration fails the second up
+	dir = writeMigrations(map[string]string{
+		"0001_create_users.sql": "-- migrate: up\nCREATE TABLE users (id integer);\n-- migrate: down\nSELECT 1;\n",
+	})
+
+	conn = openTestDB(t)
+	defer conn.Close()
+	err = TestRoundTrip(conn, dir)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "round trip of revision 1 failed to apply after it was rolled back")
+
+	// Schema differences that do not cause errors are found by comparing the schema
+	dir = writeMigrations(map[string]string{
+		"0001_create_users.sql": "-- migrate: up\nCREATE TABLE users (id integer, email text);\n-- migrate: down\nDROP TABLE users;\n",
+		"0002_users_email.sql":  "-- migrate: up\nCREATE INDEX IF NOT EXISTS users_email ON users (email);\n-- migrate: down\nSELECT 1;\n",
+	})
+
+	conn = openTestDB(t)
+	defer conn.Close()
+	require.NoError(t, TestRoundTrip(conn, dir))
+
+	conn = openTestDB(t)
+	defer conn.Close()
+	err = TestRoundTrip(conn, dir, RoundTripOptions{CompareSchema: true})
+	require.True(t, errors.Is(err, ErrSchemaMismatch))
+
+	var mismatch *SchemaMismatchError
+	require.True(t, errors.As(err, &mismatch))
+	require.Equal(t, 2, mismatch.Revision)
+	require.Empty(t, mismatch.Missing)
+	require.Equal(t, []string{"index users_email CREATE INDEX users_email ON users (email)"}, mismatch.Remaining)
+	require.EqualError(t, err, "rolling back revision 2 did not restore the schema:\n+ index users_email CREATE INDEX users_email ON users (email)")
+}
+
+func TestCompareSchema(t *testing.T) {
+	require.Nil(t, compareSchema(1, []string{"table users"}, []string{"table users"}))
+
+	mismatch := compareSchema(2, []string{"table users", "index a", "index a"}, []string{"index a", "table users", "table groups"})
+	require.NotNil(t, mismatch)
+	require.Equal(t, []string{"index a"}, mismatch.Missing)
+	require.Equal(t, []string{"table groups"}, mismatch.Remaining)
+}
@@ -0,0 +1,82 @@
+package tidal
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// MigrateAllOptions modify the default behavior of MigrateAll.
+type MigrateAllOptions struct {
+	// MigrateOptions are used to migrate each of the databases, see Migrate.
+	MigrateOptions
+
+	// Concurrency is the maximum number of databases that are migrated at the same
+	// time (1 if 0, so the databases are migrated one after another).
+	Concurrency int
+}
+
+// MigrateAll applies the registered migrations up to and including the target revision
+// (use -1 to apply all registered migrations) to every database, e.g. to the shards of
+// a sharded application that all share the same schema. Each database maintains its
+// own migrations table and is migrated as described by Migrate. All of the databases
+// must use the current dialect since it is configured per process, see Registry.
+//
+// A failure on one shard does not stop the other shards from being migrated. If any of
+// the shards fail, a ShardError is returned that reports which shards succeeded and the
+// error of each shard that failed; since each database tracks its own migrations, the
+// failed shards can be retried by calling MigrateAll with only their connections.
+func MigrateAll(conns []*sql.DB, target int, opts ...MigrateAllOptions) (err error) {
+	return DefaultRegistry.MigrateAll(conns, target, opts...)
+}
+
+// MigrateAll applies the migrations in the registry to every database, see MigrateAll.
+func (r *Registry) MigrateAll(conns []*sql.DB, target int, opts ...MigrateAllOptions) (err error) {
+	var opt MigrateAllOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	if opt.Concurrency < 1 {
+		opt.Concurrency = 1
+	}
+
+	// Verify the registry once rather than reporting the same error for every shard
+	if _, err = ordered(r.registered()); err != nil {
+		return err
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs = make(map[int]error)
+		sem  = make(chan struct{}, opt.Concurrency)
+	)
+
+	for i, conn := range conns {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(shard int, conn *sql.DB) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := r.Migrate(conn, target, opt.MigrateOptions); err != nil {
+				mu.Lock()
+				errs[shard] = err
+				mu.Unlock()
+			}
+		}(i, conn)
+	}
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	serr := &ShardError{Failed: errs}
+	for i := range conns {
+		if _, ok := errs[i]; !ok {
+			serr.Succeeded = append(serr.Succeeded, i)
+		}
+	}
+	return serr
+}
@@ -0,0 +1,57 @@
+package tidal
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateAll(t *testing.T) {
+	defer Reset()
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")
+	registerTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")
+
+	shards := make([]*sql.DB, 0, 4)
+	for i := 0; i < 4; i++ {
+		conn := openTestDB(t)
+		defer conn.Close()
+		shards = append(shards, conn)
+	}
+
+	// Shard 2 already has a groups table so its second migration fails
+	_, err := shards[2].Exec("CREATE TABLE groups (id integer)")
+	require.NoError(t, err)
+
+	err = MigrateAll(shards, -1, MigrateAllOptions{Concurrency: 2})
+	var serr *ShardError
+	require.True(t, errors.As(err, &serr))
+	require.Equal(t, []int{0, 1, 3}, serr.Succeeded)
+	require.Equal(t, []int{2}, serr.FailedShards())
+	require.Contains(t, serr.Failed[2].Error(), "migration to revision 2 failed")
+	require.Contains(t, err.Error(), "migration failed on 1 of 4 shard(s): shard 2: ")
+
+	// Each shard maintains its own migrations table
+	for i, conn := range shards {
+		revision, err := CurrentRevision(conn)
+		require.NoError(t, err)
+		if i == 2 {
+			require.Equal(t, 1, revision)
+		} else {
+			require.Equal(t, 2, revision)
+		}
+	}
+
+	// The failed shard can be retried once it has been fixed
+	_, err = shards[2].Exec("DROP TABLE groups")
+	require.NoError(t, err)
+	require.NoError(t, MigrateAll(shards[2:3], -1))
+	revision, err := CurrentRevision(shards[2])
+	require.NoError(t, err)
+	require.Equal(t, 2, revision)
+
+	// Invalid registries are reported once rather than for every shard
+	registerTestMigration(t, "0004_create_roles.sql", "CREATE TABLE roles (id integer);", "DROP TABLE roles;")
+	require.EqualError(t, MigrateAll(shards, -1), "missing revision(s) in registered migrations: 3")
+}
@@ -0,0 +1,253 @@
+package tidal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Marks the revisions that were squashed into a migration inactive when it is rolled back.
+const squashedStatusSQL = "UPDATE {table} SET active=$1, applied=NULL, elapsed=NULL WHERE revision>=$2 AND revision<$3"
+
+// Squash replaces the migration files of the revisions from through to in the migrations
+// directory with a single migration file, e.g. to speed up creating a fresh database when
+// the number of migrations has grown large. The squashed migration has the revision to
+// and concatenates the up SQL of the revisions in order and the down SQL in reverse
+// order; if any of the revisions is irreversible so is the squashed migration. The up
+// directive of the squashed migration is marked with the squash option, e.g.
+// -- migrate: up squash=1, which records that it is equivalent to the revisions from
+// through to. Databases that have applied all of the original revisions skip the
+// squashed migration, while fresh databases apply it in place of the originals.
+//
+// The original files are removed and the squashed migration is returned. Revisions that
+// are notransaction migrations, or that use any directive options, cannot be squashed
+// since their SQL cannot be safely combined with the other revisions.
+func Squash(dir string, from, to int) (m Migration, err error) {
+	if from < 1 || to <= from {
+		return m, fmt.Errorf("invalid squash range %d:%d, specify at least two revisions", from, to)
+	}
+
+	var migrations []Migration
+	if migrations, err = parseMigrations(dir, false); err != nil {
+		return m, err
+	}
+
+	squashed := make([]Migration, 0, to-from+1)
+	for _, migration := range migrations {
+		if migration.Revision >= from && migration.Revision <= to {
+			squashed = append(squashed, migration)
+		}
+	}
+
+	// Every revision in the range must be defined, or replaced by a squashed migration
+	prev := from - 1
+	for _, migration := range squashed {
+		if migration.Revision != prev+1 {
+			if start, ok := migration.Squashed(); !ok || start > prev+1 {
+				break
+			}
+		}
+		prev = migration.Revision
+	}
+
+	if prev != to {
+		return m, fmt.Errorf("revisions %d through %d are not all in %q", from, to, dir)
+	}
+
+	var pkg string
+	var up, down strings.Builder
+	irreversible := false
+	for _, revision := range squashed {
+		if err = squashable(revision, from); err != nil {
+			return m, err
+		}
+
+		if pkg == "" {
+			if pkg, err = revision.Package(); err != nil {
+				return m, err
+			}
+		}
+
+		var upsql string
+		if upsql, err = revision.UpSQL(); err != nil {
+			return m, err
+		}
+		fmt.Fprintf(&up, "-- Revision %d: %s\n%s\n\n", revision.Revision, revision.Name, strings.TrimSpace(upsql))
+		irreversible = irreversible || revision.Irreversible()
+	}
+
+	// The down sql is concatenated in reverse order so that the revisions are rolled back
+	// in the same order as if they were rolled back individually.
+	for i := len(squashed) - 1; i >= 0; i-- {
+		var downsql string
+		if downsql, err = squashed[i].DownSQL(); err != nil {
+			return m, err
+		}
+
+		if downsql = strings.TrimSpace(downsql); downsql != "" {
+			fmt.Fprintf(&down, "-- Revision %d: %s\n%s\n\n", squashed[i].Revision, squashed[i].Name, downsql)
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "-- Squashed revisions %d through %d on %s\n", from, to, nowFunc().Local().Format("2006-01-02 15:04:05 -0700"))
+	if pkg != "" {
+		fmt.Fprintf(&sb, "-- package: %s\n", pkg)
+	}
+	fmt.Fprintf(&sb, "\n-- migrate: up squash=%d\n\n%s-- migrate: down\n", from, up.String())
+	if !irreversible {
+		fmt.Fprintf(&sb, "\n%s", down.String())
+	}
+
+	// Find the original files before the squashed migration is written
+	var originals []string
+	if originals, err = squashedFiles(dir, from, to); err != nil {
+		return m, err
+	}
+
+	outpath := filepath.Join(dir, fmt.Sprintf("%04d_squash_%d_to_%d.sql", to, from, to))
+	if err = ioutil.WriteFile(outpath, []byte(sb.String()), 0644); err != nil {
+		return m, err
+	}
+
+	for _, path := range originals {
+		if path == outpath {
+			continue
+		}
+
+		if err = os.Remove(path); err != nil {
+			return m, fmt.Errorf("could not remove squashed migration: %s", err)
+		}
+	}
+	return Open(outpath)
+}
+
+// Returns an error if the migration cannot be combined with the other revisions that
+// are being squashed into a migration starting at revision from.
+func squashable(m Migration, from int) (err error) {
+	for _, direction := range []string{"up", "down"} {
+		var opts []string
+		if opts, err = m.descriptor.Options(direction); err != nil {
+			return err
+		}
+
+		for _, opt := range opts {
+			if prev, ok := parseSquash(opt); ok && direction == "up" && prev >= from {
+				// Squashed migrations can be squashed again if they are inside the range
+				continue
+			}
+			return fmt.Errorf("revision %d cannot be squashed: the %s directive has the %s option", m.Revision, direction, opt)
+		}
+	}
+	return nil
+}
+
+// Returns the paths of the migration files in the directory whose revisions are from
+// through to, including both halves of split-file migrations.
+func squashedFiles(dir string, from, to int) (paths []string, err error) {
+	var filenames []string
+	if filenames, err = migrationFiles(os.DirFS(dir), ".", RegisterOptions{}); err != nil {
+		return nil, err
+	}
+
+	for _, filename := range filenames {
+		var revision int
+		if _, revision, err = parseFilename(filename); err != nil {
+			return nil, err
+		}
+
+		if revision >= from && revision <= to {
+			paths = append(paths, filepath.Join(dir, filename))
+		}
+	}
+	return paths, nil
+}
+
+// Squashed returns the first revision that was squashed into the migration by Squash and
+// true if the migration is a squashed migration. The migration is equivalent to the
+// revisions from the returned revision through its own revision.
+func (m *Migration) Squashed() (from int, ok bool) {
+	opts, err := m.descriptor.Options("up")
+	if err != nil {
+		return 0, false
+	}
+
+	for _, opt := range opts {
+		if from, ok = parseSquash(opt); ok && from < m.Revision {
+			return from, true
+		}
+	}
+	return 0, false
+}
+
+// Parses the squash=N directive option.
+func parseSquash(opt string) (from int, ok bool) {
+	if !strings.HasPrefix(opt, "squash=") {
+		return 0, false
+	}
+
+	var err error
+	if from, err = strconv.Atoi(strings.TrimPrefix(opt, "squash=")); err != nil || from < 1 {
+		return 0, false
+	}
+	return from, true
+}
+
+// Returns true if the revision is one of the original revisions of a squashed migration,
+// which are not registered but may still have rows in the migrations table.
+func squashedRevision(migrations []Migration, revision int) bool {
+	for _, m := range migrations {
+		if from, ok := m.Squashed(); ok && revision >= from && revision < m.Revision {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns an error if the squashed migration cannot be applied because some, but not
+// all, of the original revisions it replaces have been applied to the database. If all
+// of the original revisions were applied, the squashed migration is already active.
+func checkSquashed(m Migration, status map[int]*record) error {
+	from, ok := m.Squashed()
+	if !ok {
+		return nil
+	}
+
+	for revision := from; revision < m.Revision; revision++ {
+		if row, ok := status[revision]; ok && row.active {
+			return fmt.Errorf("revision %d squashes revisions %d through %d but revision %d has already been applied, apply the original migrations before squashing them", m.Revision, from, m.Revision, revision)
+		}
+	}
+	return nil
+}
+
+// Returns true if the database applied the original revisions of the squashed migration
+// rather than the squashed migration itself, in which case the checksum stored for the
+// revision is the checksum of the last original revision.
+func appliedOriginals(m Migration, status map[int]*record) bool {
+	from, ok := m.Squashed()
+	if !ok {
+		return false
+	}
+
+	_, ok = status[from]
+	return ok
+}
+
+// Marks the original revisions of the squashed migration inactive when it is rolled back.
+func (m *Migration) downSquashed(ctx context.Context, tx *sql.Tx) (err error) {
+	from, ok := m.Squashed()
+	if !ok {
+		return nil
+	}
+
+	if _, err = tx.ExecContext(ctx, bind(squashedStatusSQL), false, from, m.Revision); err != nil {
+		return fmt.Errorf("could not update migration status of revisions squashed into revision %d: %s", m.Revision, err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package tidal
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSquash(t *testing.T) {
+	defer Reset()
+	dir := t.TempDir()
+	writeMigration := func(filename, src string) {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, filename), []byte(src), 0644))
+	}
+
+	writeMigration("0001_create_users.sql", "-- migrate: up\nCREATE TABLE users (id integer);\n-- migrate: down\nDROP TABLE users;\n")
+	writeMigration("0002_create_groups.up.sql", "CREATE TABLE groups (id integer);\n")
+	writeMigration("0002_create_groups.down.sql", "DROP TABLE groups;\n")
+	writeMigration("0003_create_roles.sql", "-- migrate: up\nCREATE TABLE roles (id integer);\n-- migrate: down\nDROP TABLE roles;\n")
+
+	// A database that applied the original migrations before they were squashed
+	existing := openTestDB(t)
+	defer existing.Close()
+	require.NoError(t, RegisterDir(dir))
+	require.NoError(t, Migrate(existing, 2))
+
+	_, err := Squash(dir, 2, 2)
+	require.EqualError(t, err, "invalid squash range 2:2, specify at least two revisions")
+	_, err = Squash(dir, 2, 4)
+	require.EqualError(t, err, `revisions 2 through 4 are not all in "`+dir+`"`)
+
+	m, err := Squash(dir, 1, 2)
+	require.NoError(t, err)
+	require.Equal(t, 2, m.Revision)
+	require.Equal(t, "squash 1 to 2", m.Name)
+	from, ok := m.Squashed()
+	require.True(t, ok)
+	require.Equal(t, 1, from)
+
+	upsql, err := m.UpSQL()
+	require.NoError(t, err)
+	require.Equal(t, "\n-- Revision 1: create users\nCREATE TABLE users (id integer);\n\n-- Revision 2: create groups\nCREATE TABLE groups (id integer);\n\n", upsql)
+	downsql, err := m.DownSQL()
+	require.NoError(t, err)
+	require.Equal(t, "\n-- Revision 2: create groups\nDROP TABLE groups;\n\n-- Revision 1: create users\nDROP TABLE users;\n\n", downsql)
+
+	files, err := migrationFiles(os.DirFS(dir), ".", RegisterOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"0002_squash_1_to_2.sql", "0003_create_roles.sql"}, files)
+
+	// The existing database skips the squashed migration
+	require.NoError(t, Reset())
+	require.NoError(t, RegisterDir(dir))
+	require.NoError(t, Migrate(existing, -1))
+	status, err := Status(existing)
+	require.NoError(t, err)
+	require.Len(t, status, 2)
+	require.True(t, status[0].Active)
+	require.True(t, status[1].Active)
+
+	// Rolling back the squashed migration marks the original revisions inactive
+	require.NoError(t, Rollback(existing, 0))
+	active, err := readActive(context.Background(), existing)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: false, 2: false, 3: false}, active)
+
+	// A fresh database applies the squashed migration in place of the originals
+	fresh := openTestDB(t)
+	defer fresh.Close()
+	require.NoError(t, Migrate(fresh, -1))
+	active, err = readActive(context.Background(), fresh)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{2: true, 3: true}, active)
+
+	// A database that applied only some of the originals cannot apply the squashed migration
+	partial := openTestDB(t)
+	defer partial.Close()
+	_, err = partial.Exec("INSERT INTO migrations (revision, name, active) VALUES (1, 'create users', true)")
+	require.NoError(t, err)
+	err = Migrate(partial, -1)
+	require.EqualError(t, err, "revision 2 squashes revisions 1 through 2 but revision 1 has already been applied, apply the original migrations before squashing them")
+}
+
+func TestSquashable(t *testing.T) {
+	descriptor, err := NewDescriptor(strings.NewReader("-- migrate: up notransaction\nCREATE INDEX CONCURRENTLY foo ON bar (id);\n"), "0001_index.sql")
+	require.NoError(t, err)
+	require.EqualError(t, squashable(Migration{Revision: 1, descriptor: descriptor}, 1), "revision 1 cannot be squashed: the up directive has the notransaction option")
+
+	descriptor, err = NewDescriptor(strings.NewReader("-- migrate: up squash=2\nSELECT 1;\n"), "0004_squash_2_to_4.sql")
+	require.NoError(t, err)
+	m := Migration{Revision: 4, descriptor: descriptor}
+	require.NoError(t, squashable(m, 1))
+	require.Error(t, squashable(m, 3))
+}
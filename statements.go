@@ -0,0 +1,315 @@
+package tidal
+
+import (
+	"strings"
+)
+
+// The statement separator of the dialects supported by tidal.
+const defaultSeparator = ";"
+
+// Split SQL into individual statements on the separator of the current dialect.
+func splitStatements(sql string) []string {
+	return splitSeparator(sql, dialect.Separator())
+}
+
+// Split SQL into individual statements or batches on the separator, e.g. a semicolon.
+// The splitter is aware of single quoted strings, double quoted and backtick quoted
+// identifiers, PostgreSQL dollar quoted strings (e.g. $$ ... $$ or $tag$ ... $tag$ in
+// function bodies), and both line (--) and block (/* */) comments, none of which are
+// split on. Comments are preserved as part of the statement that follows them. The
+// returned statements are trimmed of surrounding whitespace and do not include the
+// separator; chunks that only contain whitespace or comments are omitted.
+//
+// A separator that is a keyword, e.g. GO, only separates batches when it is on a line by
+// itself (in any case), so the batches may contain multiple statements that are
+// terminated by semicolons. Any other separator, e.g. // or ;, is a delimiter that
+// terminates a statement wherever it appears outside of quotes and comments.
+func splitSeparator(sql, sep string) (stmts []string) {
+	var (
+		sb      strings.Builder
+		content bool // if the current statement contains anything other than comments
+	)
+
+	if sep == "" {
+		sep = defaultSeparator
+	}
+	keyword := isKeyword(sep)
+
+	flush := func() {
+		if content {
+			stmts = append(stmts, strings.TrimSpace(sb.String()))
+		}
+		sb.Reset()
+		content = false
+	}
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+
+		// Batch separators must be on a line by themselves
+		if keyword && (i == 0 || sql[i-1] == '\n') {
+			j := strings.IndexByte(sql[i:], '\n')
+			if j < 0 {
+				j = len(sql) - i
+			}
+
+			if strings.EqualFold(strings.TrimSpace(sql[i:i+j]), sep) {
+				flush()
+				i += j
+				continue
+			}
+		}
+
+		switch {
+		case !keyword && strings.HasPrefix(sql[i:], sep):
+			flush()
+			i += len(sep) - 1
+			continue
+
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			// Line comment, consume until the end of the line
+			j := strings.IndexByte(sql[i:], '\n')
+			if j < 0 {
+				j = len(sql) - i
+			}
+			sb.WriteString(sql[i : i+j])
+			i += j - 1
+			continue
+
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			// Block comment, consume until the closing */
+			j := strings.Index(sql[i+2:], "*/")
+			if j < 0 {
+				j = len(sql) - i
+			} else {
+				j += 4
+			}
+			sb.WriteString(sql[i : i+j])
+			i += j - 1
+			continue
+
+		case c == '\'' || c == '"' || c == '`' || c == '$':
+			if n := quoted(sql, i); n > 0 {
+				sb.WriteString(sql[i : i+n])
+				i += n - 1
+				content = true
+				continue
+			}
+		}
+
+		sb.WriteByte(c)
+		if !isSpace(c) {
+			content = true
+		}
+	}
+
+	flush()
+	return stmts
+}
+
+// Returns the dollar quote tag that is still open at the end of the line, e.g. if the
+// line opens the body of a function, given the tag that was open at the start of the
+// line (or an empty string if the line did not start inside of a dollar quote). Quoted
+// strings and line comments that are outside of dollar quotes are skipped.
+func dollarQuote(line, tag string) string {
+	for i := 0; i < len(line); i++ {
+		if tag != "" {
+			j := strings.Index(line[i:], tag)
+			if j < 0 {
+				return tag
+			}
+			i += j + len(tag) - 1
+			tag = ""
+			continue
+		}
+
+		switch c := line[i]; {
+		case c == '-' && i+1 < len(line) && line[i+1] == '-':
+			return ""
+		case c == '\'' || c == '"':
+			if j := strings.IndexByte(line[i+1:], c); j >= 0 {
+				i += j + 1
+			} else {
+				return ""
+			}
+		case c == '$':
+			if t, ok := dollarTag(line[i:]); ok {
+				tag = t
+				i += len(t) - 1
+			}
+		}
+	}
+	return tag
+}
+
+// Returns the opening dollar quote tag, e.g. $$ or $body$ if s begins with one.
+func dollarTag(s string) (string, bool) {
+	for j := 1; j < len(s); j++ {
+		c := s[j]
+		switch {
+		case c == '$':
+			return s[:j+1], true
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			continue
+		case c >= '0' && c <= '9' && j > 1:
+			continue
+		default:
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// Returns the length of the single quoted string, the double quoted or backtick quoted
+// identifier, or the PostgreSQL dollar quoted string that starts at sql[i] including its
+// quotes, or 0 if there is not one, e.g. for a $1 placeholder. A doubled quote is an
+// escaped quote, as is a backslashed quote if backslashEscapes allows it. Quotes that
+// are not terminated extend to the end of the sql.
+func quoted(sql string, i int) int {
+	c := sql[i]
+	if c == '$' {
+		// Dollar quoted string, the tag must not start with a digit (e.g. $1)
+		tag, ok := dollarTag(sql[i:])
+		if !ok {
+			return 0
+		}
+
+		if j := strings.Index(sql[i+len(tag):], tag); j >= 0 {
+			return j + 2*len(tag)
+		}
+		return len(sql) - i
+	}
+
+	escapes := c == '\'' && backslashEscapes(sql, i)
+	for j := i + 1; j < len(sql); j++ {
+		switch {
+		case sql[j] == c && j+1 < len(sql) && sql[j+1] == c:
+			j++
+		case sql[j] == c:
+			return j - i + 1
+		case escapes && sql[j] == '\\':
+			j++
+		}
+	}
+	return len(sql) - i
+}
+
+// Returns true if a backslash escapes the next character of the single quoted string
+// that starts at sql[i]. MySQL strings always allow backslash escapes while standard
+// PostgreSQL and SQLite strings only allow them in escape strings, e.g. E'it\'s', so
+// that a string ending in a backslash such as 'C:\' is not read past its closing quote.
+func backslashEscapes(sql string, i int) bool {
+	if dialect.Name() == MySQL.Name() {
+		return true
+	}
+
+	if i == 0 || (sql[i-1] != 'E' && sql[i-1] != 'e') {
+		return false
+	}
+	return i == 1 || !isIdentifier(sql[i-2])
+}
+
+// Returns true if the byte can be part of an unquoted identifier or keyword.
+func isIdentifier(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// Returns true if the separator only contains letters, e.g. GO.
+func isKeyword(sep string) bool {
+	for i := 0; i < len(sep); i++ {
+		if c := sep[i]; !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return sep != ""
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+// Returns the sql with its line and block comments removed, except for the lines for
+// which keep returns true, e.g. directive comments, which are only kept if the line is
+// not inside of a comment, quoted string, or dollar quoted string. Lines that are blank
+// once their comments have been removed are omitted and trailing whitespace is trimmed
+// from every line; quoted strings, including newlines or comments within them, are
+// never modified.
+func removeComments(sql string, keep func(line string) bool) string {
+	out := make([]byte, 0, len(sql))
+
+	// Ends the current line, trimming trailing whitespace and omitting it if it is blank
+	start := 0
+	newline := func() {
+		for len(out) > start && isSpace(out[len(out)-1]) {
+			out = out[:len(out)-1]
+		}
+		if len(out) > start {
+			out = append(out, '\n')
+		}
+		start = len(out)
+	}
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+
+		// Keep entire lines that are directives
+		if i == 0 || sql[i-1] == '\n' {
+			j := strings.IndexByte(sql[i:], '\n')
+			if j < 0 {
+				j = len(sql) - i
+			}
+
+			if line := sql[i : i+j]; keep(line) {
+				out = append(out, line...)
+				newline()
+				i += j
+				continue
+			}
+		}
+
+		switch {
+		case c == '\n':
+			newline()
+			continue
+
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			// Line comment, skip until the end of the line
+			j := strings.IndexByte(sql[i:], '\n')
+			if j < 0 {
+				j = len(sql) - i
+			}
+			i += j - 1
+			continue
+
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			// Block comment, skip until the closing */ but keep any newlines so that
+			// the statements before and after the comment remain separated
+			j := strings.Index(sql[i+2:], "*/")
+			if j < 0 {
+				j = len(sql) - i
+			} else {
+				j += 4
+			}
+
+			if strings.IndexByte(sql[i:i+j], '\n') >= 0 {
+				newline()
+			} else {
+				out = append(out, ' ')
+			}
+			i += j - 1
+			continue
+
+		case c == '\'' || c == '"' || c == '`' || c == '$':
+			if n := quoted(sql, i); n > 0 {
+				out = append(out, sql[i:i+n]...)
+				i += n - 1
+				continue
+			}
+		}
+
+		out = append(out, c)
+	}
+
+	newline()
+	return string(out)
+}
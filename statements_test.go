@@ -0,0 +1,166 @@
+package tidal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitStatements(t *testing.T) {
+	testCases := []struct {
+		sql      string
+		expected []string
+	}{
+		{"", nil},
+		{"  \n-- just a comment\n", nil},
+		{"SELECT 1", []string{"SELECT 1"}},
+		{"SELECT 1; SELECT 2;\n", []string{"SELECT 1", "SELECT 2"}},
+		{"-- comment; not split\nSELECT 1;", []string{"-- comment; not split\nSELECT 1"}},
+		{"/* block; comment */ SELECT 1; SELECT 2", []string{"/* block; comment */ SELECT 1", "SELECT 2"}},
+		{"INSERT INTO a VALUES ('x;y', 'it''s;');", []string{"INSERT INTO a VALUES ('x;y', 'it''s;')"}},
+		{`SELECT "a;b", ` + "`c;d`" + `; SELECT 2`, []string{`SELECT "a;b", ` + "`c;d`", "SELECT 2"}},
+		{"UPDATE a SET b=$1; SELECT $2;", []string{"UPDATE a SET b=$1", "SELECT $2"}},
+		{
+			"CREATE FUNCTION f() RETURNS trigger AS $$\nBEGIN\n  -- migrate: down\n  RETURN NEW;\nEND;\n$$ LANGUAGE plpgsql;\nSELECT 1;",
+			[]string{"CREATE FUNCTION f() RETURNS trigger AS $$\nBEGIN\n  -- migrate: down\n  RETURN NEW;\nEND;\n$$ LANGUAGE plpgsql", "SELECT 1"},
+		},
+		{
+			"CREATE FUNCTION f() AS $body$ SELECT 'a;'; $body$; SELECT 1",
+			[]string{"CREATE FUNCTION f() AS $body$ SELECT 'a;'; $body$", "SELECT 1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.expected, splitStatements(tc.sql), "could not split %q", tc.sql)
+	}
+
+	// Backslashes only escape quotes in MySQL strings and in escape strings
+	trailing := `INSERT INTO t VALUES ('C:\', '-- keep me'); SELECT 'it\'s; ok'`
+	require.Equal(t, []string{`INSERT INTO t VALUES ('C:\', '-- keep me')`, `SELECT 'it\'s`, `ok'`}, splitStatements(trailing))
+	require.Equal(t, []string{`SELECT E'it\'s; ok'`, `SELECT e'\\'`, `SELECT 1`}, splitStatements(`SELECT E'it\'s; ok'; SELECT e'\\'; SELECT 1`))
+	require.Equal(t, []string{`SELECT type'\'`, `SELECT 1`}, splitStatements(`SELECT type'\'; SELECT 1`))
+
+	SetDialect(MySQL)
+	defer SetDialect(nil)
+	require.Equal(t, []string{`INSERT INTO t VALUES ('it\'s; ok')`, `SELECT 1`}, splitStatements(`INSERT INTO t VALUES ('it\'s; ok'); SELECT 1`))
+}
+
+func TestSplitSeparator(t *testing.T) {
+	// A mixed-delimiter file, only GO on a line by itself separates the batches
+	mixed := "CREATE TABLE users (id integer); CREATE TABLE roles (id integer);\nGO\n" +
+		"-- GO is not split in comments\nINSERT INTO users VALUES (1);\n  go  \n" +
+		"INSERT INTO notes VALUES ('\nGO\n');\n/*\nGO\n*/\nSELECT 1; GO\nGO"
+
+	testCases := []struct {
+		sql      string
+		sep      string
+		expected []string
+	}{
+		{"SELECT 1; SELECT 2", "", []string{"SELECT 1", "SELECT 2"}},
+		{mixed, "GO", []string{
+			"CREATE TABLE users (id integer); CREATE TABLE roles (id integer);",
+			"-- GO is not split in comments\nINSERT INTO users VALUES (1);",
+			"INSERT INTO notes VALUES ('\nGO\n');\n/*\nGO\n*/\nSELECT 1; GO",
+		}},
+		{"GO\n-- only a comment\nGO\n", "GO", nil},
+		{"SELECT 'a//b' // SELECT 2; SELECT 3 //\n-- // comment\n", "//", []string{"SELECT 'a//b'", "SELECT 2; SELECT 3"}},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.expected, splitSeparator(tc.sql, tc.sep), "could not split %q on %q", tc.sql, tc.sep)
+	}
+
+	// Statements are split on the separator of the current dialect
+	SetDialect(separatorDialect{sqlite{}, "GO"})
+	defer SetDialect(nil)
+	require.Equal(t, []string{"SELECT 1; SELECT 2", "SELECT 3"}, splitStatements("SELECT 1; SELECT 2\nGO\nSELECT 3"))
+	require.True(t, requiresSplit(dialect))
+	require.False(t, requiresSplit(SQLite))
+	require.True(t, requiresSplit(MySQL))
+}
+
+func TestMigrateSeparator(t *testing.T) {
+	defer Reset()
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	// The migration would fail if the GO separators were executed
+	SetDialect(separatorDialect{sqlite{}, "GO"})
+	defer SetDialect(nil)
+	registerTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer); CREATE TABLE roles (id integer);\nGO\nINSERT INTO users VALUES (1);", "DROP TABLE roles;\nGO\nDROP TABLE users;")
+	require.NoError(t, Migrate(conn, -1))
+
+	var count int
+	require.NoError(t, conn.QueryRow("SELECT COUNT(*) FROM users").Scan(&count))
+	require.Equal(t, 1, count)
+	require.NoError(t, Rollback(conn, 0))
+}
+
+func TestRemoveComments(t *testing.T) {
+	keep := func(line string) bool {
+		return migre.MatchString(line)
+	}
+
+	testCases := []struct {
+		sql      string
+		expected string
+	}{
+		{"", ""},
+		{"-- just a comment\n\n", ""},
+		{"SELECT 1; -- trailing comment\nSELECT 2;\n", "SELECT 1;\nSELECT 2;\n"},
+		{"SELECT 1 /* inline */ + 2;", "SELECT 1   + 2;\n"},
+		{"SELECT 1;\n/* a block\ncomment */\nSELECT 2;", "SELECT 1;\nSELECT 2;\n"},
+		{"SELECT 1;/* a block\ncomment */SELECT 2;", "SELECT 1;\nSELECT 2;\n"},
+		{"-- migrate: up\nSELECT 1;\n-- migrate: down\n", "-- migrate: up\nSELECT 1;\n-- migrate: down\n"},
+		{"SELECT '-- not a comment', 'it''s /* not */ either';", "SELECT '-- not a comment', 'it''s /* not */ either';\n"},
+		{`SELECT "a--b" FROM t; -- comment`, "SELECT \"a--b\" FROM t;\n"},
+		{"SELECT 'a\\'; -- b';", "SELECT 'a\\';\n"},
+		{"SELECT E'a\\'-- b';", "SELECT E'a\\'-- b';\n"},
+		{"INSERT INTO t VALUES ('C:\\', '-- keep me');", "INSERT INTO t VALUES ('C:\\', '-- keep me');\n"},
+		{"SELECT 'multi\n\n-- line';\n", "SELECT 'multi\n\n-- line';\n"},
+		{
+			"CREATE FUNCTION f() AS $$\nBEGIN\n  -- migrate: down\n\n  RETURN NEW; /* kept */\nEND;\n$$ LANGUAGE plpgsql; -- removed",
+			"CREATE FUNCTION f() AS $$\nBEGIN\n  -- migrate: down\n\n  RETURN NEW; /* kept */\nEND;\n$$ LANGUAGE plpgsql;\n",
+		},
+		{"UPDATE a SET b=$1; -- comment $$", "UPDATE a SET b=$1;\n"},
+	}
+
+	for i, tc := range testCases {
+		require.Equal(t, tc.expected, removeComments(tc.sql, keep), "test case %d", i)
+	}
+
+	// MySQL strings always allow backslash escapes
+	SetDialect(MySQL)
+	defer SetDialect(nil)
+	require.Equal(t, "SELECT 'a\\'-- b';\n", removeComments("SELECT 'a\\'-- b';", keep))
+}
+
+func TestDollarQuote(t *testing.T) {
+	testCases := []struct {
+		line     string
+		tag      string
+		expected string
+	}{
+		{"SELECT 1;", "", ""},
+		{"CREATE FUNCTION f() RETURNS trigger AS $$", "", "$$"},
+		{"CREATE FUNCTION f() AS $body$ SELECT 1; $body$;", "", ""},
+		{"  -- migrate: down", "$$", "$$"},
+		{"  msg text := '$$ is not the end';", "$body$", "$body$"},
+		{"$$ LANGUAGE plpgsql;", "$$", ""},
+		{"END; $body$ LANGUAGE plpgsql; SELECT $$", "$body$", "$$"},
+		{"SELECT '$$'; -- $$ in a comment", "", ""},
+		{"UPDATE a SET b=$1", "", ""},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.expected, dollarQuote(tc.line, tc.tag), "unexpected tag after %q", tc.line)
+	}
+}
+
+// Splits migrations on a custom separator, e.g. GO batches for SQL Server tools.
+type separatorDialect struct {
+	sqlite
+	sep string
+}
+
+func (d separatorDialect) Separator() string { return d.sep }
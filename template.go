@@ -0,0 +1,58 @@
+package tidal
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The variables that are substituted into template migrations, set using SetVariables.
+// If nil, variables are looked up in the environment.
+var variables map[string]string
+
+// Matches ${NAME} placeholders in template migrations.
+var varre = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// SetVariables specifies the values substituted for ${NAME} placeholders in the SQL of
+// migrations that are marked with the template option, e.g. -- migrate: up template.
+// By default (or if vars is nil) the placeholders are substituted from environment
+// variables. Substitution is opt-in per direction so that the SQL of other migrations
+// is never modified. The variables must be set before calling Migrate or Rollback.
+func SetVariables(vars map[string]string) {
+	variables = vars
+}
+
+// Substitutes the ${NAME} placeholders in the sql with the values of the variables,
+// returning an error that lists the names of any placeholders that are not defined.
+func substitute(sql string) (_ string, err error) {
+	lookup := os.LookupEnv
+	if variables != nil {
+		lookup = func(name string) (value string, ok bool) {
+			value, ok = variables[name]
+			return value, ok
+		}
+	}
+
+	missing := make(map[string]struct{})
+	sql = varre.ReplaceAllStringFunc(sql, func(s string) string {
+		name := s[2 : len(s)-1]
+		value, ok := lookup(name)
+		if !ok {
+			missing[name] = struct{}{}
+			return s
+		}
+		return value
+	})
+
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("undefined template variable(s): %s", strings.Join(names, ", "))
+	}
+	return sql, nil
+}
@@ -0,0 +1,63 @@
+package tidal
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubstitute(t *testing.T) {
+	defer SetVariables(nil)
+
+	os.Setenv("TIDAL_TEST_SCHEMA", "billing")
+	defer os.Unsetenv("TIDAL_TEST_SCHEMA")
+
+	sql, err := substitute("CREATE TABLE ${TIDAL_TEST_SCHEMA}.users (id integer); SELECT $1, $$body$$;")
+	require.NoError(t, err)
+	require.Equal(t, "CREATE TABLE billing.users (id integer); SELECT $1, $$body$$;", sql)
+
+	_, err = substitute("CREATE TABLE ${SCHEMA}.users (id integer) TABLESPACE ${TABLESPACE}; ${SCHEMA}")
+	require.EqualError(t, err, "undefined template variable(s): SCHEMA, TABLESPACE")
+
+	// Variables replace the environment rather than extending it
+	SetVariables(map[string]string{"SCHEMA": "public", "TABLESPACE": ""})
+	sql, err = substitute("CREATE TABLE ${SCHEMA}.users (id integer)${TABLESPACE};")
+	require.NoError(t, err)
+	require.Equal(t, "CREATE TABLE public.users (id integer);", sql)
+
+	_, err = substitute("${TIDAL_TEST_SCHEMA}")
+	require.EqualError(t, err, "undefined template variable(s): TIDAL_TEST_SCHEMA")
+}
+
+func TestTemplateMigration(t *testing.T) {
+	defer Reset()
+	defer SetVariables(nil)
+	conn := openTestDB(t)
+	defer conn.Close()
+
+	// Placeholders are only substituted in directions marked with the template option
+	src := "-- migrate: up template\nCREATE TABLE ${TABLE} (id integer);\n-- migrate: down\nDROP TABLE ${TABLE};\n"
+	descriptor, err := NewDescriptor(strings.NewReader(src), "0001_create_table.sql")
+	require.NoError(t, err)
+	require.NoError(t, RegisterDescriptor(descriptor))
+
+	err = Migrate(conn, -1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "could not parse revision 1 up sql: undefined template variable(s): TABLE")
+
+	SetVariables(map[string]string{"TABLE": "users"})
+	steps, err := PlanMigrate(conn, -1)
+	require.NoError(t, err)
+	require.Equal(t, "CREATE TABLE users (id integer);\n", steps[0].SQL)
+
+	require.NoError(t, Migrate(conn, -1))
+	_, err = conn.Exec("INSERT INTO users (id) VALUES (1)")
+	require.NoError(t, err)
+
+	m := registered()[0]
+	down, err := m.render("down")
+	require.NoError(t, err)
+	require.Equal(t, "DROP TABLE ${TABLE};\n", down)
+}
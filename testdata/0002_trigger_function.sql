@@ -0,0 +1,37 @@
+-- Creates a trigger function to track when rows in the users table are modified
+-- migrate: up
+
+ALTER TABLE users ADD COLUMN "modified" TIMESTAMP WITH TIME ZONE;
+
+CREATE OR REPLACE FUNCTION set_modified() RETURNS trigger AS $$
+BEGIN
+    -- migrate: down
+    -- the directive above is part of the function body and must not end the up migration
+    NEW.modified = now();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION audit_users() RETURNS trigger AS $body$
+DECLARE
+    msg text := 'user ' || NEW.id || ' modified; $$ is not the end of the body';
+BEGIN
+-- migrate: end
+    RAISE NOTICE '%', msg;
+    RETURN NEW;
+END;
+$body$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_modified BEFORE UPDATE ON users
+    FOR EACH ROW EXECUTE PROCEDURE set_modified();
+
+CREATE TRIGGER users_audit AFTER UPDATE ON users
+    FOR EACH ROW EXECUTE PROCEDURE audit_users();
+
+-- migrate: down
+
+DROP TRIGGER IF EXISTS users_audit ON users;
+DROP TRIGGER IF EXISTS users_modified ON users;
+DROP FUNCTION IF EXISTS audit_users();
+DROP FUNCTION IF EXISTS set_modified();
+ALTER TABLE users DROP COLUMN "modified";
@@ -0,0 +1,11 @@
+-- Adds an index on the email addresses of users without locking the users table
+-- against writes while the index is built. PostgreSQL cannot build an index
+-- concurrently inside of a transaction block, so the concurrently option executes
+-- each statement on its own and updates the migrations table once the index has been
+-- built and verified to be valid. If the build fails, drop the invalid index before
+-- applying the migration again.
+-- migrate: up concurrently
+CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS users_email ON users (email);
+
+-- migrate: down concurrently
+DROP INDEX CONCURRENTLY IF EXISTS users_email;
@@ -13,34 +13,105 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"sync"
 )
 
-// Contains all migrations that have been registered by the application as well as the
-// initial migration for creating the migrations database. Most migrations are added to
-// this data structure using the generated code registration functions. The tidal
-// package then manages the database with respect to these migrations.
-var migrations []Migration
+// Registry contains the migrations that have been registered by an application, which
+// tidal manages the database with respect to. Most applications use DefaultRegistry via
+// the package-level functions (e.g. Register, Migrate, and Status), which the generated
+// code registers migrations with. Independent registries allow multiple sets of
+// migrations to be managed in one process, e.g. for two databases of the same engine
+// that each have their own migrations, and allow tests to register migrations without
+// calling Reset. The zero value is an empty registry ready to use.
+//
+// A registry only holds the registered migrations. The dialect, the name and schema of
+// the migrations table, the logger, the clock, and the template variables are configured
+// per process (see SetDialect, SetTableName, SetTableSchema, SetLogger, SetClock, and
+// SetVariables) and are shared by every registry. Registries therefore cannot manage
+// databases that require different configurations, e.g. a PostgreSQL and a SQLite
+// database or migrations tables with different names; changing the configuration while
+// another registry is migrating is not supported.
+//
+// All access to migrations must be guarded by mu. Registration methods (Register,
+// RegisterDescriptor, RegisterFS, Reset) can be called concurrently from multiple
+// goroutines and concurrently with methods that read the registered migrations (e.g.
+// Migrate, Rollback, and Status), which operate on a snapshot of the registered
+// migrations taken when they are called.
+type Registry struct {
+	mu         sync.RWMutex
+	migrations []Migration
+	repeatable []Migration
+}
+
+// DefaultRegistry is the registry used by the package-level functions.
+var DefaultRegistry = &Registry{}
 
 // Register a migration to be managed by tidal. Note that although migrations can be
 // directly applied using the Migration interface, they must be registered in order to
 // preserve dependency order. It is highly recommended to register migrations and to
 // use the tidal migration interface rather than managing migrations manually.
+//
+// If a migration with the same revision is already registered, a DuplicateRevisionError
+// is returned that matches ErrDuplicateRevision using errors.Is; callers that register
+// the same migrations more than once (e.g. in tests) can ignore it to be idempotent.
+//
+// Repeatable migrations (see Migration.Repeatable) are registered separately from the
+// versioned migrations by name; registering a repeatable migration with the same name
+// as one that is already registered returns an error that matches ErrDuplicateRevision.
 func Register(m Migration) (err error) {
+	return DefaultRegistry.Register(m)
+}
+
+// Register a migration with the registry, see Register.
+func (r *Registry) Register(m Migration) (err error) {
+	if m.Repeatable() {
+		return r.registerRepeatable(m)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	// Maintain the migrations array sorted by revision id
-	i := sort.Search(len(migrations), func(i int) bool { return migrations[i].Revision >= m.Revision })
-	if i < len(migrations) && migrations[i].Revision == m.Revision {
-		return fmt.Errorf("cannot register migration with revision %d: revision already exists", m.Revision)
+	i := sort.Search(len(r.migrations), func(i int) bool { return r.migrations[i].Revision >= m.Revision })
+	if i < len(r.migrations) && r.migrations[i].Revision == m.Revision {
+		return &DuplicateRevisionError{Revision: m.Revision}
 	}
 
 	// Insort the migration into the migrations array
-	migrations = append(migrations, Migration{})
-	copy(migrations[i+1:], migrations[i:])
-	migrations[i] = m
+	r.migrations = append(r.migrations, Migration{})
+	copy(r.migrations[i+1:], r.migrations[i:])
+	r.migrations[i] = m
+	return nil
+}
+
+// Registers the repeatable migration, maintaining the repeatable migrations sorted by name.
+func (r *Registry) registerRepeatable(m Migration) (err error) {
+	if !m.Irreversible() {
+		return fmt.Errorf("cannot register repeatable migration %q: repeatable migrations cannot define down sql", m.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := sort.Search(len(r.repeatable), func(i int) bool { return r.repeatable[i].Name >= m.Name })
+	if i < len(r.repeatable) && r.repeatable[i].Name == m.Name {
+		return fmt.Errorf("cannot register repeatable migration %q: %w", m.Name, ErrDuplicateRevision)
+	}
+
+	r.repeatable = append(r.repeatable, Migration{})
+	copy(r.repeatable[i+1:], r.repeatable[i:])
+	r.repeatable[i] = m
 	return nil
 }
 
 // RegisterDescriptor creates a Migration from descriptor data and registers it.
 func RegisterDescriptor(data []byte) (err error) {
+	return DefaultRegistry.RegisterDescriptor(data)
+}
+
+// RegisterDescriptor creates a Migration from descriptor data and registers it with
+// the registry.
+func (r *Registry) RegisterDescriptor(data []byte) (err error) {
 	m := Migration{
 		descriptor: Descriptor(data),
 	}
@@ -54,19 +125,133 @@ func RegisterDescriptor(data []byte) (err error) {
 		return errors.New("descriptor data does not contain required header information")
 	}
 
+	if m.Name, m.repeatable = parseRepeatable(filename); m.repeatable {
+		return r.Register(m)
+	}
+
 	if m.Name, m.Revision, err = parseFilename(filename); err != nil {
 		return err
 	}
 
-	return Register(m)
+	return r.Register(m)
+}
+
+// Verify that the registered migrations form a contiguous sequence of revisions
+// starting at Revision 1, e.g. that no revisions are missing or duplicated. An error
+// is returned that names any missing or duplicate revision numbers; this commonly
+// happens when a migration file is accidentally deleted or renamed. The requires
+// directives of the migrations are also verified, returning an error if a required
+// revision is not registered or if the dependencies between migrations are circular.
+func Verify() (err error) {
+	return DefaultRegistry.Verify()
+}
+
+// Verify the migrations in the registry, see Verify.
+func (r *Registry) Verify() (err error) {
+	_, err = ordered(r.registered())
+	return err
+}
+
+func verify(migrations []Migration) (err error) {
+	var missing, duplicates []int
+	prev := 0
+	for _, m := range migrations {
+		switch {
+		case m.Revision == prev:
+			duplicates = append(duplicates, m.Revision)
+		case m.Revision > prev+1:
+			// The revisions replaced by a squashed migration are not missing
+			if from, ok := m.Squashed(); ok && from <= prev+1 {
+				break
+			}
+
+			for r := prev + 1; r < m.Revision; r++ {
+				missing = append(missing, r)
+			}
+		}
+		prev = m.Revision
+	}
+
+	if len(duplicates) > 0 {
+		return fmt.Errorf("duplicate revision(s) registered: %s", joinInts(duplicates))
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing revision(s) in registered migrations: %s", joinInts(missing))
+	}
+	return nil
 }
 
 // Reset removes all registered migrations. Primarily used for testing.
 func Reset() (err error) {
-	migrations = make([]Migration, 0)
+	return DefaultRegistry.Reset()
+}
+
+// Reset removes all migrations from the registry.
+func (r *Registry) Reset() (err error) {
+	r.mu.Lock()
+	r.migrations = make([]Migration, 0)
+	r.repeatable = nil
+	r.mu.Unlock()
 	return nil
 }
 
+// Migrations returns a copy of all registered migrations sorted by revision. The copy
+// includes the migration descriptors so that modifying the returned migrations has no
+// effect on the registered migrations. Note that the status fields of the returned
+// migrations are not synchronized with the database, use Status to load them.
+func Migrations() []Migration {
+	return DefaultRegistry.Migrations()
+}
+
+// Migrations returns a copy of the migrations in the registry, see Migrations.
+func (r *Registry) Migrations() []Migration {
+	out := r.registered()
+	for i := range out {
+		out[i].descriptor = append(Descriptor(nil), out[i].descriptor...)
+	}
+	return out
+}
+
+// Repeatables returns a copy of all registered repeatable migrations sorted by name, see
+// Migration.Repeatable. Repeatable migrations are not returned by Migrations.
+func Repeatables() []Migration {
+	return DefaultRegistry.Repeatables()
+}
+
+// Repeatables returns a copy of the repeatable migrations in the registry, see
+// Repeatables.
+func (r *Registry) Repeatables() []Migration {
+	out := r.repeatables()
+	for i := range out {
+		out[i].descriptor = append(Descriptor(nil), out[i].descriptor...)
+	}
+	return out
+}
+
+// Returns a copy of the migrations registered with the default registry.
+func registered() []Migration {
+	return DefaultRegistry.registered()
+}
+
+// Returns a copy of the registered migrations in revision order.
+func (r *Registry) registered() []Migration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]Migration, len(r.migrations))
+	copy(out, r.migrations)
+	return out
+}
+
+// Returns a copy of the registered repeatable migrations in name order.
+func (r *Registry) repeatables() []Migration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]Migration, len(r.repeatable))
+	copy(out, r.repeatable)
+	return out
+}
+
 // ByRevision implements sort.Interface for []Migration based on the Revision field.
 type ByRevision []Migration
 
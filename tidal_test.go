@@ -1,6 +1,9 @@
 package tidal
 
 import (
+	"context"
+	"errors"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -18,24 +21,150 @@ func TestRegister(t *testing.T) {
 	require.NoError(t, Register(Migration{Revision: 13}))
 	require.NoError(t, Register(Migration{Revision: 14}))
 
-	require.Len(t, migrations, 8)
+	require.Len(t, DefaultRegistry.migrations, 8)
 
 	// Ensure migrations is maintained in sorted order
 	prev := -1
-	for _, m := range migrations {
+	for _, m := range DefaultRegistry.migrations {
 		require.Greater(t, m.Revision, prev)
 		prev = m.Revision
 	}
 
 	// Require an error when we register a duplicate migration
-	require.Error(t, Register(Migration{Revision: 9}))
+	err := Register(Migration{Revision: 9})
+	require.EqualError(t, err, "cannot register migration with revision 9: revision already exists")
+	require.True(t, errors.Is(err, ErrDuplicateRevision))
+	require.False(t, errors.Is(err, ErrNotRegistered))
+
+	var target *DuplicateRevisionError
+	require.True(t, errors.As(err, &target))
+	require.Equal(t, 9, target.Revision)
+}
+
+func TestConcurrentRegister(t *testing.T) {
+	defer Reset()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 200)
+	for i := 1; i <= 100; i++ {
+		wg.Add(1)
+		go func(revision int) {
+			defer wg.Done()
+			errs <- Register(Migration{Revision: revision})
+
+			// Read the registered migrations concurrently with registration
+			m := Migration{Revision: revision}
+			if _, err := m.Predecessors(); err != nil {
+				errs <- err
+			}
+			Verify()
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	require.Len(t, DefaultRegistry.migrations, 100)
+	require.NoError(t, Verify())
+}
+
+func TestMigrations(t *testing.T) {
+	defer Reset()
+	require.Empty(t, Migrations())
+
+	require.NoError(t, RegisterDescriptor(generatedDescriptor))
+	require.NoError(t, Register(Migration{Revision: 3, Name: "third"}))
+	require.NoError(t, Register(Migration{Revision: 2, Name: "second"}))
+
+	ms := Migrations()
+	require.Len(t, ms, 3)
+	for i, m := range ms {
+		require.Equal(t, i+1, m.Revision)
+	}
+
+	// Modifying the returned migrations should not modify the registered migrations
+	ms[1].Name = "modified"
+	ms[0].descriptor[0] = 0x00
+	ms = append(ms[:1], ms[2:]...)
+	require.Equal(t, "second", DefaultRegistry.migrations[1].Name)
+	require.Equal(t, byte(0x1f), DefaultRegistry.migrations[0].descriptor[0])
+	require.Len(t, DefaultRegistry.migrations, 3)
+}
+
+func TestVerify(t *testing.T) {
+	defer Reset()
+	require.NoError(t, Verify())
+
+	require.NoError(t, Register(Migration{Revision: 1}))
+	require.NoError(t, Register(Migration{Revision: 2}))
+	require.NoError(t, Register(Migration{Revision: 4}))
+	require.EqualError(t, Verify(), "missing revision(s) in registered migrations: 3")
+
+	require.NoError(t, Register(Migration{Revision: 3}))
+	require.NoError(t, Verify())
+
+	require.NoError(t, Register(Migration{Revision: 7}))
+	require.EqualError(t, Verify(), "missing revision(s) in registered migrations: 5, 6")
+
+	// Duplicates cannot be registered, but should still be detected
+	DefaultRegistry.migrations = append(DefaultRegistry.migrations, Migration{Revision: 7})
+	require.EqualError(t, Verify(), "duplicate revision(s) registered: 7")
+}
+
+func TestRegistry(t *testing.T) {
+	defer SetDialect(Postgres)
+
+	// Independent registries manage the migrations of different databases
+	billing, accounts := &Registry{}, &Registry{}
+	require.NoError(t, billing.Register(newTestMigration(t, "0001_create_invoices.sql", "CREATE TABLE invoices (id integer);", "DROP TABLE invoices;")))
+	require.NoError(t, accounts.Register(newTestMigration(t, "0001_create_users.sql", "CREATE TABLE users (id integer);", "DROP TABLE users;")))
+	require.NoError(t, accounts.Register(newTestMigration(t, "0002_create_groups.sql", "CREATE TABLE groups (id integer);", "DROP TABLE groups;")))
+	require.True(t, errors.Is(accounts.Register(Migration{Revision: 2}), ErrDuplicateRevision))
+	require.Empty(t, Migrations(), "the default registry should not be modified")
+
+	bdb, adb := openTestDB(t), openTestDB(t)
+	defer bdb.Close()
+	defer adb.Close()
+
+	require.NoError(t, billing.Migrate(bdb, -1))
+	require.NoError(t, accounts.Migrate(adb, -1))
+
+	status, err := billing.Status(bdb)
+	require.NoError(t, err)
+	require.Len(t, status, 1)
+	require.True(t, status[0].Active)
+
+	status, err = accounts.Status(adb)
+	require.NoError(t, err)
+	require.Len(t, status, 2)
+
+	revision, err := accounts.Lookup("create groups")
+	require.NoError(t, err)
+	require.Equal(t, 2, revision)
+
+	require.NoError(t, accounts.Rollback(adb, 1, RollbackOptions{Force: true}))
+	active, err := readActive(context.Background(), adb)
+	require.NoError(t, err)
+	require.Equal(t, map[int]bool{1: true, 2: false}, active)
+
+	require.NoError(t, accounts.Reset())
+	require.Empty(t, accounts.Migrations())
+	require.Len(t, billing.Migrations(), 1)
 }
 
 func TestRegisterDescriptor(t *testing.T) {
 	defer Reset()
 
 	require.NoError(t, RegisterDescriptor(generatedDescriptor))
-	require.Len(t, migrations, 1)
+	require.Len(t, DefaultRegistry.migrations, 1)
+
+	// Registering the same descriptor twice can be detected to be idempotent
+	err := RegisterDescriptor(generatedDescriptor)
+	require.True(t, errors.Is(err, ErrDuplicateRevision))
+	require.Len(t, DefaultRegistry.migrations, 1)
 }
 
 var generatedDescriptor = []byte{
@@ -1,6 +1,9 @@
 package tidal
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // Semantic versioning components for easy package comparsion.
 const (
@@ -9,10 +12,46 @@ const (
 	VersionPatch = 0
 )
 
-// Version returns a string with the semvar version of the current build.
+// Build metadata that is optionally set at build time using -ldflags, e.g.
+//
+//	go build -ldflags "-X github.com/rotationalio/tidal.GitCommit=$(git rev-parse --short HEAD) -X github.com/rotationalio/tidal.BuildDate=$(date -u +%Y-%m-%d)"
+//
+// Release builds set both variables; if they are empty the binary is a dev build.
+var (
+	GitCommit string // the git commit hash the binary was built from
+	BuildDate string // the date the binary was built
+)
+
+// Version returns a string with the semvar version of the current build, followed by
+// the git commit and build date if they were set at build time, e.g. 1.0 (abc1234 2021-06-01).
 func Version() string {
+	version := semver()
+	if meta := versionMeta(); meta != "" {
+		return fmt.Sprintf("%s (%s)", version, meta)
+	}
+	return version
+}
+
+// Release returns true if the build metadata was set at build time, false for dev builds.
+func Release() bool {
+	return GitCommit != "" && BuildDate != ""
+}
+
+// Returns the version without any build metadata.
+func semver() string {
 	if VersionPatch > 0 {
 		return fmt.Sprintf("%d.%d.%d", VersionMajor, VersionMinor, VersionPatch)
 	}
 	return fmt.Sprintf("%d.%d", VersionMajor, VersionMinor)
 }
+
+// Returns the build metadata that is present joined by a space.
+func versionMeta() string {
+	meta := make([]string, 0, 2)
+	for _, s := range []string{GitCommit, BuildDate} {
+		if s != "" {
+			meta = append(meta, s)
+		}
+	}
+	return strings.Join(meta, " ")
+}
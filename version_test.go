@@ -0,0 +1,25 @@
+package tidal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersion(t *testing.T) {
+	defer func(commit, date string) {
+		GitCommit, BuildDate = commit, date
+	}(GitCommit, BuildDate)
+
+	GitCommit, BuildDate = "", ""
+	require.Equal(t, semver(), Version())
+	require.False(t, Release())
+
+	GitCommit = "abc1234"
+	require.Equal(t, semver()+" (abc1234)", Version())
+	require.False(t, Release())
+
+	BuildDate = "2021-06-01"
+	require.Equal(t, semver()+" (abc1234 2021-06-01)", Version())
+	require.True(t, Release())
+}